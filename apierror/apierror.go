@@ -0,0 +1,153 @@
+package apierror
+
+import (
+	"encoding/json"
+	"net/http"
+)
+
+// Code is a stable, machine-readable identifier of an error. Unlike messages,
+// codes do not change once they are published, so clients can rely on them.
+type Code string
+
+const (
+	// InternalError means the server failed to handle the request
+	InternalError Code = "internal_error"
+
+	// RouteNotFound means no route matches the request's path
+	RouteNotFound Code = "route_not_found"
+
+	// MethodNotAllowed means the route does not support the request's method
+	MethodNotAllowed Code = "method_not_allowed"
+
+	// UserServiceUnavailable means the user service could not be reached
+	UserServiceUnavailable Code = "user_service_unavailable"
+
+	// UserServiceError means the user service responded with an error
+	UserServiceError Code = "user_service_error"
+
+	// UserIDInvalid means the User-ID header, or a user ID in the path, is not
+	// a valid ID
+	UserIDInvalid Code = "user_id_invalid"
+
+	// ConversationIDInvalid means the conversation ID in the path is not a
+	// valid ID
+	ConversationIDInvalid Code = "conversation_id_invalid"
+
+	// ParameterInvalid means a path or query parameter is not valid. Its
+	// details name the parameter.
+	ParameterInvalid Code = "parameter_invalid"
+
+	// RequestBodyInvalid means the request body could not be read or is not
+	// valid JSON of the expected shape
+	RequestBodyInvalid Code = "request_body_invalid"
+
+	// RequestBodyMissingFields means the request body is missing mandatory
+	// fields
+	RequestBodyMissingFields Code = "request_body_missing_fields"
+
+	// FieldInvalid means a field of the request body has an invalid value.
+	// Its details name the field.
+	FieldInvalid Code = "field_invalid"
+
+	// RoleInvalid means a role in the request body is not a known role
+	RoleInvalid Code = "role_invalid"
+
+	// ConversationNotFound means the conversation does not exist or the
+	// session user is not a member of it
+	ConversationNotFound Code = "conversation_not_found"
+
+	// MemberNotFound means the user is not a member of the conversation
+	MemberNotFound Code = "member_not_found"
+
+	// UserNotFound means the user does not exist
+	UserNotFound Code = "user_not_found"
+
+	// ContentNotFound means the conversation's content does not exist
+	ContentNotFound Code = "content_not_found"
+
+	// ServiceAccountNotFound means the service account does not exist
+	ServiceAccountNotFound Code = "service_account_not_found"
+
+	// TokenNotFound means the API token does not exist
+	TokenNotFound Code = "token_not_found"
+
+	// WebhookNotFound means the webhook does not exist
+	WebhookNotFound Code = "webhook_not_found"
+
+	// InvitationPending means the session user has to accept their
+	// invitation to the conversation first
+	InvitationPending Code = "invitation_pending"
+
+	// Forbidden means the session user is not allowed to do this
+	Forbidden Code = "forbidden"
+
+	// MemberConflict means the user is already a member of the conversation
+	MemberConflict Code = "member_conflict"
+
+	// PatchInvalid means a content patch could not be parsed
+	PatchInvalid Code = "patch_invalid"
+
+	// PatchConflict means a content patch does not apply to the current
+	// content
+	PatchConflict Code = "patch_conflict"
+
+	// ContentTooLarge means an edit would grow the content past its limit
+	ContentTooLarge Code = "content_too_large"
+
+	// EditTimeout means a content edit could not be queued in time
+	EditTimeout Code = "edit_timeout"
+
+	// PreconditionFailed means the resource has changed since the client
+	// read it
+	PreconditionFailed Code = "precondition_failed"
+
+	// RangeNotSatisfiable means none of the requested ranges are within the
+	// content. Its details have the size of the content.
+	RangeNotSatisfiable Code = "range_not_satisfiable"
+
+	// QuotaExceeded means the request would exceed a quota. Its details name
+	// the quota and its limit.
+	QuotaExceeded Code = "quota_exceeded"
+
+	// RateLimited means too many requests were made. Its details have the
+	// number of seconds to wait before retrying.
+	RateLimited Code = "rate_limited"
+
+	// TokenInvalid means the API token is not valid
+	TokenInvalid Code = "token_invalid"
+
+	// HistoryTooLong means the edit history has too many revisions to replay.
+	// Its details have the limit.
+	HistoryTooLong Code = "history_too_long"
+)
+
+// Details represents extra information about an error, which depends on its
+// code.
+type Details map[string]interface{}
+
+// Error represents the body of every error response.
+type Error struct {
+	Code    Code    `json:"code"`
+	Message string  `json:"message"`
+	Details Details `json:"details"`
+}
+
+// Write replies to a request with an error, like http.Error, but with a JSON
+// body.
+func Write(w http.ResponseWriter, message string, status int, code Code) {
+	WriteDetails(w, message, status, code, nil)
+}
+
+// WriteDetails replies to a request with an error that has details.
+func WriteDetails(w http.ResponseWriter, message string, status int, code Code, details Details) {
+	if details == nil {
+		details = Details{}
+	}
+
+	h := w.Header()
+	h.Del("Content-Length")
+	h.Set("Content-Type", "application/json")
+	h.Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(&Error{Code: code, Message: message, Details: details})
+}
@@ -1,67 +1,223 @@
 package main
 
 import (
+	"context"
+	"ether/auth"
+	"ether/config"
 	"ether/filesystem"
 	"ether/handlers"
+	"ether/history"
 	"ether/kafka"
+	"ether/karen"
+	"ether/logging"
+	"ether/metrics"
 	"ether/models"
+	"ether/ratelimit"
+	"ether/search"
+	"ether/tracing"
+	"ether/webhooks"
 	"fmt"
 	"log"
 	"net/http"
 	"os"
+	"os/signal"
+	"strings"
+	"syscall"
 	"time"
 
 	"github.com/gorilla/mux"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 )
 
-func logging(f http.Handler) http.Handler {
-	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		log.Printf("path: %s, method: %s", r.URL.Path, r.Method)
-		f.ServeHTTP(w, r)
-	})
-}
-
 func main() {
+	cfg, args, err := config.Load(os.Args[1:])
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	// Show the effective configuration instead of serving if requested
+	if len(args) == 2 && args[0] == "config" && args[1] == "print" {
+		if err := cfg.Print(os.Stdout); err != nil {
+			log.Fatal(err)
+		}
+		if err := cfg.Validate(); err != nil {
+			log.Fatalf("Invalid configuration:\n%v", err)
+		}
+		return
+	}
+	if len(args) > 0 && !(len(args) == 1 && args[0] == "reindex") {
+		log.Fatalf("Unknown command: %s", strings.Join(args, " "))
+	}
+
+	if err := cfg.Validate(); err != nil {
+		log.Fatalf("Invalid configuration:\n%v", err)
+	}
+	logLevel, _ := logging.ParseLevel(cfg.LogLevel)
+	logger := logging.New(os.Stdout, logLevel)
+
+	shutdownTracing, err := tracing.Setup(
+		context.Background(),
+		cfg.TracesExporter,
+	)
+	if err != nil {
+		logger.Fatalf("Failed to set up tracing: %v", err)
+	}
+
 	connectionString := fmt.Sprintf(
 		"%s:%s@tcp(%s)/?interpolateParams=true",
-		os.Getenv("ETHER_DB_USERNAME"),
-		os.Getenv("ETHER_DB_PASSWORD"),
-		os.Getenv("ETHER_DB_LOCATION"))
-	db, err := models.NewDB(connectionString)
+		cfg.DB.Username,
+		cfg.DB.Password,
+		cfg.DB.Location)
+	db, err := models.NewDB(connectionString, logger)
 	if err != nil {
-		log.Fatal("Failed to open database: ", err)
+		logger.Fatalf("Failed to open database: %v", err)
+	}
+
+	directory := filesystem.NewDirectory(cfg.ContentDir)
+	indexer := search.NewIndexer(db, logger)
+	recorder := history.NewRecorder(db, logger)
+
+	// Rebuild the search index instead of serving if requested
+	if len(args) == 1 && args[0] == "reindex" {
+		if err := indexer.Reindex(context.Background(), directory); err != nil {
+			logger.Fatalf("Failed to rebuild search index: %v", err)
+		}
 		return
 	}
 
-	directory := filesystem.NewDirectory(os.Getenv("ETHER_CONTENT_DIR"))
+	karenConfig := karen.DefaultConfig(cfg.Karen.Server)
+	karenConfig.Timeout = time.Duration(cfg.Karen.Timeout)
+	karenClient := karen.NewClient(karenConfig)
 
-	client := &http.Client{}
-	karen := os.Getenv("KAREN_SERVER")
+	// Start webhook delivery goroutine
+	dispatcher := webhooks.NewDispatcher(db, logger, webhooks.Config{
+		Workers:              cfg.Webhooks.Workers,
+		MaxAttempts:          cfg.Webhooks.MaxAttempts,
+		RetryBackoff:         time.Duration(cfg.Webhooks.RetryBackoff),
+		DisableAfter:         cfg.Webhooks.DisableAfter,
+		ContentEditDebounce:  time.Duration(cfg.Webhooks.ContentEditDebounce),
+		Timeout:              time.Duration(cfg.Webhooks.Timeout),
+		AllowPrivateNetworks: cfg.Webhooks.AllowPrivateNetworks,
+	})
+	webhooksCtx, stopWebhooks := context.WithCancel(context.Background())
+	webhooksDone := make(chan struct{})
+	go func() {
+		dispatcher.Run(webhooksCtx)
+		close(webhooksDone)
+	}()
 
 	kafkaEnv := &kafka.Env{
 		DB:           db,
-		CachedWriter: filesystem.NewCachedWriter(directory),
+		CachedWriter: filesystem.NewCachedWriter(directory, indexer, recorder, logger),
+		Edits:        kafka.NewEditBatcher(db, logger, time.Duration(cfg.Events.ContentEditWindow)),
+		Logger:       logger,
 	}
+	kafkaEnv.Edits.Notifier = dispatcher
+	kafkaEnv.CachedWriter.MaxContentSize = cfg.Quotas.MaxContentBytes
 
 	// Start file writer goroutine
-	go kafkaEnv.CachedWriter.Run()
+	metrics.RegisterWriterQueue(kafkaEnv.CachedWriter.QueueDepth)
+	writerCtx, stopWriter := context.WithCancel(context.Background())
+	writerDone := make(chan struct{})
+	go func() {
+		kafkaEnv.CachedWriter.Run(writerCtx)
+		close(writerDone)
+	}()
 
-	kafkaReader := kafka.NewReader(
-		os.Getenv("ETHER_KAFKA_SERVER"),
-		os.Getenv("ETHER_KAFKA_TOPIC"),
-	)
+	// Start content edit event goroutine
+	editsCtx, stopEdits := context.WithCancel(context.Background())
+	editsDone := make(chan struct{})
+	go func() {
+		kafkaEnv.Edits.Run(editsCtx)
+		close(editsDone)
+	}()
+
+	kafkaConfig := kafka.ReaderConfig{
+		Brokers:               cfg.Kafka.BrokerList(),
+		Topic:                 cfg.Kafka.Topic,
+		TLSEnabled:            cfg.Kafka.TLS.Enabled,
+		TLSCAFile:             cfg.Kafka.TLS.CAFile,
+		TLSCertFile:           cfg.Kafka.TLS.CertFile,
+		TLSKeyFile:            cfg.Kafka.TLS.KeyFile,
+		TLSInsecureSkipVerify: cfg.Kafka.TLS.InsecureSkipVerify,
+		SASLMechanism:         cfg.Kafka.SASL.Mechanism,
+		SASLUsername:          cfg.Kafka.SASL.Username,
+		SASLPassword:          cfg.Kafka.SASL.Password,
+	}
+	kafkaReader, err := kafka.NewReader(kafkaConfig, logger)
+	if err != nil {
+		logger.Fatalf("Failed to create Kafka reader: %v", err)
+	}
+
+	var publisher *kafka.Publisher
+	if cfg.Kafka.PublishTopic != "" {
+		publisher, err = kafka.NewPublisher(kafkaConfig, cfg.Kafka.PublishTopic, logger)
+		if err != nil {
+			logger.Fatalf("Failed to create Kafka publisher: %v", err)
+		}
+	} else {
+		logger.Warnf("kafka.publish_topic is not set, so content edits made through the API will not be sent to connected clients")
+	}
 
 	// Start Kafka reader goroutine
-	go kafkaReader.Run(kafkaEnv.ProcessWSMessage)
+	readerCtx, stopReader := context.WithCancel(context.Background())
+	readerDone := make(chan struct{})
+	go func() {
+		kafkaReader.Run(readerCtx, kafkaEnv.ProcessWSMessage)
+		close(readerDone)
+	}()
 
 	httpEnv := &handlers.Env{
 		DB:        db,
 		Directory: directory,
-		Client:    client,
-		KarenHost: karen,
+		Karen:     karenClient,
+		Logger:    logger,
+		Writer:    kafkaEnv.CachedWriter,
+		Webhooks:  dispatcher,
+		WebhookAddresses: &webhooks.AddressPolicy{
+			AllowPrivateNetworks: cfg.Webhooks.AllowPrivateNetworks,
+		},
+		Quotas: handlers.Quotas{
+			ConversationsPerUser:   cfg.Quotas.ConversationsPerUser,
+			MembersPerConversation: cfg.Quotas.MembersPerConversation,
+			MaxContentSize:         cfg.Quotas.MaxContentBytes,
+		},
+		ReadinessChecks: []handlers.HealthCheck{
+			{Name: "db", Check: db.PingContext},
+			{Name: "content_dir", Check: func(context.Context) error {
+				return directory.CheckWritable()
+			}},
+			{Name: "kafka_reader", Check: func(context.Context) error {
+				status := kafkaReader.Status()
+				if status.State == kafka.StateStopped {
+					return fmt.Errorf("Kafka reader stopped: %v", status.LastError)
+				}
+				return nil
+			}},
+			{Name: "writer_queue", Check: func(context.Context) error {
+				return kafkaEnv.CachedWriter.CheckQueue()
+			}},
+		},
+	}
+
+	if publisher != nil {
+		httpEnv.Publisher = publisher
+	}
+
+	var readLimiter, writeLimiter *ratelimit.Limiter
+	if cfg.RateLimit.ReadsPerMinute > 0 {
+		readLimiter = ratelimit.NewLimiter(cfg.RateLimit.ReadsPerMinute, time.Minute, cfg.RateLimit.ReadBurst)
+	}
+	if cfg.RateLimit.WritesPerMinute > 0 {
+		writeLimiter = ratelimit.NewLimiter(cfg.RateLimit.WritesPerMinute, time.Minute, cfg.RateLimit.WriteBurst)
+	}
+	if changes := cfg.RateLimit.MembershipChangesPerHour; changes > 0 {
+		httpEnv.MembershipLimiter = ratelimit.NewLimiter(changes, time.Hour, changes)
 	}
 
 	httpMux := mux.NewRouter()
+	httpMux.NotFoundHandler = http.HandlerFunc(handlers.NotFoundHandler)
+	httpMux.MethodNotAllowedHandler = http.HandlerFunc(handlers.MethodNotAllowedHandler)
 
 	// Conversation CRUD
 	httpMux.HandleFunc(
@@ -84,43 +240,221 @@ func main() {
 		"/ether/v1/conversations/{conversation_id:[0-9]+}",
 		httpEnv.DeleteConversationHandler,
 	).Methods("DELETE")
-
-	// Conversation Content read
 	httpMux.HandleFunc(
+		"/ether/v1/conversations/{conversation_id:[0-9]+}/read",
+		httpEnv.PostConversationReadHandler,
+	).Methods("POST")
+	httpMux.HandleFunc(
+		"/ether/v1/unread",
+		httpEnv.GetUnreadHandler,
+	).Methods("GET")
+
+	// Conversation Content read and write
+	httpMux.Handle(
+		"/ether/v1/conversations/{conversation_id:[0-9]+}/content",
+		auth.Scoped(models.ScopeReadContent, httpEnv.GetContentHandler),
+	).Methods("GET")
+	httpMux.Handle(
 		"/ether/v1/conversations/{conversation_id:[0-9]+}/content",
-		httpEnv.GetContentHandler,
+		auth.Scoped(models.ScopeWriteContent, httpEnv.PostContentHandler),
+	).Methods("POST")
+	httpMux.HandleFunc(
+		"/ether/v1/conversations/{conversation_id:[0-9]+}/content/blame",
+		httpEnv.GetContentBlameHandler,
+	).Methods("GET")
+	httpMux.Handle(
+		"/ether/v1/conversations/{conversation_id:[0-9]+}/content/diff",
+		auth.Scoped(models.ScopeReadContent, httpEnv.GetContentDiffHandler),
 	).Methods("GET")
 
 	// User-Conversation Mapping CRUD
-	httpMux.HandleFunc(
+	httpMux.Handle(
 		"/ether/v1/conversations/{conversation_id:[0-9]+}/users",
-		httpEnv.PostMappingHandler,
+		auth.Scoped(models.ScopeManageMembers, httpEnv.PostMappingHandler),
 	).Methods("POST")
-	httpMux.HandleFunc(
+	httpMux.Handle(
 		"/ether/v1/conversations/{conversation_id:[0-9]+}/users/{user_id:[0-9]+}",
-		httpEnv.GetMappingHandler,
+		auth.Scoped(models.ScopeManageMembers, httpEnv.GetMappingHandler),
 	).Methods("GET")
-	httpMux.HandleFunc(
+	httpMux.Handle(
 		"/ether/v1/conversations/{conversation_id:[0-9]+}/users",
-		httpEnv.GetMappingsHandler,
+		auth.Scoped(models.ScopeManageMembers, httpEnv.GetMappingsHandler),
 	).Methods("GET")
-	httpMux.HandleFunc(
+	httpMux.Handle(
 		"/ether/v1/conversations/{conversation_id:[0-9]+}/users/{user_id:[0-9]+}",
-		httpEnv.PatchMappingHandler,
+		auth.Scoped(models.ScopeManageMembers, httpEnv.PatchMappingHandler),
 	).Methods("PATCH")
-	httpMux.HandleFunc(
+	httpMux.Handle(
 		"/ether/v1/conversations/{conversation_id:[0-9]+}/users/{user_id:[0-9]+}",
-		httpEnv.DeleteMappingHandler,
+		auth.Scoped(models.ScopeManageMembers, httpEnv.DeleteMappingHandler),
+	).Methods("DELETE")
+	httpMux.Handle(
+		"/ether/v1/conversations/{conversation_id:[0-9]+}/users:batch",
+		auth.Scoped(models.ScopeManageMembers, httpEnv.PostMappingsBatchHandler),
+	).Methods("POST")
+	httpMux.Handle(
+		"/ether/v1/conversations/{conversation_id:[0-9]+}/users:batch",
+		auth.Scoped(models.ScopeManageMembers, httpEnv.DeleteMappingsBatchHandler),
+	).Methods("DELETE")
+
+	// Service accounts and their API tokens
+	httpMux.HandleFunc(
+		"/ether/v1/conversations/{conversation_id:[0-9]+}/service_accounts",
+		httpEnv.PostServiceAccountHandler,
+	).Methods("POST")
+	httpMux.HandleFunc(
+		"/ether/v1/conversations/{conversation_id:[0-9]+}/service_accounts",
+		httpEnv.GetServiceAccountsHandler,
+	).Methods("GET")
+	httpMux.HandleFunc(
+		"/ether/v1/conversations/{conversation_id:[0-9]+}/service_accounts/{service_account_id:[0-9]+}",
+		httpEnv.DeleteServiceAccountHandler,
+	).Methods("DELETE")
+	httpMux.HandleFunc(
+		"/ether/v1/conversations/{conversation_id:[0-9]+}/tokens",
+		httpEnv.PostTokenHandler,
+	).Methods("POST")
+	httpMux.HandleFunc(
+		"/ether/v1/conversations/{conversation_id:[0-9]+}/tokens",
+		httpEnv.GetTokensHandler,
+	).Methods("GET")
+	httpMux.HandleFunc(
+		"/ether/v1/conversations/{conversation_id:[0-9]+}/tokens/{token_id:[0-9]+}",
+		httpEnv.DeleteTokenHandler,
+	).Methods("DELETE")
+
+	// Conversation webhooks
+	httpMux.HandleFunc(
+		"/ether/v1/conversations/{conversation_id:[0-9]+}/webhooks",
+		httpEnv.PostWebhookHandler,
+	).Methods("POST")
+	httpMux.HandleFunc(
+		"/ether/v1/conversations/{conversation_id:[0-9]+}/webhooks",
+		httpEnv.GetWebhooksHandler,
+	).Methods("GET")
+	httpMux.HandleFunc(
+		"/ether/v1/conversations/{conversation_id:[0-9]+}/webhooks/{webhook_id:[0-9]+}",
+		httpEnv.PatchWebhookHandler,
+	).Methods("PATCH")
+	httpMux.HandleFunc(
+		"/ether/v1/conversations/{conversation_id:[0-9]+}/webhooks/{webhook_id:[0-9]+}",
+		httpEnv.DeleteWebhookHandler,
 	).Methods("DELETE")
-	httpMux.Use(logging)
+	httpMux.HandleFunc(
+		"/ether/v1/conversations/{conversation_id:[0-9]+}/webhooks/{webhook_id:[0-9]+}/deliveries",
+		httpEnv.GetWebhookDeliveriesHandler,
+	).Methods("GET")
+
+	// Quota usage
+	httpMux.HandleFunc(
+		"/ether/v1/usage",
+		httpEnv.GetUsageHandler,
+	).Methods("GET")
+	httpMux.HandleFunc(
+		"/ether/v1/conversations/{conversation_id:[0-9]+}/usage",
+		httpEnv.GetConversationUsageHandler,
+	).Methods("GET")
+
+	// Conversation activity log
+	httpMux.HandleFunc(
+		"/ether/v1/conversations/{conversation_id:[0-9]+}/events",
+		httpEnv.GetEventsHandler,
+	).Methods("GET")
+
+	// Conversation Content search
+	httpMux.HandleFunc(
+		"/ether/v1/search",
+		httpEnv.GetSearchHandler,
+	).Methods("GET")
+
+	// Health checks
+	httpMux.HandleFunc("/healthz", httpEnv.GetHealthzHandler).Methods("GET")
+	httpMux.HandleFunc("/readyz", httpEnv.GetReadyzHandler).Methods("GET")
+
+	// Prometheus metrics
+	httpMux.Handle("/metrics", promhttp.Handler()).Methods("GET")
+	httpMux.Use(tracing.Middleware)
+	httpMux.Use(logging.Middleware(logger))
+	httpMux.Use(metrics.Middleware)
+	httpMux.Use(auth.Middleware(db, logger))
+	httpMux.Use(ratelimit.Middleware(readLimiter, writeLimiter, logger))
 
 	httpSrv := &http.Server{
-		Addr:         ":80",
-		ReadTimeout:  5 * time.Second,
-		WriteTimeout: 5 * time.Second,
-		IdleTimeout:  120 * time.Second,
+		Addr:         cfg.HTTP.Address,
+		ReadTimeout:  time.Duration(cfg.HTTP.ReadTimeout),
+		WriteTimeout: time.Duration(cfg.HTTP.WriteTimeout),
+		IdleTimeout:  time.Duration(cfg.HTTP.IdleTimeout),
 		Handler:      httpMux,
 	}
 
-	log.Fatal(httpSrv.ListenAndServe())
+	shutdownTimeout := time.Duration(cfg.ShutdownTimeout)
+
+	signalCtx, stopSignals := signal.NotifyContext(
+		context.Background(),
+		syscall.SIGTERM,
+		os.Interrupt,
+	)
+
+	serverErr := make(chan error, 1)
+	go func() {
+		serverErr <- httpSrv.ListenAndServe()
+	}()
+
+	exitCode := 0
+	select {
+	case <-signalCtx.Done():
+		logger.Infof("Received signal, shutting down within %s", shutdownTimeout)
+	case err := <-serverErr:
+		logger.Errorf("HTTP server stopped: %v", err)
+		exitCode = 1
+	}
+	stopSignals()
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	wait := func(name string, done <-chan struct{}) {
+		select {
+		case <-done:
+		case <-shutdownCtx.Done():
+			logger.Warnf("Timed out waiting for %s to stop", name)
+			exitCode = 1
+		}
+	}
+
+	// Stop accepting HTTP requests and wait for in-flight ones to finish
+	if err := httpSrv.Shutdown(shutdownCtx); err != nil {
+		logger.Warnf("Failed to drain HTTP requests: %v", err)
+		exitCode = 1
+	}
+	if publisher != nil {
+		if err := publisher.Close(); err != nil {
+			logger.Warnf("Failed to close Kafka publisher: %v", err)
+		}
+	}
+
+	// Stop fetching Kafka messages; the message being handled is finished and
+	// its offset committed before the reader closes, unless it is waiting to
+	// be retried
+	stopReader()
+	wait("Kafka reader", readerDone)
+
+	// Nothing can send updates to the writer anymore, so apply the queued ones
+	stopWriter()
+	wait("content writer", writerDone)
+
+	// Record the content edits that have not been recorded yet
+	stopEdits()
+	wait("content edit events", editsDone)
+
+	// Nothing can record events anymore, so deliver the queued ones
+	stopWebhooks()
+	wait("webhook deliveries", webhooksDone)
+
+	if err := shutdownTracing(shutdownCtx); err != nil {
+		logger.Warnf("Failed to flush traces: %v", err)
+	}
+	db.Close()
+	cancel()
+
+	logger.Info("Shutdown complete")
+	os.Exit(exitCode)
 }
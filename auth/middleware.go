@@ -0,0 +1,102 @@
+package auth
+
+import (
+	"context"
+	"ether/apierror"
+	"ether/logging"
+	"ether/models"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gorilla/mux"
+)
+
+// TokenStore looks up API tokens by their hash.
+type TokenStore interface {
+	GetAPITokenByHash(ctx context.Context, hash string) (*models.APIToken, error)
+}
+
+// scopedHandler is the handler of a route that API tokens with a scope can
+// access.
+type scopedHandler struct {
+	scope   models.Scope
+	handler http.HandlerFunc
+}
+
+func (h *scopedHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	h.handler(w, r)
+}
+
+// Scoped marks a route's handler as accessible to API tokens with scope. The
+// routes of every other handler can only be accessed by users.
+func Scoped(scope models.Scope, handler http.HandlerFunc) http.Handler {
+	return &scopedHandler{scope: scope, handler: handler}
+}
+
+// bearerToken returns the API token in a request's Authorization header, if it
+// has one that Ether issued.
+func bearerToken(r *http.Request) (string, bool) {
+	header := r.Header.Get("Authorization")
+	if len(header) < len("Bearer ") || !strings.EqualFold(header[:len("Bearer ")], "Bearer ") {
+		return "", false
+	}
+	token := strings.TrimSpace(header[len("Bearer "):])
+	return token, IsToken(token)
+}
+
+// Middleware authenticates requests that carry an API token issued by Ether,
+// which the API gateway passes through without a User-ID. If the token is
+// valid, grants the scope of the matched route and belongs to the requested
+// conversation, the request continues as the token's service account.
+// Requests without an API token are passed through unchanged.
+func Middleware(db TokenStore, logger *logging.Logger) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			token, ok := bearerToken(r)
+			if !ok {
+				next.ServeHTTP(w, r)
+				return
+			}
+			log := logger.WithContext(r.Context())
+
+			apiToken, err := db.GetAPITokenByHash(r.Context(), HashToken(token))
+			if err != nil {
+				errMsg := "Internal Server Error"
+				log.Errorf("%s: %v", errMsg, err)
+				apierror.Write(w, errMsg, http.StatusInternalServerError, apierror.InternalError)
+				return
+			}
+			if apiToken == nil {
+				errMsg := "Invalid API token"
+				log.Info(errMsg)
+				w.Header().Set("WWW-Authenticate", `Bearer error="invalid_token"`)
+				apierror.Write(w, errMsg, http.StatusUnauthorized, apierror.TokenInvalid)
+				return
+			}
+
+			var scope models.Scope
+			if current := mux.CurrentRoute(r); current != nil {
+				if handler, ok := current.GetHandler().(*scopedHandler); ok {
+					scope = handler.scope
+				}
+			}
+			conversationID := mux.Vars(r)["conversation_id"]
+			if scope == "" || !apiToken.HasScope(scope) ||
+				conversationID != strconv.FormatInt(apiToken.ConversationID, 10) {
+				log.Infof(
+					"API token %d of service account %d cannot access %s %s",
+					apiToken.ID,
+					apiToken.ServiceAccountID,
+					r.Method,
+					r.URL.Path,
+				)
+				apierror.Write(w, "API token does not grant access to this resource", http.StatusForbidden, apierror.Forbidden)
+				return
+			}
+
+			r.Header.Set("User-ID", strconv.FormatInt(apiToken.ServiceAccountID, 10))
+			next.ServeHTTP(w, r)
+		})
+	}
+}
@@ -0,0 +1,46 @@
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"strings"
+)
+
+const (
+	// TokenPrefix starts every API token that Ether issues, which tells them
+	// apart from the tokens of the API gateway.
+	TokenPrefix = "ether_"
+
+	tokenBytes = 32
+
+	// displayPrefixLength is how many characters of a token, including
+	// TokenPrefix, are kept to tell it apart in listings.
+	displayPrefixLength = len(TokenPrefix) + 6
+)
+
+// NewToken generates a random API token. It returns the token, which is only
+// shown to whoever creates it, along with its hash and display prefix, which
+// are stored.
+func NewToken() (token string, hash string, prefix string, err error) {
+	b := make([]byte, tokenBytes)
+	if _, err := rand.Read(b); err != nil {
+		return "", "", "", err
+	}
+	token = TokenPrefix + base64.RawURLEncoding.EncodeToString(b)
+	return token, HashToken(token), token[:displayPrefixLength], nil
+}
+
+// HashToken returns the hex-encoded SHA-256 hash of an API token. Tokens are
+// random enough that a fast hash without a salt is as safe as a slow one.
+func HashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// IsToken checks whether a credential looks like an API token issued by
+// Ether.
+func IsToken(credential string) bool {
+	return strings.HasPrefix(credential, TokenPrefix)
+}
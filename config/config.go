@@ -0,0 +1,460 @@
+package config
+
+import (
+	"errors"
+	"ether/kafka"
+	"ether/logging"
+	"ether/tracing"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+const redacted = "REDACTED"
+
+// Config represents the configuration of the whole service.
+type Config struct {
+	DB              DBConfig        `yaml:"db"`
+	ContentDir      string          `yaml:"content_dir"`
+	Kafka           KafkaConfig     `yaml:"kafka"`
+	Karen           KarenConfig     `yaml:"karen"`
+	HTTP            HTTPConfig      `yaml:"http"`
+	Events          EventsConfig    `yaml:"events"`
+	Webhooks        WebhooksConfig  `yaml:"webhooks"`
+	RateLimit       RateLimitConfig `yaml:"rate_limit"`
+	Quotas          QuotasConfig    `yaml:"quotas"`
+	LogLevel        string          `yaml:"log_level"`
+	TracesExporter  string          `yaml:"traces_exporter"`
+	ShutdownTimeout Duration        `yaml:"shutdown_timeout"`
+}
+
+// DBConfig represents the configuration of the MariaDB connection.
+type DBConfig struct {
+	Username string `yaml:"username"`
+	Password string `yaml:"password"`
+	Location string `yaml:"location"`
+}
+
+// KafkaConfig represents the configuration of the Kafka consumer.
+type KafkaConfig struct {
+	Brokers      string          `yaml:"brokers"`
+	Topic        string          `yaml:"topic"`
+	PublishTopic string          `yaml:"publish_topic"`
+	TLS          KafkaTLSConfig  `yaml:"tls"`
+	SASL         KafkaSASLConfig `yaml:"sasl"`
+}
+
+// KafkaTLSConfig represents the TLS options of the Kafka consumer.
+type KafkaTLSConfig struct {
+	Enabled            bool   `yaml:"enabled"`
+	CAFile             string `yaml:"ca_file"`
+	CertFile           string `yaml:"cert_file"`
+	KeyFile            string `yaml:"key_file"`
+	InsecureSkipVerify bool   `yaml:"insecure_skip_verify"`
+}
+
+// KafkaSASLConfig represents the SASL options of the Kafka consumer.
+type KafkaSASLConfig struct {
+	Mechanism string `yaml:"mechanism"`
+	Username  string `yaml:"username"`
+	Password  string `yaml:"password"`
+}
+
+// BrokerList returns the addresses in the comma-separated Brokers.
+func (k KafkaConfig) BrokerList() []string {
+	var brokers []string
+	for _, broker := range strings.Split(k.Brokers, ",") {
+		if broker = strings.TrimSpace(broker); broker != "" {
+			brokers = append(brokers, broker)
+		}
+	}
+	return brokers
+}
+
+// KarenConfig represents the configuration of the Karen client.
+type KarenConfig struct {
+	Server  string   `yaml:"server"`
+	Timeout Duration `yaml:"timeout"`
+}
+
+// HTTPConfig represents the configuration of the HTTP server.
+type HTTPConfig struct {
+	Address      string   `yaml:"address"`
+	ReadTimeout  Duration `yaml:"read_timeout"`
+	WriteTimeout Duration `yaml:"write_timeout"`
+	IdleTimeout  Duration `yaml:"idle_timeout"`
+}
+
+// EventsConfig represents the configuration of conversation activity logs.
+type EventsConfig struct {
+	ContentEditWindow Duration `yaml:"content_edit_window"`
+}
+
+// WebhooksConfig represents the configuration of deliveries to the webhooks of
+// conversations.
+type WebhooksConfig struct {
+	Workers             int      `yaml:"workers"`
+	MaxAttempts         int      `yaml:"max_attempts"`
+	RetryBackoff        Duration `yaml:"retry_backoff"`
+	DisableAfter        int      `yaml:"disable_after"`
+	ContentEditDebounce Duration `yaml:"content_edit_debounce"`
+	Timeout             Duration `yaml:"timeout"`
+
+	// AllowPrivateNetworks lets webhooks be registered for and sent to
+	// loopback, private, link-local and unspecified addresses.
+	AllowPrivateNetworks bool `yaml:"allow_private_networks"`
+}
+
+// RateLimitConfig represents the configuration of the rate limits on the API.
+// A limit of 0 disables it.
+type RateLimitConfig struct {
+	ReadsPerMinute           int `yaml:"reads_per_minute"`
+	ReadBurst                int `yaml:"read_burst"`
+	WritesPerMinute          int `yaml:"writes_per_minute"`
+	WriteBurst               int `yaml:"write_burst"`
+	MembershipChangesPerHour int `yaml:"membership_changes_per_hour"`
+}
+
+// QuotasConfig represents the configuration of the limits on what users can
+// create. A limit of 0 disables it.
+type QuotasConfig struct {
+	ConversationsPerUser   int `yaml:"conversations_per_user"`
+	MembersPerConversation int `yaml:"members_per_conversation"`
+	MaxContentBytes        int `yaml:"max_content_bytes"`
+}
+
+// Duration is a time.Duration that is written as a string such as "5s" in
+// config files.
+type Duration time.Duration
+
+// UnmarshalYAML parses a duration string such as "5s".
+func (d *Duration) UnmarshalYAML(value *yaml.Node) error {
+	duration, err := time.ParseDuration(value.Value)
+	if err != nil {
+		return err
+	}
+	*d = Duration(duration)
+	return nil
+}
+
+// MarshalYAML writes the duration as a string such as "5s".
+func (d Duration) MarshalYAML() (interface{}, error) {
+	return time.Duration(d).String(), nil
+}
+
+// Default returns the configuration used for anything that is not set in a
+// config file, environment variable or flag.
+func Default() *Config {
+	return &Config{
+		Karen: KarenConfig{
+			Timeout: Duration(2 * time.Second),
+		},
+		HTTP: HTTPConfig{
+			Address:      ":80",
+			ReadTimeout:  Duration(5 * time.Second),
+			WriteTimeout: Duration(5 * time.Second),
+			IdleTimeout:  Duration(120 * time.Second),
+		},
+		Events: EventsConfig{
+			ContentEditWindow: Duration(5 * time.Minute),
+		},
+		Webhooks: WebhooksConfig{
+			Workers:             4,
+			MaxAttempts:         5,
+			RetryBackoff:        Duration(time.Second),
+			DisableAfter:        10,
+			ContentEditDebounce: Duration(time.Minute),
+			Timeout:             Duration(10 * time.Second),
+		},
+		RateLimit: RateLimitConfig{
+			ReadsPerMinute:  600,
+			ReadBurst:       100,
+			WritesPerMinute: 120,
+			WriteBurst:      30,
+		},
+		LogLevel:       "info",
+		TracesExporter: tracing.ExporterNone,
+
+		// Kept below ECS's default 30 second stop timeout, after which the
+		// container is killed
+		ShutdownTimeout: Duration(25 * time.Second),
+	}
+}
+
+// setting represents a single configuration value that can be set by an
+// environment variable or a flag.
+type setting struct {
+	env   string
+	flag  string
+	usage string
+	set   func(value string) error
+}
+
+func stringSetting(env, flag, usage string, p *string) setting {
+	return setting{env: env, flag: flag, usage: usage, set: func(value string) error {
+		*p = value
+		return nil
+	}}
+}
+
+func boolSetting(env, flag, usage string, p *bool) setting {
+	return setting{env: env, flag: flag, usage: usage, set: func(value string) error {
+		b, err := strconv.ParseBool(value)
+		if err != nil {
+			return err
+		}
+		*p = b
+		return nil
+	}}
+}
+
+func intSetting(env, flag, usage string, p *int) setting {
+	return setting{env: env, flag: flag, usage: usage, set: func(value string) error {
+		i, err := strconv.Atoi(value)
+		if err != nil {
+			return err
+		}
+		*p = i
+		return nil
+	}}
+}
+
+func durationSetting(env, flag, usage string, p *Duration) setting {
+	return setting{env: env, flag: flag, usage: usage, set: func(value string) error {
+		duration, err := time.ParseDuration(value)
+		if err != nil {
+			return err
+		}
+		*p = Duration(duration)
+		return nil
+	}}
+}
+
+// settings returns every value of c that can be set by an environment variable
+// or a flag.
+func (c *Config) settings() []setting {
+	return []setting{
+		stringSetting("ETHER_DB_USERNAME", "db-username", "username for accessing MariaDB", &c.DB.Username),
+		stringSetting("ETHER_DB_PASSWORD", "db-password", "password for accessing MariaDB", &c.DB.Password),
+		stringSetting("ETHER_DB_LOCATION", "db-location", "host and port where MariaDB is located", &c.DB.Location),
+		stringSetting("ETHER_CONTENT_DIR", "content-dir", "directory where conversation content HTML files are stored", &c.ContentDir),
+		stringSetting("ETHER_KAFKA_BROKERS", "kafka-brokers", "comma-separated hosts and ports of Kafka brokers", &c.Kafka.Brokers),
+		stringSetting("ETHER_KAFKA_TOPIC", "kafka-topic", "Kafka topic of conversation updates", &c.Kafka.Topic),
+		stringSetting("ETHER_KAFKA_PUBLISH_TOPIC", "kafka-publish-topic", "Kafka topic to publish content edits made through the API to", &c.Kafka.PublishTopic),
+		boolSetting("ETHER_KAFKA_TLS_ENABLED", "kafka-tls-enabled", "connect to Kafka over TLS", &c.Kafka.TLS.Enabled),
+		stringSetting("ETHER_KAFKA_TLS_CA_FILE", "kafka-tls-ca-file", "CA certificates to verify Kafka brokers with", &c.Kafka.TLS.CAFile),
+		stringSetting("ETHER_KAFKA_TLS_CERT_FILE", "kafka-tls-cert-file", "client certificate for Kafka", &c.Kafka.TLS.CertFile),
+		stringSetting("ETHER_KAFKA_TLS_KEY_FILE", "kafka-tls-key-file", "client key for Kafka", &c.Kafka.TLS.KeyFile),
+		boolSetting("ETHER_KAFKA_TLS_INSECURE_SKIP_VERIFY", "kafka-tls-insecure-skip-verify", "skip verifying Kafka broker certificates", &c.Kafka.TLS.InsecureSkipVerify),
+		stringSetting("ETHER_KAFKA_SASL_MECHANISM", "kafka-sasl-mechanism", "SASL mechanism for Kafka", &c.Kafka.SASL.Mechanism),
+		stringSetting("ETHER_KAFKA_SASL_USERNAME", "kafka-sasl-username", "SASL username for Kafka", &c.Kafka.SASL.Username),
+		stringSetting("ETHER_KAFKA_SASL_PASSWORD", "kafka-sasl-password", "SASL password for Kafka", &c.Kafka.SASL.Password),
+		stringSetting("ETHER_KAREN_SERVER", "karen-server", "host and port where Karen, the user service, is located", &c.Karen.Server),
+		durationSetting("ETHER_KAREN_TIMEOUT", "karen-timeout", "timeout of each request to Karen", &c.Karen.Timeout),
+		stringSetting("ETHER_HTTP_ADDRESS", "http-address", "address the HTTP server listens on", &c.HTTP.Address),
+		durationSetting("ETHER_HTTP_READ_TIMEOUT", "http-read-timeout", "HTTP server read timeout", &c.HTTP.ReadTimeout),
+		durationSetting("ETHER_HTTP_WRITE_TIMEOUT", "http-write-timeout", "HTTP server write timeout", &c.HTTP.WriteTimeout),
+		durationSetting("ETHER_HTTP_IDLE_TIMEOUT", "http-idle-timeout", "HTTP server idle timeout", &c.HTTP.IdleTimeout),
+		durationSetting("ETHER_EVENTS_CONTENT_EDIT_WINDOW", "events-content-edit-window", "how long a user must stop editing before their edits are recorded as one event", &c.Events.ContentEditWindow),
+		intSetting("ETHER_WEBHOOKS_WORKERS", "webhooks-workers", "how many events are delivered to webhooks at a time", &c.Webhooks.Workers),
+		intSetting("ETHER_WEBHOOKS_MAX_ATTEMPTS", "webhooks-max-attempts", "how many times an event is sent to a webhook before the delivery fails", &c.Webhooks.MaxAttempts),
+		durationSetting("ETHER_WEBHOOKS_RETRY_BACKOFF", "webhooks-retry-backoff", "how long to wait before retrying a webhook delivery, doubled for each retry", &c.Webhooks.RetryBackoff),
+		intSetting("ETHER_WEBHOOKS_DISABLE_AFTER", "webhooks-disable-after", "how many failed deliveries in a row disable a webhook", &c.Webhooks.DisableAfter),
+		durationSetting("ETHER_WEBHOOKS_CONTENT_EDIT_DEBOUNCE", "webhooks-content-edit-debounce", "how long a conversation must not be edited before its edits are sent to webhooks", &c.Webhooks.ContentEditDebounce),
+		durationSetting("ETHER_WEBHOOKS_TIMEOUT", "webhooks-timeout", "timeout of each request to a webhook", &c.Webhooks.Timeout),
+		boolSetting("ETHER_WEBHOOKS_ALLOW_PRIVATE_NETWORKS", "webhooks-allow-private-networks", "allow webhooks to loopback, private, link-local and unspecified addresses", &c.Webhooks.AllowPrivateNetworks),
+		intSetting("ETHER_RATE_LIMIT_READS_PER_MINUTE", "rate-limit-reads-per-minute", "how many reads each user can make to each API per minute, or 0 for no limit", &c.RateLimit.ReadsPerMinute),
+		intSetting("ETHER_RATE_LIMIT_READ_BURST", "rate-limit-read-burst", "how many reads each user can make to each API at once", &c.RateLimit.ReadBurst),
+		intSetting("ETHER_RATE_LIMIT_WRITES_PER_MINUTE", "rate-limit-writes-per-minute", "how many writes each user can make to each API per minute, or 0 for no limit", &c.RateLimit.WritesPerMinute),
+		intSetting("ETHER_RATE_LIMIT_WRITE_BURST", "rate-limit-write-burst", "how many writes each user can make to each API at once", &c.RateLimit.WriteBurst),
+		intSetting("ETHER_RATE_LIMIT_MEMBERSHIP_CHANGES_PER_HOUR", "rate-limit-membership-changes-per-hour", "how many members each user can add to or remove from each conversation per hour, or 0 for no limit", &c.RateLimit.MembershipChangesPerHour),
+		intSetting("ETHER_QUOTAS_CONVERSATIONS_PER_USER", "quotas-conversations-per-user", "how many conversations a user can own, or 0 for no limit", &c.Quotas.ConversationsPerUser),
+		intSetting("ETHER_QUOTAS_MEMBERS_PER_CONVERSATION", "quotas-members-per-conversation", "how many members a conversation can have, or 0 for no limit", &c.Quotas.MembersPerConversation),
+		intSetting("ETHER_QUOTAS_MAX_CONTENT_BYTES", "quotas-max-content-bytes", "size in bytes that edits cannot grow a conversation's content past, or 0 for no limit", &c.Quotas.MaxContentBytes),
+		stringSetting("ETHER_LOG_LEVEL", "log-level", "minimum level of log entries to write", &c.LogLevel),
+		stringSetting("ETHER_TRACES_EXPORTER", "traces-exporter", "where to export traces", &c.TracesExporter),
+		durationSetting("ETHER_SHUTDOWN_TIMEOUT", "shutdown-timeout", "how long to wait for in-flight work when shutting down", &c.ShutdownTimeout),
+	}
+}
+
+// Load builds the configuration from, in increasing order of precedence, the
+// defaults, the YAML config file given by the -config flag or the
+// ETHER_CONFIG_FILE environment variable, environment variables and flags. It
+// returns the configuration and the arguments that follow the flags.
+func Load(args []string) (*Config, []string, error) {
+	c := Default()
+	settings := c.settings()
+
+	flags := flag.NewFlagSet("ether", flag.ContinueOnError)
+	configFile := flags.String("config", os.Getenv("ETHER_CONFIG_FILE"), "path to a YAML config file")
+	flagValues := make(map[string]setting, len(settings))
+	for _, s := range settings {
+		flags.String(s.flag, "", s.usage+" (env "+s.env+")")
+		flagValues[s.flag] = s
+	}
+	if err := flags.Parse(args); err != nil {
+		return nil, nil, err
+	}
+
+	if *configFile != "" {
+		if err := c.loadFile(*configFile); err != nil {
+			return nil, nil, fmt.Errorf("Failed to load config file %s: %v", *configFile, err)
+		}
+	}
+
+	for _, s := range settings {
+		if value, ok := os.LookupEnv(s.env); ok {
+			if err := s.set(value); err != nil {
+				return nil, nil, fmt.Errorf("Invalid %s: %v", s.env, err)
+			}
+		}
+	}
+
+	var err error
+	flags.Visit(func(f *flag.Flag) {
+		s, ok := flagValues[f.Name]
+		if !ok || err != nil {
+			return
+		}
+		if setErr := s.set(f.Value.String()); setErr != nil {
+			err = fmt.Errorf("Invalid -%s: %v", f.Name, setErr)
+		}
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return c, flags.Args(), nil
+}
+
+// loadFile overwrites c with every value set in the YAML file at path.
+func (c *Config) loadFile(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	decoder := yaml.NewDecoder(f)
+	decoder.KnownFields(true)
+	if err := decoder.Decode(c); err != nil && err != io.EOF {
+		return err
+	}
+	return nil
+}
+
+// Validate checks that every required value is set and every value is valid,
+// and returns an error describing every problem found.
+func (c *Config) Validate() error {
+	var errs []error
+	required := func(name, value string) {
+		if value == "" {
+			errs = append(errs, fmt.Errorf("%s is required", name))
+		}
+	}
+	positive := func(name string, value Duration) {
+		if value <= 0 {
+			errs = append(errs, fmt.Errorf("%s must be positive", name))
+		}
+	}
+	positiveInt := func(name string, value int) {
+		if value <= 0 {
+			errs = append(errs, fmt.Errorf("%s must be positive", name))
+		}
+	}
+
+	required("db.username", c.DB.Username)
+	required("db.location", c.DB.Location)
+	required("kafka.topic", c.Kafka.Topic)
+	if len(c.Kafka.BrokerList()) == 0 {
+		errs = append(errs, errors.New("kafka.brokers is required"))
+	}
+	if c.Kafka.PublishTopic != "" && c.Kafka.PublishTopic == c.Kafka.Topic {
+		errs = append(errs, errors.New("kafka.publish_topic must not be kafka.topic, or edits would be applied twice"))
+	}
+	if (c.Kafka.TLS.CertFile == "") != (c.Kafka.TLS.KeyFile == "") {
+		errs = append(errs, errors.New("kafka.tls.cert_file and kafka.tls.key_file must be set together"))
+	}
+	switch strings.ToLower(c.Kafka.SASL.Mechanism) {
+	case "":
+	case kafka.SASLPlain, kafka.SASLScramSHA256, kafka.SASLScramSHA512:
+		required("kafka.sasl.username", c.Kafka.SASL.Username)
+	default:
+		errs = append(errs, fmt.Errorf("kafka.sasl.mechanism: invalid value %q", c.Kafka.SASL.Mechanism))
+	}
+	required("karen.server", c.Karen.Server)
+	required("http.address", c.HTTP.Address)
+
+	if c.ContentDir == "" {
+		errs = append(errs, errors.New("content_dir is required"))
+	} else if info, err := os.Stat(c.ContentDir); err != nil {
+		errs = append(errs, fmt.Errorf("content_dir: %v", err))
+	} else if !info.IsDir() {
+		errs = append(errs, fmt.Errorf("content_dir: %s is not a directory", c.ContentDir))
+	}
+
+	positive("karen.timeout", c.Karen.Timeout)
+	positive("http.read_timeout", c.HTTP.ReadTimeout)
+	positive("http.write_timeout", c.HTTP.WriteTimeout)
+	positive("http.idle_timeout", c.HTTP.IdleTimeout)
+	positive("events.content_edit_window", c.Events.ContentEditWindow)
+	positive("webhooks.retry_backoff", c.Webhooks.RetryBackoff)
+	positive("webhooks.content_edit_debounce", c.Webhooks.ContentEditDebounce)
+	positive("webhooks.timeout", c.Webhooks.Timeout)
+	positiveInt("webhooks.workers", c.Webhooks.Workers)
+	positiveInt("webhooks.max_attempts", c.Webhooks.MaxAttempts)
+	positiveInt("webhooks.disable_after", c.Webhooks.DisableAfter)
+	limit := func(name string, value int) {
+		if value < 0 {
+			errs = append(errs, fmt.Errorf("%s must not be negative", name))
+		}
+	}
+	limit("rate_limit.reads_per_minute", c.RateLimit.ReadsPerMinute)
+	if c.RateLimit.ReadsPerMinute > 0 {
+		positiveInt("rate_limit.read_burst", c.RateLimit.ReadBurst)
+	}
+	limit("rate_limit.writes_per_minute", c.RateLimit.WritesPerMinute)
+	if c.RateLimit.WritesPerMinute > 0 {
+		positiveInt("rate_limit.write_burst", c.RateLimit.WriteBurst)
+	}
+	limit("rate_limit.membership_changes_per_hour", c.RateLimit.MembershipChangesPerHour)
+	limit("quotas.conversations_per_user", c.Quotas.ConversationsPerUser)
+	limit("quotas.members_per_conversation", c.Quotas.MembersPerConversation)
+	limit("quotas.max_content_bytes", c.Quotas.MaxContentBytes)
+	positive("shutdown_timeout", c.ShutdownTimeout)
+
+	if _, err := logging.ParseLevel(c.LogLevel); err != nil {
+		errs = append(errs, fmt.Errorf("log_level: %v", err))
+	}
+	switch c.TracesExporter {
+	case tracing.ExporterNone, tracing.ExporterOTLP, tracing.ExporterStdout:
+	default:
+		errs = append(errs, fmt.Errorf("traces_exporter: invalid value %q", c.TracesExporter))
+	}
+
+	return errors.Join(errs...)
+}
+
+// Print writes c as YAML with its secrets redacted.
+func (c *Config) Print(w io.Writer) error {
+	printed := *c
+	if printed.DB.Password != "" {
+		printed.DB.Password = redacted
+	}
+	if printed.Kafka.SASL.Password != "" {
+		printed.Kafka.SASL.Password = redacted
+	}
+
+	encoder := yaml.NewEncoder(w)
+	encoder.SetIndent(2)
+	if err := encoder.Encode(&printed); err != nil {
+		return err
+	}
+	return encoder.Close()
+}
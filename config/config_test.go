@@ -0,0 +1,128 @@
+package config
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLoadPrecedence(t *testing.T) {
+	dir := t.TempDir()
+	file := filepath.Join(dir, "ether.yaml")
+	contents := "db:\n  username: file_user\n  location: file:3306\nkafka:\n  topic: file_topic\n"
+	if err := os.WriteFile(file, []byte(contents), 0600); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("ETHER_DB_LOCATION", "env:3306")
+	t.Setenv("ETHER_KAFKA_TOPIC", "env_topic")
+
+	c, args, err := Load([]string{"-config", file, "-kafka-topic", "flag_topic", "reindex"})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	if c.DB.Username != "file_user" {
+		t.Errorf("Expected db.username from file, got %q", c.DB.Username)
+	}
+	if c.DB.Location != "env:3306" {
+		t.Errorf("Expected db.location from env, got %q", c.DB.Location)
+	}
+	if c.Kafka.Topic != "flag_topic" {
+		t.Errorf("Expected kafka.topic from flag, got %q", c.Kafka.Topic)
+	}
+	if time.Duration(c.HTTP.ReadTimeout) != 5*time.Second {
+		t.Errorf("Expected default http.read_timeout, got %v", time.Duration(c.HTTP.ReadTimeout))
+	}
+	if len(args) != 1 || args[0] != "reindex" {
+		t.Errorf("Expected remaining args [reindex], got %v", args)
+	}
+}
+
+func TestLoadInvalid(t *testing.T) {
+	tests := []struct {
+		Name string
+		Args []string
+		Env  map[string]string
+	}{
+		{
+			Name: "Invalid duration env",
+			Env:  map[string]string{"ETHER_HTTP_READ_TIMEOUT": "soon"},
+		},
+		{
+			Name: "Invalid duration flag",
+			Args: []string{"-shutdown-timeout", "soon"},
+		},
+		{
+			Name: "Unknown flag",
+			Args: []string{"-port", "80"},
+		},
+		{
+			Name: "Missing config file",
+			Args: []string{"-config", "/nonexistent/ether.yaml"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.Name, func(t *testing.T) {
+			for key, value := range test.Env {
+				t.Setenv(key, value)
+			}
+			if _, _, err := Load(test.Args); err == nil {
+				t.Error("Expected an error, got nil")
+			}
+		})
+	}
+}
+
+func TestValidate(t *testing.T) {
+	c := Default()
+	c.DB.Username = "ether"
+	c.DB.Location = "localhost:3306"
+	c.ContentDir = t.TempDir()
+	c.Kafka.Brokers = "localhost:9092, localhost:9093"
+	c.Kafka.Topic = "updates"
+	c.Karen.Server = "localhost:81"
+	if err := c.Validate(); err != nil {
+		t.Errorf("Unexpected error: %v", err)
+	}
+	if brokers := c.Kafka.BrokerList(); !reflect.DeepEqual(brokers, []string{"localhost:9092", "localhost:9093"}) {
+		t.Errorf("Expected 2 brokers, got %v", brokers)
+	}
+
+	c.LogLevel = "loud"
+	c.HTTP.WriteTimeout = 0
+	c.Kafka.SASL.Mechanism = "kerberos"
+	c.Kafka.PublishTopic = c.Kafka.Topic
+	c.Webhooks.MaxAttempts = 0
+	c.RateLimit.WriteBurst = 0
+	c.Quotas.MaxContentBytes = -1
+	err := c.Validate()
+	if err == nil {
+		t.Fatal("Expected an error, got nil")
+	}
+	for _, name := range []string{"log_level", "http.write_timeout", "kafka.sasl.mechanism", "kafka.publish_topic", "webhooks.max_attempts", "rate_limit.write_burst", "quotas.max_content_bytes"} {
+		if !strings.Contains(err.Error(), name) {
+			t.Errorf("Expected error to mention %s, got %q", name, err)
+		}
+	}
+}
+
+func TestPrintRedactsSecrets(t *testing.T) {
+	c := Default()
+	c.DB.Password = "hunter2"
+
+	var b bytes.Buffer
+	if err := c.Print(&b); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if strings.Contains(b.String(), "hunter2") {
+		t.Errorf("Printed config contains the DB password: %s", b.String())
+	}
+	if !strings.Contains(b.String(), "password: "+redacted) {
+		t.Errorf("Printed config does not redact the DB password: %s", b.String())
+	}
+}
@@ -13,10 +13,132 @@ CREATE TABLE IF NOT EXISTS conversations (
 CREATE TABLE IF NOT EXISTS users_to_conversations (
     UserID INTEGER NOT NULL,
     ConversationID INTEGER NOT NULL,
-    Role ENUM('owner', 'admin', 'user'),
+    Role ENUM('owner', 'admin', 'user', 'service'),
     Nickname VARCHAR(255),
     Pending TINYINT(1),
     LastOpened TIMESTAMP,
     FOREIGN KEY (ConversationID) REFERENCES conversations(ID),
     PRIMARY KEY(UserID, ConversationID)
 );
+
+ALTER TABLE users_to_conversations
+    MODIFY Role ENUM('owner', 'admin', 'user', 'service');
+
+CREATE TABLE IF NOT EXISTS conversation_search (
+    ConversationID INTEGER NOT NULL,
+    Content MEDIUMTEXT,
+    FOREIGN KEY (ConversationID) REFERENCES conversations(ID),
+    PRIMARY KEY(ConversationID),
+    FULLTEXT(Content)
+);
+
+CREATE TABLE IF NOT EXISTS conversation_carets (
+    UserID INTEGER NOT NULL,
+    ConversationID INTEGER NOT NULL,
+    CaretStart INTEGER NOT NULL,
+    CaretEnd INTEGER NOT NULL,
+    FOREIGN KEY (ConversationID) REFERENCES conversations(ID),
+    PRIMARY KEY(UserID, ConversationID)
+);
+
+CREATE TABLE IF NOT EXISTS conversation_revisions (
+    ConversationID INTEGER NOT NULL,
+    Version BIGINT NOT NULL,
+    UserID INTEGER,
+    Patch MEDIUMTEXT NOT NULL,
+    CreatedAt TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    FOREIGN KEY (ConversationID) REFERENCES conversations(ID),
+    PRIMARY KEY(ConversationID, Version)
+);
+
+ALTER TABLE conversation_revisions
+    ADD COLUMN IF NOT EXISTS Hash CHAR(64),
+    ADD COLUMN IF NOT EXISTS Reset BOOLEAN NOT NULL DEFAULT FALSE;
+
+CREATE INDEX IF NOT EXISTS ConversationResets ON conversation_revisions(ConversationID, Reset, Version);
+
+CREATE TABLE IF NOT EXISTS conversation_snapshots (
+    ConversationID INTEGER NOT NULL,
+    Version BIGINT NOT NULL,
+    Content MEDIUMTEXT NOT NULL,
+    FOREIGN KEY (ConversationID) REFERENCES conversations(ID),
+    PRIMARY KEY(ConversationID, Version)
+);
+
+CREATE TABLE IF NOT EXISTS conversation_events (
+    ID BIGINT NOT NULL AUTO_INCREMENT,
+    ConversationID INTEGER NOT NULL,
+    ActorID INTEGER,
+    Action VARCHAR(64) NOT NULL,
+    TargetID INTEGER,
+    BeforeValue TEXT,
+    AfterValue TEXT,
+    CreatedAt TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY(ID),
+    INDEX(ConversationID, ID)
+);
+
+CREATE TABLE IF NOT EXISTS service_accounts (
+    ID INTEGER NOT NULL AUTO_INCREMENT,
+    ConversationID INTEGER NOT NULL,
+    Name VARCHAR(255) NOT NULL,
+    CreatorID INTEGER NOT NULL,
+    CreatedAt TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    FOREIGN KEY (ConversationID) REFERENCES conversations(ID),
+    PRIMARY KEY(ID)
+) AUTO_INCREMENT=2000000000;
+
+CREATE TABLE IF NOT EXISTS api_tokens (
+    ID INTEGER NOT NULL AUTO_INCREMENT,
+    ServiceAccountID INTEGER NOT NULL,
+    ConversationID INTEGER NOT NULL,
+    Name VARCHAR(255) NOT NULL,
+    Scopes VARCHAR(255) NOT NULL,
+    Prefix VARCHAR(32) NOT NULL,
+    Hash CHAR(64) NOT NULL,
+    CreatorID INTEGER NOT NULL,
+    CreatedAt TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    FOREIGN KEY (ServiceAccountID) REFERENCES service_accounts(ID),
+    FOREIGN KEY (ConversationID) REFERENCES conversations(ID),
+    PRIMARY KEY(ID),
+    UNIQUE(Hash),
+    INDEX(ConversationID)
+);
+
+CREATE TABLE IF NOT EXISTS webhooks (
+    ID INTEGER NOT NULL AUTO_INCREMENT,
+    ConversationID INTEGER NOT NULL,
+    URL VARCHAR(2048) NOT NULL,
+    Secret VARCHAR(255) NOT NULL,
+    Events VARCHAR(255) NOT NULL,
+    Enabled TINYINT(1) NOT NULL,
+    ConsecutiveFailures INTEGER NOT NULL DEFAULT 0,
+    CreatorID INTEGER NOT NULL,
+    CreatedAt TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY(ID),
+    INDEX(ConversationID)
+);
+
+CREATE TABLE IF NOT EXISTS webhook_deliveries (
+    ID BIGINT NOT NULL AUTO_INCREMENT,
+    WebhookID INTEGER NOT NULL,
+    EventID BIGINT NOT NULL,
+    Action VARCHAR(64) NOT NULL,
+    Attempt INTEGER NOT NULL,
+    StatusCode INTEGER,
+    Error TEXT,
+    DurationMS BIGINT NOT NULL,
+    CreatedAt TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    FOREIGN KEY (WebhookID) REFERENCES webhooks(ID),
+    PRIMARY KEY(ID),
+    INDEX(WebhookID, ID)
+);
+
+CREATE TABLE IF NOT EXISTS conversation_edit_batches (
+    ConversationID INTEGER NOT NULL,
+    UserID INTEGER NOT NULL,
+    Edits INTEGER NOT NULL,
+    FirstEditAt DATETIME NOT NULL,
+    LastEditAt DATETIME NOT NULL,
+    PRIMARY KEY(ConversationID, UserID)
+);
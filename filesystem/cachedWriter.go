@@ -1,75 +1,315 @@
 package filesystem
 
 import (
-	"log"
+	"context"
+	"errors"
+	"ether/logging"
+	"ether/metrics"
+	"ether/tracing"
+	"fmt"
+	"sync/atomic"
 	"time"
 
 	"github.com/sergi/go-diff/diffmatchpatch"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const (
+	writeQueueSize = 1024
+
+	// stallTimeout is how long updates can wait in the queue without any of
+	// them being processed before the CachedWriter is considered stalled.
+	stallTimeout = 30 * time.Second
 )
 
 var dmp *diffmatchpatch.DiffMatchPatch = diffmatchpatch.New()
 
+var (
+	// ErrInvalidPatch is the error of an Update whose patch cannot be parsed.
+	ErrInvalidPatch = errors.New("Invalid patch")
+
+	// ErrPatchNotApplied is the error of an Update whose patch did not apply
+	// to the content.
+	ErrPatchNotApplied = errors.New("Patch did not apply to the content")
+
+	// ErrContentTooLarge is the error of an Update that would grow the content
+	// past the CachedWriter's MaxContentSize.
+	ErrContentTooLarge = errors.New("Content is too large")
+)
+
 // File represents a cached file.
 type File struct {
 	content      string
 	lastReadTime time.Time
 }
 
-// Update represents a conversation content file update.
+// Operation represents how an Update changes a content file.
+type Operation int
+
+const (
+	// OperationPatch applies the Update's Patch to the content.
+	OperationPatch Operation = iota
+
+	// OperationAppend adds the Update's Content to the end of the content.
+	OperationAppend
+
+	// OperationReplace replaces the content with the Update's Content.
+	OperationReplace
+)
+
+// Update represents a conversation content file update. Context, if not nil,
+// carries the trace of the message that produced the update. UserID, if not
+// nil, is the author of the update.
 type Update struct {
+	Context        context.Context
 	ConversationID int64
+	UserID         *int64
+	Operation      Operation
 	Patch          string
+	Content        string
+
+	// Result, if not nil, is sent the result of the update once it has been
+	// processed. It should be buffered so that the CachedWriter never waits
+	// for it to be received.
+	Result chan<- UpdateResult
+
+	// OnApplied, if not nil, is called once the update has been applied and
+	// before the next update is, so that what it does happens in the same
+	// order as the updates. It is not called for an update that fails.
+	OnApplied func(ctx context.Context, result UpdateResult) error
+}
+
+// UpdateResult represents the outcome of an Update. Patch is the patch that
+// changed the content, made from the content itself, and is empty if the
+// content did not change. AppliedErr is the error returned by the Update's
+// OnApplied, in which case the content was still changed.
+type UpdateResult struct {
+	Patch      string
+	Err        error
+	AppliedErr error
+}
+
+// Indexer represents a consumer of updated content that keeps a search index
+// of it.
+type Indexer interface {
+	Index(ctx context.Context, conversationID int64, content string) error
+}
+
+// Recorder represents a consumer of content changes that keeps their history.
+type Recorder interface {
+	Record(ctx context.Context, conversationID int64, userID *int64, before, after string) error
 }
 
 // CachedWriter encapsulates the behaviour of updating files in the filesytem
 // with caching capabilities to minimize I/O operations.
 type CachedWriter struct {
 	directory *Directory
+	indexer   Indexer
+	recorder  Recorder
+	logger    *logging.Logger
 	files     map[int64]File
 	Write     chan *Update
+
+	// MaxContentSize, if positive, is the size in bytes that updates cannot
+	// grow a content file past. Updates that shrink a file that is already
+	// larger are still applied.
+	MaxContentSize int
+
+	// lastActivity is the Unix time in nanoseconds at which an update last
+	// started or finished being processed, or at which the CachedWriter was
+	// created.
+	lastActivity int64
 }
 
-// NewCachedWriter initializes a new CachedWriter.
-func NewCachedWriter(directory *Directory) *CachedWriter {
+// NewCachedWriter initializes a new CachedWriter. The indexer, if not nil, is
+// given the new content of every file that is written, and the recorder, if
+// not nil, is given every change.
+func NewCachedWriter(directory *Directory, indexer Indexer, recorder Recorder, logger *logging.Logger) *CachedWriter {
 	return &CachedWriter{
 		directory: directory,
+		indexer:   indexer,
+		recorder:  recorder,
+		logger:    logger,
 		files:     make(map[int64]File),
-		Write:     make(chan *Update),
+		Write:     make(chan *Update, writeQueueSize),
+
+		lastActivity: time.Now().UnixNano(),
 	}
 }
 
-// Run loops indefinitely and blocks on a channel where Update structs will come
-// in and be processed one by one.
-func (cw *CachedWriter) Run() {
+// QueueDepth returns the number of updates waiting to be processed.
+func (cw *CachedWriter) QueueDepth() int {
+	return len(cw.Write)
+}
+
+// CheckQueue returns an error if the update queue is full or if updates are
+// waiting in it but none have been processed recently.
+func (cw *CachedWriter) CheckQueue() error {
+	depth := cw.QueueDepth()
+	if depth >= cap(cw.Write) {
+		return fmt.Errorf("Update queue is full (%d updates)", depth)
+	}
+
+	lastActivity := time.Unix(0, atomic.LoadInt64(&cw.lastActivity))
+	if idle := time.Since(lastActivity); depth > 0 && idle > stallTimeout {
+		return fmt.Errorf(
+			"Update queue has %d updates but none were processed in %s",
+			depth,
+			idle.Round(time.Second),
+		)
+	}
+	return nil
+}
+
+// Run blocks on a channel where Update structs will come in and processes them
+// one by one until ctx is cancelled. It then processes the updates that are
+// already queued before returning, so nothing should be sent to the channel
+// after ctx is cancelled.
+func (cw *CachedWriter) Run(ctx context.Context) {
 	/* TODO: cache the file content so that we don't have to read and write the
 	   file every time a new Update comes in. */
 
 	for {
 		select {
 		case update := <-cw.Write:
-			content, err := cw.directory.ReadFile(update.ConversationID)
-			if err != nil {
-				log.Printf("Failed to read content file: %v", err)
-				continue
-			}
-
-			patches, err := dmp.PatchFromText(update.Patch)
-			if err != nil {
-				log.Printf("Could not process patch string: %s", update.Patch)
-				continue
-			}
-
-			newContent, okList := dmp.PatchApply(patches, string(content))
-			if !okList[0] {
-				log.Printf("Could not apply patch: %s", update.Patch)
-				continue
-			}
-
-			err = cw.directory.WriteFile(update.ConversationID, []byte(newContent))
-			if err != nil {
-				log.Printf("Failed to write content file: %v", err)
-				continue
-			}
+			cw.process(update)
+		case <-ctx.Done():
+			cw.flush()
+			return
+		}
+	}
+}
+
+// flush processes every update in the queue without waiting for more.
+func (cw *CachedWriter) flush() {
+	cw.logger.Infof("Flushing %d queued content updates", cw.QueueDepth())
+	for {
+		select {
+		case update := <-cw.Write:
+			cw.process(update)
+		default:
+			return
+		}
+	}
+}
+
+// process applies an update, sends its result if it has a Result channel and
+// records the activity for CheckQueue.
+func (cw *CachedWriter) process(update *Update) {
+	atomic.StoreInt64(&cw.lastActivity, time.Now().UnixNano())
+	result := cw.apply(update)
+	if update.Result != nil {
+		update.Result <- result
+	}
+	atomic.StoreInt64(&cw.lastActivity, time.Now().UnixNano())
+}
+
+// newContent returns the content that an Update changes content to.
+func (update *Update) newContent(content string) (string, error) {
+	switch update.Operation {
+	case OperationAppend:
+		return content + update.Content, nil
+	case OperationReplace:
+		return update.Content, nil
+	}
+
+	patches, err := dmp.PatchFromText(update.Patch)
+	if err != nil {
+		return "", fmt.Errorf("%w: %v", ErrInvalidPatch, err)
+	}
+	// A patch is applied whole or not at all, rather than leaving the content
+	// with only some of its hunks
+	newContent, okList := dmp.PatchApply(patches, content)
+	for _, ok := range okList {
+		if !ok {
+			return "", ErrPatchNotApplied
+		}
+	}
+	return newContent, nil
+}
+
+// apply applies a single Update to its conversation content file.
+func (cw *CachedWriter) apply(update *Update) UpdateResult {
+	start := time.Now()
+
+	ctx := update.Context
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	ctx, span := tracing.Tracer().Start(
+		ctx,
+		"CachedWriter.apply",
+		trace.WithAttributes(attribute.Int64("conversation.id", update.ConversationID)),
+	)
+	defer span.End()
+	logger := cw.logger.WithContext(ctx).With("conversation_id", update.ConversationID)
+
+	content, err := cw.directory.ReadFile(update.ConversationID)
+	if err != nil {
+		logger.Errorf("Failed to read content file: %v", err)
+		metrics.WriterPatchFailures.WithLabelValues("read").Inc()
+		tracing.Fail(span, err)
+		return UpdateResult{Err: err}
+	}
+
+	newContent, err := update.newContent(string(content))
+	if err == ErrPatchNotApplied {
+		logger.Warnf("Could not apply patch: %s", update.Patch)
+		metrics.WriterPatchFailures.WithLabelValues("apply").Inc()
+		span.SetStatus(codes.Error, "patch did not apply")
+		return UpdateResult{Err: err}
+	} else if err != nil {
+		logger.Warnf("Could not process patch string: %s", update.Patch)
+		metrics.WriterPatchFailures.WithLabelValues("parse").Inc()
+		tracing.Fail(span, err)
+		return UpdateResult{Err: err}
+	}
+	if cw.MaxContentSize > 0 && len(newContent) > cw.MaxContentSize && len(newContent) > len(content) {
+		err := fmt.Errorf(
+			"%w: %d bytes is over the limit of %d bytes",
+			ErrContentTooLarge,
+			len(newContent),
+			cw.MaxContentSize,
+		)
+		logger.Warnf("Rejected update: %v", err)
+		metrics.WriterPatchFailures.WithLabelValues("size").Inc()
+		span.SetStatus(codes.Error, "content too large")
+		return UpdateResult{Err: err}
+	}
+	err = cw.directory.WriteFile(update.ConversationID, []byte(newContent))
+	if err != nil {
+		logger.Errorf("Failed to write content file: %v", err)
+		metrics.WriterPatchFailures.WithLabelValues("write").Inc()
+		tracing.Fail(span, err)
+		return UpdateResult{Err: err}
+	}
+	metrics.WriterBytesWritten.Add(float64(len(newContent)))
+	metrics.WriterPatchDuration.Observe(time.Since(start).Seconds())
+
+	if cw.recorder != nil {
+		if err := cw.recorder.Record(ctx, update.ConversationID, update.UserID, string(content), newContent); err != nil {
+			logger.Errorf("Failed to record content revision: %v", err)
+		}
+	}
+
+	if cw.indexer != nil {
+		if err := cw.indexer.Index(ctx, update.ConversationID, newContent); err != nil {
+			logger.Errorf("Failed to index content file: %v", err)
+		}
+	}
+
+	result := UpdateResult{}
+	if newContent != string(content) {
+		result.Patch = dmp.PatchToText(dmp.PatchMake(string(content), newContent))
+	}
+
+	if update.OnApplied != nil {
+		if err := update.OnApplied(ctx, result); err != nil {
+			logger.Errorf("Failed to process applied update: %v", err)
+			result.AppliedErr = err
 		}
 	}
+	return result
 }
@@ -0,0 +1,212 @@
+package filesystem
+
+import (
+	"context"
+	"errors"
+	"ether/logging"
+	"io/ioutil"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// newTestWriter initializes a CachedWriter on a temporary directory that has
+// an empty content file for conversation 1.
+func newTestWriter(t *testing.T) (*CachedWriter, *Directory) {
+	directory := NewDirectory(t.TempDir())
+	if err := directory.Create(1); err != nil {
+		t.Fatalf("Failed to create content file: %v", err)
+	}
+	return NewCachedWriter(directory, nil, nil, logging.New(ioutil.Discard, logging.LevelError)), directory
+}
+
+func TestCachedWriterRun(t *testing.T) {
+	writer, directory := newTestWriter(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		writer.Run(ctx)
+		close(done)
+	}()
+
+	result := make(chan UpdateResult, 1)
+	writer.Write <- &Update{
+		ConversationID: 1,
+		Operation:      OperationAppend,
+		Content:        "hello",
+		Result:         result,
+	}
+
+	select {
+	case res := <-result:
+		if res.Err != nil {
+			t.Errorf("Unexpected error: %v", res.Err)
+		}
+		if res.Patch == "" {
+			t.Error("Expected a patch, got none")
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Update was not processed")
+	}
+
+	cancel()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("Run did not return after ctx was cancelled")
+	}
+
+	if content, _ := directory.ReadFile(1); string(content) != "hello" {
+		t.Errorf("Content is incorrect, expected %q, got %q", "hello", content)
+	}
+}
+
+func TestCachedWriterRunFlushes(t *testing.T) {
+	writer, directory := newTestWriter(t)
+
+	// Run is given a cancelled ctx, so it must still process the updates that
+	// are already queued before returning
+	results := make(chan UpdateResult, 3)
+	for _, content := range []string{"a", "b", "c"} {
+		writer.Write <- &Update{
+			ConversationID: 1,
+			Operation:      OperationAppend,
+			Content:        content,
+			Result:         results,
+		}
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	writer.Run(ctx)
+
+	if depth := writer.QueueDepth(); depth != 0 {
+		t.Errorf("Expected an empty queue, got %d updates", depth)
+	}
+	if len(results) != 3 {
+		t.Errorf("Expected %d results, got %d", 3, len(results))
+	}
+	if content, _ := directory.ReadFile(1); string(content) != "abc" {
+		t.Errorf("Content is incorrect, expected %q, got %q", "abc", content)
+	}
+}
+
+func TestCachedWriterOnApplied(t *testing.T) {
+	writer, _ := newTestWriter(t)
+
+	applied := make([]string, 0)
+	onApplied := func(name string) func(context.Context, UpdateResult) error {
+		return func(ctx context.Context, result UpdateResult) error {
+			applied = append(applied, name)
+			if name == "failing" {
+				return errors.New("test error")
+			}
+			return nil
+		}
+	}
+
+	results := make(chan UpdateResult, 3)
+	writer.Write <- &Update{
+		ConversationID: 1,
+		Operation:      OperationAppend,
+		Content:        "hello",
+		Result:         results,
+		OnApplied:      onApplied("append"),
+	}
+	writer.Write <- &Update{
+		ConversationID: 1,
+		Patch:          "not a patch",
+		Result:         results,
+		OnApplied:      onApplied("invalid"),
+	}
+	writer.Write <- &Update{
+		ConversationID: 1,
+		Operation:      OperationReplace,
+		Content:        "bye",
+		Result:         results,
+		OnApplied:      onApplied("failing"),
+	}
+	writer.flush()
+
+	if expected := []string{"append", "failing"}; !reflect.DeepEqual(applied, expected) {
+		t.Errorf("OnApplied was called for incorrect updates, expected %v, got %v", expected, applied)
+	}
+	if res := <-results; res.Err != nil || res.AppliedErr != nil {
+		t.Errorf("Unexpected errors: %v, %v", res.Err, res.AppliedErr)
+	}
+	if res := <-results; !errors.Is(res.Err, ErrInvalidPatch) {
+		t.Errorf("Expected %v, got %v", ErrInvalidPatch, res.Err)
+	}
+	if res := <-results; res.Err != nil || res.AppliedErr == nil {
+		t.Errorf("Expected only an OnApplied error, got %v, %v", res.Err, res.AppliedErr)
+	}
+}
+
+func TestCachedWriterMaxContentSize(t *testing.T) {
+	writer, directory := newTestWriter(t)
+	writer.MaxContentSize = 5
+
+	applied := 0
+	update := func(operation Operation, content string) UpdateResult {
+		result := make(chan UpdateResult, 1)
+		writer.Write <- &Update{
+			ConversationID: 1,
+			Operation:      operation,
+			Content:        content,
+			Result:         result,
+			OnApplied: func(ctx context.Context, res UpdateResult) error {
+				applied++
+				return nil
+			},
+		}
+		writer.flush()
+		return <-result
+	}
+
+	if res := update(OperationAppend, "hello"); res.Err != nil {
+		t.Fatalf("Unexpected error for content at the limit: %v", res.Err)
+	}
+
+	if res := update(OperationAppend, "!"); !errors.Is(res.Err, ErrContentTooLarge) {
+		t.Errorf("Expected %v, got %v", ErrContentTooLarge, res.Err)
+	}
+	if content, _ := directory.ReadFile(1); string(content) != "hello" {
+		t.Errorf("Content was changed by a rejected update, expected %q, got %q", "hello", content)
+	}
+	if applied != 1 {
+		t.Errorf("OnApplied was called for a rejected update")
+	}
+
+	// Content that is already over a lowered limit can still shrink
+	writer.MaxContentSize = 2
+	if res := update(OperationReplace, "bye"); res.Err != nil {
+		t.Errorf("Unexpected error for shrinking content: %v", res.Err)
+	}
+	if content, _ := directory.ReadFile(1); string(content) != "bye" {
+		t.Errorf("Content is incorrect, expected %q, got %q", "bye", content)
+	}
+}
+
+func TestCachedWriterPartialPatch(t *testing.T) {
+	writer, directory := newTestWriter(t)
+	if err := directory.WriteFile(1, []byte("hello world")); err != nil {
+		t.Fatalf("Failed to write content file: %v", err)
+	}
+
+	// The first hunk applies, but the second does not
+	patch := "@@ -1,5 +1,5 @@\n-hello\n+HELLO\n" + "@@ -100,5 +100,5 @@\n-zzzzz\n+ZZZZZ\n"
+	result := make(chan UpdateResult, 1)
+	writer.Write <- &Update{
+		ConversationID: 1,
+		Patch:          patch,
+		Result:         result,
+	}
+	writer.flush()
+
+	if res := <-result; !errors.Is(res.Err, ErrPatchNotApplied) {
+		t.Errorf("Expected %v, got %v", ErrPatchNotApplied, res.Err)
+	}
+	if content, _ := directory.ReadFile(1); string(content) != "hello world" {
+		t.Errorf("Content was changed by a partly applied patch, expected %q, got %q", "hello world", content)
+	}
+}
@@ -5,6 +5,8 @@ import (
 	"io/ioutil"
 	"os"
 	"path"
+	"strconv"
+	"strings"
 )
 
 // Directory represents a directory in the filesystem where content files are
@@ -48,16 +50,97 @@ func (d *Directory) ReadFile(conversationID int64) ([]byte, error) {
 	return data, err
 }
 
+// Open opens the content file for the given conversation ID for reading, so
+// that it can be streamed rather than read into memory.
+func (d *Directory) Open(conversationID int64) (*os.File, error) {
+	return os.Open(d.getPath(conversationID))
+}
+
+// Size returns the size in bytes of the content file for the given
+// conversation ID.
+func (d *Directory) Size(conversationID int64) (int64, error) {
+	info, err := os.Stat(d.getPath(conversationID))
+	if err != nil {
+		return 0, err
+	}
+	return info.Size(), nil
+}
+
 // WriteFile overwrites the content file for the given conversation ID with the
-// the given bytes.
+// the given bytes. The bytes are written to a temporary file that then
+// replaces the content file, so that readers that opened the content file
+// before see all of its old bytes and never a partial write.
 func (d *Directory) WriteFile(conversationID int64, b []byte) error {
 	filePath := d.getPath(conversationID)
-	if _, err := os.Stat(filePath); err != nil {
+	info, err := os.Stat(filePath)
+	if err != nil {
 		return err
 	}
 
-	err := ioutil.WriteFile(filePath, b, 0)
-	return err
+	f, err := ioutil.TempFile(d.location, fmt.Sprintf(".%d-", conversationID))
+	if err != nil {
+		return err
+	}
+	tmpPath := f.Name()
+	if _, err := f.Write(b); err != nil {
+		f.Close()
+		os.Remove(tmpPath)
+		return err
+	}
+	if err := f.Chmod(info.Mode()); err != nil {
+		f.Close()
+		os.Remove(tmpPath)
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		os.Remove(tmpPath)
+		return err
+	}
+	if err := f.Close(); err != nil {
+		os.Remove(tmpPath)
+		return err
+	}
+
+	if err := os.Rename(tmpPath, filePath); err != nil {
+		os.Remove(tmpPath)
+		return err
+	}
+	return nil
+}
+
+// List returns the conversation IDs of all content files in the directory.
+func (d *Directory) List() ([]int64, error) {
+	files, err := ioutil.ReadDir(d.location)
+	if err != nil {
+		return nil, err
+	}
+
+	conversationIDs := make([]int64, 0, len(files))
+	for _, file := range files {
+		name := file.Name()
+		if file.IsDir() || path.Ext(name) != ".html" {
+			continue
+		}
+
+		conversationID, err := strconv.ParseInt(strings.TrimSuffix(name, ".html"), 10, 64)
+		if err != nil {
+			continue
+		}
+		conversationIDs = append(conversationIDs, conversationID)
+	}
+	return conversationIDs, nil
+}
+
+// CheckWritable verifies that files can be created in the directory by
+// creating and removing a temporary file.
+func (d *Directory) CheckWritable() error {
+	f, err := ioutil.TempFile(d.location, ".healthcheck-")
+	if err != nil {
+		return err
+	}
+	f.Close()
+	return os.Remove(f.Name())
 }
 
 // Remove deletes the content file for the given conversation ID.
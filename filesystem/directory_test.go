@@ -0,0 +1,57 @@
+package filesystem
+
+import (
+	"io/ioutil"
+	"os"
+	"reflect"
+	"testing"
+)
+
+func TestDirectoryWriteFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ether-directory-")
+	if err != nil {
+		t.Fatalf("Failed to create directory: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	d := NewDirectory(dir)
+
+	if err := d.WriteFile(1, []byte("hello")); !os.IsNotExist(err) {
+		t.Errorf("Expected missing file to not be written, got %v", err)
+	}
+
+	if err := d.Create(1); err != nil {
+		t.Fatalf("Failed to create file: %v", err)
+	}
+	if err := d.WriteFile(1, []byte("hello world")); err != nil {
+		t.Fatalf("Failed to write file: %v", err)
+	}
+
+	// A reader that opened the file before it is replaced keeps reading the
+	// old content in full
+	f, err := d.Open(1)
+	if err != nil {
+		t.Fatalf("Failed to open file: %v", err)
+	}
+	defer f.Close()
+	if err := d.WriteFile(1, []byte("goodbye")); err != nil {
+		t.Fatalf("Failed to write file: %v", err)
+	}
+	if data, _ := ioutil.ReadAll(f); string(data) != "hello world" {
+		t.Errorf("Open file has incorrect content, expected %q, got %q", "hello world", data)
+	}
+	if data, _ := d.ReadFile(1); string(data) != "goodbye" {
+		t.Errorf("File has incorrect content, expected %q, got %q", "goodbye", data)
+	}
+
+	// No temporary files are left behind
+	ids, err := d.List()
+	if err != nil {
+		t.Fatalf("Failed to list files: %v", err)
+	}
+	if !reflect.DeepEqual(ids, []int64{1}) {
+		t.Errorf("Incorrect conversation IDs, expected %v, got %v", []int64{1}, ids)
+	}
+	if files, _ := ioutil.ReadDir(dir); len(files) != 1 {
+		t.Errorf("Expected 1 file in directory, got %d", len(files))
+	}
+}
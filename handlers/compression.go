@@ -0,0 +1,75 @@
+package handlers
+
+import (
+	"compress/gzip"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/andybalholm/brotli"
+)
+
+const (
+	encodingBrotli   = "br"
+	encodingGzip     = "gzip"
+	encodingIdentity = "identity"
+
+	// minCompressSize is the size in bytes below which content is not worth
+	// compressing.
+	minCompressSize = 1024
+
+	// brotliLevel trades some compression ratio for speed, since content is
+	// compressed on every request.
+	brotliLevel = 5
+)
+
+// negotiateEncoding chooses the content coding of a response from the
+// request's Accept-Encoding header. Brotli is preferred over gzip when the
+// client accepts both equally, and identity is chosen if it accepts neither.
+func negotiateEncoding(acceptEncoding string) string {
+	best := encodingIdentity
+	bestQ := 0.0
+	for _, part := range strings.Split(acceptEncoding, ",") {
+		fields := strings.Split(part, ";")
+		coding := strings.ToLower(strings.TrimSpace(fields[0]))
+		q := 1.0
+		for _, param := range fields[1:] {
+			param = strings.TrimSpace(param)
+			if strings.HasPrefix(param, "q=") {
+				parsed, err := strconv.ParseFloat(strings.TrimPrefix(param, "q="), 64)
+				if err != nil {
+					parsed = 0
+				}
+				q = parsed
+			}
+		}
+
+		if coding != encodingBrotli && coding != encodingGzip {
+			continue
+		}
+		if q > bestQ || (q == bestQ && q > 0 && coding == encodingBrotli) {
+			best = coding
+			bestQ = q
+		}
+	}
+	return best
+}
+
+// newEncoder returns a writer that compresses what is written to it with a
+// content coding before writing it to w. It must be closed to flush the end of
+// the compressed stream.
+func newEncoder(w io.Writer, encoding string) io.WriteCloser {
+	if encoding == encodingBrotli {
+		return brotli.NewWriterLevel(w, brotliLevel)
+	}
+	return gzip.NewWriter(w)
+}
+
+// encodedETag returns the entity tag of a representation with a content
+// coding, which must differ from that of the unencoded representation.
+func encodedETag(etag, encoding string) string {
+	if etag == "" || encoding == encodingIdentity {
+		return etag
+	}
+	return strings.TrimSuffix(etag, `"`) + "-" + encoding + `"`
+}
@@ -0,0 +1,26 @@
+package handlers
+
+import "testing"
+
+func TestNegotiateEncoding(t *testing.T) {
+	tests := []struct {
+		AcceptEncoding string
+		Encoding       string
+	}{
+		{AcceptEncoding: "", Encoding: encodingIdentity},
+		{AcceptEncoding: "gzip", Encoding: encodingGzip},
+		{AcceptEncoding: "gzip, deflate, br", Encoding: encodingBrotli},
+		{AcceptEncoding: "br;q=0.5, gzip;q=0.8", Encoding: encodingGzip},
+		{AcceptEncoding: "br;q=0, gzip;q=0", Encoding: encodingIdentity},
+		{AcceptEncoding: "deflate", Encoding: encodingIdentity},
+		{AcceptEncoding: "GZIP;q=1.0", Encoding: encodingGzip},
+	}
+
+	for _, test := range tests {
+		t.Run(test.AcceptEncoding, func(t *testing.T) {
+			if encoding := negotiateEncoding(test.AcceptEncoding); encoding != test.Encoding {
+				t.Errorf("Incorrect encoding, expected %s, got %s", test.Encoding, encoding)
+			}
+		})
+	}
+}
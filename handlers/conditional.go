@@ -0,0 +1,198 @@
+package handlers
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"ether/apierror"
+	"ether/models"
+	"hash"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// contentETag returns the strong entity tag of a representation, which is a
+// hash of its bytes.
+func contentETag(data []byte) string {
+	h := sha256.New()
+	h.Write(data)
+	return hashETag(h)
+}
+
+// hashETag returns the strong entity tag of a representation that has been
+// written to a SHA-256 hash.
+func hashETag(h hash.Hash) string {
+	return `"` + hex.EncodeToString(h.Sum(nil)[:16]) + `"`
+}
+
+// jsonETag returns the strong entity tag of the JSON representation of v.
+func jsonETag(v interface{}) string {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return ""
+	}
+	return contentETag(data)
+}
+
+// conversationETag returns the entity tag of the fields of a conversation that
+// PatchConversationHandler can change. LastModified is left out, since it
+// changes whenever the content is edited, which does not conflict with
+// changing the conversation's details.
+func conversationETag(conversation *models.Conversation) string {
+	return jsonETag(&models.Conversation{
+		ID:          conversation.ID,
+		Name:        conversation.Name,
+		Description: conversation.Description,
+		AvatarURL:   conversation.AvatarURL,
+	})
+}
+
+// mappingETag returns the entity tag of the fields of a member that
+// PatchMappingHandler can change. The member's caret and LastOpened are left
+// out, since they change whenever the member reads or moves around the
+// content, which does not conflict with changing their details.
+func mappingETag(member *models.UserConversationMapping) string {
+	return jsonETag(&models.UserConversationMapping{
+		UserID:         member.UserID,
+		ConversationID: member.ConversationID,
+		Role:           member.Role,
+		Nickname:       member.Nickname,
+		Pending:        member.Pending,
+	})
+}
+
+// parseLastModified parses a time from the database, returning the zero time
+// if it cannot be parsed.
+func parseLastModified(value string) time.Time {
+	t, err := time.Parse(models.TimeLayout, value)
+	if err != nil {
+		return time.Time{}
+	}
+	return t
+}
+
+// etagMatches checks if an If-Match or If-None-Match header value lists an
+// entity tag. Weak comparison ignores the W/ prefix of weak entity tags.
+func etagMatches(header, etag string, weak bool) bool {
+	if etag == "" {
+		return false
+	}
+	for _, candidate := range strings.Split(header, ",") {
+		candidate = strings.TrimSpace(candidate)
+		if candidate == "*" {
+			return true
+		}
+		if weak {
+			candidate = strings.TrimPrefix(candidate, "W/")
+		}
+		if candidate == etag {
+			return true
+		}
+	}
+	return false
+}
+
+// checkNotModified sets the validators of a GET response and, if the request's
+// preconditions show that the client already has the representation, responds
+// with 304 Not Modified and returns true. If-None-Match takes precedence over
+// If-Modified-Since, as in RFC 7232.
+func (env *Env) checkNotModified(w http.ResponseWriter, r *http.Request, etag string, lastModified time.Time) bool {
+	if etag != "" {
+		w.Header().Set("ETag", etag)
+	}
+	if !lastModified.IsZero() {
+		w.Header().Set("Last-Modified", lastModified.UTC().Format(http.TimeFormat))
+	}
+
+	if inm := r.Header.Get("If-None-Match"); inm != "" {
+		if !etagMatches(inm, etag, true) {
+			return false
+		}
+	} else if ims := r.Header.Get("If-Modified-Since"); ims != "" && !lastModified.IsZero() {
+		since, err := http.ParseTime(ims)
+		if err != nil || lastModified.Truncate(time.Second).After(since) {
+			return false
+		}
+	} else {
+		return false
+	}
+
+	w.WriteHeader(http.StatusNotModified)
+	return true
+}
+
+// checkIfMatch checks a modifying request's If-Match precondition against the
+// current entity tag of the resource. If it fails, it responds with 412
+// Precondition Failed and returns false. Requests without If-Match always
+// pass.
+func (env *Env) checkIfMatch(w http.ResponseWriter, r *http.Request, etag string) bool {
+	ifMatch := r.Header.Get("If-Match")
+	if ifMatch == "" || etagMatches(ifMatch, etag, false) {
+		return true
+	}
+
+	errMsg := "Resource has been modified since it was read"
+	env.logger(r).Infof("%s: If-Match %s, current %s", errMsg, ifMatch, etag)
+	apierror.Write(w, errMsg, http.StatusPreconditionFailed, apierror.PreconditionFailed)
+	return false
+}
+
+// modified responds with 412 Precondition Failed and returns true if a
+// conditional update failed because the resource was modified after it was
+// read.
+func (env *Env) modified(w http.ResponseWriter, r *http.Request, err error) bool {
+	if !errors.Is(err, models.ErrModified) {
+		return false
+	}
+
+	errMsg := "Resource has been modified since it was read"
+	env.logger(r).Info(errMsg)
+	apierror.Write(w, errMsg, http.StatusPreconditionFailed, apierror.PreconditionFailed)
+	return true
+}
+
+// serveContentWriter wraps the ResponseWriter given to http.ServeContent, whose
+// error responses are plain text, to respond with the JSON errors of the API
+// instead.
+type serveContentWriter struct {
+	http.ResponseWriter
+	size int64
+
+	// failed is the status of the error response that was written, if any,
+	// whose plain text body is dropped
+	failed int
+}
+
+func (w *serveContentWriter) WriteHeader(status int) {
+	switch status {
+	case http.StatusPreconditionFailed:
+		w.failed = status
+		apierror.Write(w.ResponseWriter, "Resource has been modified since it was read", status, apierror.PreconditionFailed)
+	case http.StatusRequestedRangeNotSatisfiable:
+		w.failed = status
+		apierror.WriteDetails(w.ResponseWriter, "Requested range not satisfiable", status, apierror.RangeNotSatisfiable, apierror.Details{"size": w.size})
+	default:
+		w.ResponseWriter.WriteHeader(status)
+	}
+}
+
+func (w *serveContentWriter) Write(b []byte) (int, error) {
+	if w.failed != 0 {
+		return len(b), nil
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+// serveContent serves content of a known size like http.ServeContent, which
+// handles Range, If-Range and the other preconditions, but with JSON error
+// responses.
+func (env *Env) serveContent(w http.ResponseWriter, r *http.Request, lastModified time.Time, content io.ReadSeeker, size int64) {
+	cw := &serveContentWriter{ResponseWriter: w, size: size}
+	http.ServeContent(cw, r, "", lastModified, content)
+	if cw.failed != 0 {
+		env.logger(r).Infof("Could not serve content: %s (Range %q, %d bytes)", http.StatusText(cw.failed), r.Header.Get("Range"), size)
+	}
+}
@@ -0,0 +1,330 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"ether/models"
+	"ether/utils"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gorilla/mux"
+)
+
+func TestGetConversationHandlerConditional(t *testing.T) {
+	conversation := &models.Conversation{
+		ID:           1,
+		Name:         "test_name",
+		Description:  utils.StringPtr("test_desc"),
+		AvatarURL:    utils.StringPtr("test_url"),
+		LastModified: "2020-01-01 10:00:00",
+	}
+	etag := conversationETag(conversation)
+
+	tests := []struct {
+		Name       string
+		StatusCode int
+		Headers    map[string]string
+	}{
+		{
+			Name:       "No preconditions",
+			StatusCode: http.StatusOK,
+		},
+		{
+			Name:       "If-None-Match matches",
+			StatusCode: http.StatusNotModified,
+			Headers:    map[string]string{"If-None-Match": `"other", ` + etag},
+		},
+		{
+			Name:       "If-None-Match matches weakly",
+			StatusCode: http.StatusNotModified,
+			Headers:    map[string]string{"If-None-Match": "W/" + etag},
+		},
+		{
+			Name:       "If-None-Match does not match",
+			StatusCode: http.StatusOK,
+			Headers: map[string]string{
+				"If-None-Match":     `"other"`,
+				"If-Modified-Since": "Wed, 01 Jan 2020 10:00:00 GMT",
+			},
+		},
+		{
+			Name:       "Not modified since",
+			StatusCode: http.StatusNotModified,
+			Headers:    map[string]string{"If-Modified-Since": "Wed, 01 Jan 2020 10:00:00 GMT"},
+		},
+		{
+			Name:       "Modified since",
+			StatusCode: http.StatusOK,
+			Headers:    map[string]string{"If-Modified-Since": "Wed, 01 Jan 2020 09:59:59 GMT"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.Name, func(t *testing.T) {
+			r := httptest.NewRequest("GET", "/ether/v1/conversations/1", nil)
+			r.Header.Set("User-ID", "1")
+			for key, value := range test.Headers {
+				r.Header.Set(key, value)
+			}
+			r = mux.SetURLVars(r, map[string]string{"conversation_id": "1"})
+			w := httptest.NewRecorder()
+
+			mDB := models.NewMockDB(
+				[]*models.Conversation{conversation},
+				[]*models.UserConversationMapping{&models.UserConversationMapping{
+					UserID:         1,
+					ConversationID: 1,
+					Role:           models.User,
+					Pending:        utils.BoolPtr(false),
+				}},
+				nil,
+			)
+			env := &Env{DB: mDB}
+			env.GetConversationHandler(w, r)
+
+			if w.Code != test.StatusCode {
+				t.Errorf("Response has incorrect status code, expected status code %d, got %d", test.StatusCode, w.Code)
+			}
+			if resETag := w.Header().Get("ETag"); resETag != etag {
+				t.Errorf("Response has incorrect ETag, expected %s, got %s", etag, resETag)
+			}
+			if lastModified := w.Header().Get("Last-Modified"); lastModified != "Wed, 01 Jan 2020 10:00:00 GMT" {
+				t.Errorf("Response has incorrect Last-Modified, got %s", lastModified)
+			}
+		})
+	}
+}
+
+func TestPatchHandlersIfMatch(t *testing.T) {
+	conversation := &models.Conversation{
+		ID:          1,
+		Name:        "test_name",
+		Description: utils.StringPtr("test_desc"),
+		AvatarURL:   utils.StringPtr("test_url"),
+	}
+	owner := &models.UserConversationMapping{
+		UserID:         1,
+		ConversationID: 1,
+		Role:           models.Owner,
+		Nickname:       utils.StringPtr(""),
+		Pending:        utils.BoolPtr(false),
+	}
+	member := &models.UserConversationMapping{
+		UserID:         2,
+		ConversationID: 1,
+		Role:           models.User,
+		Nickname:       utils.StringPtr(""),
+		Pending:        utils.BoolPtr(false),
+	}
+
+	tests := []struct {
+		Name       string
+		StatusCode int
+		Mapping    bool
+		IfMatch    string
+	}{
+		{
+			Name:       "Conversation without If-Match",
+			StatusCode: http.StatusOK,
+		},
+		{
+			Name:       "Conversation with matching If-Match",
+			StatusCode: http.StatusOK,
+			IfMatch:    conversationETag(conversation),
+		},
+		{
+			Name:       "Conversation with stale If-Match",
+			StatusCode: http.StatusPreconditionFailed,
+			IfMatch:    `"stale"`,
+		},
+		{
+			Name:       "Mapping with matching If-Match",
+			StatusCode: http.StatusOK,
+			Mapping:    true,
+			IfMatch:    mappingETag(member),
+		},
+		{
+			Name:       "Mapping with weak If-Match",
+			StatusCode: http.StatusPreconditionFailed,
+			Mapping:    true,
+			IfMatch:    "W/" + mappingETag(member),
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.Name, func(t *testing.T) {
+			conversationCopy := *conversation
+			ownerCopy := *owner
+			memberCopy := *member
+			mDB := models.NewMockDB(
+				[]*models.Conversation{&conversationCopy},
+				[]*models.UserConversationMapping{&ownerCopy, &memberCopy},
+				nil,
+			)
+			env := &Env{DB: mDB}
+			w := httptest.NewRecorder()
+
+			var r *http.Request
+			if test.Mapping {
+				body, _ := json.Marshal(map[string]string{"role": "admin"})
+				r = httptest.NewRequest("PATCH", "/ether/v1/conversations/1/users/2", bytes.NewReader(body))
+				r = mux.SetURLVars(r, map[string]string{"conversation_id": "1", "user_id": "2"})
+			} else {
+				body, _ := json.Marshal(map[string]string{"name": "new_name"})
+				r = httptest.NewRequest("PATCH", "/ether/v1/conversations/1", bytes.NewReader(body))
+				r = mux.SetURLVars(r, map[string]string{"conversation_id": "1"})
+			}
+			r.Header.Set("User-ID", "1")
+			if test.IfMatch != "" {
+				r.Header.Set("If-Match", test.IfMatch)
+			}
+
+			if test.Mapping {
+				env.PatchMappingHandler(w, r)
+			} else {
+				env.PatchConversationHandler(w, r)
+			}
+
+			if w.Code != test.StatusCode {
+				t.Errorf("Response has incorrect status code, expected status code %d, got %d", test.StatusCode, w.Code)
+				return
+			}
+			if w.Code == http.StatusOK && w.Header().Get("ETag") == "" {
+				t.Error("Response is missing ETag")
+			}
+			if w.Code == http.StatusPreconditionFailed && len(mDB.Events) > 0 {
+				t.Error("Resource was modified despite failed precondition")
+			}
+		})
+	}
+}
+
+// staleDB returns the conversation and members as they were before another
+// request modified them.
+type staleDB struct {
+	*models.MockDB
+	conversation *models.Conversation
+	member       *models.UserConversationMapping
+}
+
+func (db *staleDB) GetConversation(ctx context.Context, id int64) (*models.Conversation, error) {
+	if _, err := db.MockDB.GetConversation(ctx, id); err != nil {
+		return nil, err
+	}
+	return db.conversation, nil
+}
+
+func (db *staleDB) GetUserConversationMapping(ctx context.Context, userID, conversationID int64) (*models.UserConversationMapping, error) {
+	mapping, err := db.MockDB.GetUserConversationMapping(ctx, userID, conversationID)
+	if err != nil || userID != db.member.UserID {
+		return mapping, err
+	}
+	return db.member, nil
+}
+
+func TestPatchHandlersConcurrentUpdate(t *testing.T) {
+	tests := []struct {
+		Name    string
+		Mapping bool
+	}{
+		{Name: "Conversation"},
+		{Name: "Mapping", Mapping: true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.Name, func(t *testing.T) {
+			mDB := models.NewMockDB(
+				[]*models.Conversation{&models.Conversation{
+					ID:          1,
+					Name:        "other_name",
+					Description: utils.StringPtr("test_desc"),
+					AvatarURL:   utils.StringPtr("test_url"),
+				}},
+				[]*models.UserConversationMapping{
+					&models.UserConversationMapping{
+						UserID:         1,
+						ConversationID: 1,
+						Role:           models.Owner,
+						Pending:        utils.BoolPtr(false),
+					},
+					&models.UserConversationMapping{
+						UserID:         2,
+						ConversationID: 1,
+						Role:           models.Admin,
+						Pending:        utils.BoolPtr(false),
+					},
+				},
+				nil,
+			)
+			db := &staleDB{
+				MockDB: mDB,
+				conversation: &models.Conversation{
+					ID:          1,
+					Name:        "test_name",
+					Description: utils.StringPtr("test_desc"),
+					AvatarURL:   utils.StringPtr("test_url"),
+				},
+				member: &models.UserConversationMapping{
+					UserID:         2,
+					ConversationID: 1,
+					Role:           models.User,
+					Pending:        utils.BoolPtr(false),
+				},
+			}
+			env := &Env{DB: db}
+			w := httptest.NewRecorder()
+
+			if test.Mapping {
+				body, _ := json.Marshal(map[string]string{"nickname": "new_nickname"})
+				r := httptest.NewRequest("PATCH", "/ether/v1/conversations/1/users/2", bytes.NewReader(body))
+				r = mux.SetURLVars(r, map[string]string{"conversation_id": "1", "user_id": "2"})
+				r.Header.Set("User-ID", "1")
+				r.Header.Set("If-Match", mappingETag(db.member))
+				env.PatchMappingHandler(w, r)
+			} else {
+				body, _ := json.Marshal(map[string]string{"description": "new_desc"})
+				r := httptest.NewRequest("PATCH", "/ether/v1/conversations/1", bytes.NewReader(body))
+				r = mux.SetURLVars(r, map[string]string{"conversation_id": "1"})
+				r.Header.Set("User-ID", "1")
+				r.Header.Set("If-Match", conversationETag(db.conversation))
+				env.PatchConversationHandler(w, r)
+			}
+
+			if w.Code != http.StatusPreconditionFailed {
+				t.Errorf("Response has incorrect status code, expected status code %d, got %d", http.StatusPreconditionFailed, w.Code)
+			}
+			if len(mDB.Events) > 0 {
+				t.Error("Event was recorded for a failed update")
+			}
+			if test.Mapping && mDB.GetMapping(2, 1).Nickname != nil {
+				t.Error("Member was updated despite concurrent modification")
+			}
+			if !test.Mapping && *mDB.Conversations[1].Description != "test_desc" {
+				t.Error("Conversation was updated despite concurrent modification")
+			}
+		})
+	}
+}
+
+func TestConversationETagIgnoresLastModified(t *testing.T) {
+	conversation := &models.Conversation{
+		ID:           1,
+		Name:         "test_name",
+		Description:  utils.StringPtr("test_desc"),
+		AvatarURL:    utils.StringPtr("test_url"),
+		LastModified: "2020-01-01 10:00:00",
+	}
+	touched := *conversation
+	touched.LastModified = "2020-01-01 11:00:00"
+	if conversationETag(conversation) != conversationETag(&touched) {
+		t.Error("Entity tag changed with LastModified")
+	}
+	renamed := *conversation
+	renamed.Name = "new_name"
+	if conversationETag(conversation) == conversationETag(&renamed) {
+		t.Error("Entity tag did not change with name")
+	}
+}
@@ -1,22 +1,61 @@
 package handlers
 
 import (
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"ether/apierror"
+	"ether/filesystem"
+	"ether/history"
+	"ether/logging"
+	"ether/models"
 	"fmt"
-	"log"
+	"io"
 	"net/http"
 	"os"
 	"strconv"
+	"time"
 
 	"github.com/gorilla/mux"
+	"go.opentelemetry.io/otel/trace"
 )
 
+const (
+	diffFormatUnified = "unified"
+	diffFormatHTML    = "html"
+
+	// diffLastOpened is the diff bound that refers to the version of a
+	// conversation's content when the session user last opened it.
+	diffLastOpened = "last_opened"
+
+	contentOperationPatch   = "patch"
+	contentOperationAppend  = "append"
+	contentOperationReplace = "replace"
+)
+
+// contentWrite represents an edit to a conversation's content, which is either
+// a diff-match-patch patch or content to append or replace it with
+type contentWrite struct {
+	Operation string  `json:"operation,omitempty"`
+	Patch     *string `json:"patch,omitempty"`
+	Content   *string `json:"content,omitempty"`
+}
+
+// blameResponse represents a conversation's content at a version, split into
+// the spans last edited by each revision
+type blameResponse struct {
+	Version int64           `json:"version"`
+	Spans   []*history.Span `json:"spans"`
+}
+
 // GetContentHandler gets a conversation's content
 func (env *Env) GetContentHandler(w http.ResponseWriter, r *http.Request) {
 	userID, err := strconv.ParseInt(r.Header.Get("User-ID"), 10, 64)
 	if err != nil {
 		errMsg := "Invalid user ID"
-		log.Println(errMsg + ": " + err.Error())
-		http.Error(w, errMsg, http.StatusBadRequest)
+		env.logger(r).Infof("%s: %v", errMsg, err)
+		apierror.Write(w, errMsg, http.StatusBadRequest, apierror.UserIDInvalid)
 		return
 	}
 
@@ -24,39 +63,492 @@ func (env *Env) GetContentHandler(w http.ResponseWriter, r *http.Request) {
 	conversationID, err := strconv.ParseInt(vars["conversation_id"], 10, 64)
 	if err != nil {
 		errMsg := "Invalid conversation ID"
-		log.Println(errMsg + ": " + err.Error())
-		http.Error(w, errMsg, http.StatusBadRequest)
+		env.logger(r).Infof("%s: %v", errMsg, err)
+		apierror.Write(w, errMsg, http.StatusBadRequest, apierror.ConversationIDInvalid)
 		return
 	}
 
-	conversation, err := env.getConversation(w, conversationID)
+	conversation, err := env.getConversation(w, r, conversationID)
 	if err != nil || conversation == nil {
 		return
 	}
 
-	sessionMember, err := env.getMapping(w, userID, conversationID, "Conversation not found")
+	sessionMember, err := env.getMapping(w, r, userID, conversationID, "Conversation not found", apierror.ConversationNotFound)
 	if err != nil || sessionMember == nil {
 		return
 	}
 
 	if *sessionMember.Pending {
 		errMsg := "Cannot get conversation content while invitation is pending"
-		log.Println(errMsg)
-		http.Error(w, errMsg, http.StatusForbidden)
+		env.logger(r).Info(errMsg)
+		apierror.Write(w, errMsg, http.StatusForbidden, apierror.InvitationPending)
 		return
 	}
 
-	data, err := env.Directory.ReadFile(conversationID)
+	f, err := env.Directory.Open(conversationID)
 	if os.IsNotExist(err) {
 		errMsg := fmt.Sprintf("File for conversation %d does not exist", conversationID)
-		log.Println(errMsg)
-		http.Error(w, "File not found", http.StatusNotFound)
+		env.logger(r).Info(errMsg)
+		apierror.Write(w, "File not found", http.StatusNotFound, apierror.ContentNotFound)
 		return
 	} else if err != nil {
-		internalServerError(w, err)
+		env.internalServerError(w, r, err)
+		return
+	}
+	defer f.Close()
+
+	// Hash the file as it is read rather than reading it all into memory, then
+	// go back to its start to send it. Edits replace the file rather than
+	// writing to it, so the open file does not change in between.
+	hash := sha256.New()
+	size, err := io.Copy(hash, f)
+	if err != nil {
+		env.internalServerError(w, r, err)
 		return
 	}
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		env.internalServerError(w, r, err)
+		return
+	}
+	etag := hashETag(hash)
+	lastModified := parseLastModified(conversation.LastModified)
+
+	w.Header().Set("Content-Type", "text/html")
+	w.Header().Set("Vary", "Accept-Encoding")
+
+	// Ranges are of the unencoded content, so range requests and content too
+	// small to be worth compressing are served as is, which handles Range,
+	// If-Range and the other preconditions
+	encoding := negotiateEncoding(r.Header.Get("Accept-Encoding"))
+	if encoding == encodingIdentity || size < minCompressSize || r.Header.Get("Range") != "" {
+		w.Header().Set("ETag", etag)
+		env.serveContent(w, r, lastModified, f, size)
+		return
+	}
+
+	if env.checkNotModified(w, r, encodedETag(etag, encoding), lastModified) {
+		return
+	}
+	w.Header().Set("Content-Encoding", encoding)
+	encoder := newEncoder(w, encoding)
+	if _, err := io.Copy(encoder, f); err != nil {
+		env.logger(r).Warnf("Failed to send content: %v", err)
+	}
+	if err := encoder.Close(); err != nil {
+		env.logger(r).Warnf("Failed to send content: %v", err)
+	}
+}
 
-	w.Header().Add("Content-Type", "text/html")
-	w.Write(data)
+// GetContentBlameHandler gets a conversation's content split into spans, each
+// annotated with the last user to edit it
+func (env *Env) GetContentBlameHandler(w http.ResponseWriter, r *http.Request) {
+	userID, err := strconv.ParseInt(r.Header.Get("User-ID"), 10, 64)
+	if err != nil {
+		errMsg := "Invalid user ID"
+		env.logger(r).Infof("%s: %v", errMsg, err)
+		apierror.Write(w, errMsg, http.StatusBadRequest, apierror.UserIDInvalid)
+		return
+	}
+
+	vars := mux.Vars(r)
+	conversationID, err := strconv.ParseInt(vars["conversation_id"], 10, 64)
+	if err != nil {
+		errMsg := "Invalid conversation ID"
+		env.logger(r).Infof("%s: %v", errMsg, err)
+		apierror.Write(w, errMsg, http.StatusBadRequest, apierror.ConversationIDInvalid)
+		return
+	}
+
+	conversation, err := env.getConversation(w, r, conversationID)
+	if err != nil || conversation == nil {
+		return
+	}
+
+	sessionMember, err := env.getMapping(w, r, userID, conversationID, "Conversation not found", apierror.ConversationNotFound)
+	if err != nil || sessionMember == nil {
+		return
+	}
+
+	if *sessionMember.Pending {
+		errMsg := "Cannot get conversation content while invitation is pending"
+		env.logger(r).Info(errMsg)
+		apierror.Write(w, errMsg, http.StatusForbidden, apierror.InvitationPending)
+		return
+	}
+
+	if sessionMember.Role != models.Owner && sessionMember.Role != models.Admin {
+		errMsg := fmt.Sprintf("User %d cannot view edit history of conversation %d", userID, conversationID)
+		env.logger(r).Info(errMsg)
+		apierror.Write(w, "Forbidden from viewing conversation edit history", http.StatusForbidden, apierror.Forbidden)
+		return
+	}
+
+	// Only the revisions that Blame replays are loaded, and one more to tell
+	// if there are too many, without reading the whole history
+	revisions, err := env.DB.GetRevisionsSinceReset(r.Context(), conversationID, history.MaxBlameRevisions+1)
+	if err != nil {
+		env.internalServerError(w, r, err)
+		return
+	}
+
+	response := &blameResponse{Spans: []*history.Span{}}
+	if len(revisions) > 0 {
+		response.Version = revisions[len(revisions)-1].Version
+		response.Spans, err = history.Blame(revisions)
+		if errors.Is(err, history.ErrHistoryTooLong) {
+			errMsg := fmt.Sprintf("Edit history of conversation %d is too long to attribute", conversationID)
+			env.logger(r).Info(errMsg)
+			apierror.WriteDetails(w, errMsg, http.StatusUnprocessableEntity, apierror.HistoryTooLong, apierror.Details{"limit": history.MaxBlameRevisions})
+			return
+		} else if err != nil {
+			env.internalServerError(w, r, err)
+			return
+		}
+	} else {
+		// The content has not been edited since revisions were kept, so none
+		// of it can be attributed
+		data, err := env.Directory.ReadFile(conversationID)
+		if os.IsNotExist(err) {
+			errMsg := fmt.Sprintf("File for conversation %d does not exist", conversationID)
+			env.logger(r).Info(errMsg)
+			apierror.Write(w, "File not found", http.StatusNotFound, apierror.ContentNotFound)
+			return
+		} else if err != nil {
+			env.internalServerError(w, r, err)
+			return
+		}
+		if len(data) > 0 {
+			response.Spans = append(response.Spans, &history.Span{Text: string(data)})
+		}
+	}
+
+	w.Header().Add("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+// GetContentDiffHandler gets the differences between two versions of a
+// conversation's content
+func (env *Env) GetContentDiffHandler(w http.ResponseWriter, r *http.Request) {
+	userID, err := strconv.ParseInt(r.Header.Get("User-ID"), 10, 64)
+	if err != nil {
+		errMsg := "Invalid user ID"
+		env.logger(r).Infof("%s: %v", errMsg, err)
+		apierror.Write(w, errMsg, http.StatusBadRequest, apierror.UserIDInvalid)
+		return
+	}
+
+	vars := mux.Vars(r)
+	conversationID, err := strconv.ParseInt(vars["conversation_id"], 10, 64)
+	if err != nil {
+		errMsg := "Invalid conversation ID"
+		env.logger(r).Infof("%s: %v", errMsg, err)
+		apierror.Write(w, errMsg, http.StatusBadRequest, apierror.ConversationIDInvalid)
+		return
+	}
+
+	query := r.URL.Query()
+	format := query.Get("format")
+	if format == "" {
+		format = diffFormatUnified
+	}
+	if format != diffFormatUnified && format != diffFormatHTML {
+		errMsg := fmt.Sprintf(`Invalid diff format, must be "%s" or "%s"`, diffFormatUnified, diffFormatHTML)
+		env.logger(r).Info(errMsg)
+		apierror.WriteDetails(w, errMsg, http.StatusBadRequest, apierror.ParameterInvalid, apierror.Details{"parameter": "format"})
+		return
+	}
+
+	conversation, err := env.getConversation(w, r, conversationID)
+	if err != nil || conversation == nil {
+		return
+	}
+
+	sessionMember, err := env.getMapping(w, r, userID, conversationID, "Conversation not found", apierror.ConversationNotFound)
+	if err != nil || sessionMember == nil {
+		return
+	}
+
+	if *sessionMember.Pending {
+		errMsg := "Cannot get conversation content while invitation is pending"
+		env.logger(r).Info(errMsg)
+		apierror.Write(w, errMsg, http.StatusForbidden, apierror.InvitationPending)
+		return
+	}
+
+	revisions, err := env.DB.GetRevisions(r.Context(), conversationID)
+	if err != nil {
+		env.internalServerError(w, r, err)
+		return
+	}
+
+	from := query.Get("from")
+	if from == "" {
+		from = diffLastOpened
+	}
+	fromVersion, err := resolveVersion(from, revisions, sessionMember)
+	if err != nil {
+		errMsg := fmt.Sprintf("Invalid from: %v", err)
+		env.logger(r).Info(errMsg)
+		apierror.WriteDetails(w, errMsg, http.StatusBadRequest, apierror.ParameterInvalid, apierror.Details{"parameter": "from"})
+		return
+	}
+
+	var toVersion int64
+	if len(revisions) > 0 {
+		toVersion = revisions[len(revisions)-1].Version
+	}
+	if to := query.Get("to"); to != "" {
+		toVersion, err = resolveVersion(to, revisions, sessionMember)
+		if err != nil {
+			errMsg := fmt.Sprintf("Invalid to: %v", err)
+			env.logger(r).Info(errMsg)
+			apierror.WriteDetails(w, errMsg, http.StatusBadRequest, apierror.ParameterInvalid, apierror.Details{"parameter": "to"})
+			return
+		}
+	}
+
+	fromContent, err := env.contentAt(r.Context(), conversationID, revisions, fromVersion)
+	if err != nil {
+		env.internalServerError(w, r, err)
+		return
+	}
+	toContent, err := env.contentAt(r.Context(), conversationID, revisions, toVersion)
+	if err != nil {
+		env.internalServerError(w, r, err)
+		return
+	}
+
+	if format == diffFormatHTML {
+		w.Header().Add("Content-Type", "text/html")
+		w.Write([]byte(history.HTMLDiff(fromContent, toContent)))
+		return
+	}
+	w.Header().Add("Content-Type", "text/x-diff")
+	w.Write([]byte(history.UnifiedDiff(
+		fmt.Sprintf("version %d", fromVersion),
+		fromContent,
+		fmt.Sprintf("version %d", toVersion),
+		toContent,
+	)))
+}
+
+// contentAt rebuilds a conversation's content at a version from its latest
+// snapshot at or before the version and its revisions.
+func (env *Env) contentAt(ctx context.Context, conversationID int64, revisions []*models.Revision, version int64) (string, error) {
+	snapshot, err := env.DB.GetSnapshot(ctx, conversationID, version)
+	if err != nil {
+		return "", err
+	}
+	return history.Content(snapshot, revisions, version)
+}
+
+// resolveVersion converts a diff bound, which is a version, a time or
+// "last_opened", to the version of a conversation's content that it refers to.
+func resolveVersion(value string, revisions []*models.Revision, member *models.UserConversationMapping) (int64, error) {
+	var latest int64
+	if len(revisions) > 0 {
+		latest = revisions[len(revisions)-1].Version
+	}
+
+	if value == diffLastOpened {
+		lastOpened, err := time.Parse(models.TimeLayout, member.LastOpened)
+		if err != nil {
+			return 0, fmt.Errorf("conversation has not been opened")
+		}
+		return history.VersionAt(revisions, lastOpened), nil
+	}
+
+	if version, err := strconv.ParseInt(value, 10, 64); err == nil {
+		if version < 0 || version > latest {
+			return 0, fmt.Errorf("version %d does not exist, latest is %d", version, latest)
+		}
+		return version, nil
+	}
+
+	t, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return 0, fmt.Errorf(`must be a version, an RFC 3339 time or "%s"`, diffLastOpened)
+	}
+	return history.VersionAt(revisions, t.UTC()), nil
+}
+
+// PostContentHandler edits a conversation's content with a patch or by
+// appending to or replacing it
+func (env *Env) PostContentHandler(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+	userID, err := strconv.ParseInt(r.Header.Get("User-ID"), 10, 64)
+	if err != nil {
+		errMsg := "Invalid user ID"
+		env.logger(r).Infof("%s: %v", errMsg, err)
+		apierror.Write(w, errMsg, http.StatusBadRequest, apierror.UserIDInvalid)
+		return
+	}
+
+	vars := mux.Vars(r)
+	conversationID, err := strconv.ParseInt(vars["conversation_id"], 10, 64)
+	if err != nil {
+		errMsg := "Invalid conversation ID"
+		env.logger(r).Infof("%s: %v", errMsg, err)
+		apierror.Write(w, errMsg, http.StatusBadRequest, apierror.ConversationIDInvalid)
+		return
+	}
+
+	reqWrite := &contentWrite{}
+	if err := env.parseJSON(w, r, reqWrite); err != nil {
+		return
+	}
+
+	update := &filesystem.Update{ConversationID: conversationID, UserID: &userID}
+	switch reqWrite.Operation {
+	case contentOperationPatch, "":
+		if reqWrite.Patch == nil || reqWrite.Content != nil {
+			errMsg := `"patch" operation must have "patch" and not "content"`
+			env.logger(r).Info(errMsg)
+			apierror.WriteDetails(w, errMsg, http.StatusBadRequest, apierror.FieldInvalid, apierror.Details{"field": "patch"})
+			return
+		}
+		update.Operation = filesystem.OperationPatch
+		update.Patch = *reqWrite.Patch
+	case contentOperationAppend, contentOperationReplace:
+		if reqWrite.Content == nil || reqWrite.Patch != nil {
+			errMsg := fmt.Sprintf(`"%s" operation must have "content" and not "patch"`, reqWrite.Operation)
+			env.logger(r).Info(errMsg)
+			apierror.WriteDetails(w, errMsg, http.StatusBadRequest, apierror.FieldInvalid, apierror.Details{"field": "content"})
+			return
+		}
+		update.Operation = filesystem.OperationAppend
+		if reqWrite.Operation == contentOperationReplace {
+			update.Operation = filesystem.OperationReplace
+		}
+		update.Content = *reqWrite.Content
+	default:
+		errMsg := fmt.Sprintf(
+			`Invalid operation, must be "%s", "%s" or "%s"`,
+			contentOperationPatch,
+			contentOperationAppend,
+			contentOperationReplace,
+		)
+		env.logger(r).Info(errMsg)
+		apierror.WriteDetails(w, errMsg, http.StatusBadRequest, apierror.FieldInvalid, apierror.Details{"field": "operation"})
+		return
+	}
+
+	conversation, err := env.getConversation(w, r, conversationID)
+	if err != nil || conversation == nil {
+		return
+	}
+
+	sessionMember, err := env.getMapping(w, r, userID, conversationID, "Conversation not found", apierror.ConversationNotFound)
+	if err != nil || sessionMember == nil {
+		return
+	}
+
+	if *sessionMember.Pending {
+		errMsg := "Cannot edit conversation content while invitation is pending"
+		env.logger(r).Info(errMsg)
+		apierror.Write(w, errMsg, http.StatusForbidden, apierror.InvitationPending)
+		return
+	}
+
+	// The update is applied even if the client goes away after it is queued,
+	// so it gets a context that continues the request's trace but is not
+	// cancelled with it, and what follows from the edit is done by the writer
+	// once it is applied rather than by this request
+	ctx := logging.WithRequestID(context.Background(), logging.RequestID(r.Context()))
+	ctx = trace.ContextWithSpanContext(ctx, trace.SpanContextFromContext(r.Context()))
+	result := make(chan filesystem.UpdateResult, 1)
+	update.Context = ctx
+	update.Result = result
+	update.OnApplied = func(ctx context.Context, res filesystem.UpdateResult) error {
+		return env.afterContentEdit(ctx, conversationID, userID, res.Patch)
+	}
+
+	select {
+	case env.Writer.Write <- update:
+	case <-r.Context().Done():
+		errMsg := "Timed out waiting to queue content edit"
+		env.logger(r).Warnf("%s: %v", errMsg, r.Context().Err())
+		apierror.Write(w, errMsg, http.StatusServiceUnavailable, apierror.EditTimeout)
+		return
+	}
+
+	var res filesystem.UpdateResult
+	select {
+	case res = <-result:
+	case <-r.Context().Done():
+		// The edit is queued and will still be applied, but its patch is not
+		// known yet
+		env.logger(r).Warnf("Timed out waiting for content edit to be applied: %v", r.Context().Err())
+		w.Header().Add("Content-Type", "application/json")
+		w.WriteHeader(http.StatusAccepted)
+		json.NewEncoder(w).Encode(&contentWrite{})
+		return
+	}
+
+	if errors.Is(res.Err, filesystem.ErrInvalidPatch) {
+		errMsg := res.Err.Error()
+		env.logger(r).Info(errMsg)
+		apierror.Write(w, errMsg, http.StatusBadRequest, apierror.PatchInvalid)
+		return
+	} else if errors.Is(res.Err, filesystem.ErrPatchNotApplied) {
+		errMsg := res.Err.Error()
+		env.logger(r).Info(errMsg)
+		apierror.Write(w, errMsg, http.StatusConflict, apierror.PatchConflict)
+		return
+	} else if errors.Is(res.Err, filesystem.ErrContentTooLarge) {
+		errMsg := res.Err.Error()
+		env.logger(r).Info(errMsg)
+		apierror.WriteDetails(w, errMsg, http.StatusRequestEntityTooLarge, apierror.ContentTooLarge, apierror.Details{"limit": env.Writer.MaxContentSize})
+		return
+	} else if os.IsNotExist(res.Err) {
+		errMsg := fmt.Sprintf("File for conversation %d does not exist", conversationID)
+		env.logger(r).Info(errMsg)
+		apierror.Write(w, "File not found", http.StatusNotFound, apierror.ContentNotFound)
+		return
+	} else if res.Err != nil {
+		env.internalServerError(w, r, res.Err)
+		return
+	}
+
+	// An error after the edit was applied has been logged by the writer, and
+	// the edit is not undone, so the client is not told to retry it
+	if res.AppliedErr != nil {
+		env.logger(r).Warnf("Content edit was applied but not fully processed: %v", res.AppliedErr)
+	}
+
+	w.Header().Add("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(&contentWrite{Patch: &res.Patch})
+}
+
+// afterContentEdit touches the conversation and the editor's membership, adds
+// the edit to the editor's batch of edits, which is recorded as a single
+// content_edited event like edits from Kafka are, and publishes the patch of an
+// edit that the writer has applied. Nothing is done if the edit did not change
+// the content.
+func (env *Env) afterContentEdit(ctx context.Context, conversationID, userID int64, patch string) error {
+	if patch == "" {
+		return nil
+	}
+
+	err := env.DB.Transaction(ctx, func(tx models.Datastore) error {
+		if err := tx.TouchConversation(ctx, conversationID); err != nil {
+			return err
+		}
+		if err := tx.TouchUserConversationMapping(ctx, userID, conversationID); err != nil {
+			return err
+		}
+		return tx.AddEdit(ctx, conversationID, &userID, time.Now())
+	})
+	if err != nil {
+		return err
+	}
+
+	// The edit has been made, so clients that miss it live will still get it
+	// when they next load the content
+	if env.Publisher != nil {
+		if err := env.Publisher.PublishPatch(ctx, conversationID, userID, patch); err != nil {
+			env.Logger.WithContext(ctx).Errorf("Failed to publish content edit: %v", err)
+		}
+	}
+	return nil
 }
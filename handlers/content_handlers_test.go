@@ -1,19 +1,31 @@
 package handlers
 
 import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"ether/apierror"
 	"ether/filesystem"
+	"ether/history"
 	"ether/models"
 	"ether/utils"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"net/http"
 	"net/http/httptest"
 	"os"
 	"path"
+	"reflect"
 	"strconv"
+	"strings"
 	"testing"
+	"time"
 
+	"github.com/andybalholm/brotli"
 	"github.com/gorilla/mux"
+	"github.com/sergi/go-diff/diffmatchpatch"
 )
 
 func TestGetContentHandler(t *testing.T) {
@@ -123,3 +135,728 @@ func TestGetContentHandler(t *testing.T) {
 		})
 	}
 }
+
+func TestGetContentBlameHandler(t *testing.T) {
+	var ownerID int64 = 1
+	var userID int64 = 2
+	conversation := &models.Conversation{
+		ID:          1,
+		Name:        "test_name",
+		Description: utils.StringPtr("test_desc"),
+		AvatarURL:   utils.StringPtr("test_url"),
+	}
+
+	tests := []struct {
+		Name       string
+		StatusCode int
+		Content    string
+		Edits      []string
+		Mapping    *models.UserConversationMapping
+		ResBody    *blameResponse
+	}{
+		{
+			Name:       "Successful blame retrieval",
+			StatusCode: http.StatusOK,
+			Edits:      []string{"hello", "hello world"},
+			Mapping: &models.UserConversationMapping{
+				UserID:         ownerID,
+				ConversationID: 1,
+				Role:           models.Owner,
+				Pending:        utils.BoolPtr(false),
+			},
+			ResBody: &blameResponse{
+				Version: 2,
+				Spans: []*history.Span{
+					{Text: "hello", UserID: &ownerID, Version: 1},
+					{Text: " world", UserID: &ownerID, Version: 2},
+				},
+			},
+		},
+		{
+			Name:       "Successful blame retrieval (no revisions)",
+			StatusCode: http.StatusOK,
+			Content:    "hello world",
+			Mapping: &models.UserConversationMapping{
+				UserID:         ownerID,
+				ConversationID: 1,
+				Role:           models.Admin,
+				Pending:        utils.BoolPtr(false),
+			},
+			ResBody: &blameResponse{
+				Spans: []*history.Span{{Text: "hello world"}},
+			},
+		},
+		{
+			Name:       "Failed blame retrieval (user role)",
+			StatusCode: http.StatusForbidden,
+			Mapping: &models.UserConversationMapping{
+				UserID:         userID,
+				ConversationID: 1,
+				Role:           models.User,
+				Pending:        utils.BoolPtr(false),
+			},
+		},
+		{
+			Name:       "Failed blame retrieval (pending invitation)",
+			StatusCode: http.StatusForbidden,
+			Mapping: &models.UserConversationMapping{
+				UserID:         userID,
+				ConversationID: 1,
+				Role:           models.Admin,
+				Pending:        utils.BoolPtr(true),
+			},
+		},
+	}
+
+	var conversationID int64 = 1
+	var contentDir = os.Getenv("ETHER_CONTENT_DIR")
+	for _, test := range tests {
+		t.Run(test.Name, func(t *testing.T) {
+			filePath := path.Join(contentDir, fmt.Sprintf("%d.html", conversationID))
+			f, _ := os.Create(filePath)
+			f.WriteString(test.Content)
+			f.Close()
+			defer os.Remove(filePath)
+
+			r := httptest.NewRequest("GET", "/ether/v1/conversations/1/content/blame", nil)
+			r.Header.Set("User-ID", strconv.FormatInt(test.Mapping.UserID, 10))
+			r = mux.SetURLVars(r, map[string]string{
+				"conversation_id": strconv.FormatInt(conversationID, 10),
+			})
+			w := httptest.NewRecorder()
+
+			mDB := models.NewMockDB(
+				[]*models.Conversation{conversation},
+				[]*models.UserConversationMapping{test.Mapping},
+				nil,
+			)
+			recorder := history.NewRecorder(mDB, nil)
+			before := ""
+			for _, edit := range test.Edits {
+				_ = recorder.Record(r.Context(), conversationID, &ownerID, before, edit)
+				before = edit
+			}
+
+			env := &Env{
+				DB:        mDB,
+				Directory: filesystem.NewDirectory(contentDir),
+			}
+			env.GetContentBlameHandler(w, r)
+
+			if w.Code != test.StatusCode {
+				t.Errorf("Response has incorrect status code, expected status code %d, got %d", test.StatusCode, w.Code)
+				return
+			}
+
+			if w.Code == http.StatusOK {
+				resBody := &blameResponse{}
+				_ = json.NewDecoder(w.Body).Decode(resBody)
+				for _, span := range resBody.Spans {
+					span.EditedAt = ""
+				}
+				if !reflect.DeepEqual(test.ResBody, resBody) {
+					t.Errorf("Response has incorrect body, expected %+v, got %+v", test.ResBody, resBody)
+				}
+			}
+		})
+	}
+}
+
+func TestGetContentBlameHandlerTooLong(t *testing.T) {
+	var ownerID int64 = 1
+	dmp := diffmatchpatch.New()
+	resetPatch := dmp.PatchToText(dmp.PatchMake("", "hello"))
+
+	tests := []struct {
+		Name       string
+		StatusCode int
+		Reset      bool
+	}{
+		{
+			Name:       "History too long",
+			StatusCode: http.StatusUnprocessableEntity,
+		},
+		{
+			Name:       "History too long before a reset",
+			StatusCode: http.StatusOK,
+			Reset:      true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.Name, func(t *testing.T) {
+			// Revisions before the last reset are never replayed, so they do
+			// not need valid patches
+			revisions := make([]*models.Revision, 0, history.MaxBlameRevisions+2)
+			for i := 1; i <= history.MaxBlameRevisions+1; i++ {
+				revisions = append(revisions, &models.Revision{ConversationID: 1, Version: int64(i), UserID: &ownerID, Patch: "not a patch"})
+			}
+			if test.Reset {
+				revisions = append(revisions, &models.Revision{ConversationID: 1, Version: int64(len(revisions) + 1), Patch: resetPatch, Reset: true})
+			}
+
+			r := httptest.NewRequest("GET", "/ether/v1/conversations/1/content/blame", nil)
+			r.Header.Set("User-ID", "1")
+			r = mux.SetURLVars(r, map[string]string{"conversation_id": "1"})
+			w := httptest.NewRecorder()
+
+			mDB := models.NewMockDB(
+				[]*models.Conversation{&models.Conversation{ID: 1, Name: "test_name"}},
+				[]*models.UserConversationMapping{&models.UserConversationMapping{
+					UserID:         ownerID,
+					ConversationID: 1,
+					Role:           models.Owner,
+					Pending:        utils.BoolPtr(false),
+				}},
+				nil,
+			)
+			mDB.Revisions[1] = revisions
+
+			env := &Env{DB: mDB}
+			env.GetContentBlameHandler(w, r)
+
+			if w.Code != test.StatusCode {
+				t.Errorf("Response has incorrect status code, expected status code %d, got %d", test.StatusCode, w.Code)
+				return
+			}
+			if w.Code == http.StatusUnprocessableEntity {
+				resBody := apierror.Error{}
+				_ = json.NewDecoder(w.Body).Decode(&resBody)
+				if resBody.Code != apierror.HistoryTooLong {
+					t.Errorf("Response has incorrect error code, expected %q, got %q", apierror.HistoryTooLong, resBody.Code)
+				}
+			}
+		})
+	}
+}
+
+func TestGetContentDiffHandler(t *testing.T) {
+	var userID int64 = 1
+	conversation := &models.Conversation{
+		ID:          1,
+		Name:        "test_name",
+		Description: utils.StringPtr("test_desc"),
+		AvatarURL:   utils.StringPtr("test_url"),
+	}
+	revisions := []*models.Revision{
+		{ConversationID: 1, UserID: &userID, Patch: "@@ -0,0 +1,2 @@\n+a%0A\n", CreatedAt: "2020-01-01 10:00:00"},
+		{ConversationID: 1, UserID: &userID, Patch: "@@ -1,2 +1,4 @@\n a%0A\n+b%0A\n", CreatedAt: "2020-01-01 11:00:00"},
+		{ConversationID: 1, UserID: &userID, Patch: "@@ -1,4 +1,6 @@\n a%0Ab%0A\n+c%0A\n", CreatedAt: "2020-01-01 12:00:00"},
+	}
+
+	tests := []struct {
+		Name       string
+		StatusCode int
+		Query      string
+		Mapping    *models.UserConversationMapping
+		ResBody    string
+	}{
+		{
+			Name:       "Successful diff (since last opened)",
+			StatusCode: http.StatusOK,
+			Mapping: &models.UserConversationMapping{
+				UserID:         userID,
+				ConversationID: 1,
+				Role:           models.User,
+				Pending:        utils.BoolPtr(false),
+				LastOpened:     "2020-01-01 11:30:00",
+			},
+			ResBody: "--- version 2\n+++ version 3\n@@ -1,2 +1,3 @@\n a\n b\n+c\n",
+		},
+		{
+			Name:       "Successful diff (versions)",
+			StatusCode: http.StatusOK,
+			Query:      "?from=0&to=1",
+			Mapping: &models.UserConversationMapping{
+				UserID:         userID,
+				ConversationID: 1,
+				Role:           models.User,
+				Pending:        utils.BoolPtr(false),
+			},
+			ResBody: "--- version 0\n+++ version 1\n@@ -0,0 +1,1 @@\n+a\n",
+		},
+		{
+			Name:       "Successful diff (times)",
+			StatusCode: http.StatusOK,
+			Query:      "?from=2020-01-01T10:30:00Z&to=2020-01-01T11:30:00Z",
+			Mapping: &models.UserConversationMapping{
+				UserID:         userID,
+				ConversationID: 1,
+				Role:           models.User,
+				Pending:        utils.BoolPtr(false),
+			},
+			ResBody: "--- version 1\n+++ version 2\n@@ -1,1 +1,2 @@\n a\n+b\n",
+		},
+		{
+			Name:       "Successful diff (no changes)",
+			StatusCode: http.StatusOK,
+			Query:      "?from=3",
+			Mapping: &models.UserConversationMapping{
+				UserID:         userID,
+				ConversationID: 1,
+				Role:           models.User,
+				Pending:        utils.BoolPtr(false),
+			},
+			ResBody: "",
+		},
+		{
+			Name:       "Failed diff (version does not exist)",
+			StatusCode: http.StatusBadRequest,
+			Query:      "?from=4",
+			Mapping: &models.UserConversationMapping{
+				UserID:         userID,
+				ConversationID: 1,
+				Role:           models.User,
+				Pending:        utils.BoolPtr(false),
+			},
+		},
+		{
+			Name:       "Failed diff (invalid format)",
+			StatusCode: http.StatusBadRequest,
+			Query:      "?from=1&format=side-by-side",
+			Mapping: &models.UserConversationMapping{
+				UserID:         userID,
+				ConversationID: 1,
+				Role:           models.User,
+				Pending:        utils.BoolPtr(false),
+			},
+		},
+		{
+			Name:       "Failed diff (pending invitation)",
+			StatusCode: http.StatusForbidden,
+			Query:      "?from=1",
+			Mapping: &models.UserConversationMapping{
+				UserID:         userID,
+				ConversationID: 1,
+				Role:           models.User,
+				Pending:        utils.BoolPtr(true),
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.Name, func(t *testing.T) {
+			r := httptest.NewRequest("GET", "/ether/v1/conversations/1/content/diff"+test.Query, nil)
+			r.Header.Set("User-ID", strconv.FormatInt(userID, 10))
+			r = mux.SetURLVars(r, map[string]string{"conversation_id": "1"})
+			w := httptest.NewRecorder()
+
+			mDB := models.NewMockDB(
+				[]*models.Conversation{conversation},
+				[]*models.UserConversationMapping{test.Mapping},
+				nil,
+			)
+			for _, revision := range revisions {
+				rev := *revision
+				_ = mDB.CreateRevision(r.Context(), &rev)
+			}
+
+			env := &Env{DB: mDB}
+			env.GetContentDiffHandler(w, r)
+
+			if w.Code != test.StatusCode {
+				t.Errorf("Response has incorrect status code, expected status code %d, got %d", test.StatusCode, w.Code)
+				return
+			}
+
+			if w.Code == http.StatusOK {
+				resBody, _ := ioutil.ReadAll(w.Body)
+				if test.ResBody != string(resBody) {
+					t.Errorf("Response has incorrect body, expected %q, got %q", test.ResBody, string(resBody))
+				}
+			}
+		})
+	}
+}
+
+func TestGetContentHandlerEncoding(t *testing.T) {
+	content := strings.Repeat("<div>hello world</div>\n", 100)
+	etag := contentETag([]byte(content))
+
+	tests := []struct {
+		Name            string
+		StatusCode      int
+		Headers         map[string]string
+		Content         string
+		ContentEncoding string
+		ContentRange    string
+		ResContent      string
+		ErrCode         apierror.Code
+	}{
+		{
+			Name:       "Identity",
+			StatusCode: http.StatusOK,
+			Content:    content,
+			ResContent: content,
+		},
+		{
+			Name:            "Gzip",
+			StatusCode:      http.StatusOK,
+			Headers:         map[string]string{"Accept-Encoding": "gzip"},
+			Content:         content,
+			ContentEncoding: "gzip",
+			ResContent:      content,
+		},
+		{
+			Name:            "Brotli",
+			StatusCode:      http.StatusOK,
+			Headers:         map[string]string{"Accept-Encoding": "gzip, br"},
+			Content:         content,
+			ContentEncoding: "br",
+			ResContent:      content,
+		},
+		{
+			Name:       "Too small to compress",
+			StatusCode: http.StatusOK,
+			Headers:    map[string]string{"Accept-Encoding": "gzip"},
+			Content:    "hello world",
+			ResContent: "hello world",
+		},
+		{
+			Name:         "Range",
+			StatusCode:   http.StatusPartialContent,
+			Headers:      map[string]string{"Range": "bytes=5-15", "Accept-Encoding": "gzip"},
+			Content:      content,
+			ContentRange: fmt.Sprintf("bytes 5-15/%d", len(content)),
+			ResContent:   content[5:16],
+		},
+		{
+			Name:         "Range not satisfiable",
+			StatusCode:   http.StatusRequestedRangeNotSatisfiable,
+			Headers:      map[string]string{"Range": fmt.Sprintf("bytes=%d-", len(content)+1)},
+			Content:      content,
+			ContentRange: fmt.Sprintf("bytes */%d", len(content)),
+			ErrCode:      apierror.RangeNotSatisfiable,
+		},
+		{
+			Name:       "If-Match precondition failed",
+			StatusCode: http.StatusPreconditionFailed,
+			Headers:    map[string]string{"If-Match": `"other"`},
+			Content:    content,
+			ErrCode:    apierror.PreconditionFailed,
+		},
+		{
+			Name:       "Gzip not modified",
+			StatusCode: http.StatusNotModified,
+			Headers: map[string]string{
+				"Accept-Encoding": "gzip",
+				"If-None-Match":   encodedETag(etag, encodingGzip),
+			},
+			Content: content,
+		},
+		{
+			Name:       "Identity not modified",
+			StatusCode: http.StatusNotModified,
+			Headers:    map[string]string{"If-None-Match": etag},
+			Content:    content,
+		},
+	}
+
+	var conversationID int64 = 1
+	var contentDir = os.Getenv("ETHER_CONTENT_DIR")
+	for _, test := range tests {
+		t.Run(test.Name, func(t *testing.T) {
+			filePath := path.Join(contentDir, fmt.Sprintf("%d.html", conversationID))
+			f, _ := os.Create(filePath)
+			f.WriteString(test.Content)
+			f.Close()
+			defer os.Remove(filePath)
+
+			r := httptest.NewRequest("GET", "/ether/v1/conversations/1/content", nil)
+			r.Header.Set("User-ID", "1")
+			for key, value := range test.Headers {
+				r.Header.Set(key, value)
+			}
+			r = mux.SetURLVars(r, map[string]string{"conversation_id": "1"})
+			w := httptest.NewRecorder()
+
+			mDB := models.NewMockDB(
+				[]*models.Conversation{&models.Conversation{ID: 1, Name: "test_name"}},
+				[]*models.UserConversationMapping{&models.UserConversationMapping{
+					UserID:         1,
+					ConversationID: 1,
+					Role:           models.User,
+					Pending:        utils.BoolPtr(false),
+				}},
+				nil,
+			)
+			env := &Env{
+				DB:        mDB,
+				Directory: filesystem.NewDirectory(contentDir),
+			}
+			env.GetContentHandler(w, r)
+
+			if w.Code != test.StatusCode {
+				t.Errorf("Response has incorrect status code, expected status code %d, got %d", test.StatusCode, w.Code)
+				return
+			}
+			if encoding := w.Header().Get("Content-Encoding"); encoding != test.ContentEncoding {
+				t.Errorf("Response has incorrect Content-Encoding, expected %q, got %q", test.ContentEncoding, encoding)
+			}
+			if contentRange := w.Header().Get("Content-Range"); test.ContentRange != "" && contentRange != test.ContentRange {
+				t.Errorf("Response has incorrect Content-Range, expected %q, got %q", test.ContentRange, contentRange)
+			}
+			if test.ErrCode != "" {
+				resBody := apierror.Error{}
+				if err := json.NewDecoder(w.Body).Decode(&resBody); err != nil || resBody.Code != test.ErrCode {
+					t.Errorf("Response has incorrect error, expected code %q, got %q (%v)", test.ErrCode, resBody.Code, err)
+				}
+				return
+			}
+			if w.Code != http.StatusOK && w.Code != http.StatusPartialContent {
+				return
+			}
+
+			var body io.Reader = w.Body
+			switch test.ContentEncoding {
+			case "gzip":
+				body, _ = gzip.NewReader(body)
+			case "br":
+				body = brotli.NewReader(body)
+			}
+			resContent, _ := ioutil.ReadAll(body)
+			if string(resContent) != test.ResContent {
+				t.Errorf("Response has incorrect body, expected %d bytes, got %d", len(test.ResContent), len(resContent))
+			}
+		})
+	}
+}
+
+type mockPublisher struct {
+	Patches []string
+}
+
+func (p *mockPublisher) PublishPatch(ctx context.Context, conversationID, userID int64, patch string) error {
+	p.Patches = append(p.Patches, patch)
+	return nil
+}
+
+func TestPostContentHandler(t *testing.T) {
+	tests := []struct {
+		Name       string
+		StatusCode int
+		Content    string
+		ReqBody    interface{}
+		Pending    bool
+		ResContent string
+		Published  bool
+		MaxSize    int
+	}{
+		{
+			Name:       "Successful patch",
+			StatusCode: http.StatusOK,
+			Content:    "hello world",
+			ReqBody:    map[string]string{"patch": "@@ -1,11 +1,12 @@\n hello world\n+!\n"},
+			ResContent: "hello world!",
+			Published:  true,
+		},
+		{
+			Name:       "Successful append",
+			StatusCode: http.StatusOK,
+			Content:    "hello",
+			ReqBody:    map[string]string{"operation": "append", "content": " world"},
+			ResContent: "hello world",
+			Published:  true,
+		},
+		{
+			Name:       "Successful replace",
+			StatusCode: http.StatusOK,
+			Content:    "hello",
+			ReqBody:    map[string]string{"operation": "replace", "content": "goodbye"},
+			ResContent: "goodbye",
+			Published:  true,
+		},
+		{
+			Name:       "Successful replace (no change)",
+			StatusCode: http.StatusOK,
+			Content:    "hello",
+			ReqBody:    map[string]string{"operation": "replace", "content": "hello"},
+			ResContent: "hello",
+		},
+		{
+			Name:       "Patch does not apply",
+			StatusCode: http.StatusConflict,
+			Content:    "hello world",
+			ReqBody:    map[string]string{"patch": "@@ -1,20 +1,0 @@\n-abcdefghijklmnopqrst\n"},
+			ResContent: "hello world",
+		},
+		{
+			Name:       "Patch applies only partly",
+			StatusCode: http.StatusConflict,
+			Content:    "hello world",
+			ReqBody:    map[string]string{"patch": "@@ -1,5 +1,5 @@\n-hello\n+HELLO\n@@ -100,5 +100,5 @@\n-zzzzz\n+ZZZZZ\n"},
+			ResContent: "hello world",
+		},
+		{
+			Name:       "Invalid patch",
+			StatusCode: http.StatusBadRequest,
+			Content:    "hello world",
+			ReqBody:    map[string]string{"patch": "not a patch"},
+			ResContent: "hello world",
+		},
+		{
+			Name:       "Invalid operation",
+			StatusCode: http.StatusBadRequest,
+			Content:    "hello",
+			ReqBody:    map[string]string{"operation": "prepend", "content": "hello"},
+			ResContent: "hello",
+		},
+		{
+			Name:       "Append without content",
+			StatusCode: http.StatusBadRequest,
+			Content:    "hello",
+			ReqBody:    map[string]string{"operation": "append", "patch": "@@ -1,5 +1,6 @@\n hello\n+!\n"},
+			ResContent: "hello",
+		},
+		{
+			Name:       "Pending invitation",
+			StatusCode: http.StatusForbidden,
+			Content:    "hello",
+			ReqBody:    map[string]string{"operation": "append", "content": " world"},
+			Pending:    true,
+			ResContent: "hello",
+		},
+		{
+			Name:       "Content too large",
+			StatusCode: http.StatusRequestEntityTooLarge,
+			Content:    "hello",
+			ReqBody:    map[string]string{"operation": "append", "content": " world"},
+			ResContent: "hello",
+			MaxSize:    10,
+		},
+		{
+			Name:       "Content over limit shrunk",
+			StatusCode: http.StatusOK,
+			Content:    "hello world",
+			ReqBody:    map[string]string{"operation": "replace", "content": "hello worl"},
+			ResContent: "hello worl",
+			Published:  true,
+			MaxSize:    5,
+		},
+	}
+
+	var conversationID int64 = 1
+	var contentDir = os.Getenv("ETHER_CONTENT_DIR")
+	for _, test := range tests {
+		t.Run(test.Name, func(t *testing.T) {
+			filePath := path.Join(contentDir, fmt.Sprintf("%d.html", conversationID))
+			f, _ := os.Create(filePath)
+			f.WriteString(test.Content)
+			f.Close()
+			defer os.Remove(filePath)
+
+			body, _ := json.Marshal(test.ReqBody)
+			r := httptest.NewRequest("POST", "/ether/v1/conversations/1/content", bytes.NewReader(body))
+			r.Header.Set("User-ID", "1")
+			r = mux.SetURLVars(r, map[string]string{"conversation_id": "1"})
+			w := httptest.NewRecorder()
+
+			mDB := models.NewMockDB(
+				[]*models.Conversation{&models.Conversation{ID: 1, Name: "test_name"}},
+				[]*models.UserConversationMapping{&models.UserConversationMapping{
+					UserID:         1,
+					ConversationID: 1,
+					Role:           models.User,
+					Pending:        utils.BoolPtr(test.Pending),
+				}},
+				nil,
+			)
+			directory := filesystem.NewDirectory(contentDir)
+			writer := filesystem.NewCachedWriter(directory, nil, nil, nil)
+			writer.MaxContentSize = test.MaxSize
+			ctx, stop := context.WithCancel(context.Background())
+			defer stop()
+			go writer.Run(ctx)
+
+			publisher := &mockPublisher{}
+			env := &Env{
+				DB:        mDB,
+				Directory: directory,
+				Writer:    writer,
+				Publisher: publisher,
+			}
+			env.PostContentHandler(w, r)
+
+			if w.Code != test.StatusCode {
+				t.Errorf("Response has incorrect status code, expected status code %d, got %d", test.StatusCode, w.Code)
+			}
+			if data, _ := ioutil.ReadFile(filePath); string(data) != test.ResContent {
+				t.Errorf("Content has incorrect value, expected %q, got %q", test.ResContent, string(data))
+			}
+			if published := len(publisher.Patches) > 0; published != test.Published {
+				t.Errorf("Edit was published: %t, expected %t", published, test.Published)
+			}
+			// Edits are batched into content_edited events rather than
+			// recorded as one each
+			if len(mDB.Events) != 0 {
+				t.Errorf("Incorrect number of events, expected 0, got %d", len(mDB.Events))
+			}
+			if edited := len(mDB.EditBatches) == 1 && mDB.EditBatches[0].Edits == 1; edited != test.Published {
+				t.Errorf("Edit was added to a batch: %t, expected %t", edited, test.Published)
+			}
+		})
+	}
+}
+
+func TestPostContentHandlerAccepted(t *testing.T) {
+	var conversationID int64 = 1
+	contentDir := os.Getenv("ETHER_CONTENT_DIR")
+	filePath := path.Join(contentDir, fmt.Sprintf("%d.html", conversationID))
+	_ = ioutil.WriteFile(filePath, []byte("hello"), 0644)
+	defer os.Remove(filePath)
+
+	body, _ := json.Marshal(map[string]string{"operation": "append", "content": " world"})
+	ctx, cancel := context.WithCancel(context.Background())
+	r := httptest.NewRequest("POST", "/ether/v1/conversations/1/content", bytes.NewReader(body)).WithContext(ctx)
+	r.Header.Set("User-ID", "1")
+	r = mux.SetURLVars(r, map[string]string{"conversation_id": "1"})
+	w := httptest.NewRecorder()
+
+	mDB := models.NewMockDB(
+		[]*models.Conversation{&models.Conversation{ID: 1, Name: "test_name"}},
+		[]*models.UserConversationMapping{&models.UserConversationMapping{
+			UserID:         1,
+			ConversationID: 1,
+			Role:           models.User,
+			Pending:        utils.BoolPtr(false),
+		}},
+		nil,
+	)
+	directory := filesystem.NewDirectory(contentDir)
+	writer := filesystem.NewCachedWriter(directory, nil, nil, nil)
+	publisher := &mockPublisher{}
+	env := &Env{
+		DB:        mDB,
+		Directory: directory,
+		Writer:    writer,
+		Publisher: publisher,
+	}
+
+	// The request ends once the edit is queued, before the writer applies it
+	go func() {
+		for writer.QueueDepth() == 0 {
+			time.Sleep(time.Millisecond)
+		}
+		cancel()
+	}()
+	env.PostContentHandler(w, r)
+
+	if w.Code != http.StatusAccepted {
+		t.Errorf("Response has incorrect status code, expected status code %d, got %d", http.StatusAccepted, w.Code)
+	}
+
+	// What follows from the edit still happens once it is applied
+	stopped, stop := context.WithCancel(context.Background())
+	stop()
+	writer.Run(stopped)
+
+	if data, _ := ioutil.ReadFile(filePath); string(data) != "hello world" {
+		t.Errorf("Content has incorrect value, expected %q, got %q", "hello world", string(data))
+	}
+	if len(publisher.Patches) != 1 {
+		t.Errorf("Incorrect number of published edits, expected 1, got %d", len(publisher.Patches))
+	}
+	if len(mDB.EditBatches) != 1 {
+		t.Errorf("Incorrect number of edit batches, expected 1, got %d", len(mDB.EditBatches))
+	}
+}
@@ -2,10 +2,10 @@ package handlers
 
 import (
 	"encoding/json"
+	"ether/apierror"
 	"ether/models"
 	"ether/utils"
 	"fmt"
-	"log"
 	"net/http"
 	"strconv"
 
@@ -18,20 +18,20 @@ func (env *Env) PostConversationHandler(w http.ResponseWriter, r *http.Request)
 	userID, err := strconv.ParseInt(r.Header.Get("User-ID"), 10, 64)
 	if err != nil {
 		errMsg := "Invalid user ID"
-		log.Println(errMsg + ": " + err.Error())
-		http.Error(w, errMsg, http.StatusBadRequest)
+		env.logger(r).Infof("%s: %v", errMsg, err)
+		apierror.Write(w, errMsg, http.StatusBadRequest, apierror.UserIDInvalid)
 		return
 	}
 
 	reqConversation := &models.Conversation{}
-	if err := parseJSON(w, r.Body, reqConversation); err != nil {
+	if err := env.parseJSON(w, r, reqConversation); err != nil {
 		return
 	}
 
 	if reqConversation.Name == "" {
 		errMsg := "Request body is missing mandatory field(s)"
-		log.Println(errMsg)
-		http.Error(w, errMsg, http.StatusBadRequest)
+		env.logger(r).Info(errMsg)
+		apierror.Write(w, errMsg, http.StatusBadRequest, apierror.RequestBodyMissingFields)
 		return
 	}
 
@@ -42,18 +42,34 @@ func (env *Env) PostConversationHandler(w http.ResponseWriter, r *http.Request)
 		reqConversation.AvatarURL = utils.StringPtr("")
 	}
 
-	conversationID, err := env.DB.CreateConversation(reqConversation, userID)
-	if err != nil {
-		internalServerError(w, err)
+	err = env.withEvents(r.Context(), func(tx models.Datastore) ([]*models.Event, error) {
+		if err := env.checkConversationQuota(r.Context(), tx, userID); err != nil {
+			return nil, err
+		}
+		conversationID, err := tx.CreateConversation(r.Context(), reqConversation, userID)
+		if err != nil {
+			return nil, err
+		}
+		if err := env.Directory.Create(conversationID); err != nil {
+			return nil, err
+		}
+
+		reqConversation.ID = conversationID
+		return []*models.Event{&models.Event{
+			ConversationID: conversationID,
+			ActorID:        &userID,
+			Action:         models.EventConversationCreated,
+			After:          models.EventValue(newConversationValues(reqConversation)),
+		}}, nil
+	})
+	if env.quotaExceeded(w, r, err) {
 		return
-	}
-
-	if env.Directory.Create(conversationID); err != nil {
-		internalServerError(w, err)
+	} else if err != nil {
+		env.internalServerError(w, r, err)
 		return
 	}
+	conversationID := reqConversation.ID
 
-	reqConversation.ID = conversationID
 	location := fmt.Sprintf("%s/%d", r.URL.Path, conversationID)
 	w.Header().Add("Location", location)
 	w.Header().Add("Content-Type", "application/json")
@@ -66,21 +82,21 @@ func (env *Env) GetConversationsHandler(w http.ResponseWriter, r *http.Request)
 	userID, err := strconv.ParseInt(r.Header.Get("User-ID"), 10, 64)
 	if err != nil {
 		errMsg := "Invalid user ID"
-		log.Println(errMsg + ": " + err.Error())
-		http.Error(w, errMsg, http.StatusBadRequest)
+		env.logger(r).Infof("%s: %v", errMsg, err)
+		apierror.Write(w, errMsg, http.StatusBadRequest, apierror.UserIDInvalid)
 		return
 	}
 
 	sort := r.URL.Query().Get("sort_by")
 	if sort != "" && sort != "asc" && sort != "desc" {
 		errMsg := "Invalid sorting keyword"
-		http.Error(w, errMsg, http.StatusBadRequest)
+		apierror.WriteDetails(w, errMsg, http.StatusBadRequest, apierror.ParameterInvalid, apierror.Details{"parameter": "sort_by"})
 		return
 	}
 
-	conversations, err := env.DB.GetConversations(userID, sort)
+	conversations, err := env.DB.GetConversations(r.Context(), userID, sort)
 	if err != nil || conversations == nil {
-		internalServerError(w, err)
+		env.internalServerError(w, r, err)
 		return
 	}
 
@@ -95,8 +111,8 @@ func (env *Env) GetConversationHandler(w http.ResponseWriter, r *http.Request) {
 	userID, err := strconv.ParseInt(r.Header.Get("User-ID"), 10, 64)
 	if err != nil {
 		errMsg := "Invalid user ID"
-		log.Println(errMsg + ": " + err.Error())
-		http.Error(w, errMsg, http.StatusBadRequest)
+		env.logger(r).Infof("%s: %v", errMsg, err)
+		apierror.Write(w, errMsg, http.StatusBadRequest, apierror.UserIDInvalid)
 		return
 	}
 
@@ -104,21 +120,25 @@ func (env *Env) GetConversationHandler(w http.ResponseWriter, r *http.Request) {
 	conversationID, err := strconv.ParseInt(vars["conversation_id"], 10, 64)
 	if err != nil {
 		errMsg := "Invalid conversation ID"
-		log.Println(errMsg + ": " + err.Error())
-		http.Error(w, errMsg, http.StatusBadRequest)
+		env.logger(r).Infof("%s: %v", errMsg, err)
+		apierror.Write(w, errMsg, http.StatusBadRequest, apierror.ConversationIDInvalid)
 		return
 	}
 
-	conversation, err := env.getConversation(w, conversationID)
+	conversation, err := env.getConversation(w, r, conversationID)
 	if err != nil || conversation == nil {
 		return
 	}
 
-	sessionMember, err := env.getMapping(w, userID, conversationID, "Conversation not found")
+	sessionMember, err := env.getMapping(w, r, userID, conversationID, "Conversation not found", apierror.ConversationNotFound)
 	if err != nil || sessionMember == nil {
 		return
 	}
 
+	if env.checkNotModified(w, r, conversationETag(conversation), parseLastModified(conversation.LastModified)) {
+		return
+	}
+
 	w.Header().Add("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(conversation)
 }
@@ -128,8 +148,8 @@ func (env *Env) DeleteConversationHandler(w http.ResponseWriter, r *http.Request
 	userID, err := strconv.ParseInt(r.Header.Get("User-ID"), 10, 64)
 	if err != nil {
 		errMsg := "Invalid user ID"
-		log.Println(errMsg + ": " + err.Error())
-		http.Error(w, errMsg, http.StatusBadRequest)
+		env.logger(r).Infof("%s: %v", errMsg, err)
+		apierror.Write(w, errMsg, http.StatusBadRequest, apierror.UserIDInvalid)
 		return
 	}
 
@@ -137,36 +157,53 @@ func (env *Env) DeleteConversationHandler(w http.ResponseWriter, r *http.Request
 	conversationID, err := strconv.ParseInt(vars["conversation_id"], 10, 64)
 	if err != nil {
 		errMsg := "Invalid conversation ID"
-		log.Println(errMsg + ": " + err.Error())
-		http.Error(w, errMsg, http.StatusBadRequest)
+		env.logger(r).Infof("%s: %v", errMsg, err)
+		apierror.Write(w, errMsg, http.StatusBadRequest, apierror.ConversationIDInvalid)
 		return
 	}
 
-	conversation, err := env.getConversation(w, conversationID)
+	conversation, err := env.getConversation(w, r, conversationID)
 	if err != nil || conversation == nil {
 		return
 	}
 
-	sessionMember, err := env.getMapping(w, userID, conversationID, "Conversation not found")
+	sessionMember, err := env.getMapping(w, r, userID, conversationID, "Conversation not found", apierror.ConversationNotFound)
 	if err != nil || sessionMember == nil {
 		return
 	}
 
 	if sessionMember.Role != models.Owner {
 		errMsg := fmt.Sprintf("User %d is not an Owner of conversation %d and cannot delete it", userID, conversationID)
-		log.Println(errMsg)
-		http.Error(w, "Forbidden from deleting conversation", http.StatusForbidden)
+		env.logger(r).Info(errMsg)
+		apierror.Write(w, "Forbidden from deleting conversation", http.StatusForbidden, apierror.Forbidden)
 		return
 	}
 
 	if err := env.Directory.Remove(conversationID); err != nil {
-		internalServerError(w, err)
+		env.internalServerError(w, r, err)
 		return
 	}
 
-	err = env.DB.DeleteConversation(conversationID)
+	err = env.withEvents(r.Context(), func(tx models.Datastore) ([]*models.Event, error) {
+		// The webhooks are deleted with the conversation, so the event is sent
+		// to the ones it had
+		webhooks, err := tx.GetWebhooks(r.Context(), conversationID)
+		if err != nil {
+			return nil, err
+		}
+		if err := tx.DeleteConversation(r.Context(), conversationID); err != nil {
+			return nil, err
+		}
+		return []*models.Event{&models.Event{
+			ConversationID: conversationID,
+			ActorID:        &userID,
+			Action:         models.EventConversationDeleted,
+			Before:         models.EventValue(newConversationValues(conversation)),
+			Webhooks:       webhooks,
+		}}, nil
+	})
 	if err != nil {
-		internalServerError(w, err)
+		env.internalServerError(w, r, err)
 		return
 	}
 
@@ -179,20 +216,20 @@ func (env *Env) PatchConversationHandler(w http.ResponseWriter, r *http.Request)
 	userID, err := strconv.ParseInt(r.Header.Get("User-ID"), 10, 64)
 	if err != nil {
 		errMsg := "Invalid user ID"
-		log.Println(errMsg + ": " + err.Error())
-		http.Error(w, errMsg, http.StatusBadRequest)
+		env.logger(r).Infof("%s: %v", errMsg, err)
+		apierror.Write(w, errMsg, http.StatusBadRequest, apierror.UserIDInvalid)
 		return
 	}
 
 	reqConversation := &models.Conversation{}
-	if err := parseJSON(w, r.Body, reqConversation); err != nil {
+	if err := env.parseJSON(w, r, reqConversation); err != nil {
 		return
 	}
 
 	if reqConversation.Name == "" && reqConversation.Description == nil && reqConversation.AvatarURL == nil {
 		errMsg := `Request body must have one of "name", "description", or "avatar_url"`
-		log.Println(errMsg)
-		http.Error(w, errMsg, http.StatusBadRequest)
+		env.logger(r).Info(errMsg)
+		apierror.Write(w, errMsg, http.StatusBadRequest, apierror.RequestBodyMissingFields)
 		return
 	}
 
@@ -200,36 +237,132 @@ func (env *Env) PatchConversationHandler(w http.ResponseWriter, r *http.Request)
 	conversationID, err := strconv.ParseInt(vars["conversation_id"], 10, 64)
 	if err != nil {
 		errMsg := "Invalid conversation ID"
-		log.Println(errMsg + ": " + err.Error())
-		http.Error(w, errMsg, http.StatusBadRequest)
+		env.logger(r).Infof("%s: %v", errMsg, err)
+		apierror.Write(w, errMsg, http.StatusBadRequest, apierror.ConversationIDInvalid)
 		return
 	}
 
-	conversation, err := env.getConversation(w, conversationID)
+	conversation, err := env.getConversation(w, r, conversationID)
 	if err != nil || conversation == nil {
 		return
 	}
 
-	sessionMember, err := env.getMapping(w, userID, conversationID, "Conversation not found")
+	sessionMember, err := env.getMapping(w, r, userID, conversationID, "Conversation not found", apierror.ConversationNotFound)
 	if err != nil || sessionMember == nil {
 		return
 	}
 
 	if *sessionMember.Pending {
 		errMsg := "Cannot modify conversation while invitation is pending"
-		log.Println(errMsg)
-		http.Error(w, errMsg, http.StatusForbidden)
+		env.logger(r).Info(errMsg)
+		apierror.Write(w, errMsg, http.StatusForbidden, apierror.InvitationPending)
+		return
+	}
+
+	if !env.checkIfMatch(w, r, conversationETag(conversation)) {
 		return
 	}
 
 	newConversation := conversation.Merge(reqConversation)
 
-	err = env.DB.UpdateConversation(newConversation)
+	err = env.withEvents(r.Context(), func(tx models.Datastore) ([]*models.Event, error) {
+		if err := tx.UpdateConversation(r.Context(), newConversation, conversation); err != nil {
+			return nil, err
+		}
+		return []*models.Event{&models.Event{
+			ConversationID: conversationID,
+			ActorID:        &userID,
+			Action:         models.EventConversationUpdated,
+			Before:         models.EventValue(newConversationValues(conversation)),
+			After:          models.EventValue(newConversationValues(newConversation)),
+		}}, nil
+	})
+	if env.modified(w, r, err) {
+		return
+	} else if err != nil {
+		env.internalServerError(w, r, err)
+		return
+	}
+
+	// Read the conversation back for its LastModified, so that the response
+	// matches what GetConversationHandler returns
+	updatedConversation, err := env.DB.GetConversation(r.Context(), conversationID)
 	if err != nil {
-		internalServerError(w, err)
+		env.internalServerError(w, r, err)
 		return
 	}
+	if updatedConversation == nil {
+		updatedConversation = newConversation
+	}
 
+	w.Header().Set("ETag", conversationETag(updatedConversation))
 	w.Header().Add("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(newConversation)
+	json.NewEncoder(w).Encode(updatedConversation)
+}
+
+// PostConversationReadHandler marks a single conversation as read by the
+// session user
+func (env *Env) PostConversationReadHandler(w http.ResponseWriter, r *http.Request) {
+	userID, err := strconv.ParseInt(r.Header.Get("User-ID"), 10, 64)
+	if err != nil {
+		errMsg := "Invalid user ID"
+		env.logger(r).Infof("%s: %v", errMsg, err)
+		apierror.Write(w, errMsg, http.StatusBadRequest, apierror.UserIDInvalid)
+		return
+	}
+
+	vars := mux.Vars(r)
+	conversationID, err := strconv.ParseInt(vars["conversation_id"], 10, 64)
+	if err != nil {
+		errMsg := "Invalid conversation ID"
+		env.logger(r).Infof("%s: %v", errMsg, err)
+		apierror.Write(w, errMsg, http.StatusBadRequest, apierror.ConversationIDInvalid)
+		return
+	}
+
+	conversation, err := env.getConversation(w, r, conversationID)
+	if err != nil || conversation == nil {
+		return
+	}
+
+	sessionMember, err := env.getMapping(w, r, userID, conversationID, "Conversation not found", apierror.ConversationNotFound)
+	if err != nil || sessionMember == nil {
+		return
+	}
+
+	if *sessionMember.Pending {
+		errMsg := "Cannot mark conversation as read while invitation is pending"
+		env.logger(r).Info(errMsg)
+		apierror.Write(w, errMsg, http.StatusForbidden, apierror.InvitationPending)
+		return
+	}
+
+	if err := env.DB.TouchUserConversationMapping(r.Context(), userID, conversationID); err != nil {
+		env.internalServerError(w, r, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// GetUnreadHandler returns the number of a user's conversations that were
+// modified after the user last opened them
+func (env *Env) GetUnreadHandler(w http.ResponseWriter, r *http.Request) {
+	userID, err := strconv.ParseInt(r.Header.Get("User-ID"), 10, 64)
+	if err != nil {
+		errMsg := "Invalid user ID"
+		env.logger(r).Infof("%s: %v", errMsg, err)
+		apierror.Write(w, errMsg, http.StatusBadRequest, apierror.UserIDInvalid)
+		return
+	}
+
+	count, err := env.DB.GetUnreadCount(r.Context(), userID)
+	if err != nil {
+		env.internalServerError(w, r, err)
+		return
+	}
+
+	w.Header().Add("Content-Type", "application/json")
+	response := map[string]int{"unread": count}
+	json.NewEncoder(w).Encode(response)
 }
@@ -288,6 +288,7 @@ func TestGetConversationsHandler(t *testing.T) {
 					Description:  utils.StringPtr("test_desc"),
 					AvatarURL:    utils.StringPtr("test_url"),
 					LastModified: "2006-01-02 15:04:05",
+					Unread:       utils.BoolPtr(false),
 				},
 				&models.Conversation{
 					ID:           1,
@@ -295,6 +296,7 @@ func TestGetConversationsHandler(t *testing.T) {
 					Description:  utils.StringPtr("test_desc"),
 					AvatarURL:    utils.StringPtr("test_url"),
 					LastModified: "2006-01-02 15:04:06",
+					Unread:       utils.BoolPtr(true),
 				},
 			},
 			},
@@ -681,10 +683,17 @@ func TestDeleteConversationsHandler(t *testing.T) {
 				[]*models.UserConversationMapping{test.Mapping},
 				nil,
 			)
+			_ = mDB.CreateWebhook(r.Context(), &models.Webhook{
+				ConversationID: conversationID,
+				URL:            "https://example.com/hook",
+				Enabled:        utils.BoolPtr(true),
+			})
+			notifier := &eventRecorder{}
 
 			env := &Env{
 				DB:        mDB,
 				Directory: filesystem.NewDirectory(contentDir),
+				Webhooks:  notifier,
 			}
 			env.DeleteConversationHandler(w, r)
 
@@ -702,7 +711,154 @@ func TestDeleteConversationsHandler(t *testing.T) {
 						t.Errorf("File still exists at location: %s", filePath)
 					}
 				}
+
+				// The webhooks are deleted with the conversation, and the
+				// deletion is sent to them
+				if len(mDB.Webhooks) != 0 {
+					t.Error("Didn't delete the conversation's webhooks")
+				}
+				if len(notifier.events) != 1 || len(notifier.events[0].Webhooks) != 1 {
+					t.Error("Deletion event was not given the deleted webhooks")
+				}
+			} else if len(mDB.Webhooks) != 1 {
+				t.Error("Improperly deleted the conversation's webhooks")
+			}
+		})
+	}
+}
+
+func TestPostConversationReadHandler(t *testing.T) {
+	tests := []struct {
+		Name         string
+		StatusCode   int
+		Conversation *models.Conversation
+		Mapping      *models.UserConversationMapping
+	}{
+		{
+			Name:       "Successful conversation read",
+			StatusCode: http.StatusNoContent,
+			Conversation: &models.Conversation{
+				ID:           1,
+				Name:         "test_name",
+				LastModified: "2006-01-02 15:04:06",
+			},
+			Mapping: &models.UserConversationMapping{
+				UserID:         1,
+				ConversationID: 1,
+				Role:           "user",
+				Nickname:       utils.StringPtr(""),
+				Pending:        utils.BoolPtr(false),
+				LastOpened:     "2006-01-02 15:04:05",
+			},
+		},
+		{
+			Name:       "Failed conversation read (pending invitation)",
+			StatusCode: http.StatusForbidden,
+			Conversation: &models.Conversation{
+				ID:           1,
+				Name:         "test_name",
+				LastModified: "2006-01-02 15:04:06",
+			},
+			Mapping: &models.UserConversationMapping{
+				UserID:         1,
+				ConversationID: 1,
+				Role:           "user",
+				Nickname:       utils.StringPtr(""),
+				Pending:        utils.BoolPtr(true),
+				LastOpened:     "2006-01-02 15:04:05",
+			},
+		},
+		{
+			Name:       "Failed conversation read (user not in conversation)",
+			StatusCode: http.StatusNotFound,
+			Conversation: &models.Conversation{
+				ID:           1,
+				Name:         "test_name",
+				LastModified: "2006-01-02 15:04:06",
+			},
+		},
+	}
+
+	var userID int64 = 1
+	var conversationID int64 = 1
+	for _, test := range tests {
+		t.Run(test.Name, func(t *testing.T) {
+			r := httptest.NewRequest("POST", "/ether/v1/conversations/1/read", nil)
+			r.Header.Set("User-ID", strconv.FormatInt(userID, 10))
+			r = mux.SetURLVars(r, map[string]string{
+				"conversation_id": strconv.FormatInt(conversationID, 10),
+			})
+			w := httptest.NewRecorder()
+
+			mDB := models.NewMockDB(
+				[]*models.Conversation{test.Conversation},
+				[]*models.UserConversationMapping{test.Mapping},
+				nil,
+			)
+
+			env := &Env{DB: mDB}
+			env.PostConversationReadHandler(w, r)
+
+			if w.Code != test.StatusCode {
+				t.Errorf("Response has incorrect status code, expected status code %d, got %d", test.StatusCode, w.Code)
+			}
+
+			if w.Code == http.StatusNoContent {
+				// Validate DB function calls
+				lastOpened := mDB.GetMapping(userID, conversationID).LastOpened
+				if lastOpened <= test.Conversation.LastModified {
+					t.Errorf("Didn't properly update last opened time, got %s", lastOpened)
+				}
 			}
 		})
 	}
 }
+
+func TestGetUnreadHandler(t *testing.T) {
+	var userID int64 = 1
+	r := httptest.NewRequest("GET", "/ether/v1/unread", nil)
+	r.Header.Set("User-ID", strconv.FormatInt(userID, 10))
+	w := httptest.NewRecorder()
+
+	mDB := models.NewMockDB(
+		[]*models.Conversation{
+			&models.Conversation{ID: 1, Name: "test_name", LastModified: "2006-01-02 15:04:06"},
+			&models.Conversation{ID: 2, Name: "test_name", LastModified: "2006-01-02 15:04:04"},
+			&models.Conversation{ID: 3, Name: "test_name", LastModified: "2006-01-02 15:04:06"},
+		},
+		[]*models.UserConversationMapping{
+			&models.UserConversationMapping{
+				UserID:         1,
+				ConversationID: 1,
+				Pending:        utils.BoolPtr(false),
+				LastOpened:     "2006-01-02 15:04:05",
+			},
+			&models.UserConversationMapping{
+				UserID:         1,
+				ConversationID: 2,
+				Pending:        utils.BoolPtr(false),
+				LastOpened:     "2006-01-02 15:04:05",
+			},
+			&models.UserConversationMapping{
+				UserID:         1,
+				ConversationID: 3,
+				Pending:        utils.BoolPtr(true),
+				LastOpened:     "2006-01-02 15:04:05",
+			},
+		},
+		nil,
+	)
+
+	env := &Env{DB: mDB}
+	env.GetUnreadHandler(w, r)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("Response has incorrect status code, expected status code %d, got %d", http.StatusOK, w.Code)
+	}
+
+	resBody := map[string]int{}
+	_ = json.NewDecoder(w.Body).Decode(&resBody)
+	if resBody["unread"] != 1 {
+		t.Errorf("Response has incorrect unread count, expected 1, got %d", resBody["unread"])
+	}
+}
@@ -0,0 +1,17 @@
+package handlers
+
+import (
+	"ether/apierror"
+	"net/http"
+)
+
+// NotFoundHandler responds to requests whose path does not match any route
+func NotFoundHandler(w http.ResponseWriter, r *http.Request) {
+	apierror.Write(w, "Route not found", http.StatusNotFound, apierror.RouteNotFound)
+}
+
+// MethodNotAllowedHandler responds to requests whose path matches a route that
+// does not support their method
+func MethodNotAllowedHandler(w http.ResponseWriter, r *http.Request) {
+	apierror.Write(w, "Method not allowed", http.StatusMethodNotAllowed, apierror.MethodNotAllowed)
+}
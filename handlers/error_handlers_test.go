@@ -0,0 +1,184 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"ether/apierror"
+	"ether/karen"
+	"ether/models"
+	"ether/utils"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	"github.com/go-sql-driver/mysql"
+	"github.com/gorilla/mux"
+)
+
+func TestErrorResponses(t *testing.T) {
+	tests := []struct {
+		Name           string
+		StatusCode     int
+		UserID         string
+		ConversationID string
+		ReqBody        string
+		Error          error
+		ResBody        *apierror.Error
+	}{
+		{
+			Name:           "Invalid user ID",
+			StatusCode:     http.StatusBadRequest,
+			UserID:         "abc",
+			ConversationID: "1",
+			ReqBody:        `{"user_id": 3, "role": "user"}`,
+			ResBody: &apierror.Error{
+				Code:    apierror.UserIDInvalid,
+				Message: "Invalid user ID",
+				Details: apierror.Details{},
+			},
+		},
+		{
+			Name:           "Conversation not found",
+			StatusCode:     http.StatusNotFound,
+			UserID:         "1",
+			ConversationID: "2",
+			ReqBody:        `{"user_id": 3, "role": "user"}`,
+			ResBody: &apierror.Error{
+				Code:    apierror.ConversationNotFound,
+				Message: "Conversation not found",
+				Details: apierror.Details{},
+			},
+		},
+		{
+			Name:           "Session user not in conversation",
+			StatusCode:     http.StatusNotFound,
+			UserID:         "4",
+			ConversationID: "1",
+			ReqBody:        `{"user_id": 3, "role": "user"}`,
+			ResBody: &apierror.Error{
+				Code:    apierror.ConversationNotFound,
+				Message: "Conversation not found",
+				Details: apierror.Details{},
+			},
+		},
+		{
+			Name:           "Invitation pending",
+			StatusCode:     http.StatusForbidden,
+			UserID:         "2",
+			ConversationID: "1",
+			ReqBody:        `{"user_id": 3, "role": "user"}`,
+			ResBody: &apierror.Error{
+				Code:    apierror.InvitationPending,
+				Message: "Cannot add users to conversation while invitation is pending",
+				Details: apierror.Details{},
+			},
+		},
+		{
+			Name:           "Invalid role",
+			StatusCode:     http.StatusBadRequest,
+			UserID:         "1",
+			ConversationID: "1",
+			ReqBody:        `{"user_id": 3, "role": "king"}`,
+			ResBody: &apierror.Error{
+				Code:    apierror.RoleInvalid,
+				Message: "Invalid role value",
+				Details: apierror.Details{},
+			},
+		},
+		{
+			Name:           "Member conflict",
+			StatusCode:     http.StatusConflict,
+			UserID:         "1",
+			ConversationID: "1",
+			ReqBody:        `{"user_id": 3, "role": "user"}`,
+			Error:          &mysql.MySQLError{Number: 1062, Message: ""},
+			ResBody: &apierror.Error{
+				Code:    apierror.MemberConflict,
+				Message: "User 3 is already in conversation 1",
+				Details: apierror.Details{},
+			},
+		},
+		{
+			Name:           "Request body with wrong type",
+			StatusCode:     http.StatusBadRequest,
+			UserID:         "1",
+			ConversationID: "1",
+			ReqBody:        `{"user_id": "3", "role": "user"}`,
+			ResBody: &apierror.Error{
+				Code:    apierror.RequestBodyInvalid,
+				Message: "Failed to parse request body",
+				Details: apierror.Details{
+					"field":    "user_id",
+					"expected": "number",
+					"offset":   float64(15),
+				},
+			},
+		},
+		{
+			Name:           "Request body with invalid JSON",
+			StatusCode:     http.StatusBadRequest,
+			UserID:         "1",
+			ConversationID: "1",
+			ReqBody:        `{"user_id": 3,}`,
+			ResBody: &apierror.Error{
+				Code:    apierror.RequestBodyInvalid,
+				Message: "Failed to parse request body",
+				Details: apierror.Details{"offset": float64(15)},
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.Name, func(t *testing.T) {
+			r := httptest.NewRequest("POST", "/ether/v1/conversations/1/users", bytes.NewBufferString(test.ReqBody))
+			r.Header.Set("User-ID", test.UserID)
+			r = mux.SetURLVars(r, map[string]string{"conversation_id": test.ConversationID})
+			w := httptest.NewRecorder()
+
+			var errList []error = nil
+			if test.Error != nil {
+				errList = make([]error, 3)
+				errList[2] = test.Error
+			}
+			mDB := models.NewMockDB(
+				[]*models.Conversation{&models.Conversation{ID: 1, Name: "testname"}},
+				[]*models.UserConversationMapping{
+					&models.UserConversationMapping{
+						UserID:         1,
+						ConversationID: 1,
+						Role:           models.Owner,
+						Pending:        utils.BoolPtr(false),
+					},
+					&models.UserConversationMapping{
+						UserID:         2,
+						ConversationID: 1,
+						Role:           models.User,
+						Pending:        utils.BoolPtr(true),
+					},
+				},
+				errList,
+			)
+
+			env := &Env{
+				DB:    mDB,
+				Karen: karen.NewMockClient([]int64{2, 3}, nil),
+			}
+			env.PostMappingHandler(w, r)
+
+			if w.Code != test.StatusCode {
+				t.Errorf("Response has incorrect status code, expected status code %d, got %d", test.StatusCode, w.Code)
+				return
+			}
+			if contentType := w.Header().Get("Content-Type"); contentType != "application/json" {
+				t.Errorf("Response has incorrect Content-Type, expected %q, got %q", "application/json", contentType)
+			}
+
+			resBody := apierror.Error{}
+			_ = json.NewDecoder(w.Body).Decode(&resBody)
+			if !reflect.DeepEqual(*test.ResBody, resBody) {
+				t.Errorf("Response has incorrect body, expected %+v, got %+v", *test.ResBody, resBody)
+			}
+		})
+	}
+}
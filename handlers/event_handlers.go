@@ -0,0 +1,178 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"ether/apierror"
+	"ether/models"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gorilla/mux"
+)
+
+const (
+	defaultEventsLimit = 50
+	maxEventsLimit     = 200
+)
+
+// conversationValues represents the metadata of a conversation that is
+// recorded in its activity log
+type conversationValues struct {
+	Name        string  `json:"name"`
+	Description *string `json:"description"`
+	AvatarURL   *string `json:"avatar_url"`
+}
+
+func newConversationValues(conversation *models.Conversation) *conversationValues {
+	return &conversationValues{
+		Name:        conversation.Name,
+		Description: conversation.Description,
+		AvatarURL:   conversation.AvatarURL,
+	}
+}
+
+// memberValues represents the details of a member that are recorded in a
+// conversation's activity log
+type memberValues struct {
+	Role     models.Role `json:"role"`
+	Nickname *string     `json:"nickname"`
+	Pending  *bool       `json:"pending"`
+}
+
+func newMemberValues(member *models.UserConversationMapping) *memberValues {
+	return &memberValues{
+		Role:     member.Role,
+		Nickname: member.Nickname,
+		Pending:  member.Pending,
+	}
+}
+
+// withEvents makes a change with the Datastore given to change, and appends
+// the events that change returns to their conversations' activity logs, in a
+// single transaction, so that a change is never made without being recorded
+// or recorded without being made. Webhooks are told about the events once the
+// transaction is committed.
+func (env *Env) withEvents(ctx context.Context, change func(tx models.Datastore) ([]*models.Event, error)) error {
+	var events []*models.Event
+	err := env.DB.Transaction(ctx, func(tx models.Datastore) error {
+		var err error
+		events, err = change(tx)
+		if err != nil {
+			return err
+		}
+		for _, event := range events {
+			if err := tx.CreateEvent(ctx, event); err != nil {
+				return fmt.Errorf("Failed to record %s event: %w", event.Action, err)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	if env.Webhooks != nil {
+		for _, event := range events {
+			env.Webhooks.Notify(event)
+		}
+	}
+	return nil
+}
+
+// GetEventsHandler gets a page of a conversation's activity log
+func (env *Env) GetEventsHandler(w http.ResponseWriter, r *http.Request) {
+	userID, err := strconv.ParseInt(r.Header.Get("User-ID"), 10, 64)
+	if err != nil {
+		errMsg := "Invalid user ID"
+		env.logger(r).Infof("%s: %v", errMsg, err)
+		apierror.Write(w, errMsg, http.StatusBadRequest, apierror.UserIDInvalid)
+		return
+	}
+
+	vars := mux.Vars(r)
+	conversationID, err := strconv.ParseInt(vars["conversation_id"], 10, 64)
+	if err != nil {
+		errMsg := "Invalid conversation ID"
+		env.logger(r).Infof("%s: %v", errMsg, err)
+		apierror.Write(w, errMsg, http.StatusBadRequest, apierror.ConversationIDInvalid)
+		return
+	}
+
+	filter := models.EventFilter{Limit: defaultEventsLimit}
+	query := r.URL.Query()
+	if limit := query.Get("limit"); limit != "" {
+		filter.Limit, err = strconv.Atoi(limit)
+		if err != nil || filter.Limit < 1 || filter.Limit > maxEventsLimit {
+			errMsg := fmt.Sprintf("Limit must be between 1 and %d", maxEventsLimit)
+			env.logger(r).Info(errMsg)
+			apierror.WriteDetails(w, errMsg, http.StatusBadRequest, apierror.ParameterInvalid, apierror.Details{"parameter": "limit"})
+			return
+		}
+	}
+	if cursor := query.Get("cursor"); cursor != "" {
+		filter.Cursor, err = strconv.ParseInt(cursor, 10, 64)
+		if err != nil || filter.Cursor < 1 {
+			errMsg := "Invalid cursor"
+			env.logger(r).Info(errMsg)
+			apierror.WriteDetails(w, errMsg, http.StatusBadRequest, apierror.ParameterInvalid, apierror.Details{"parameter": "cursor"})
+			return
+		}
+	}
+	for _, types := range query["type"] {
+		for _, name := range strings.Split(types, ",") {
+			action := models.EventAction(strings.TrimSpace(name))
+			if !action.Valid() {
+				errMsg := fmt.Sprintf("Invalid event type: %s", action)
+				env.logger(r).Info(errMsg)
+				apierror.WriteDetails(w, errMsg, http.StatusBadRequest, apierror.ParameterInvalid, apierror.Details{"parameter": "type"})
+				return
+			}
+			filter.Actions = append(filter.Actions, action)
+		}
+	}
+
+	conversation, err := env.getConversation(w, r, conversationID)
+	if err != nil || conversation == nil {
+		return
+	}
+
+	sessionMember, err := env.getMapping(w, r, userID, conversationID, "Conversation not found", apierror.ConversationNotFound)
+	if err != nil || sessionMember == nil {
+		return
+	}
+
+	if *sessionMember.Pending {
+		errMsg := "Cannot view conversation events while invitation is pending"
+		env.logger(r).Info(errMsg)
+		apierror.Write(w, errMsg, http.StatusForbidden, apierror.InvitationPending)
+		return
+	}
+
+	if sessionMember.Role != models.Owner && sessionMember.Role != models.Admin {
+		errMsg := fmt.Sprintf("User %d cannot view events of conversation %d", userID, conversationID)
+		env.logger(r).Info(errMsg)
+		apierror.Write(w, "Forbidden from viewing conversation events", http.StatusForbidden, apierror.Forbidden)
+		return
+	}
+
+	// Read one more event than requested to know if there is another page
+	pageSize := filter.Limit
+	filter.Limit++
+	events, err := env.DB.GetEvents(r.Context(), conversationID, filter)
+	if err != nil {
+		env.internalServerError(w, r, err)
+		return
+	}
+
+	response := &models.EventList{Events: events}
+	if len(events) > pageSize {
+		response.Events = events[:pageSize]
+		response.NextCursor = &events[pageSize-1].ID
+	}
+
+	w.Header().Add("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
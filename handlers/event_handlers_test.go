@@ -0,0 +1,217 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"ether/models"
+	"ether/utils"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strconv"
+	"testing"
+
+	"github.com/gorilla/mux"
+)
+
+func TestGetEventsHandler(t *testing.T) {
+	var ownerID int64 = 1337
+	var memberID int64 = 1338
+	events := []*models.Event{
+		&models.Event{ConversationID: 11, ActorID: &ownerID, Action: models.EventConversationCreated},
+		&models.Event{ConversationID: 11, ActorID: &ownerID, Action: models.EventMemberAdded, TargetID: &memberID},
+		&models.Event{ConversationID: 12, ActorID: &ownerID, Action: models.EventConversationCreated},
+		&models.Event{ConversationID: 11, ActorID: &ownerID, Action: models.EventConversationUpdated},
+	}
+
+	tests := []struct {
+		Name          string
+		StatusCode    int
+		Query         string
+		SessionMember *models.UserConversationMapping
+		ResIDs        []int64
+		NextCursor    *int64
+	}{
+		{
+			Name:       "Successful events retrieval (owner)",
+			StatusCode: http.StatusOK,
+			SessionMember: &models.UserConversationMapping{
+				UserID:         ownerID,
+				ConversationID: 11,
+				Role:           models.Owner,
+				Pending:        utils.BoolPtr(false),
+			},
+			ResIDs: []int64{4, 2, 1},
+		},
+		{
+			Name:       "Successful events retrieval (admin, paginated)",
+			StatusCode: http.StatusOK,
+			Query:      "?limit=1&cursor=4",
+			SessionMember: &models.UserConversationMapping{
+				UserID:         ownerID,
+				ConversationID: 11,
+				Role:           models.Admin,
+				Pending:        utils.BoolPtr(false),
+			},
+			ResIDs:     []int64{2},
+			NextCursor: utils.Int64Ptr(2),
+		},
+		{
+			Name:       "Successful events retrieval (type filter)",
+			StatusCode: http.StatusOK,
+			Query:      "?type=conversation_created,conversation_updated",
+			SessionMember: &models.UserConversationMapping{
+				UserID:         ownerID,
+				ConversationID: 11,
+				Role:           models.Owner,
+				Pending:        utils.BoolPtr(false),
+			},
+			ResIDs: []int64{4, 1},
+		},
+		{
+			Name:       "Invalid event type",
+			StatusCode: http.StatusBadRequest,
+			Query:      "?type=renamed",
+			SessionMember: &models.UserConversationMapping{
+				UserID:         ownerID,
+				ConversationID: 11,
+				Role:           models.Owner,
+				Pending:        utils.BoolPtr(false),
+			},
+		},
+		{
+			Name:       "Invalid limit",
+			StatusCode: http.StatusBadRequest,
+			Query:      "?limit=1000",
+			SessionMember: &models.UserConversationMapping{
+				UserID:         ownerID,
+				ConversationID: 11,
+				Role:           models.Owner,
+				Pending:        utils.BoolPtr(false),
+			},
+		},
+		{
+			Name:       "Forbidden (user)",
+			StatusCode: http.StatusForbidden,
+			SessionMember: &models.UserConversationMapping{
+				UserID:         memberID,
+				ConversationID: 11,
+				Role:           models.User,
+				Pending:        utils.BoolPtr(false),
+			},
+		},
+		{
+			Name:       "Forbidden (pending)",
+			StatusCode: http.StatusForbidden,
+			SessionMember: &models.UserConversationMapping{
+				UserID:         memberID,
+				ConversationID: 11,
+				Role:           models.Admin,
+				Pending:        utils.BoolPtr(true),
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.Name, func(t *testing.T) {
+			r := httptest.NewRequest("GET", "/ether/v1/conversations/11/events"+test.Query, nil)
+			r.Header.Set("User-ID", strconv.FormatInt(test.SessionMember.UserID, 10))
+			r = mux.SetURLVars(r, map[string]string{"conversation_id": "11"})
+			w := httptest.NewRecorder()
+
+			mDB := models.NewMockDB(
+				[]*models.Conversation{&models.Conversation{ID: 11, Name: "testname"}},
+				[]*models.UserConversationMapping{test.SessionMember},
+				nil,
+			)
+			for _, event := range events {
+				e := *event
+				_ = mDB.CreateEvent(r.Context(), &e)
+			}
+
+			env := &Env{DB: mDB}
+			env.GetEventsHandler(w, r)
+
+			if w.Code != test.StatusCode {
+				t.Errorf("Response has incorrect status code, expected status code %d, got %d", test.StatusCode, w.Code)
+				return
+			}
+
+			if w.Code == http.StatusOK {
+				resBody := models.EventList{}
+				_ = json.NewDecoder(w.Body).Decode(&resBody)
+				resIDs := make([]int64, len(resBody.Events))
+				for i, event := range resBody.Events {
+					resIDs[i] = event.ID
+				}
+				if !reflect.DeepEqual(test.ResIDs, resIDs) {
+					t.Errorf("Response has incorrect events, expected IDs %v, got %v", test.ResIDs, resIDs)
+				}
+				if !reflect.DeepEqual(test.NextCursor, resBody.NextCursor) {
+					t.Errorf("Response has incorrect next cursor, expected %v, got %v", test.NextCursor, resBody.NextCursor)
+				}
+			}
+		})
+	}
+}
+
+// eventRecorder is an EventNotifier that remembers the events it is told
+// about.
+type eventRecorder struct {
+	events []*models.Event
+}
+
+func (n *eventRecorder) Notify(event *models.Event) {
+	n.events = append(n.events, event)
+}
+
+func TestEventRecordingFailure(t *testing.T) {
+	tests := []struct {
+		Name       string
+		StatusCode int
+		Error      error
+		Events     int
+	}{
+		{
+			Name:       "Change is recorded",
+			StatusCode: http.StatusNoContent,
+			Events:     1,
+		},
+		{
+			Name:       "Change fails when its event cannot be recorded",
+			StatusCode: http.StatusInternalServerError,
+			Error:      errors.New("test error"),
+			Events:     0,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.Name, func(t *testing.T) {
+			r := httptest.NewRequest("DELETE", "/ether/v1/conversations/11/users/1338", nil)
+			r.Header.Set("User-ID", "1337")
+			r = mux.SetURLVars(r, map[string]string{"conversation_id": "11", "user_id": "1338"})
+			w := httptest.NewRecorder()
+
+			// The event is created after getting the conversation, both
+			// members and deleting the member
+			mDB := models.NewMockDB(
+				[]*models.Conversation{&models.Conversation{ID: 11}},
+				[]*models.UserConversationMapping{
+					&models.UserConversationMapping{UserID: 1337, ConversationID: 11, Role: models.Owner, Pending: utils.BoolPtr(false)},
+					&models.UserConversationMapping{UserID: 1338, ConversationID: 11, Role: models.User, Pending: utils.BoolPtr(false)},
+				},
+				[]error{nil, nil, nil, nil, test.Error},
+			)
+			notifier := &eventRecorder{}
+			env := &Env{DB: mDB, Webhooks: notifier}
+			env.DeleteMappingHandler(w, r)
+
+			if w.Code != test.StatusCode {
+				t.Errorf("Response has incorrect status code, expected status code %d, got %d", test.StatusCode, w.Code)
+			}
+			if len(notifier.events) != test.Events {
+				t.Errorf("Incorrect number of events notified, expected %d, got %d", test.Events, len(notifier.events))
+			}
+		})
+	}
+}
@@ -0,0 +1,88 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"sync"
+	"time"
+)
+
+const (
+	healthCheckTimeout = 2 * time.Second
+
+	healthStatusOK          = "ok"
+	healthStatusUnavailable = "unavailable"
+)
+
+// HealthCheck represents a check of a dependency that must be working for the
+// service to be ready to handle requests.
+type HealthCheck struct {
+	Name  string
+	Check func(ctx context.Context) error
+}
+
+// HealthCheckResult represents the outcome of a single HealthCheck.
+type HealthCheckResult struct {
+	Status string `json:"status"`
+	Error  string `json:"error,omitempty"`
+}
+
+// HealthResponse represents the body of a liveness or readiness response.
+type HealthResponse struct {
+	Status string                       `json:"status"`
+	Checks map[string]HealthCheckResult `json:"checks,omitempty"`
+}
+
+func writeHealthResponse(w http.ResponseWriter, status int, response HealthResponse) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(response)
+}
+
+// GetHealthzHandler reports that the process is alive and serving HTTP.
+func (env *Env) GetHealthzHandler(w http.ResponseWriter, r *http.Request) {
+	writeHealthResponse(w, http.StatusOK, HealthResponse{Status: healthStatusOK})
+}
+
+// GetReadyzHandler runs every readiness check concurrently and reports the
+// result of each, responding with 503 if any of them failed.
+func (env *Env) GetReadyzHandler(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), healthCheckTimeout)
+	defer cancel()
+
+	results := make([]HealthCheckResult, len(env.ReadinessChecks))
+	var wg sync.WaitGroup
+	for i, check := range env.ReadinessChecks {
+		wg.Add(1)
+		go func(i int, check HealthCheck) {
+			defer wg.Done()
+			results[i] = HealthCheckResult{Status: healthStatusOK}
+			if err := check.Check(ctx); err != nil {
+				results[i] = HealthCheckResult{
+					Status: healthStatusUnavailable,
+					Error:  err.Error(),
+				}
+			}
+		}(i, check)
+	}
+	wg.Wait()
+
+	response := HealthResponse{
+		Status: healthStatusOK,
+		Checks: make(map[string]HealthCheckResult, len(results)),
+	}
+	status := http.StatusOK
+	for i, result := range results {
+		name := env.ReadinessChecks[i].Name
+		response.Checks[name] = result
+		if result.Status != healthStatusOK {
+			env.logger(r).Warnf("Readiness check %s failed: %s", name, result.Error)
+			response.Status = healthStatusUnavailable
+			status = http.StatusServiceUnavailable
+		}
+	}
+
+	writeHealthResponse(w, status, response)
+}
@@ -0,0 +1,90 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+)
+
+func TestGetHealthzHandler(t *testing.T) {
+	r := httptest.NewRequest("GET", "/healthz", nil)
+	w := httptest.NewRecorder()
+
+	env := &Env{}
+	env.GetHealthzHandler(w, r)
+
+	if w.Code != http.StatusOK {
+		t.Errorf("Response has incorrect status code, expected status code %d, got %d", http.StatusOK, w.Code)
+	}
+}
+
+func TestGetReadyzHandler(t *testing.T) {
+	passing := func(context.Context) error { return nil }
+	failing := func(context.Context) error { return errors.New("broken") }
+
+	tests := []struct {
+		Name       string
+		StatusCode int
+		Checks     []HealthCheck
+		ResBody    *HealthResponse
+	}{
+		{
+			Name:       "Ready",
+			StatusCode: http.StatusOK,
+			Checks: []HealthCheck{
+				{Name: "db", Check: passing},
+				{Name: "kafka_reader", Check: passing},
+			},
+			ResBody: &HealthResponse{
+				Status: healthStatusOK,
+				Checks: map[string]HealthCheckResult{
+					"db":           {Status: healthStatusOK},
+					"kafka_reader": {Status: healthStatusOK},
+				},
+			},
+		},
+		{
+			Name:       "Not ready (failing check)",
+			StatusCode: http.StatusServiceUnavailable,
+			Checks: []HealthCheck{
+				{Name: "db", Check: passing},
+				{Name: "kafka_reader", Check: failing},
+			},
+			ResBody: &HealthResponse{
+				Status: healthStatusUnavailable,
+				Checks: map[string]HealthCheckResult{
+					"db": {Status: healthStatusOK},
+					"kafka_reader": {
+						Status: healthStatusUnavailable,
+						Error:  "broken",
+					},
+				},
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.Name, func(t *testing.T) {
+			r := httptest.NewRequest("GET", "/readyz", nil)
+			w := httptest.NewRecorder()
+
+			env := &Env{ReadinessChecks: test.Checks}
+			env.GetReadyzHandler(w, r)
+
+			if w.Code != test.StatusCode {
+				t.Errorf("Response has incorrect status code, expected status code %d, got %d", test.StatusCode, w.Code)
+			}
+
+			// Validate HTTP response content
+			resBody := HealthResponse{}
+			_ = json.NewDecoder(w.Body).Decode(&resBody)
+			if !reflect.DeepEqual(*test.ResBody, resBody) {
+				t.Errorf("Response has incorrect body, expected %+v, got %+v", *test.ResBody, resBody)
+			}
+		})
+	}
+}
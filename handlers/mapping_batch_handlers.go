@@ -0,0 +1,378 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"ether/apierror"
+	"ether/models"
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+const (
+	maxBatchSize     = 100
+	karenConcurrency = 8
+)
+
+// usersExist concurrently asks Karen whether multiple users exist and returns
+// the answers in the same order, with at most karenConcurrency requests in
+// flight at a time.
+func (env *Env) usersExist(ctx context.Context, userIDs []int64) ([]bool, error) {
+	exists := make([]bool, len(userIDs))
+	errs := make([]error, len(userIDs))
+	sem := make(chan struct{}, karenConcurrency)
+	var wg sync.WaitGroup
+	for i, userID := range userIDs {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(i int, userID int64) {
+			defer func() {
+				<-sem
+				wg.Done()
+			}()
+			exists[i], errs[i] = env.Karen.UserExists(ctx, userID)
+		}(i, userID)
+	}
+	wg.Wait()
+
+	for _, err := range errs {
+		if err != nil {
+			return nil, err
+		}
+	}
+	return exists, nil
+}
+
+// parseBatch parses and validates the list of users in a batch membership
+// request body.
+func (env *Env) parseBatch(w http.ResponseWriter, r *http.Request) (*models.UserConversationMappingList, error) {
+	reqList := &models.UserConversationMappingList{}
+	if err := env.parseJSON(w, r, reqList); err != nil {
+		return nil, err
+	}
+
+	if len(reqList.Users) == 0 || len(reqList.Users) > maxBatchSize {
+		errMsg := fmt.Sprintf("Request body must have between 1 and %d users", maxBatchSize)
+		env.logger(r).Info(errMsg)
+		apierror.WriteDetails(w, errMsg, http.StatusBadRequest, apierror.FieldInvalid, apierror.Details{"field": "users"})
+		return nil, errors.New(errMsg)
+	}
+
+	for _, reqMember := range reqList.Users {
+		if reqMember == nil || reqMember.UserID == 0 {
+			errMsg := "Request body is missing field(s)"
+			env.logger(r).Info(errMsg)
+			apierror.Write(w, errMsg, http.StatusBadRequest, apierror.RequestBodyMissingFields)
+			return nil, errors.New(errMsg)
+		}
+		clearResponseFields(reqMember)
+	}
+
+	return reqList, nil
+}
+
+// PostMappingsBatchHandler adds multiple users to a conversation
+func (env *Env) PostMappingsBatchHandler(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+	userID, err := strconv.ParseInt(r.Header.Get("User-ID"), 10, 64)
+	if err != nil {
+		errMsg := "Invalid user ID"
+		env.logger(r).Infof("%s: %v", errMsg, err)
+		apierror.Write(w, errMsg, http.StatusBadRequest, apierror.UserIDInvalid)
+		return
+	}
+
+	vars := mux.Vars(r)
+	conversationID, err := strconv.ParseInt(vars["conversation_id"], 10, 64)
+	if err != nil {
+		errMsg := "Invalid conversation ID"
+		env.logger(r).Infof("%s: %v", errMsg, err)
+		apierror.Write(w, errMsg, http.StatusBadRequest, apierror.ConversationIDInvalid)
+		return
+	}
+
+	conversation, err := env.getConversation(w, r, conversationID)
+	if err != nil || conversation == nil {
+		return
+	}
+
+	sessionMember, err := env.getMapping(w, r, userID, conversationID, "Conversation not found", apierror.ConversationNotFound)
+	if err != nil || sessionMember == nil {
+		return
+	}
+
+	if *sessionMember.Pending {
+		errMsg := "Cannot add users to conversation while invitation is pending"
+		env.logger(r).Info(errMsg)
+		apierror.Write(w, errMsg, http.StatusForbidden, apierror.InvitationPending)
+		return
+	}
+
+	reqList, err := env.parseBatch(w, r)
+	if err != nil {
+		return
+	}
+
+	for _, reqMember := range reqList.Users {
+		if !reqMember.Role.Valid() {
+			errMsg := "Invalid role value"
+			env.logger(r).Info(errMsg)
+			apierror.Write(w, errMsg, http.StatusBadRequest, apierror.RoleInvalid)
+			return
+		}
+	}
+
+	// Decide which users can be added before asking Karen about them
+	results := make([]*models.MappingResult, len(reqList.Users))
+	candidates := make([]int, 0, len(reqList.Users))
+	candidateIDs := make([]int64, 0, len(reqList.Users))
+	seen := make(map[int64]bool)
+	for i, reqMember := range reqList.Users {
+		results[i] = &models.MappingResult{UserID: reqMember.UserID}
+		if seen[reqMember.UserID] {
+			results[i].Status = models.MappingConflict
+			continue
+		}
+		seen[reqMember.UserID] = true
+
+		if !sessionMember.Role.CanAssign(reqMember.Role) {
+			results[i].Status = models.MappingForbidden
+			continue
+		}
+		candidates = append(candidates, i)
+		candidateIDs = append(candidateIDs, reqMember.UserID)
+	}
+
+	if !env.allowMembershipChanges(w, r, userID, conversationID, len(candidateIDs)) {
+		return
+	}
+
+	// Check with Karen if users to be added exist
+	exists, err := env.usersExist(r.Context(), candidateIDs)
+	if err != nil {
+		env.karenError(w, r, err)
+		return
+	}
+
+	newMembers := make([]*models.UserConversationMapping, 0, len(candidates))
+	newMemberIndices := make([]int, 0, len(candidates))
+	now := time.Now()
+	for j, i := range candidates {
+		if !exists[j] {
+			results[i].Status = models.MappingNotFound
+			continue
+		}
+		newMembers = append(newMembers, newMember(conversationID, reqList.Users[i].UserID, reqList.Users[i].Role, now))
+		newMemberIndices = append(newMemberIndices, i)
+	}
+
+	if len(newMembers) > 0 {
+		var rowErrs []error
+		err := env.withEvents(r.Context(), func(tx models.Datastore) ([]*models.Event, error) {
+			if err := env.checkMemberQuota(r.Context(), tx, conversationID, len(newMembers)); err != nil {
+				return nil, err
+			}
+
+			var err error
+			rowErrs, err = tx.CreateUserConversationMappings(r.Context(), newMembers)
+			if err != nil {
+				return nil, err
+			}
+
+			events := make([]*models.Event, 0, len(newMembers))
+			for j, newMember := range newMembers {
+				if rowErrs[j] != nil {
+					continue
+				}
+				events = append(events, &models.Event{
+					ConversationID: conversationID,
+					ActorID:        &userID,
+					Action:         models.EventMemberAdded,
+					TargetID:       &newMember.UserID,
+					After:          models.EventValue(newMemberValues(newMember)),
+				})
+			}
+			return events, nil
+		})
+		if env.quotaExceeded(w, r, err) {
+			return
+		} else if err != nil {
+			env.internalServerError(w, r, err)
+			return
+		}
+
+		added := 0
+		for j, i := range newMemberIndices {
+			if rowErrs[j] != nil {
+				results[i].Status = models.MappingConflict
+				continue
+			}
+			results[i].Status = models.MappingCreated
+			added++
+		}
+		env.chargeMembershipChanges(userID, conversationID, added)
+	}
+
+	w.Header().Add("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(&models.MappingResultList{Results: results})
+}
+
+// DeleteMappingsBatchHandler removes multiple users from a conversation
+func (env *Env) DeleteMappingsBatchHandler(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+	userID, err := strconv.ParseInt(r.Header.Get("User-ID"), 10, 64)
+	if err != nil {
+		errMsg := "Invalid user ID"
+		env.logger(r).Infof("%s: %v", errMsg, err)
+		apierror.Write(w, errMsg, http.StatusBadRequest, apierror.UserIDInvalid)
+		return
+	}
+
+	vars := mux.Vars(r)
+	conversationID, err := strconv.ParseInt(vars["conversation_id"], 10, 64)
+	if err != nil {
+		errMsg := "Invalid conversation ID"
+		env.logger(r).Infof("%s: %v", errMsg, err)
+		apierror.Write(w, errMsg, http.StatusBadRequest, apierror.ConversationIDInvalid)
+		return
+	}
+
+	conversation, err := env.getConversation(w, r, conversationID)
+	if err != nil || conversation == nil {
+		return
+	}
+
+	sessionMember, err := env.getMapping(w, r, userID, conversationID, "Conversation not found", apierror.ConversationNotFound)
+	if err != nil || sessionMember == nil {
+		return
+	}
+
+	reqList, err := env.parseBatch(w, r)
+	if err != nil {
+		return
+	}
+
+	results := make([]*models.MappingResult, len(reqList.Users))
+	targetIndices := make([]int, 0, len(reqList.Users))
+	targetIDs := make([]int64, 0, len(reqList.Users))
+	targetMembers := make([]*models.UserConversationMapping, 0, len(reqList.Users))
+	seen := make(map[int64]bool)
+	for i, reqMember := range reqList.Users {
+		targetMemberID := reqMember.UserID
+		results[i] = &models.MappingResult{UserID: targetMemberID}
+		if seen[targetMemberID] {
+			results[i].Status = models.MappingNotFound
+			continue
+		}
+		seen[targetMemberID] = true
+
+		targetMember := sessionMember
+		if userID == targetMemberID {
+			if sessionMember.Role == models.Owner {
+				results[i].Status = models.MappingForbidden
+				continue
+			}
+		} else {
+			if *sessionMember.Pending {
+				results[i].Status = models.MappingForbidden
+				continue
+			}
+
+			targetMember, err = env.DB.GetUserConversationMapping(r.Context(), targetMemberID, conversationID)
+			if err != nil {
+				env.internalServerError(w, r, err)
+				return
+			}
+			if targetMember == nil {
+				results[i].Status = models.MappingNotFound
+				continue
+			}
+
+			res, err := sessionMember.Role.Compare(targetMember.Role)
+			if err != nil {
+				env.internalServerError(w, r, err)
+				return
+			} else if res != 1 {
+				results[i].Status = models.MappingForbidden
+				continue
+			}
+		}
+
+		targetIndices = append(targetIndices, i)
+		targetIDs = append(targetIDs, targetMemberID)
+		targetMembers = append(targetMembers, targetMember)
+	}
+
+	// Leaving a conversation is never limited
+	removals := 0
+	for _, targetMemberID := range targetIDs {
+		if targetMemberID != userID {
+			removals++
+		}
+	}
+	if !env.allowMembershipChanges(w, r, userID, conversationID, removals) {
+		return
+	}
+
+	if len(targetIDs) > 0 {
+		var deleted []bool
+		err := env.withEvents(r.Context(), func(tx models.Datastore) ([]*models.Event, error) {
+			var err error
+			deleted, err = tx.DeleteUserConversationMappings(r.Context(), targetIDs, conversationID)
+			if err != nil {
+				return nil, err
+			}
+
+			events := make([]*models.Event, 0, len(targetMembers))
+			for j, targetMember := range targetMembers {
+				if !deleted[j] {
+					continue
+				}
+				// A service account cannot exist outside of its conversation,
+				// so it is deleted, which also revokes its API tokens, like
+				// DeleteMappingHandler does
+				if targetMember.Role == models.Service {
+					if err := tx.DeleteServiceAccount(r.Context(), targetIDs[j]); err != nil {
+						return nil, err
+					}
+				}
+				events = append(events, &models.Event{
+					ConversationID: conversationID,
+					ActorID:        &userID,
+					Action:         models.EventMemberRemoved,
+					TargetID:       &targetIDs[j],
+					Before:         models.EventValue(newMemberValues(targetMember)),
+				})
+			}
+			return events, nil
+		})
+		if err != nil {
+			env.internalServerError(w, r, err)
+			return
+		}
+
+		// Members removed concurrently by another request are not found here
+		// and are not charged for
+		removed := 0
+		for j, i := range targetIndices {
+			if !deleted[j] {
+				results[i].Status = models.MappingNotFound
+				continue
+			}
+			results[i].Status = models.MappingDeleted
+			if targetIDs[j] != userID {
+				removed++
+			}
+		}
+		env.chargeMembershipChanges(userID, conversationID, removed)
+	}
+
+	w.Header().Add("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(&models.MappingResultList{Results: results})
+}
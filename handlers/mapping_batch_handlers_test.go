@@ -0,0 +1,553 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"ether/karen"
+	"ether/models"
+	"ether/ratelimit"
+	"ether/utils"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+func TestPostMappingsBatchHandler(t *testing.T) {
+	tests := []struct {
+		Name          string
+		StatusCode    int
+		ReqBody       interface{}
+		ResList       []*models.MappingResult
+		Conversation  *models.Conversation
+		SessionMember *models.UserConversationMapping
+		Members       []*models.UserConversationMapping
+	}{
+		{
+			Name:       "Successful batch member creation",
+			StatusCode: http.StatusOK,
+			ReqBody: map[string]interface{}{
+				"users": []map[string]interface{}{
+					{"user_id": 1, "role": "user"},
+					{"user_id": 2, "role": "admin"},
+					{"user_id": 3, "role": "user"},
+					{"user_id": 404, "role": "user"},
+					{"user_id": 1, "role": "user"},
+				},
+			},
+			ResList: []*models.MappingResult{
+				&models.MappingResult{UserID: 1, Status: models.MappingCreated},
+				&models.MappingResult{UserID: 2, Status: models.MappingForbidden},
+				&models.MappingResult{UserID: 3, Status: models.MappingConflict},
+				&models.MappingResult{UserID: 404, Status: models.MappingNotFound},
+				&models.MappingResult{UserID: 1, Status: models.MappingConflict},
+			},
+			Conversation: &models.Conversation{
+				ID:          11,
+				Name:        "testname",
+				Description: utils.StringPtr("testdesc"),
+			},
+			SessionMember: &models.UserConversationMapping{
+				UserID:         1337,
+				ConversationID: 11,
+				Role:           "admin",
+				Nickname:       utils.StringPtr("testadmin"),
+				Pending:        utils.BoolPtr(false),
+			},
+			Members: []*models.UserConversationMapping{
+				&models.UserConversationMapping{
+					UserID:         3,
+					ConversationID: 11,
+					Role:           "user",
+					Nickname:       utils.StringPtr(""),
+					Pending:        utils.BoolPtr(false),
+				},
+			},
+		},
+		{
+			Name:       "Failed batch member creation (invalid role string)",
+			StatusCode: http.StatusBadRequest,
+			ReqBody: map[string]interface{}{
+				"users": []map[string]interface{}{
+					{"user_id": 1, "role": "foobar"},
+				},
+			},
+			Conversation: &models.Conversation{
+				ID:          11,
+				Name:        "testname",
+				Description: utils.StringPtr("testdesc"),
+			},
+			SessionMember: &models.UserConversationMapping{
+				UserID:         1337,
+				ConversationID: 11,
+				Role:           "owner",
+				Nickname:       utils.StringPtr("testowner"),
+				Pending:        utils.BoolPtr(false),
+			},
+		},
+		{
+			Name:       "Failed batch member creation (empty list)",
+			StatusCode: http.StatusBadRequest,
+			ReqBody: map[string]interface{}{
+				"users": []map[string]interface{}{},
+			},
+			Conversation: &models.Conversation{
+				ID:          11,
+				Name:        "testname",
+				Description: utils.StringPtr("testdesc"),
+			},
+			SessionMember: &models.UserConversationMapping{
+				UserID:         1337,
+				ConversationID: 11,
+				Role:           "owner",
+				Nickname:       utils.StringPtr("testowner"),
+				Pending:        utils.BoolPtr(false),
+			},
+		},
+		{
+			Name:       "Failed batch member creation (pending user)",
+			StatusCode: http.StatusForbidden,
+			ReqBody: map[string]interface{}{
+				"users": []map[string]interface{}{
+					{"user_id": 1, "role": "user"},
+				},
+			},
+			Conversation: &models.Conversation{
+				ID:          11,
+				Name:        "testname",
+				Description: utils.StringPtr("testdesc"),
+			},
+			SessionMember: &models.UserConversationMapping{
+				UserID:         1337,
+				ConversationID: 11,
+				Role:           "user",
+				Nickname:       utils.StringPtr("testuser"),
+				Pending:        utils.BoolPtr(true),
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.Name, func(t *testing.T) {
+			var userID int64 = 1337
+			var conversationID int64 = 11
+
+			reqBody, _ := json.Marshal(test.ReqBody)
+			r := httptest.NewRequest("POST", "/ether/v1/conversations/11/users:batch", bytes.NewReader(reqBody))
+			r.Header.Set("User-ID", strconv.FormatInt(userID, 10))
+			r = mux.SetURLVars(r, map[string]string{
+				"conversation_id": strconv.FormatInt(conversationID, 10),
+			})
+			w := httptest.NewRecorder()
+
+			mDB := models.NewMockDB(
+				[]*models.Conversation{test.Conversation},
+				append(test.Members, test.SessionMember),
+				nil,
+			)
+
+			env := &Env{
+				DB:    mDB,
+				Karen: karen.NewMockClient([]int64{1, 2, 3}, nil),
+			}
+			env.PostMappingsBatchHandler(w, r)
+
+			if w.Code != test.StatusCode {
+				t.Errorf("Response has incorrect status code, expected status code %d, got %d", test.StatusCode, w.Code)
+				return
+			}
+
+			if w.Code == http.StatusOK {
+				// Validate HTTP response content
+				resBody := models.MappingResultList{}
+				_ = json.NewDecoder(w.Body).Decode(&resBody)
+				if !reflect.DeepEqual(test.ResList, resBody.Results) {
+					t.Errorf("Response has incorrect body, expected %+v, got %+v", test.ResList, resBody.Results)
+				}
+
+				// Validate DB function calls
+				for _, result := range test.ResList {
+					if result.Status == models.MappingCreated && mDB.GetMapping(result.UserID, conversationID) == nil {
+						t.Errorf("Didn't properly create member %d", result.UserID)
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestDeleteMappingsBatchHandler(t *testing.T) {
+	tests := []struct {
+		Name          string
+		StatusCode    int
+		ReqBody       interface{}
+		ResList       []*models.MappingResult
+		Conversation  *models.Conversation
+		SessionMember *models.UserConversationMapping
+		Members       []*models.UserConversationMapping
+	}{
+		{
+			Name:       "Successful batch member deletion",
+			StatusCode: http.StatusOK,
+			ReqBody: map[string]interface{}{
+				"users": []map[string]interface{}{
+					{"user_id": 1},
+					{"user_id": 2},
+					{"user_id": 3},
+					{"user_id": 1337},
+				},
+			},
+			ResList: []*models.MappingResult{
+				&models.MappingResult{UserID: 1, Status: models.MappingDeleted},
+				&models.MappingResult{UserID: 2, Status: models.MappingForbidden},
+				&models.MappingResult{UserID: 3, Status: models.MappingNotFound},
+				&models.MappingResult{UserID: 1337, Status: models.MappingDeleted},
+			},
+			Conversation: &models.Conversation{
+				ID:          11,
+				Name:        "testname",
+				Description: utils.StringPtr("testdesc"),
+			},
+			SessionMember: &models.UserConversationMapping{
+				UserID:         1337,
+				ConversationID: 11,
+				Role:           "admin",
+				Nickname:       utils.StringPtr("testadmin"),
+				Pending:        utils.BoolPtr(false),
+			},
+			Members: []*models.UserConversationMapping{
+				&models.UserConversationMapping{
+					UserID:         1,
+					ConversationID: 11,
+					Role:           "user",
+					Nickname:       utils.StringPtr(""),
+					Pending:        utils.BoolPtr(false),
+				},
+				&models.UserConversationMapping{
+					UserID:         2,
+					ConversationID: 11,
+					Role:           "admin",
+					Nickname:       utils.StringPtr(""),
+					Pending:        utils.BoolPtr(false),
+				},
+			},
+		},
+		{
+			Name:       "Successful batch member deletion (owner deletes self)",
+			StatusCode: http.StatusOK,
+			ReqBody: map[string]interface{}{
+				"users": []map[string]interface{}{
+					{"user_id": 1337},
+				},
+			},
+			ResList: []*models.MappingResult{
+				&models.MappingResult{UserID: 1337, Status: models.MappingForbidden},
+			},
+			Conversation: &models.Conversation{
+				ID:          11,
+				Name:        "testname",
+				Description: utils.StringPtr("testdesc"),
+			},
+			SessionMember: &models.UserConversationMapping{
+				UserID:         1337,
+				ConversationID: 11,
+				Role:           "owner",
+				Nickname:       utils.StringPtr("testowner"),
+				Pending:        utils.BoolPtr(false),
+			},
+		},
+		{
+			Name:       "Failed batch member deletion (session user not in conversation)",
+			StatusCode: http.StatusNotFound,
+			ReqBody: map[string]interface{}{
+				"users": []map[string]interface{}{
+					{"user_id": 1},
+				},
+			},
+			Conversation: &models.Conversation{
+				ID:          11,
+				Name:        "testname",
+				Description: utils.StringPtr("testdesc"),
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.Name, func(t *testing.T) {
+			var userID int64 = 1337
+			var conversationID int64 = 11
+
+			reqBody, _ := json.Marshal(test.ReqBody)
+			r := httptest.NewRequest("DELETE", "/ether/v1/conversations/11/users:batch", bytes.NewReader(reqBody))
+			r.Header.Set("User-ID", strconv.FormatInt(userID, 10))
+			r = mux.SetURLVars(r, map[string]string{
+				"conversation_id": strconv.FormatInt(conversationID, 10),
+			})
+			w := httptest.NewRecorder()
+
+			mDB := models.NewMockDB(
+				[]*models.Conversation{test.Conversation},
+				append(test.Members, test.SessionMember),
+				nil,
+			)
+
+			env := &Env{DB: mDB}
+			env.DeleteMappingsBatchHandler(w, r)
+
+			if w.Code != test.StatusCode {
+				t.Errorf("Response has incorrect status code, expected status code %d, got %d", test.StatusCode, w.Code)
+				return
+			}
+
+			if w.Code == http.StatusOK {
+				// Validate HTTP response content
+				resBody := models.MappingResultList{}
+				_ = json.NewDecoder(w.Body).Decode(&resBody)
+				if !reflect.DeepEqual(test.ResList, resBody.Results) {
+					t.Errorf("Response has incorrect body, expected %+v, got %+v", test.ResList, resBody.Results)
+				}
+
+				// Validate DB function calls
+				for _, result := range test.ResList {
+					member := mDB.GetMapping(result.UserID, conversationID)
+					if result.Status == models.MappingDeleted && member != nil {
+						t.Errorf("Didn't properly delete member %d", result.UserID)
+					} else if result.Status == models.MappingForbidden && member == nil {
+						t.Errorf("Improperly deleted member %d", result.UserID)
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestDeleteMappingsBatchHandlerServiceAccount(t *testing.T) {
+	mDB := models.NewMockDB(
+		[]*models.Conversation{&models.Conversation{ID: 1, Name: "testname"}},
+		[]*models.UserConversationMapping{&models.UserConversationMapping{
+			UserID:         1,
+			ConversationID: 1,
+			Role:           models.Owner,
+			Pending:        utils.BoolPtr(false),
+		}},
+		nil,
+	)
+	account := &models.ServiceAccount{ConversationID: 1, Name: "ci-bot", CreatorID: 1}
+	_ = mDB.CreateServiceAccount(context.Background(), account)
+	_ = mDB.CreateAPIToken(context.Background(), &models.APIToken{
+		ServiceAccountID: account.ID,
+		ConversationID:   1,
+		Hash:             "hash",
+	})
+
+	reqBody, _ := json.Marshal(map[string]interface{}{
+		"users": []map[string]interface{}{{"user_id": account.ID}},
+	})
+	r := httptest.NewRequest("DELETE", "/ether/v1/conversations/1/users:batch", bytes.NewReader(reqBody))
+	r.Header.Set("User-ID", "1")
+	r = mux.SetURLVars(r, map[string]string{"conversation_id": "1"})
+	w := httptest.NewRecorder()
+
+	env := &Env{DB: mDB}
+	env.DeleteMappingsBatchHandler(w, r)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("Response has incorrect status code, expected status code %d, got %d", http.StatusOK, w.Code)
+	}
+	resBody := models.MappingResultList{}
+	_ = json.NewDecoder(w.Body).Decode(&resBody)
+	expected := []*models.MappingResult{&models.MappingResult{UserID: account.ID, Status: models.MappingDeleted}}
+	if !reflect.DeepEqual(expected, resBody.Results) {
+		t.Errorf("Response has incorrect body, expected %+v, got %+v", expected, resBody.Results)
+	}
+	if mDB.GetMapping(account.ID, 1) != nil || mDB.ServiceAccounts[account.ID] != nil {
+		t.Error("Service account was not deleted")
+	}
+	if len(mDB.APITokens) != 0 {
+		t.Error("Service account tokens were not revoked")
+	}
+}
+
+// leftDB removes a member just before a batch delete, as if they left the
+// conversation in another request.
+type leftDB struct {
+	*models.MockDB
+	userID int64
+}
+
+func (db *leftDB) Transaction(ctx context.Context, fn func(tx models.Datastore) error) error {
+	return fn(db)
+}
+
+func (db *leftDB) DeleteUserConversationMappings(ctx context.Context, userIDs []int64, conversationID int64) ([]bool, error) {
+	db.SetMapping(db.userID, conversationID, nil)
+	return db.MockDB.DeleteUserConversationMappings(ctx, userIDs, conversationID)
+}
+
+func TestDeleteMappingsBatchHandlerConcurrentDelete(t *testing.T) {
+	mDB := models.NewMockDB(
+		[]*models.Conversation{&models.Conversation{ID: 1, Name: "testname"}},
+		[]*models.UserConversationMapping{
+			&models.UserConversationMapping{
+				UserID:         1,
+				ConversationID: 1,
+				Role:           models.Owner,
+				Pending:        utils.BoolPtr(false),
+			},
+			&models.UserConversationMapping{
+				UserID:         2,
+				ConversationID: 1,
+				Role:           models.User,
+				Pending:        utils.BoolPtr(false),
+			},
+			&models.UserConversationMapping{
+				UserID:         3,
+				ConversationID: 1,
+				Role:           models.User,
+				Pending:        utils.BoolPtr(false),
+			},
+		},
+		nil,
+	)
+	env := &Env{
+		DB:                &leftDB{MockDB: mDB, userID: 3},
+		MembershipLimiter: ratelimit.NewLimiter(2, time.Hour, 2),
+	}
+
+	r := httptest.NewRequest("DELETE", "/ether/v1/conversations/1/users:batch", bytes.NewBufferString(`{"users": [{"user_id": 2}, {"user_id": 3}]}`))
+	r.Header.Set("User-ID", "1")
+	r = mux.SetURLVars(r, map[string]string{"conversation_id": "1"})
+	w := httptest.NewRecorder()
+	env.DeleteMappingsBatchHandler(w, r)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("Response has incorrect status code, expected status code %d, got %d", http.StatusOK, w.Code)
+	}
+	resBody := models.MappingResultList{}
+	_ = json.NewDecoder(w.Body).Decode(&resBody)
+	expected := []*models.MappingResult{
+		&models.MappingResult{UserID: 2, Status: models.MappingDeleted},
+		&models.MappingResult{UserID: 3, Status: models.MappingNotFound},
+	}
+	if !reflect.DeepEqual(expected, resBody.Results) {
+		t.Errorf("Response has incorrect body, expected %+v, got %+v", expected, resBody.Results)
+	}
+	if len(mDB.Events) != 1 || *mDB.Events[0].TargetID != 2 {
+		t.Errorf("Expected a single member_removed event for user 2, got %+v", mDB.Events)
+	}
+	if ok, _ := env.MembershipLimiter.Check(membershipKey(1, 1), 1); !ok {
+		t.Error("Member removed by another request was charged to the limit")
+	}
+}
+
+func TestMembershipLimiter(t *testing.T) {
+	tests := []struct {
+		Name       string
+		StatusCode int
+		Method     string
+		ReqBody    string
+		TargetID   string
+		UserID     string
+	}{
+		{
+			Name:       "Unknown user is not counted",
+			StatusCode: http.StatusNotFound,
+			Method:     "POST",
+			ReqBody:    `{"user_id": 9, "role": "user"}`,
+		},
+		{
+			Name:       "Member added within limit",
+			StatusCode: http.StatusCreated,
+			Method:     "POST",
+			ReqBody:    `{"user_id": 2, "role": "user"}`,
+		},
+		{
+			Name:       "Batch added within limit",
+			StatusCode: http.StatusOK,
+			Method:     "POST",
+			ReqBody:    `{"users": [{"user_id": 3, "role": "user"}, {"user_id": 4, "role": "user"}]}`,
+		},
+		{
+			Name:       "Member added over limit",
+			StatusCode: http.StatusTooManyRequests,
+			Method:     "POST",
+			ReqBody:    `{"user_id": 5, "role": "user"}`,
+		},
+		{
+			Name:       "Member removed over limit",
+			StatusCode: http.StatusTooManyRequests,
+			Method:     "DELETE",
+			TargetID:   "2",
+		},
+		{
+			Name:       "Another member within their own limit",
+			StatusCode: http.StatusCreated,
+			Method:     "POST",
+			ReqBody:    `{"user_id": 5, "role": "user"}`,
+			UserID:     "6",
+		},
+		{
+			Name:       "Member leaves over limit",
+			StatusCode: http.StatusNoContent,
+			Method:     "DELETE",
+			TargetID:   "1",
+		},
+	}
+
+	mDB := models.NewMockDB(
+		[]*models.Conversation{&models.Conversation{ID: 1, Name: "testname"}},
+		[]*models.UserConversationMapping{
+			&models.UserConversationMapping{
+				UserID:         1,
+				ConversationID: 1,
+				Role:           models.Admin,
+				Pending:        utils.BoolPtr(false),
+			},
+			&models.UserConversationMapping{
+				UserID:         6,
+				ConversationID: 1,
+				Role:           models.Admin,
+				Pending:        utils.BoolPtr(false),
+			},
+		},
+		nil,
+	)
+	env := &Env{
+		DB:                mDB,
+		Karen:             karen.NewMockClient([]int64{2, 3, 4, 5, 6}, nil),
+		MembershipLimiter: ratelimit.NewLimiter(3, time.Hour, 3),
+	}
+
+	// Each request depends on the limit used by the ones before it
+	for _, test := range tests {
+		r := httptest.NewRequest(test.Method, "/ether/v1/conversations/1/users", bytes.NewBufferString(test.ReqBody))
+		userID := test.UserID
+		if userID == "" {
+			userID = "1"
+		}
+		r.Header.Set("User-ID", userID)
+		r = mux.SetURLVars(r, map[string]string{"conversation_id": "1", "user_id": test.TargetID})
+		w := httptest.NewRecorder()
+
+		if test.Method == "DELETE" {
+			env.DeleteMappingHandler(w, r)
+		} else if strings.Contains(test.ReqBody, "users") {
+			env.PostMappingsBatchHandler(w, r)
+		} else {
+			env.PostMappingHandler(w, r)
+		}
+
+		if w.Code != test.StatusCode {
+			t.Errorf("%s: Response has incorrect status code, expected status code %d, got %d", test.Name, test.StatusCode, w.Code)
+		}
+		if w.Code == http.StatusTooManyRequests && w.Header().Get("Retry-After") == "" {
+			t.Errorf("%s: Response is missing Retry-After", test.Name)
+		}
+	}
+}
@@ -2,9 +2,9 @@ package handlers
 
 import (
 	"encoding/json"
+	"ether/apierror"
 	"ether/models"
 	"fmt"
-	"log"
 	"net/http"
 	"strconv"
 	"time"
@@ -13,18 +13,14 @@ import (
 	"github.com/gorilla/mux"
 )
 
-const (
-	usersRoute = "/karen/v1/users/"
-)
-
 // PostMappingHandler adds a single user to a conversation
 func (env *Env) PostMappingHandler(w http.ResponseWriter, r *http.Request) {
 	defer r.Body.Close()
 	userID, err := strconv.ParseInt(r.Header.Get("User-ID"), 10, 64)
 	if err != nil {
 		errMsg := "Invalid user ID"
-		log.Println(errMsg + ": " + err.Error())
-		http.Error(w, errMsg, http.StatusBadRequest)
+		env.logger(r).Infof("%s: %v", errMsg, err)
+		apierror.Write(w, errMsg, http.StatusBadRequest, apierror.UserIDInvalid)
 		return
 	}
 
@@ -32,93 +28,95 @@ func (env *Env) PostMappingHandler(w http.ResponseWriter, r *http.Request) {
 	conversationID, err := strconv.ParseInt(vars["conversation_id"], 10, 64)
 	if err != nil {
 		errMsg := "Invalid conversation ID"
-		log.Println(errMsg + ": " + err.Error())
-		http.Error(w, errMsg, http.StatusBadRequest)
+		env.logger(r).Infof("%s: %v", errMsg, err)
+		apierror.Write(w, errMsg, http.StatusBadRequest, apierror.ConversationIDInvalid)
 		return
 	}
 
-	conversation, err := env.getConversation(w, conversationID)
+	conversation, err := env.getConversation(w, r, conversationID)
 	if err != nil || conversation == nil {
 		return
 	}
 
-	sessionMember, err := env.getMapping(w, userID, conversationID, "Conversation not found")
+	sessionMember, err := env.getMapping(w, r, userID, conversationID, "Conversation not found", apierror.ConversationNotFound)
 	if err != nil || sessionMember == nil {
 		return
 	}
 
 	if *sessionMember.Pending {
 		errMsg := "Cannot add users to conversation while invitation is pending"
-		log.Println(errMsg)
-		http.Error(w, errMsg, http.StatusForbidden)
+		env.logger(r).Info(errMsg)
+		apierror.Write(w, errMsg, http.StatusForbidden, apierror.InvitationPending)
 		return
 	}
 
 	reqMember := &models.UserConversationMapping{}
-	if err := parseJSON(w, r.Body, reqMember); err != nil {
+	if err := env.parseJSON(w, r, reqMember); err != nil {
 		return
 	}
+	clearResponseFields(reqMember)
 
 	if reqMember.UserID == 0 || reqMember.Role == "" {
 		errMsg := "Request body is missing field(s)"
-		log.Println(errMsg)
-		http.Error(w, errMsg, http.StatusBadRequest)
+		env.logger(r).Info(errMsg)
+		apierror.Write(w, errMsg, http.StatusBadRequest, apierror.RequestBodyMissingFields)
 		return
 	}
 
-	// Check with Karen if user to be added exists
-	url := fmt.Sprintf("http://" + env.KarenHost + usersRoute + strconv.FormatInt(reqMember.UserID, 10))
-	request, err := http.NewRequest("GET", url, nil)
-	if err != nil {
-		internalServerError(w, err)
+	if !env.allowMembershipChanges(w, r, userID, conversationID, 1) {
 		return
 	}
-	request.Header.Add("User-ID", strconv.FormatInt(reqMember.UserID, 10))
-	response, err := env.Client.Do(request)
+
+	// Check with Karen if user to be added exists
+	exists, err := env.Karen.UserExists(r.Context(), reqMember.UserID)
 	if err != nil {
-		internalServerError(w, err)
+		env.karenError(w, r, err)
 		return
 	}
-	if response.StatusCode != http.StatusOK {
-		if response.StatusCode == http.StatusNotFound {
-			errMsg := "User not found"
-			log.Println(errMsg)
-			http.Error(w, errMsg, http.StatusNotFound)
-		} else {
-			errMsg := response.Status
-			log.Println(errMsg)
-			http.Error(w, errMsg, response.StatusCode)
-		}
+	if !exists {
+		errMsg := "User not found"
+		env.logger(r).Info(errMsg)
+		apierror.Write(w, errMsg, http.StatusNotFound, apierror.UserNotFound)
 		return
 	}
 
-	if !reqMember.Role.Valid() || reqMember.Role == models.Owner ||
-		sessionMember.Role != models.Owner && reqMember.Role != models.User {
+	if !sessionMember.Role.CanAssign(reqMember.Role) {
 		errMsg := "Invalid role value"
-		log.Println(errMsg)
-		http.Error(w, errMsg, http.StatusBadRequest)
+		env.logger(r).Info(errMsg)
+		apierror.Write(w, errMsg, http.StatusBadRequest, apierror.RoleInvalid)
 		return
 	}
 
-	reqMember.ConversationID = conversationID
-	reqMember.Nickname = new(string)
-	var pending bool = false // TODO: set this to true
-	reqMember.Pending = &pending
-	reqMember.LastOpened = time.Now().Format("2006-01-02 15:04:05")
-	err = env.DB.CreateUserConversationMapping(reqMember)
-	if err != nil {
+	reqMember = newMember(conversationID, reqMember.UserID, reqMember.Role, time.Now())
+	err = env.withEvents(r.Context(), func(tx models.Datastore) ([]*models.Event, error) {
+		if err := env.checkMemberQuota(r.Context(), tx, conversationID, 1); err != nil {
+			return nil, err
+		}
+		if err := tx.CreateUserConversationMapping(r.Context(), reqMember); err != nil {
+			return nil, err
+		}
+		return []*models.Event{&models.Event{
+			ConversationID: conversationID,
+			ActorID:        &userID,
+			Action:         models.EventMemberAdded,
+			TargetID:       &reqMember.UserID,
+			After:          models.EventValue(newMemberValues(reqMember)),
+		}}, nil
+	})
+	if env.quotaExceeded(w, r, err) {
+		return
+	} else if err != nil {
 		mySQLErr, ok := err.(*mysql.MySQLError)
 		if ok && mySQLErr.Number == 1062 {
 			errMsg := fmt.Sprintf("User %d is already in conversation %d", reqMember.UserID, conversationID)
-			log.Println(errMsg)
-			http.Error(w, errMsg, http.StatusConflict)
+			env.logger(r).Info(errMsg)
+			apierror.Write(w, errMsg, http.StatusConflict, apierror.MemberConflict)
 		} else {
-			internalServerError(w, err)
+			env.internalServerError(w, r, err)
 		}
 		return
 	}
-
-	// TODO: write to a Kafka topic for patches to read from
+	env.chargeMembershipChanges(userID, conversationID, 1)
 
 	location := fmt.Sprintf("%s/%d", r.URL.Path, reqMember.UserID)
 	w.Header().Add("Location", location)
@@ -132,8 +130,8 @@ func (env *Env) GetMappingHandler(w http.ResponseWriter, r *http.Request) {
 	userID, err := strconv.ParseInt(r.Header.Get("User-ID"), 10, 64)
 	if err != nil {
 		errMsg := "Invalid user ID"
-		log.Println(errMsg + ": " + err.Error())
-		http.Error(w, errMsg, http.StatusBadRequest)
+		env.logger(r).Infof("%s: %v", errMsg, err)
+		apierror.Write(w, errMsg, http.StatusBadRequest, apierror.UserIDInvalid)
 		return
 	}
 
@@ -141,38 +139,45 @@ func (env *Env) GetMappingHandler(w http.ResponseWriter, r *http.Request) {
 	conversationID, err := strconv.ParseInt(vars["conversation_id"], 10, 64)
 	if err != nil {
 		errMsg := "Invalid conversation ID"
-		log.Println(errMsg + ": " + err.Error())
-		http.Error(w, errMsg, http.StatusBadRequest)
+		env.logger(r).Infof("%s: %v", errMsg, err)
+		apierror.Write(w, errMsg, http.StatusBadRequest, apierror.ConversationIDInvalid)
 		return
 	}
 	targetMemberID, err := strconv.ParseInt(vars["user_id"], 10, 64)
 	if err != nil {
 		errMsg := "Invalid user ID"
-		log.Println(errMsg + ": " + err.Error())
-		http.Error(w, errMsg, http.StatusBadRequest)
+		env.logger(r).Infof("%s: %v", errMsg, err)
+		apierror.Write(w, errMsg, http.StatusBadRequest, apierror.UserIDInvalid)
 		return
 	}
 
-	conversation, err := env.getConversation(w, conversationID)
+	conversation, err := env.getConversation(w, r, conversationID)
 	if err != nil || conversation == nil {
 		return
 	}
 
-	sessionMember, err := env.getMapping(w, userID, conversationID, "Conversation not found")
+	sessionMember, err := env.getMapping(w, r, userID, conversationID, "Conversation not found", apierror.ConversationNotFound)
 	if err != nil || sessionMember == nil {
 		return
 	}
 
 	var targetMember *models.UserConversationMapping
 	if userID != targetMemberID {
-		targetMember, err = env.getMapping(w, targetMemberID, conversationID, "User not found")
-		if err != nil || sessionMember == nil {
+		targetMember, err = env.getMapping(w, r, targetMemberID, conversationID, "User not found", apierror.MemberNotFound)
+		if err != nil || targetMember == nil {
 			return
 		}
 	} else {
 		targetMember = sessionMember
 	}
 
+	targetMember.Caret, err = env.DB.GetCaret(r.Context(), targetMember.UserID, conversationID)
+	if err != nil {
+		env.internalServerError(w, r, err)
+		return
+	}
+
+	w.Header().Set("ETag", mappingETag(targetMember))
 	w.Header().Add("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(targetMember)
 }
@@ -182,8 +187,8 @@ func (env *Env) GetMappingsHandler(w http.ResponseWriter, r *http.Request) {
 	userID, err := strconv.ParseInt(r.Header.Get("User-ID"), 10, 64)
 	if err != nil {
 		errMsg := "Invalid user ID"
-		log.Println(errMsg + ": " + err.Error())
-		http.Error(w, errMsg, http.StatusBadRequest)
+		env.logger(r).Infof("%s: %v", errMsg, err)
+		apierror.Write(w, errMsg, http.StatusBadRequest, apierror.UserIDInvalid)
 		return
 	}
 
@@ -191,35 +196,105 @@ func (env *Env) GetMappingsHandler(w http.ResponseWriter, r *http.Request) {
 	conversationID, err := strconv.ParseInt(vars["conversation_id"], 10, 64)
 	if err != nil {
 		errMsg := "Invalid conversation ID"
-		log.Println(errMsg + ": " + err.Error())
-		http.Error(w, errMsg, http.StatusBadRequest)
+		env.logger(r).Infof("%s: %v", errMsg, err)
+		apierror.Write(w, errMsg, http.StatusBadRequest, apierror.ConversationIDInvalid)
 		return
 	}
 
-	sessionMember, err := env.getMapping(w, userID, conversationID, "Conversation not found")
+	expand := r.URL.Query().Get("expand")
+	if expand != "" && expand != "user" {
+		errMsg := "Invalid expand value"
+		env.logger(r).Info(errMsg)
+		apierror.WriteDetails(w, errMsg, http.StatusBadRequest, apierror.ParameterInvalid, apierror.Details{"parameter": "expand"})
+		return
+	}
+
+	sessionMember, err := env.getMapping(w, r, userID, conversationID, "Conversation not found", apierror.ConversationNotFound)
 	if err != nil || sessionMember == nil {
 		return
 	}
 
-	members, err := env.DB.GetUserConversationMappings(conversationID)
+	members, err := env.DB.GetUserConversationMappings(r.Context(), conversationID)
 	if err != nil {
-		internalServerError(w, err)
+		env.internalServerError(w, r, err)
 		return
 	}
+
+	if expand == "user" {
+		env.expandUsers(r, members)
+	}
 	memberList := &models.UserConversationMappingList{Users: members}
 
 	w.Header().Add("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(memberList)
 }
 
+// expandUsers embeds the Karen user profile of each member. Members whose
+// profile could not be retrieved, either because Karen is unavailable or the
+// user no longer exists, are marked as missing their profile instead. Service
+// accounts have no profile, so they are left as they are.
+func (env *Env) expandUsers(r *http.Request, members []*models.UserConversationMapping) {
+	userIDs := make([]int64, 0, len(members))
+	for _, member := range members {
+		if member.Role != models.Service {
+			userIDs = append(userIDs, member.UserID)
+		}
+	}
+
+	users, err := env.Karen.GetUsers(r.Context(), userIDs)
+	if err != nil {
+		env.logger(r).Warnf("Failed to get user profiles from Karen: %v", err)
+	}
+
+	for _, member := range members {
+		if member.Role == models.Service {
+			continue
+		}
+		user := users[member.UserID]
+		if user == nil {
+			member.UserMissing = true
+			continue
+		}
+		member.User = &models.UserProfile{
+			Name:      user.Name,
+			Email:     user.Email,
+			AvatarURL: user.AvatarURL,
+		}
+	}
+}
+
+// newMember initializes a member that is being added to a conversation, for
+// both PostMappingHandler and PostMappingsBatchHandler.
+//
+// TODO: write added members to a Kafka topic for patches to read from
+func newMember(conversationID, userID int64, role models.Role, now time.Time) *models.UserConversationMapping {
+	var pending bool = false // TODO: set this to true
+	return &models.UserConversationMapping{
+		UserID:         userID,
+		ConversationID: conversationID,
+		Role:           role,
+		Nickname:       new(string),
+		Pending:        &pending,
+		LastOpened:     now.Format("2006-01-02 15:04:05"),
+	}
+}
+
+// clearResponseFields resets the fields of a member that are only ever set in
+// responses, so that a request body cannot inject them.
+func clearResponseFields(member *models.UserConversationMapping) {
+	member.Caret = nil
+	member.User = nil
+	member.UserMissing = false
+}
+
 // PatchMappingHandler updates a single user in a conversation
 func (env *Env) PatchMappingHandler(w http.ResponseWriter, r *http.Request) {
 	defer r.Body.Close()
 	userID, err := strconv.ParseInt(r.Header.Get("User-ID"), 10, 64)
 	if err != nil {
 		errMsg := "Invalid user ID"
-		log.Println(errMsg + ": " + err.Error())
-		http.Error(w, errMsg, http.StatusBadRequest)
+		env.logger(r).Infof("%s: %v", errMsg, err)
+		apierror.Write(w, errMsg, http.StatusBadRequest, apierror.UserIDInvalid)
 		return
 	}
 
@@ -227,32 +302,32 @@ func (env *Env) PatchMappingHandler(w http.ResponseWriter, r *http.Request) {
 	conversationID, err := strconv.ParseInt(vars["conversation_id"], 10, 64)
 	if err != nil {
 		errMsg := "Invalid conversation ID"
-		log.Println(errMsg + ": " + err.Error())
-		http.Error(w, errMsg, http.StatusBadRequest)
+		env.logger(r).Infof("%s: %v", errMsg, err)
+		apierror.Write(w, errMsg, http.StatusBadRequest, apierror.ConversationIDInvalid)
 		return
 	}
 	targetMemberID, err := strconv.ParseInt(vars["user_id"], 10, 64)
 	if err != nil {
 		errMsg := "Invalid user ID"
-		log.Println(errMsg + ": " + err.Error())
-		http.Error(w, errMsg, http.StatusBadRequest)
+		env.logger(r).Infof("%s: %v", errMsg, err)
+		apierror.Write(w, errMsg, http.StatusBadRequest, apierror.UserIDInvalid)
 		return
 	}
 
-	conversation, err := env.getConversation(w, conversationID)
+	conversation, err := env.getConversation(w, r, conversationID)
 	if err != nil || conversation == nil {
 		return
 	}
 
-	sessionMember, err := env.getMapping(w, userID, conversationID, "Conversation not found")
+	sessionMember, err := env.getMapping(w, r, userID, conversationID, "Conversation not found", apierror.ConversationNotFound)
 	if err != nil || sessionMember == nil {
 		return
 	}
 
 	var targetMember *models.UserConversationMapping
 	if userID != targetMemberID {
-		targetMember, err = env.getMapping(w, targetMemberID, conversationID, "User not found")
-		if err != nil || sessionMember == nil {
+		targetMember, err = env.getMapping(w, r, targetMemberID, conversationID, "User not found", apierror.MemberNotFound)
+		if err != nil || targetMember == nil {
 			return
 		}
 	} else {
@@ -260,45 +335,51 @@ func (env *Env) PatchMappingHandler(w http.ResponseWriter, r *http.Request) {
 	}
 
 	reqMember := &models.UserConversationMapping{}
-	if err := parseJSON(w, r.Body, reqMember); err != nil {
+	if err := env.parseJSON(w, r, reqMember); err != nil {
 		return
 	}
+	clearResponseFields(reqMember)
 	reqMember.LastOpened = ""
 
 	if reqMember.Role == "" && reqMember.Nickname == nil && reqMember.Pending == nil {
 		errMsg := "Request body is missing field(s)"
-		log.Println(errMsg)
-		http.Error(w, errMsg, http.StatusBadRequest)
+		env.logger(r).Info(errMsg)
+		apierror.Write(w, errMsg, http.StatusBadRequest, apierror.RequestBodyMissingFields)
 		return
 	}
 
 	if userID != targetMemberID && *sessionMember.Pending {
 		errMsg := "Cannot modify other users in conversation while invitation is pending"
-		log.Println(errMsg)
-		http.Error(w, errMsg, http.StatusForbidden)
+		env.logger(r).Info(errMsg)
+		apierror.Write(w, errMsg, http.StatusForbidden, apierror.InvitationPending)
 		return
 	} else if *sessionMember.Pending && (reqMember.Role != "" || reqMember.Nickname != nil) {
 		errMsg := "Cannot modify self in conversation (besides pending status) while invitation is pending"
-		log.Println(errMsg)
-		http.Error(w, errMsg, http.StatusForbidden)
+		env.logger(r).Info(errMsg)
+		apierror.Write(w, errMsg, http.StatusForbidden, apierror.InvitationPending)
 		return
 	}
 
 	if reqMember.Role != "" {
-		if !reqMember.Role.Valid() || reqMember.Role == models.Owner {
+		if !reqMember.Role.Valid() || reqMember.Role == models.Owner || reqMember.Role == models.Service {
 			errMsg := "Invalid role value"
-			log.Println(errMsg)
-			http.Error(w, errMsg, http.StatusBadRequest)
+			env.logger(r).Info(errMsg)
+			apierror.Write(w, errMsg, http.StatusBadRequest, apierror.RoleInvalid)
+			return
+		} else if targetMember.Role == models.Service {
+			errMsg := "Cannot modify role of service account"
+			env.logger(r).Info(errMsg)
+			apierror.Write(w, errMsg, http.StatusForbidden, apierror.Forbidden)
 			return
 		} else if sessionMember.Role != models.Owner {
 			errMsg := fmt.Sprintf("User %d cannot modify roles in conversation %d", userID, conversationID)
-			log.Println(errMsg)
-			http.Error(w, "Forbidden from modifying roles", http.StatusForbidden)
+			env.logger(r).Info(errMsg)
+			apierror.Write(w, "Forbidden from modifying roles", http.StatusForbidden, apierror.Forbidden)
 			return
 		} else if userID == targetMemberID {
 			errMsg := "Cannot modify own role"
-			log.Println(errMsg)
-			http.Error(w, errMsg, http.StatusForbidden)
+			env.logger(r).Info(errMsg)
+			apierror.Write(w, errMsg, http.StatusForbidden, apierror.Forbidden)
 			return
 		}
 	}
@@ -306,24 +387,43 @@ func (env *Env) PatchMappingHandler(w http.ResponseWriter, r *http.Request) {
 	if reqMember.Pending != nil {
 		if userID != targetMemberID {
 			errMsg := "Cannot modify invitation status of other user"
-			log.Println(errMsg)
-			http.Error(w, errMsg, http.StatusForbidden)
+			env.logger(r).Info(errMsg)
+			apierror.Write(w, errMsg, http.StatusForbidden, apierror.Forbidden)
 			return
 		} else if !*targetMember.Pending {
 			errMsg := "Cannot modify invitation status after accepting invitation"
-			log.Println(errMsg)
-			http.Error(w, errMsg, http.StatusForbidden)
+			env.logger(r).Info(errMsg)
+			apierror.Write(w, errMsg, http.StatusForbidden, apierror.Forbidden)
 			return
 		}
 	}
 
+	if !env.checkIfMatch(w, r, mappingETag(targetMember)) {
+		return
+	}
+
 	newMember := targetMember.Merge(reqMember)
-	err = env.DB.UpdateUserConversationMapping(newMember)
-	if err != nil {
-		internalServerError(w, err)
+	err = env.withEvents(r.Context(), func(tx models.Datastore) ([]*models.Event, error) {
+		if err := tx.UpdateUserConversationMapping(r.Context(), newMember, targetMember); err != nil {
+			return nil, err
+		}
+		return []*models.Event{&models.Event{
+			ConversationID: conversationID,
+			ActorID:        &userID,
+			Action:         models.EventMemberUpdated,
+			TargetID:       &targetMemberID,
+			Before:         models.EventValue(newMemberValues(targetMember)),
+			After:          models.EventValue(newMemberValues(newMember)),
+		}}, nil
+	})
+	if env.modified(w, r, err) {
+		return
+	} else if err != nil {
+		env.internalServerError(w, r, err)
 		return
 	}
 
+	w.Header().Set("ETag", mappingETag(newMember))
 	w.Header().Add("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(newMember)
 }
@@ -333,8 +433,8 @@ func (env *Env) DeleteMappingHandler(w http.ResponseWriter, r *http.Request) {
 	userID, err := strconv.ParseInt(r.Header.Get("User-ID"), 10, 64)
 	if err != nil {
 		errMsg := "Invalid user ID"
-		log.Println(errMsg + ": " + err.Error())
-		http.Error(w, errMsg, http.StatusBadRequest)
+		env.logger(r).Infof("%s: %v", errMsg, err)
+		apierror.Write(w, errMsg, http.StatusBadRequest, apierror.UserIDInvalid)
 		return
 	}
 
@@ -342,44 +442,45 @@ func (env *Env) DeleteMappingHandler(w http.ResponseWriter, r *http.Request) {
 	conversationID, err := strconv.ParseInt(vars["conversation_id"], 10, 64)
 	if err != nil {
 		errMsg := "Invalid conversation ID"
-		log.Println(errMsg + ": " + err.Error())
-		http.Error(w, errMsg, http.StatusBadRequest)
+		env.logger(r).Infof("%s: %v", errMsg, err)
+		apierror.Write(w, errMsg, http.StatusBadRequest, apierror.ConversationIDInvalid)
 		return
 	}
 	targetMemberID, err := strconv.ParseInt(vars["user_id"], 10, 64)
 	if err != nil {
 		errMsg := "Invalid user ID"
-		log.Println(errMsg + ": " + err.Error())
-		http.Error(w, errMsg, http.StatusBadRequest)
+		env.logger(r).Infof("%s: %v", errMsg, err)
+		apierror.Write(w, errMsg, http.StatusBadRequest, apierror.UserIDInvalid)
 		return
 	}
 
-	conversation, err := env.getConversation(w, conversationID)
+	conversation, err := env.getConversation(w, r, conversationID)
 	if err != nil || conversation == nil {
 		return
 	}
 
-	sessionMember, err := env.getMapping(w, userID, conversationID, "Conversation not found")
+	sessionMember, err := env.getMapping(w, r, userID, conversationID, "Conversation not found", apierror.ConversationNotFound)
 	if err != nil || sessionMember == nil {
 		return
 	}
 
+	targetMember := sessionMember
 	if userID != targetMemberID {
 		if *sessionMember.Pending {
 			errMsg := "Cannot remove other users from conversation while invitation is pending"
-			log.Println(errMsg)
-			http.Error(w, errMsg, http.StatusForbidden)
+			env.logger(r).Info(errMsg)
+			apierror.Write(w, errMsg, http.StatusForbidden, apierror.InvitationPending)
 			return
 		}
 
-		targetMember, err := env.getMapping(w, targetMemberID, conversationID, "User not found")
+		targetMember, err = env.getMapping(w, r, targetMemberID, conversationID, "User not found", apierror.MemberNotFound)
 		if err != nil || targetMember == nil {
 			return
 		}
 
 		res, err := sessionMember.Role.Compare(targetMember.Role)
 		if err != nil {
-			internalServerError(w, err)
+			env.internalServerError(w, r, err)
 			return
 		} else if res != 1 {
 			errMsg := fmt.Sprintf(
@@ -388,22 +489,48 @@ func (env *Env) DeleteMappingHandler(w http.ResponseWriter, r *http.Request) {
 				targetMemberID,
 				conversationID,
 			)
-			log.Println(errMsg)
-			http.Error(w, "Forbidden from removing this user", http.StatusForbidden)
+			env.logger(r).Info(errMsg)
+			apierror.Write(w, "Forbidden from removing this user", http.StatusForbidden, apierror.Forbidden)
 			return
 		}
 	} else if sessionMember.Role == models.Owner {
 		errMsg := fmt.Sprintf("User %d (owner) cannot remove themself from conversation %d", userID, conversationID)
-		log.Println(errMsg)
-		http.Error(w, "Forbidden from removing this user", http.StatusForbidden)
+		env.logger(r).Info(errMsg)
+		apierror.Write(w, "Forbidden from removing this user", http.StatusForbidden, apierror.Forbidden)
+		return
+	}
+
+	// Leaving a conversation is never limited
+	if userID != targetMemberID && !env.allowMembershipChanges(w, r, userID, conversationID, 1) {
 		return
 	}
 
-	err = env.DB.DeleteUserConversationMapping(targetMemberID, conversationID)
+	err = env.withEvents(r.Context(), func(tx models.Datastore) ([]*models.Event, error) {
+		var err error
+		if targetMember.Role == models.Service {
+			// A service account cannot exist outside of its conversation
+			err = tx.DeleteServiceAccount(r.Context(), targetMemberID)
+		} else {
+			err = tx.DeleteUserConversationMapping(r.Context(), targetMemberID, conversationID)
+		}
+		if err != nil {
+			return nil, err
+		}
+		return []*models.Event{&models.Event{
+			ConversationID: conversationID,
+			ActorID:        &userID,
+			Action:         models.EventMemberRemoved,
+			TargetID:       &targetMemberID,
+			Before:         models.EventValue(newMemberValues(targetMember)),
+		}}, nil
+	})
 	if err != nil {
-		internalServerError(w, err)
+		env.internalServerError(w, r, err)
 		return
 	}
+	if userID != targetMemberID {
+		env.chargeMembershipChanges(userID, conversationID, 1)
+	}
 
 	w.WriteHeader(http.StatusNoContent)
 }
@@ -3,13 +3,13 @@ package handlers
 import (
 	"bytes"
 	"encoding/json"
+	"ether/karen"
 	"ether/models"
 	"ether/utils"
 	"net/http"
 	"net/http/httptest"
 	"reflect"
 	"strconv"
-	"strings"
 	"testing"
 
 	"github.com/go-sql-driver/mysql"
@@ -64,6 +64,38 @@ func TestPostMappingHandler(t *testing.T) {
 			},
 			Location: "/ether/v1/conversations/11/users/1",
 		},
+		{
+			Name:            "Successful member creation (response-only fields ignored)",
+			StatusCode:      http.StatusCreated,
+			KarenStatusCode: http.StatusOK,
+			ReqBody: map[string]interface{}{
+				"user_id":      1,
+				"role":         "user",
+				"caret":        map[string]interface{}{"start": 1, "end": 2},
+				"user":         map[string]interface{}{"name": "injected", "email": "injected@example.com"},
+				"user_missing": true,
+			},
+			ResBody: &models.UserConversationMapping{
+				UserID:         1,
+				ConversationID: 11,
+				Role:           "user",
+				Nickname:       utils.StringPtr(""),
+				Pending:        utils.BoolPtr(false),
+			},
+			Conversation: &models.Conversation{
+				ID:          11,
+				Name:        "testname",
+				Description: utils.StringPtr("testdesc"),
+			},
+			SessionMember: &models.UserConversationMapping{
+				UserID:         1337,
+				ConversationID: 11,
+				Role:           "owner",
+				Nickname:       utils.StringPtr("testowner"),
+				Pending:        utils.BoolPtr(false),
+			},
+			Location: "/ether/v1/conversations/11/users/1",
+		},
 		{
 			Name:            "Successful member creation (owner creates admin)",
 			StatusCode:      http.StatusCreated,
@@ -330,6 +362,27 @@ func TestPostMappingHandler(t *testing.T) {
 			},
 			Location: "/ether/v1/conversations/11/users/1",
 		},
+		{
+			Name:            "Failed member creation (Karen unavailable)",
+			StatusCode:      http.StatusServiceUnavailable,
+			KarenStatusCode: http.StatusServiceUnavailable,
+			ReqBody: map[string]interface{}{
+				"user_id": 1,
+				"role":    "user",
+			},
+			Conversation: &models.Conversation{
+				ID:          11,
+				Name:        "testname",
+				Description: utils.StringPtr("testdesc"),
+			},
+			SessionMember: &models.UserConversationMapping{
+				UserID:         1337,
+				ConversationID: 11,
+				Role:           "owner",
+				Nickname:       utils.StringPtr("testowner"),
+				Pending:        utils.BoolPtr(false),
+			},
+		},
 	}
 
 	for _, test := range tests {
@@ -338,18 +391,6 @@ func TestPostMappingHandler(t *testing.T) {
 			var memberID int64 = 1
 			var conversationID int64 = 11
 
-			mockUsersHandler := func(w http.ResponseWriter, r *http.Request) {
-				if test.KarenStatusCode != http.StatusOK {
-					http.Error(w, "User not found", test.KarenStatusCode)
-					return
-				}
-
-				json.NewEncoder(w)
-			}
-
-			server := httptest.NewServer(http.HandlerFunc(mockUsersHandler))
-			defer server.Close()
-
 			reqBody, _ := json.Marshal(test.ReqBody)
 			r := httptest.NewRequest("POST", "/ether/v1/conversations/11/users", bytes.NewReader(reqBody))
 			r.Header.Set("User-ID", strconv.FormatInt(userID, 10))
@@ -369,10 +410,16 @@ func TestPostMappingHandler(t *testing.T) {
 				errList,
 			)
 
+			mKaren := karen.NewMockClient(nil, nil)
+			if test.KarenStatusCode == http.StatusOK {
+				mKaren.Users[memberID] = &karen.User{ID: memberID}
+			} else if test.KarenStatusCode == http.StatusServiceUnavailable {
+				mKaren.Err = karen.ErrUnavailable
+			}
+
 			env := &Env{
-				DB:        mDB,
-				Client:    &http.Client{},
-				KarenHost: strings.TrimPrefix(server.URL, "http://"),
+				DB:    mDB,
+				Karen: mKaren,
 			}
 			env.PostMappingHandler(w, r)
 
@@ -441,6 +488,30 @@ func TestGetMappingHandler(t *testing.T) {
 				Pending:        utils.BoolPtr(false),
 			},
 		},
+		{
+			Name:       "Successful member retrieval (with caret)",
+			StatusCode: http.StatusOK,
+			ResBody: &models.UserConversationMapping{
+				UserID:         1,
+				ConversationID: 11,
+				Role:           "user",
+				Nickname:       utils.StringPtr(""),
+				Pending:        utils.BoolPtr(false),
+				Caret:          &models.Caret{Start: 4, End: 9},
+			},
+			Conversation: &models.Conversation{
+				ID:          11,
+				Name:        "testname",
+				Description: utils.StringPtr("testdesc"),
+			},
+			SessionMember: &models.UserConversationMapping{
+				UserID:         1337,
+				ConversationID: 11,
+				Role:           "owner",
+				Nickname:       utils.StringPtr("testowner"),
+				Pending:        utils.BoolPtr(false),
+			},
+		},
 		{
 			Name:       "Successful member retrieval (self)",
 			StatusCode: http.StatusOK,
@@ -520,6 +591,9 @@ func TestGetMappingHandler(t *testing.T) {
 				[]*models.UserConversationMapping{test.SessionMember, test.ResBody},
 				nil,
 			)
+			if test.ResBody != nil && test.ResBody.Caret != nil {
+				_ = mDB.SetCaret(r.Context(), memberID, conversationID, *test.ResBody.Caret)
+			}
 
 			env := &Env{DB: mDB}
 			env.GetMappingHandler(w, r)
@@ -672,6 +746,104 @@ func TestGetMappingsHandler(t *testing.T) {
 	}
 }
 
+func TestGetMappingsHandlerExpandUser(t *testing.T) {
+	tests := []struct {
+		Name       string
+		StatusCode int
+		Expand     string
+		KarenUsers map[int64]*karen.User
+		KarenErr   error
+		ResUsers   map[int64]*models.UserProfile
+	}{
+		{
+			Name:       "Successful members retrieval (expanded users)",
+			StatusCode: http.StatusOK,
+			Expand:     "user",
+			KarenUsers: map[int64]*karen.User{
+				1337: &karen.User{ID: 1337, Name: "testowner", Email: "owner@example.com"},
+			},
+			ResUsers: map[int64]*models.UserProfile{
+				1337: &models.UserProfile{Name: "testowner", Email: "owner@example.com"},
+			},
+		},
+		{
+			Name:       "Successful members retrieval (Karen unavailable)",
+			StatusCode: http.StatusOK,
+			Expand:     "user",
+			KarenErr:   karen.ErrUnavailable,
+			ResUsers:   map[int64]*models.UserProfile{},
+		},
+		{
+			Name:       "Failed members retrieval (invalid expand value)",
+			StatusCode: http.StatusBadRequest,
+			Expand:     "foobar",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.Name, func(t *testing.T) {
+			var userID int64 = 1337
+			var conversationID int64 = 11
+
+			r := httptest.NewRequest("GET", "/ether/v1/conversations/11/users?expand="+test.Expand, nil)
+			r.Header.Set("User-ID", strconv.FormatInt(userID, 10))
+			r = mux.SetURLVars(r, map[string]string{
+				"conversation_id": strconv.FormatInt(conversationID, 10),
+			})
+			w := httptest.NewRecorder()
+
+			mDB := models.NewMockDB(
+				[]*models.Conversation{&models.Conversation{ID: 11, Name: "testname"}},
+				[]*models.UserConversationMapping{
+					&models.UserConversationMapping{
+						UserID:         1,
+						ConversationID: 11,
+						Role:           "user",
+						Nickname:       utils.StringPtr("testuser"),
+						Pending:        utils.BoolPtr(false),
+					},
+					&models.UserConversationMapping{
+						UserID:         1337,
+						ConversationID: 11,
+						Role:           "owner",
+						Nickname:       utils.StringPtr("testowner"),
+						Pending:        utils.BoolPtr(false),
+					},
+				},
+				nil,
+			)
+
+			mKaren := karen.NewMockClient(nil, test.KarenErr)
+			for userID, user := range test.KarenUsers {
+				mKaren.Users[userID] = user
+			}
+
+			env := &Env{DB: mDB, Karen: mKaren}
+			env.GetMappingsHandler(w, r)
+
+			if w.Code != test.StatusCode {
+				t.Errorf("Response has incorrect status code, expected status code %d, got %d", test.StatusCode, w.Code)
+				return
+			}
+
+			if w.Code == http.StatusOK {
+				// Validate HTTP response content
+				resBody := models.UserConversationMappingList{}
+				_ = json.NewDecoder(w.Body).Decode(&resBody)
+				for _, member := range resBody.Users {
+					expected := test.ResUsers[member.UserID]
+					if !reflect.DeepEqual(expected, member.User) {
+						t.Errorf("Member %d has incorrect user, expected %+v, got %+v", member.UserID, expected, member.User)
+					}
+					if member.UserMissing != (expected == nil) {
+						t.Errorf("Member %d has incorrect missing user flag, got %t", member.UserID, member.UserMissing)
+					}
+				}
+			}
+		})
+	}
+}
+
 func TestPatchMappingsHandler(t *testing.T) {
 	tests := []struct {
 		Name          string
@@ -1213,3 +1385,44 @@ func TestDeleteMappingHandler(t *testing.T) {
 		})
 	}
 }
+
+func TestDeleteMappingHandlerServiceAccount(t *testing.T) {
+	r := httptest.NewRequest("DELETE", "/ether/v1/conversations/1/users/0", nil)
+	r.Header.Set("User-ID", "1")
+	w := httptest.NewRecorder()
+
+	mDB := models.NewMockDB(
+		[]*models.Conversation{&models.Conversation{ID: 1, Name: "testname"}},
+		[]*models.UserConversationMapping{&models.UserConversationMapping{
+			UserID:         1,
+			ConversationID: 1,
+			Role:           models.Owner,
+			Pending:        utils.BoolPtr(false),
+		}},
+		nil,
+	)
+	account := &models.ServiceAccount{ConversationID: 1, Name: "ci-bot", CreatorID: 1}
+	_ = mDB.CreateServiceAccount(r.Context(), account)
+	_ = mDB.CreateAPIToken(r.Context(), &models.APIToken{
+		ServiceAccountID: account.ID,
+		ConversationID:   1,
+		Hash:             "hash",
+	})
+	r = mux.SetURLVars(r, map[string]string{
+		"conversation_id": "1",
+		"user_id":         strconv.FormatInt(account.ID, 10),
+	})
+
+	env := &Env{DB: mDB}
+	env.DeleteMappingHandler(w, r)
+
+	if w.Code != http.StatusNoContent {
+		t.Fatalf("Response has incorrect status code, expected status code %d, got %d", http.StatusNoContent, w.Code)
+	}
+	if mDB.GetMapping(account.ID, 1) != nil || mDB.ServiceAccounts[account.ID] != nil {
+		t.Error("Service account was not deleted")
+	}
+	if len(mDB.APITokens) != 0 {
+		t.Error("Service account tokens were not revoked")
+	}
+}
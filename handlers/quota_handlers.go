@@ -0,0 +1,199 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"ether/apierror"
+	"ether/models"
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+
+	"github.com/gorilla/mux"
+)
+
+// Quotas represents the limits on what users can create. A limit of 0 means
+// there is no limit.
+type Quotas struct {
+	// ConversationsPerUser is how many conversations a user can own.
+	ConversationsPerUser int
+
+	// MembersPerConversation is how many members, including pending ones and
+	// service accounts, a conversation can have.
+	MembersPerConversation int
+
+	// MaxContentSize is the size in bytes that edits cannot grow a
+	// conversation's content past. It is enforced by the content writer.
+	MaxContentSize int
+}
+
+// newQuota builds the usage of a quota, which has no limit if limit is 0
+func newQuota(used int64, limit int) models.Quota {
+	quota := models.Quota{Used: used}
+	if limit > 0 {
+		l := int64(limit)
+		quota.Limit = &l
+	}
+	return quota
+}
+
+// quotaError is returned by the quota checks that are made in the
+// transactions that would exceed the quota.
+type quotaError struct {
+	quota string
+	limit int
+	used  int
+	msg   string
+}
+
+func (e *quotaError) Error() string {
+	return e.msg
+}
+
+// checkConversationQuota checks that a user can create another conversation,
+// returning a quotaError if not. It is called in the transaction that creates
+// the conversation, whose count of the user's conversations locks them until
+// it ends, so that concurrent requests cannot together exceed the quota.
+func (env *Env) checkConversationQuota(ctx context.Context, tx models.Datastore, userID int64) error {
+	limit := env.Quotas.ConversationsPerUser
+	if limit <= 0 {
+		return nil
+	}
+
+	count, err := tx.CountOwnedConversations(ctx, userID)
+	if err != nil {
+		return err
+	}
+	if count >= limit {
+		return &quotaError{
+			quota: "conversations_per_user",
+			limit: limit,
+			used:  count,
+			msg:   fmt.Sprintf("Conversation quota exceeded: a user can own at most %d conversations", limit),
+		}
+	}
+	return nil
+}
+
+// checkMemberQuota checks that n members can be added to a conversation,
+// returning a quotaError if not. Like checkConversationQuota, it is called in
+// the transaction that adds them.
+func (env *Env) checkMemberQuota(ctx context.Context, tx models.Datastore, conversationID int64, n int) error {
+	limit := env.Quotas.MembersPerConversation
+	if limit <= 0 || n == 0 {
+		return nil
+	}
+
+	count, err := tx.CountUserConversationMappings(ctx, conversationID)
+	if err != nil {
+		return err
+	}
+	if count+n > limit {
+		return &quotaError{
+			quota: "members_per_conversation",
+			limit: limit,
+			used:  count,
+			msg: fmt.Sprintf(
+				"Member quota exceeded: a conversation can have at most %d members, and this one has %d",
+				limit,
+				count,
+			),
+		}
+	}
+	return nil
+}
+
+// quotaExceeded responds with 403 Forbidden and returns true if err is a
+// quotaError
+func (env *Env) quotaExceeded(w http.ResponseWriter, r *http.Request, err error) bool {
+	var quotaErr *quotaError
+	if !errors.As(err, &quotaErr) {
+		return false
+	}
+	env.logger(r).Infof("%s (%d used)", quotaErr.msg, quotaErr.used)
+	apierror.WriteDetails(w, quotaErr.msg, http.StatusForbidden, apierror.QuotaExceeded, apierror.Details{"quota": quotaErr.quota, "limit": quotaErr.limit, "used": quotaErr.used})
+	return true
+}
+
+// GetUsageHandler gets the session user's usage of their quotas
+func (env *Env) GetUsageHandler(w http.ResponseWriter, r *http.Request) {
+	userID, err := strconv.ParseInt(r.Header.Get("User-ID"), 10, 64)
+	if err != nil {
+		errMsg := "Invalid user ID"
+		env.logger(r).Infof("%s: %v", errMsg, err)
+		apierror.Write(w, errMsg, http.StatusBadRequest, apierror.UserIDInvalid)
+		return
+	}
+
+	count, err := env.DB.CountOwnedConversations(r.Context(), userID)
+	if err != nil {
+		env.internalServerError(w, r, err)
+		return
+	}
+
+	w.Header().Add("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(&models.UserUsage{
+		Conversations: newQuota(int64(count), env.Quotas.ConversationsPerUser),
+	})
+}
+
+// GetConversationUsageHandler gets a conversation's usage of its quotas
+func (env *Env) GetConversationUsageHandler(w http.ResponseWriter, r *http.Request) {
+	userID, err := strconv.ParseInt(r.Header.Get("User-ID"), 10, 64)
+	if err != nil {
+		errMsg := "Invalid user ID"
+		env.logger(r).Infof("%s: %v", errMsg, err)
+		apierror.Write(w, errMsg, http.StatusBadRequest, apierror.UserIDInvalid)
+		return
+	}
+
+	conversationID, err := strconv.ParseInt(mux.Vars(r)["conversation_id"], 10, 64)
+	if err != nil {
+		errMsg := "Invalid conversation ID"
+		env.logger(r).Infof("%s: %v", errMsg, err)
+		apierror.Write(w, errMsg, http.StatusBadRequest, apierror.ConversationIDInvalid)
+		return
+	}
+
+	conversation, err := env.getConversation(w, r, conversationID)
+	if err != nil || conversation == nil {
+		return
+	}
+
+	sessionMember, err := env.getMapping(w, r, userID, conversationID, "Conversation not found", apierror.ConversationNotFound)
+	if err != nil || sessionMember == nil {
+		return
+	}
+
+	if *sessionMember.Pending {
+		errMsg := "Cannot view conversation usage while invitation is pending"
+		env.logger(r).Info(errMsg)
+		apierror.Write(w, errMsg, http.StatusForbidden, apierror.InvitationPending)
+		return
+	}
+
+	members, err := env.DB.CountUserConversationMappings(r.Context(), conversationID)
+	if err != nil {
+		env.internalServerError(w, r, err)
+		return
+	}
+
+	size, err := env.Directory.Size(conversationID)
+	if os.IsNotExist(err) {
+		errMsg := fmt.Sprintf("File for conversation %d does not exist", conversationID)
+		env.logger(r).Info(errMsg)
+		apierror.Write(w, "File not found", http.StatusNotFound, apierror.ContentNotFound)
+		return
+	} else if err != nil {
+		env.internalServerError(w, r, err)
+		return
+	}
+
+	w.Header().Add("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(&models.ConversationUsage{
+		Members:      newQuota(int64(members), env.Quotas.MembersPerConversation),
+		ContentBytes: newQuota(size, env.Quotas.MaxContentSize),
+	})
+}
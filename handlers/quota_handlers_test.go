@@ -0,0 +1,237 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"ether/filesystem"
+	"ether/karen"
+	"ether/models"
+	"ether/utils"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	"reflect"
+	"testing"
+
+	"github.com/gorilla/mux"
+)
+
+func TestPostConversationHandlerQuota(t *testing.T) {
+	tests := []struct {
+		Name       string
+		StatusCode int
+		Owned      int
+		Limit      int
+	}{
+		{
+			Name:       "Conversation created under quota",
+			StatusCode: http.StatusCreated,
+			Owned:      1,
+			Limit:      2,
+		},
+		{
+			Name:       "Conversation quota exceeded",
+			StatusCode: http.StatusForbidden,
+			Owned:      2,
+			Limit:      2,
+		},
+		{
+			Name:       "No conversation quota",
+			StatusCode: http.StatusCreated,
+			Owned:      2,
+		},
+	}
+
+	var contentDir = os.Getenv("ETHER_CONTENT_DIR")
+	for _, test := range tests {
+		t.Run(test.Name, func(t *testing.T) {
+			conversations := make([]*models.Conversation, test.Owned)
+			mappings := make([]*models.UserConversationMapping, test.Owned)
+			for i := range conversations {
+				conversations[i] = &models.Conversation{ID: int64(i + 1), Name: "testname"}
+				mappings[i] = &models.UserConversationMapping{
+					UserID:         1,
+					ConversationID: int64(i + 1),
+					Role:           models.Owner,
+					Pending:        utils.BoolPtr(false),
+				}
+			}
+			mDB := models.NewMockDB(conversations, mappings, nil)
+			defer os.Remove(path.Join(contentDir, fmt.Sprintf("%d.html", test.Owned+1)))
+
+			r := httptest.NewRequest("POST", "/ether/v1/conversations", bytes.NewBufferString(`{"name": "test_name"}`))
+			r.Header.Set("User-ID", "1")
+			w := httptest.NewRecorder()
+
+			env := &Env{
+				DB:        mDB,
+				Directory: filesystem.NewDirectory(contentDir),
+				Quotas:    Quotas{ConversationsPerUser: test.Limit},
+			}
+			env.PostConversationHandler(w, r)
+
+			if w.Code != test.StatusCode {
+				t.Errorf("Response has incorrect status code, expected status code %d, got %d", test.StatusCode, w.Code)
+			}
+			if created := len(mDB.Conversations) > test.Owned; created != (test.StatusCode == http.StatusCreated) {
+				t.Errorf("Conversation was created: %t, expected %t", created, !created)
+			}
+		})
+	}
+}
+
+func TestPostMappingHandlerQuota(t *testing.T) {
+	tests := []struct {
+		Name       string
+		StatusCode int
+		ReqBody    string
+		Batch      bool
+		Limit      int
+	}{
+		{
+			Name:       "Member added under quota",
+			StatusCode: http.StatusCreated,
+			ReqBody:    `{"user_id": 3, "role": "user"}`,
+			Limit:      3,
+		},
+		{
+			Name:       "Member quota exceeded",
+			StatusCode: http.StatusForbidden,
+			ReqBody:    `{"user_id": 3, "role": "user"}`,
+			Limit:      2,
+		},
+		{
+			Name:       "Batch under quota",
+			StatusCode: http.StatusOK,
+			ReqBody:    `{"users": [{"user_id": 3, "role": "user"}, {"user_id": 4, "role": "user"}]}`,
+			Batch:      true,
+			Limit:      4,
+		},
+		{
+			Name:       "Batch member quota exceeded",
+			StatusCode: http.StatusForbidden,
+			ReqBody:    `{"users": [{"user_id": 3, "role": "user"}, {"user_id": 4, "role": "user"}]}`,
+			Batch:      true,
+			Limit:      3,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.Name, func(t *testing.T) {
+			r := httptest.NewRequest("POST", "/ether/v1/conversations/1/users", bytes.NewBufferString(test.ReqBody))
+			r.Header.Set("User-ID", "1")
+			r = mux.SetURLVars(r, map[string]string{"conversation_id": "1"})
+			w := httptest.NewRecorder()
+
+			mDB := models.NewMockDB(
+				[]*models.Conversation{&models.Conversation{ID: 1, Name: "testname"}},
+				[]*models.UserConversationMapping{
+					&models.UserConversationMapping{
+						UserID:         1,
+						ConversationID: 1,
+						Role:           models.Owner,
+						Pending:        utils.BoolPtr(false),
+					},
+					&models.UserConversationMapping{
+						UserID:         2,
+						ConversationID: 1,
+						Role:           models.User,
+						Pending:        utils.BoolPtr(false),
+					},
+				},
+				nil,
+			)
+
+			env := &Env{
+				DB:     mDB,
+				Karen:  karen.NewMockClient([]int64{3, 4}, nil),
+				Quotas: Quotas{MembersPerConversation: test.Limit},
+			}
+			if test.Batch {
+				env.PostMappingsBatchHandler(w, r)
+			} else {
+				env.PostMappingHandler(w, r)
+			}
+
+			if w.Code != test.StatusCode {
+				t.Errorf("Response has incorrect status code, expected status code %d, got %d", test.StatusCode, w.Code)
+			}
+			if w.Code == http.StatusForbidden && len(mDB.Mappings[1]) != 2 {
+				t.Errorf("Members were added over the quota")
+			}
+		})
+	}
+}
+
+func TestGetConversationUsageHandler(t *testing.T) {
+	limit := func(l int64) *int64 { return &l }
+	tests := []struct {
+		Name       string
+		StatusCode int
+		Quotas     Quotas
+		ResBody    *models.ConversationUsage
+	}{
+		{
+			Name:       "Usage with quotas",
+			StatusCode: http.StatusOK,
+			Quotas:     Quotas{MembersPerConversation: 10, MaxContentSize: 1024},
+			ResBody: &models.ConversationUsage{
+				Members:      models.Quota{Used: 1, Limit: limit(10)},
+				ContentBytes: models.Quota{Used: 11, Limit: limit(1024)},
+			},
+		},
+		{
+			Name:       "Usage without quotas",
+			StatusCode: http.StatusOK,
+			ResBody: &models.ConversationUsage{
+				Members:      models.Quota{Used: 1},
+				ContentBytes: models.Quota{Used: 11},
+			},
+		},
+	}
+
+	var contentDir = os.Getenv("ETHER_CONTENT_DIR")
+	for _, test := range tests {
+		t.Run(test.Name, func(t *testing.T) {
+			filePath := path.Join(contentDir, "1.html")
+			_ = os.WriteFile(filePath, []byte("hello world"), 0644)
+			defer os.Remove(filePath)
+
+			r := httptest.NewRequest("GET", "/ether/v1/conversations/1/usage", nil)
+			r.Header.Set("User-ID", "1")
+			r = mux.SetURLVars(r, map[string]string{"conversation_id": "1"})
+			w := httptest.NewRecorder()
+
+			mDB := models.NewMockDB(
+				[]*models.Conversation{&models.Conversation{ID: 1, Name: "testname"}},
+				[]*models.UserConversationMapping{&models.UserConversationMapping{
+					UserID:         1,
+					ConversationID: 1,
+					Role:           models.User,
+					Pending:        utils.BoolPtr(false),
+				}},
+				nil,
+			)
+
+			env := &Env{
+				DB:        mDB,
+				Directory: filesystem.NewDirectory(contentDir),
+				Quotas:    test.Quotas,
+			}
+			env.GetConversationUsageHandler(w, r)
+
+			if w.Code != test.StatusCode {
+				t.Errorf("Response has incorrect status code, expected status code %d, got %d", test.StatusCode, w.Code)
+				return
+			}
+
+			resBody := models.ConversationUsage{}
+			_ = json.NewDecoder(w.Body).Decode(&resBody)
+			if !reflect.DeepEqual(*test.ResBody, resBody) {
+				t.Errorf("Response has incorrect body, expected %+v, got %+v", *test.ResBody, resBody)
+			}
+		})
+	}
+}
@@ -0,0 +1,47 @@
+package handlers
+
+import (
+	"encoding/json"
+	"ether/apierror"
+	"ether/models"
+	"ether/search"
+	"net/http"
+	"strconv"
+)
+
+const (
+	maxSnippets = 3
+)
+
+// GetSearchHandler searches the content of all of a user's conversations
+func (env *Env) GetSearchHandler(w http.ResponseWriter, r *http.Request) {
+	userID, err := strconv.ParseInt(r.Header.Get("User-ID"), 10, 64)
+	if err != nil {
+		errMsg := "Invalid user ID"
+		env.logger(r).Infof("%s: %v", errMsg, err)
+		apierror.Write(w, errMsg, http.StatusBadRequest, apierror.UserIDInvalid)
+		return
+	}
+
+	terms := search.Terms(r.URL.Query().Get("q"))
+	if len(terms) == 0 {
+		errMsg := "Missing or invalid search query"
+		env.logger(r).Info(errMsg)
+		apierror.WriteDetails(w, errMsg, http.StatusBadRequest, apierror.ParameterInvalid, apierror.Details{"parameter": "q"})
+		return
+	}
+
+	results, err := env.DB.SearchConversations(r.Context(), userID, terms)
+	if err != nil {
+		env.internalServerError(w, r, err)
+		return
+	}
+
+	for _, result := range results {
+		result.Snippets = search.Snippets(result.Content, terms, maxSnippets)
+	}
+	resultList := &models.SearchResultList{Results: results}
+
+	w.Header().Add("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resultList)
+}
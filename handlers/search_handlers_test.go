@@ -0,0 +1,113 @@
+package handlers
+
+import (
+	"encoding/json"
+	"ether/models"
+	"ether/utils"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"strconv"
+	"testing"
+)
+
+func TestGetSearchHandler(t *testing.T) {
+	tests := []struct {
+		Name          string
+		StatusCode    int
+		Query         string
+		ResBody       *models.SearchResultList
+		Conversations []*models.Conversation
+		Mappings      []*models.UserConversationMapping
+		SearchIndex   map[int64]string
+	}{
+		{
+			Name:       "Successful search",
+			StatusCode: http.StatusOK,
+			Query:      "Hello",
+			ResBody: &models.SearchResultList{Results: []*models.SearchResult{
+				&models.SearchResult{
+					ConversationID: 1,
+					Name:           "test_name",
+					Snippets:       []string{"<mark>hello</mark> world &amp; friends"},
+				},
+			}},
+			Conversations: []*models.Conversation{
+				&models.Conversation{ID: 1, Name: "test_name"},
+				&models.Conversation{ID: 2, Name: "test_name"},
+			},
+			Mappings: []*models.UserConversationMapping{
+				&models.UserConversationMapping{
+					UserID:         1,
+					ConversationID: 1,
+					Role:           "owner",
+					Pending:        utils.BoolPtr(false),
+				},
+				&models.UserConversationMapping{
+					UserID:         1,
+					ConversationID: 2,
+					Role:           "user",
+					Pending:        utils.BoolPtr(false),
+				},
+			},
+			SearchIndex: map[int64]string{
+				1: "hello world & friends",
+				2: "goodbye",
+			},
+		},
+		{
+			Name:       "Successful search (pending invitation excluded)",
+			StatusCode: http.StatusOK,
+			Query:      "hello",
+			ResBody:    &models.SearchResultList{Results: []*models.SearchResult{}},
+			Conversations: []*models.Conversation{
+				&models.Conversation{ID: 1, Name: "test_name"},
+			},
+			Mappings: []*models.UserConversationMapping{
+				&models.UserConversationMapping{
+					UserID:         1,
+					ConversationID: 1,
+					Role:           "user",
+					Pending:        utils.BoolPtr(true),
+				},
+			},
+			SearchIndex: map[int64]string{1: "hello world"},
+		},
+		{
+			Name:       "Failed search (missing query)",
+			StatusCode: http.StatusBadRequest,
+			Query:      " ",
+		},
+	}
+
+	var userID int64 = 1
+	for _, test := range tests {
+		t.Run(test.Name, func(t *testing.T) {
+			r := httptest.NewRequest("GET", "/ether/v1/search?q="+url.QueryEscape(test.Query), nil)
+			r.Header.Set("User-ID", strconv.FormatInt(userID, 10))
+			w := httptest.NewRecorder()
+
+			mDB := models.NewMockDB(test.Conversations, test.Mappings, nil)
+			for conversationID, content := range test.SearchIndex {
+				mDB.SearchIndex[conversationID] = content
+			}
+
+			env := &Env{DB: mDB}
+			env.GetSearchHandler(w, r)
+
+			if w.Code != test.StatusCode {
+				t.Errorf("Response has incorrect status code, expected status code %d, got %d", test.StatusCode, w.Code)
+			}
+
+			if w.Code == http.StatusOK {
+				// Validate HTTP response content
+				resBody := models.SearchResultList{}
+				_ = json.NewDecoder(w.Body).Decode(&resBody)
+				if !reflect.DeepEqual(*test.ResBody, resBody) {
+					t.Errorf("Response has incorrect body, expected %+v, got %+v", *test.ResBody, resBody)
+				}
+			}
+		})
+	}
+}
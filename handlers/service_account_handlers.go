@@ -0,0 +1,217 @@
+package handlers
+
+import (
+	"encoding/json"
+	"ether/apierror"
+	"ether/models"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+const maxServiceAccountNameLength = 255
+
+// getAccountManager parses the session user and conversation of a request to
+// manage service accounts or API tokens, and checks that the session user is
+// an owner or admin who has accepted their invitation. It responds with an
+// error and returns nil if the request cannot continue.
+func (env *Env) getAccountManager(w http.ResponseWriter, r *http.Request) (*models.UserConversationMapping, error) {
+	userID, err := strconv.ParseInt(r.Header.Get("User-ID"), 10, 64)
+	if err != nil {
+		errMsg := "Invalid user ID"
+		env.logger(r).Infof("%s: %v", errMsg, err)
+		apierror.Write(w, errMsg, http.StatusBadRequest, apierror.UserIDInvalid)
+		return nil, err
+	}
+
+	conversationID, err := strconv.ParseInt(mux.Vars(r)["conversation_id"], 10, 64)
+	if err != nil {
+		errMsg := "Invalid conversation ID"
+		env.logger(r).Infof("%s: %v", errMsg, err)
+		apierror.Write(w, errMsg, http.StatusBadRequest, apierror.ConversationIDInvalid)
+		return nil, err
+	}
+
+	conversation, err := env.getConversation(w, r, conversationID)
+	if err != nil || conversation == nil {
+		return nil, err
+	}
+
+	sessionMember, err := env.getMapping(w, r, userID, conversationID, "Conversation not found", apierror.ConversationNotFound)
+	if err != nil || sessionMember == nil {
+		return nil, err
+	}
+
+	if *sessionMember.Pending {
+		errMsg := "Cannot manage service accounts while invitation is pending"
+		env.logger(r).Info(errMsg)
+		apierror.Write(w, errMsg, http.StatusForbidden, apierror.InvitationPending)
+		return nil, nil
+	}
+
+	if sessionMember.Role != models.Owner && sessionMember.Role != models.Admin {
+		errMsg := fmt.Sprintf("User %d cannot manage service accounts of conversation %d", userID, conversationID)
+		env.logger(r).Info(errMsg)
+		apierror.Write(w, "Forbidden from managing service accounts", http.StatusForbidden, apierror.Forbidden)
+		return nil, nil
+	}
+
+	return sessionMember, nil
+}
+
+// getServiceAccount gets a service account of a conversation, responding with
+// 404 Not Found if it does not exist or belongs to another conversation
+func (env *Env) getServiceAccount(
+	w http.ResponseWriter,
+	r *http.Request,
+	id int64,
+	conversationID int64,
+) (*models.ServiceAccount, error) {
+	account, err := env.DB.GetServiceAccount(r.Context(), id)
+	if err != nil {
+		env.internalServerError(w, r, err)
+		return nil, err
+	}
+
+	if account == nil || account.ConversationID != conversationID {
+		env.logger(r).Infof("Service account %d is not in conversation %d", id, conversationID)
+		apierror.Write(w, "Service account not found", http.StatusNotFound, apierror.ServiceAccountNotFound)
+		return nil, nil
+	}
+
+	return account, nil
+}
+
+// PostServiceAccountHandler creates a service account, which is added to the
+// conversation as a member with the service role
+func (env *Env) PostServiceAccountHandler(w http.ResponseWriter, r *http.Request) {
+	sessionMember, err := env.getAccountManager(w, r)
+	if err != nil || sessionMember == nil {
+		return
+	}
+
+	account := &models.ServiceAccount{}
+	if err := env.parseJSON(w, r, account); err != nil {
+		return
+	}
+
+	account.Name = strings.TrimSpace(account.Name)
+	if account.Name == "" {
+		errMsg := "Request body is missing field(s)"
+		env.logger(r).Info(errMsg)
+		apierror.Write(w, errMsg, http.StatusBadRequest, apierror.RequestBodyMissingFields)
+		return
+	} else if len(account.Name) > maxServiceAccountNameLength {
+		errMsg := fmt.Sprintf("Service account name must be at most %d bytes", maxServiceAccountNameLength)
+		env.logger(r).Info(errMsg)
+		apierror.WriteDetails(w, errMsg, http.StatusBadRequest, apierror.FieldInvalid, apierror.Details{"field": "name"})
+		return
+	}
+
+	account.ID = 0
+	account.ConversationID = sessionMember.ConversationID
+	account.CreatorID = sessionMember.UserID
+	account.CreatedAt = time.Now().UTC().Format(models.TimeLayout)
+	err = env.withEvents(r.Context(), func(tx models.Datastore) ([]*models.Event, error) {
+		if err := env.checkMemberQuota(r.Context(), tx, account.ConversationID, 1); err != nil {
+			return nil, err
+		}
+		if err := tx.CreateServiceAccount(r.Context(), account); err != nil {
+			return nil, err
+		}
+		return []*models.Event{&models.Event{
+			ConversationID: account.ConversationID,
+			ActorID:        &sessionMember.UserID,
+			Action:         models.EventMemberAdded,
+			TargetID:       &account.ID,
+			After: models.EventValue(newMemberValues(&models.UserConversationMapping{
+				Role:     models.Service,
+				Nickname: &account.Name,
+				Pending:  new(bool),
+			})),
+		}}, nil
+	})
+	if env.quotaExceeded(w, r, err) {
+		return
+	} else if err != nil {
+		env.internalServerError(w, r, err)
+		return
+	}
+
+	location := fmt.Sprintf("%s/%d", r.URL.Path, account.ID)
+	w.Header().Add("Location", location)
+	w.Header().Add("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(account)
+}
+
+// GetServiceAccountsHandler gets the service accounts of a conversation
+func (env *Env) GetServiceAccountsHandler(w http.ResponseWriter, r *http.Request) {
+	sessionMember, err := env.getAccountManager(w, r)
+	if err != nil || sessionMember == nil {
+		return
+	}
+
+	accounts, err := env.DB.GetServiceAccounts(r.Context(), sessionMember.ConversationID)
+	if err != nil {
+		env.internalServerError(w, r, err)
+		return
+	}
+
+	w.Header().Add("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(&models.ServiceAccountList{ServiceAccounts: accounts})
+}
+
+// DeleteServiceAccountHandler deletes a service account, which removes it
+// from the conversation and revokes all of its API tokens
+func (env *Env) DeleteServiceAccountHandler(w http.ResponseWriter, r *http.Request) {
+	sessionMember, err := env.getAccountManager(w, r)
+	if err != nil || sessionMember == nil {
+		return
+	}
+
+	accountID, err := strconv.ParseInt(mux.Vars(r)["service_account_id"], 10, 64)
+	if err != nil {
+		errMsg := "Invalid service account ID"
+		env.logger(r).Infof("%s: %v", errMsg, err)
+		apierror.WriteDetails(w, errMsg, http.StatusBadRequest, apierror.ParameterInvalid, apierror.Details{"parameter": "service_account_id"})
+		return
+	}
+
+	account, err := env.getServiceAccount(w, r, accountID, sessionMember.ConversationID)
+	if err != nil || account == nil {
+		return
+	}
+
+	targetMember, err := env.DB.GetUserConversationMapping(r.Context(), account.ID, account.ConversationID)
+	if err != nil {
+		env.internalServerError(w, r, err)
+		return
+	}
+
+	err = env.withEvents(r.Context(), func(tx models.Datastore) ([]*models.Event, error) {
+		if err := tx.DeleteServiceAccount(r.Context(), account.ID); err != nil {
+			return nil, err
+		}
+		if targetMember == nil {
+			return nil, nil
+		}
+		return []*models.Event{&models.Event{
+			ConversationID: account.ConversationID,
+			ActorID:        &sessionMember.UserID,
+			Action:         models.EventMemberRemoved,
+			TargetID:       &account.ID,
+			Before:         models.EventValue(newMemberValues(targetMember)),
+		}}, nil
+	})
+	if err != nil {
+		env.internalServerError(w, r, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
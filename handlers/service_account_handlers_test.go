@@ -0,0 +1,188 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"ether/models"
+	"ether/utils"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+
+	"github.com/gorilla/mux"
+)
+
+func TestPostServiceAccountHandler(t *testing.T) {
+	tests := []struct {
+		Name          string
+		StatusCode    int
+		ReqBody       string
+		SessionMember *models.UserConversationMapping
+	}{
+		{
+			Name:       "Successful service account creation",
+			StatusCode: http.StatusCreated,
+			ReqBody:    `{"name": " ci-bot "}`,
+			SessionMember: &models.UserConversationMapping{
+				UserID:         1,
+				ConversationID: 1,
+				Role:           models.Owner,
+				Pending:        utils.BoolPtr(false),
+			},
+		},
+		{
+			Name:       "Missing name",
+			StatusCode: http.StatusBadRequest,
+			ReqBody:    `{"name": " "}`,
+			SessionMember: &models.UserConversationMapping{
+				UserID:         1,
+				ConversationID: 1,
+				Role:           models.Admin,
+				Pending:        utils.BoolPtr(false),
+			},
+		},
+		{
+			Name:       "Forbidden (user)",
+			StatusCode: http.StatusForbidden,
+			ReqBody:    `{"name": "ci-bot"}`,
+			SessionMember: &models.UserConversationMapping{
+				UserID:         1,
+				ConversationID: 1,
+				Role:           models.User,
+				Pending:        utils.BoolPtr(false),
+			},
+		},
+		{
+			Name:       "Forbidden (pending)",
+			StatusCode: http.StatusForbidden,
+			ReqBody:    `{"name": "ci-bot"}`,
+			SessionMember: &models.UserConversationMapping{
+				UserID:         1,
+				ConversationID: 1,
+				Role:           models.Admin,
+				Pending:        utils.BoolPtr(true),
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.Name, func(t *testing.T) {
+			r := httptest.NewRequest("POST", "/ether/v1/conversations/1/service_accounts", bytes.NewBufferString(test.ReqBody))
+			r.Header.Set("User-ID", strconv.FormatInt(test.SessionMember.UserID, 10))
+			r = mux.SetURLVars(r, map[string]string{"conversation_id": "1"})
+			w := httptest.NewRecorder()
+
+			mDB := models.NewMockDB(
+				[]*models.Conversation{&models.Conversation{ID: 1, Name: "testname"}},
+				[]*models.UserConversationMapping{test.SessionMember},
+				nil,
+			)
+			env := &Env{DB: mDB}
+			env.PostServiceAccountHandler(w, r)
+
+			if w.Code != test.StatusCode {
+				t.Errorf("Response has incorrect status code, expected status code %d, got %d", test.StatusCode, w.Code)
+				return
+			}
+
+			if w.Code == http.StatusCreated {
+				resBody := models.ServiceAccount{}
+				_ = json.NewDecoder(w.Body).Decode(&resBody)
+				if resBody.Name != "ci-bot" || resBody.ConversationID != 1 || !models.IsServiceAccount(resBody.ID) {
+					t.Errorf("Response has incorrect service account %+v", resBody)
+				}
+
+				member := mDB.GetMapping(resBody.ID, 1)
+				if member == nil || member.Role != models.Service || *member.Pending {
+					t.Errorf("Service account was not added to the conversation with the service role")
+				}
+			}
+		})
+	}
+}
+
+func TestDeleteServiceAccountHandler(t *testing.T) {
+	tests := []struct {
+		Name             string
+		StatusCode       int
+		ServiceAccountID int64
+		ConversationID   int64
+	}{
+		{
+			Name:             "Successful service account deletion",
+			StatusCode:       http.StatusNoContent,
+			ServiceAccountID: models.ServiceAccountMinID,
+			ConversationID:   1,
+		},
+		{
+			Name:             "Service account in other conversation",
+			StatusCode:       http.StatusNotFound,
+			ServiceAccountID: models.ServiceAccountMinID,
+			ConversationID:   2,
+		},
+		{
+			Name:             "Service account does not exist",
+			StatusCode:       http.StatusNotFound,
+			ServiceAccountID: models.ServiceAccountMinID + 1,
+			ConversationID:   1,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.Name, func(t *testing.T) {
+			r := httptest.NewRequest("DELETE", "/ether/v1/conversations/1/service_accounts/0", nil)
+			r.Header.Set("User-ID", "1")
+			r = mux.SetURLVars(r, map[string]string{
+				"conversation_id":    strconv.FormatInt(test.ConversationID, 10),
+				"service_account_id": strconv.FormatInt(test.ServiceAccountID, 10),
+			})
+			w := httptest.NewRecorder()
+
+			mDB := models.NewMockDB(
+				[]*models.Conversation{
+					&models.Conversation{ID: 1, Name: "testname"},
+					&models.Conversation{ID: 2, Name: "testname"},
+				},
+				[]*models.UserConversationMapping{
+					&models.UserConversationMapping{
+						UserID:         1,
+						ConversationID: 1,
+						Role:           models.Owner,
+						Pending:        utils.BoolPtr(false),
+					},
+					&models.UserConversationMapping{
+						UserID:         1,
+						ConversationID: 2,
+						Role:           models.Owner,
+						Pending:        utils.BoolPtr(false),
+					},
+				},
+				nil,
+			)
+			account := &models.ServiceAccount{ConversationID: 1, Name: "ci-bot", CreatorID: 1}
+			_ = mDB.CreateServiceAccount(r.Context(), account)
+			_ = mDB.CreateAPIToken(r.Context(), &models.APIToken{
+				ServiceAccountID: account.ID,
+				ConversationID:   1,
+				Hash:             "hash",
+			})
+
+			env := &Env{DB: mDB}
+			env.DeleteServiceAccountHandler(w, r)
+
+			if w.Code != test.StatusCode {
+				t.Errorf("Response has incorrect status code, expected status code %d, got %d", test.StatusCode, w.Code)
+				return
+			}
+
+			deleted := w.Code == http.StatusNoContent
+			if (mDB.GetMapping(account.ID, 1) == nil) != deleted {
+				t.Errorf("Service account membership was deleted: %t, expected %t", !deleted, deleted)
+			}
+			if (len(mDB.APITokens) == 0) != deleted {
+				t.Errorf("Service account tokens were revoked: %t, expected %t", !deleted, deleted)
+			}
+		})
+	}
+}
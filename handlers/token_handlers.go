@@ -0,0 +1,189 @@
+package handlers
+
+import (
+	"encoding/json"
+	"ether/apierror"
+	"ether/auth"
+	"ether/models"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+const maxTokenNameLength = 255
+
+// tokenValues represents the details of an API token that are recorded in a
+// conversation's activity log
+type tokenValues struct {
+	ID     int64          `json:"id"`
+	Name   string         `json:"name"`
+	Scopes []models.Scope `json:"scopes"`
+	Prefix string         `json:"prefix"`
+}
+
+func newTokenValues(token *models.APIToken) *tokenValues {
+	return &tokenValues{
+		ID:     token.ID,
+		Name:   token.Name,
+		Scopes: token.Scopes,
+		Prefix: token.Prefix,
+	}
+}
+
+// PostTokenHandler issues an API token to a service account of a conversation.
+// The token is only ever returned in this response.
+func (env *Env) PostTokenHandler(w http.ResponseWriter, r *http.Request) {
+	sessionMember, err := env.getAccountManager(w, r)
+	if err != nil || sessionMember == nil {
+		return
+	}
+
+	reqToken := &models.APIToken{}
+	if err := env.parseJSON(w, r, reqToken); err != nil {
+		return
+	}
+
+	reqToken.Name = strings.TrimSpace(reqToken.Name)
+	if reqToken.ServiceAccountID == 0 || reqToken.Name == "" || len(reqToken.Scopes) == 0 {
+		errMsg := "Request body is missing field(s)"
+		env.logger(r).Info(errMsg)
+		apierror.Write(w, errMsg, http.StatusBadRequest, apierror.RequestBodyMissingFields)
+		return
+	} else if len(reqToken.Name) > maxTokenNameLength {
+		errMsg := fmt.Sprintf("Token name must be at most %d bytes", maxTokenNameLength)
+		env.logger(r).Info(errMsg)
+		apierror.WriteDetails(w, errMsg, http.StatusBadRequest, apierror.FieldInvalid, apierror.Details{"field": "name"})
+		return
+	}
+
+	scopes := make([]models.Scope, 0, len(reqToken.Scopes))
+	seen := make(map[models.Scope]bool)
+	for _, scope := range reqToken.Scopes {
+		if !scope.Valid() {
+			errMsg := fmt.Sprintf("Invalid scope %q", scope)
+			env.logger(r).Info(errMsg)
+			apierror.WriteDetails(w, errMsg, http.StatusBadRequest, apierror.FieldInvalid, apierror.Details{"field": "scopes"})
+			return
+		}
+		if !seen[scope] {
+			seen[scope] = true
+			scopes = append(scopes, scope)
+		}
+	}
+
+	account, err := env.getServiceAccount(w, r, reqToken.ServiceAccountID, sessionMember.ConversationID)
+	if err != nil || account == nil {
+		return
+	}
+
+	token, hash, prefix, err := auth.NewToken()
+	if err != nil {
+		env.internalServerError(w, r, err)
+		return
+	}
+
+	newToken := &models.APIToken{
+		ServiceAccountID: account.ID,
+		ConversationID:   account.ConversationID,
+		Name:             reqToken.Name,
+		Scopes:           scopes,
+		Prefix:           prefix,
+		Hash:             hash,
+		CreatorID:        sessionMember.UserID,
+		CreatedAt:        time.Now().UTC().Format(models.TimeLayout),
+	}
+	err = env.withEvents(r.Context(), func(tx models.Datastore) ([]*models.Event, error) {
+		if err := tx.CreateAPIToken(r.Context(), newToken); err != nil {
+			return nil, err
+		}
+		return []*models.Event{&models.Event{
+			ConversationID: account.ConversationID,
+			ActorID:        &sessionMember.UserID,
+			Action:         models.EventTokenCreated,
+			TargetID:       &account.ID,
+			After:          models.EventValue(newTokenValues(newToken)),
+		}}, nil
+	})
+	if err != nil {
+		env.internalServerError(w, r, err)
+		return
+	}
+
+	response := *newToken
+	response.Token = token
+	location := fmt.Sprintf("%s/%d", r.URL.Path, newToken.ID)
+	w.Header().Add("Location", location)
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Add("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(&response)
+}
+
+// GetTokensHandler gets the API tokens of a conversation, without the tokens
+// themselves
+func (env *Env) GetTokensHandler(w http.ResponseWriter, r *http.Request) {
+	sessionMember, err := env.getAccountManager(w, r)
+	if err != nil || sessionMember == nil {
+		return
+	}
+
+	tokens, err := env.DB.GetAPITokens(r.Context(), sessionMember.ConversationID)
+	if err != nil {
+		env.internalServerError(w, r, err)
+		return
+	}
+
+	w.Header().Add("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(&models.APITokenList{Tokens: tokens})
+}
+
+// DeleteTokenHandler revokes an API token of a conversation
+func (env *Env) DeleteTokenHandler(w http.ResponseWriter, r *http.Request) {
+	sessionMember, err := env.getAccountManager(w, r)
+	if err != nil || sessionMember == nil {
+		return
+	}
+
+	tokenID, err := strconv.ParseInt(mux.Vars(r)["token_id"], 10, 64)
+	if err != nil {
+		errMsg := "Invalid token ID"
+		env.logger(r).Infof("%s: %v", errMsg, err)
+		apierror.WriteDetails(w, errMsg, http.StatusBadRequest, apierror.ParameterInvalid, apierror.Details{"parameter": "token_id"})
+		return
+	}
+
+	token, err := env.DB.GetAPIToken(r.Context(), tokenID)
+	if err != nil {
+		env.internalServerError(w, r, err)
+		return
+	}
+	if token == nil || token.ConversationID != sessionMember.ConversationID {
+		errMsg := "Token not found"
+		env.logger(r).Infof("Token %d is not in conversation %d", tokenID, sessionMember.ConversationID)
+		apierror.Write(w, errMsg, http.StatusNotFound, apierror.TokenNotFound)
+		return
+	}
+
+	err = env.withEvents(r.Context(), func(tx models.Datastore) ([]*models.Event, error) {
+		if err := tx.DeleteAPIToken(r.Context(), token.ID); err != nil {
+			return nil, err
+		}
+		return []*models.Event{&models.Event{
+			ConversationID: token.ConversationID,
+			ActorID:        &sessionMember.UserID,
+			Action:         models.EventTokenRevoked,
+			TargetID:       &token.ServiceAccountID,
+			Before:         models.EventValue(newTokenValues(token)),
+		}}, nil
+	})
+	if err != nil {
+		env.internalServerError(w, r, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
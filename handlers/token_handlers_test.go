@@ -0,0 +1,185 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"ether/auth"
+	"ether/models"
+	"ether/utils"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strconv"
+	"testing"
+
+	"github.com/gorilla/mux"
+)
+
+func TestPostTokenHandler(t *testing.T) {
+	accountID := strconv.FormatInt(models.ServiceAccountMinID, 10)
+	tests := []struct {
+		Name       string
+		StatusCode int
+		ReqBody    string
+		Role       models.Role
+		ResScopes  []models.Scope
+	}{
+		{
+			Name:       "Successful token creation",
+			StatusCode: http.StatusCreated,
+			ReqBody:    `{"service_account_id": ` + accountID + `, "name": "deploy", "scopes": ["read-content", "write-content", "read-content"]}`,
+			Role:       models.Owner,
+			ResScopes:  []models.Scope{models.ScopeReadContent, models.ScopeWriteContent},
+		},
+		{
+			Name:       "Invalid scope",
+			StatusCode: http.StatusBadRequest,
+			ReqBody:    `{"service_account_id": ` + accountID + `, "name": "deploy", "scopes": ["delete-conversation"]}`,
+			Role:       models.Owner,
+		},
+		{
+			Name:       "Missing scopes",
+			StatusCode: http.StatusBadRequest,
+			ReqBody:    `{"service_account_id": ` + accountID + `, "name": "deploy"}`,
+			Role:       models.Admin,
+		},
+		{
+			Name:       "Service account not found",
+			StatusCode: http.StatusNotFound,
+			ReqBody:    `{"service_account_id": 1, "name": "deploy", "scopes": ["read-content"]}`,
+			Role:       models.Admin,
+		},
+		{
+			Name:       "Forbidden (user)",
+			StatusCode: http.StatusForbidden,
+			ReqBody:    `{"service_account_id": ` + accountID + `, "name": "deploy", "scopes": ["read-content"]}`,
+			Role:       models.User,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.Name, func(t *testing.T) {
+			r := httptest.NewRequest("POST", "/ether/v1/conversations/1/tokens", bytes.NewBufferString(test.ReqBody))
+			r.Header.Set("User-ID", "1")
+			r = mux.SetURLVars(r, map[string]string{"conversation_id": "1"})
+			w := httptest.NewRecorder()
+
+			mDB := models.NewMockDB(
+				[]*models.Conversation{&models.Conversation{ID: 1, Name: "testname"}},
+				[]*models.UserConversationMapping{&models.UserConversationMapping{
+					UserID:         1,
+					ConversationID: 1,
+					Role:           test.Role,
+					Pending:        utils.BoolPtr(false),
+				}},
+				nil,
+			)
+			_ = mDB.CreateServiceAccount(r.Context(), &models.ServiceAccount{ConversationID: 1, Name: "ci-bot"})
+
+			env := &Env{DB: mDB}
+			env.PostTokenHandler(w, r)
+
+			if w.Code != test.StatusCode {
+				t.Errorf("Response has incorrect status code, expected status code %d, got %d", test.StatusCode, w.Code)
+				return
+			}
+
+			if w.Code == http.StatusCreated {
+				resBody := models.APIToken{}
+				_ = json.NewDecoder(w.Body).Decode(&resBody)
+				if !reflect.DeepEqual(test.ResScopes, resBody.Scopes) {
+					t.Errorf("Response has incorrect scopes, expected %v, got %v", test.ResScopes, resBody.Scopes)
+				}
+				if !auth.IsToken(resBody.Token) {
+					t.Errorf("Response has invalid token %q", resBody.Token)
+				}
+
+				stored := mDB.APITokens[resBody.ID]
+				if stored == nil || stored.Hash != auth.HashToken(resBody.Token) {
+					t.Errorf("Token hash was not stored")
+				} else if stored.Token != "" {
+					t.Errorf("Token was stored in plain text")
+				}
+			}
+		})
+	}
+}
+
+func TestDeleteTokenHandler(t *testing.T) {
+	tests := []struct {
+		Name           string
+		StatusCode     int
+		TokenID        string
+		ConversationID string
+	}{
+		{
+			Name:           "Successful token revocation",
+			StatusCode:     http.StatusNoContent,
+			TokenID:        "1",
+			ConversationID: "1",
+		},
+		{
+			Name:           "Token in other conversation",
+			StatusCode:     http.StatusNotFound,
+			TokenID:        "1",
+			ConversationID: "2",
+		},
+		{
+			Name:           "Token does not exist",
+			StatusCode:     http.StatusNotFound,
+			TokenID:        "2",
+			ConversationID: "1",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.Name, func(t *testing.T) {
+			r := httptest.NewRequest("DELETE", "/ether/v1/conversations/1/tokens/1", nil)
+			r.Header.Set("User-ID", "1")
+			r = mux.SetURLVars(r, map[string]string{
+				"conversation_id": test.ConversationID,
+				"token_id":        test.TokenID,
+			})
+			w := httptest.NewRecorder()
+
+			mDB := models.NewMockDB(
+				[]*models.Conversation{
+					&models.Conversation{ID: 1, Name: "testname"},
+					&models.Conversation{ID: 2, Name: "testname"},
+				},
+				[]*models.UserConversationMapping{
+					&models.UserConversationMapping{
+						UserID:         1,
+						ConversationID: 1,
+						Role:           models.Admin,
+						Pending:        utils.BoolPtr(false),
+					},
+					&models.UserConversationMapping{
+						UserID:         1,
+						ConversationID: 2,
+						Role:           models.Admin,
+						Pending:        utils.BoolPtr(false),
+					},
+				},
+				nil,
+			)
+			_ = mDB.CreateAPIToken(r.Context(), &models.APIToken{
+				ServiceAccountID: models.ServiceAccountMinID,
+				ConversationID:   1,
+				Hash:             "hash",
+			})
+
+			env := &Env{DB: mDB}
+			env.DeleteTokenHandler(w, r)
+
+			if w.Code != test.StatusCode {
+				t.Errorf("Response has incorrect status code, expected status code %d, got %d", test.StatusCode, w.Code)
+				return
+			}
+
+			if revoked := len(mDB.APITokens) == 0; revoked != (w.Code == http.StatusNoContent) {
+				t.Errorf("Token was revoked: %t, expected %t", revoked, !revoked)
+			}
+		})
+	}
+}
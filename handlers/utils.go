@@ -1,60 +1,189 @@
 package handlers
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
+	"ether/apierror"
 	"ether/filesystem"
+	"ether/karen"
+	"ether/logging"
 	"ether/models"
-	"fmt"
-	"io"
+	"ether/ratelimit"
+	"ether/webhooks"
 	"io/ioutil"
-	"log"
 	"net/http"
+	"reflect"
+	"strconv"
 )
 
+// ContentPublisher represents a publisher of content edits that are made
+// through the API, which sends them to the clients that are connected to the
+// "patches" service.
+type ContentPublisher interface {
+	PublishPatch(ctx context.Context, conversationID, userID int64, patch string) error
+}
+
 // Env represents all application-level items that are needed by HTTP handlers.
 type Env struct {
 	DB        models.Datastore
 	Directory *filesystem.Directory
-	Client    *http.Client
-	KarenHost string
+	Karen     karen.Client
+	Logger    *logging.Logger
+
+	// Writer applies content edits in the same order as those from Kafka.
+	Writer *filesystem.CachedWriter
+
+	// Publisher, if not nil, publishes the content edits made through the
+	// API.
+	Publisher ContentPublisher
+
+	// Webhooks, if not nil, is told about the events recorded by the
+	// handlers.
+	Webhooks models.EventNotifier
+
+	// MembershipLimiter, if not nil, limits how many members each user can
+	// add to or remove from each conversation.
+	MembershipLimiter *ratelimit.Limiter
+
+	// Quotas limits what users can create.
+	Quotas Quotas
+
+	// ReadinessChecks are run by GetReadyzHandler.
+	ReadinessChecks []HealthCheck
+
+	// WebhookAddresses decides which hosts webhooks can be registered for. If
+	// nil, hosts that resolve to private addresses are refused.
+	WebhookAddresses *webhooks.AddressPolicy
+}
+
+// allowMembershipChanges checks that a user can add n members to or remove n
+// members from a conversation without exceeding their membership limit in it,
+// responding with 429 Too Many Requests and returning false if not. The
+// changes are only counted against the limit by chargeMembershipChanges, once
+// they have been made.
+func (env *Env) allowMembershipChanges(w http.ResponseWriter, r *http.Request, userID, conversationID int64, n int) bool {
+	if env.MembershipLimiter == nil || n == 0 {
+		return true
+	}
+
+	ok, retryAfter := env.MembershipLimiter.Check(membershipKey(userID, conversationID), n)
+	if !ok {
+		env.logger(r).Infof(
+			"User %d is rate limited on membership changes in conversation %d for %s",
+			userID,
+			conversationID,
+			retryAfter,
+		)
+		ratelimit.Reject(w, retryAfter, "Too many membership changes in this conversation")
+	}
+	return ok
 }
 
-func internalServerError(w http.ResponseWriter, err error) {
+// chargeMembershipChanges counts n members that a user has added to or
+// removed from a conversation against their membership limit in it.
+func (env *Env) chargeMembershipChanges(userID, conversationID int64, n int) {
+	if env.MembershipLimiter == nil || n == 0 {
+		return
+	}
+	env.MembershipLimiter.Take(membershipKey(userID, conversationID), n)
+}
+
+// membershipKey returns the key of a user's membership limit in a
+// conversation.
+func membershipKey(userID, conversationID int64) string {
+	return strconv.FormatInt(userID, 10) + ":" + strconv.FormatInt(conversationID, 10)
+}
+
+// logger returns the logger for a request, which includes its request ID.
+func (env *Env) logger(r *http.Request) *logging.Logger {
+	return env.Logger.WithContext(r.Context())
+}
+
+func (env *Env) internalServerError(w http.ResponseWriter, r *http.Request, err error) {
 	errMsg := "Internal Server Error"
-	log.Println(errMsg + ": " + err.Error())
-	http.Error(w, errMsg, http.StatusInternalServerError)
+	env.logger(r).Errorf("%s: %v", errMsg, err)
+	apierror.Write(w, errMsg, http.StatusInternalServerError, apierror.InternalError)
 }
 
-func parseJSON(w http.ResponseWriter, body io.ReadCloser, bodyObj interface{}) error {
-	bodyBytes, err := ioutil.ReadAll(body)
+// karenError responds to a request that could not be completed because a call
+// to Karen failed.
+func (env *Env) karenError(w http.ResponseWriter, r *http.Request, err error) {
+	env.logger(r).Errorf("Failed to call Karen: %v", err)
+	if err == karen.ErrUnavailable {
+		apierror.Write(w, "User service unavailable", http.StatusServiceUnavailable, apierror.UserServiceUnavailable)
+	} else {
+		apierror.Write(w, "User service error", http.StatusBadGateway, apierror.UserServiceError)
+	}
+}
+
+// parseJSON reads a request body into bodyObj. If the body cannot be read or
+// parsed, it responds with 400 Bad Request. The details of a parse error only
+// have where it is: the offset in the body, and for a value of the wrong type,
+// its field and the expected JSON type. The decoder's own message is only
+// logged, since it names Go types.
+func (env *Env) parseJSON(w http.ResponseWriter, r *http.Request, bodyObj interface{}) error {
+	bodyBytes, err := ioutil.ReadAll(r.Body)
 	if err != nil {
-		errMsg := "Failed to read request body: " + err.Error()
-		log.Println(errMsg)
-		http.Error(w, errMsg, http.StatusBadRequest)
+		errMsg := "Failed to read request body"
+		env.logger(r).Infof("%s: %v", errMsg, err)
+		apierror.Write(w, errMsg, http.StatusBadRequest, apierror.RequestBodyInvalid)
 		return err
 	}
 
 	if err := json.Unmarshal(bodyBytes, bodyObj); err != nil {
-		errMsg := "Failed to parse request body: " + err.Error()
-		log.Println(errMsg)
-		http.Error(w, errMsg, http.StatusBadRequest)
+		errMsg := "Failed to parse request body"
+		env.logger(r).Infof("%s: %v", errMsg, err)
+		details := apierror.Details{}
+		var typeErr *json.UnmarshalTypeError
+		var syntaxErr *json.SyntaxError
+		if errors.As(err, &typeErr) {
+			if typeErr.Field != "" {
+				details["field"] = typeErr.Field
+			}
+			details["expected"] = jsonType(typeErr.Type)
+			details["offset"] = typeErr.Offset
+		} else if errors.As(err, &syntaxErr) {
+			details["offset"] = syntaxErr.Offset
+		}
+		apierror.WriteDetails(w, errMsg, http.StatusBadRequest, apierror.RequestBodyInvalid, details)
 		return err
 	}
 
 	return nil
 }
 
-func (env *Env) getConversation(w http.ResponseWriter, id int64) (*models.Conversation, error) {
-	conversation, err := env.DB.GetConversation(id)
+// jsonType returns the name of the JSON type that decodes into a Go type
+func jsonType(t reflect.Type) string {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	switch t.Kind() {
+	case reflect.Bool:
+		return "boolean"
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64,
+		reflect.Float32, reflect.Float64:
+		return "number"
+	case reflect.String:
+		return "string"
+	case reflect.Slice, reflect.Array:
+		return "array"
+	default:
+		return "object"
+	}
+}
+
+func (env *Env) getConversation(w http.ResponseWriter, r *http.Request, id int64) (*models.Conversation, error) {
+	conversation, err := env.DB.GetConversation(r.Context(), id)
 	if err != nil {
-		internalServerError(w, err)
+		env.internalServerError(w, r, err)
 		return nil, err
 	}
 
 	if conversation == nil {
-		errMsg := fmt.Sprintf("Conversation %d does not exist", id)
-		log.Println(errMsg)
-		http.Error(w, "Conversation not found", http.StatusNotFound)
+		env.logger(r).Infof("Conversation %d does not exist", id)
+		apierror.Write(w, "Conversation not found", http.StatusNotFound, apierror.ConversationNotFound)
 		return nil, nil
 	}
 
@@ -63,20 +192,21 @@ func (env *Env) getConversation(w http.ResponseWriter, id int64) (*models.Conver
 
 func (env *Env) getMapping(
 	w http.ResponseWriter,
+	r *http.Request,
 	userID int64,
 	conversationID int64,
 	httpNotFoundMsg string,
+	notFoundCode apierror.Code,
 ) (*models.UserConversationMapping, error) {
-	mapping, err := env.DB.GetUserConversationMapping(userID, conversationID)
+	mapping, err := env.DB.GetUserConversationMapping(r.Context(), userID, conversationID)
 	if err != nil {
-		internalServerError(w, err)
+		env.internalServerError(w, r, err)
 		return nil, err
 	}
 
 	if mapping == nil {
-		errMsg := fmt.Sprintf("User %d is not in conversation %d", userID, conversationID)
-		log.Println(errMsg)
-		http.Error(w, httpNotFoundMsg, http.StatusNotFound)
+		env.logger(r).Infof("User %d is not in conversation %d", userID, conversationID)
+		apierror.Write(w, httpNotFoundMsg, http.StatusNotFound, notFoundCode)
 		return nil, nil
 	}
 
@@ -0,0 +1,312 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"ether/apierror"
+	"ether/models"
+	"ether/webhooks"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+const (
+	maxWebhookURLLength = 2048
+
+	defaultDeliveriesLimit = 50
+	maxDeliveriesLimit     = 200
+)
+
+// webhookPatch represents the fields of a webhook that can be changed
+type webhookPatch struct {
+	URL     *string               `json:"url"`
+	Events  *[]models.EventAction `json:"events"`
+	Enabled *bool                 `json:"enabled"`
+}
+
+// getWebhookOwner parses the session user and conversation of a request to
+// manage webhooks, and checks that the session user is the conversation's
+// owner. It responds with an error and returns nil if the request cannot
+// continue.
+func (env *Env) getWebhookOwner(w http.ResponseWriter, r *http.Request) (*models.UserConversationMapping, error) {
+	userID, err := strconv.ParseInt(r.Header.Get("User-ID"), 10, 64)
+	if err != nil {
+		errMsg := "Invalid user ID"
+		env.logger(r).Infof("%s: %v", errMsg, err)
+		apierror.Write(w, errMsg, http.StatusBadRequest, apierror.UserIDInvalid)
+		return nil, err
+	}
+
+	conversationID, err := strconv.ParseInt(mux.Vars(r)["conversation_id"], 10, 64)
+	if err != nil {
+		errMsg := "Invalid conversation ID"
+		env.logger(r).Infof("%s: %v", errMsg, err)
+		apierror.Write(w, errMsg, http.StatusBadRequest, apierror.ConversationIDInvalid)
+		return nil, err
+	}
+
+	conversation, err := env.getConversation(w, r, conversationID)
+	if err != nil || conversation == nil {
+		return nil, err
+	}
+
+	sessionMember, err := env.getMapping(w, r, userID, conversationID, "Conversation not found", apierror.ConversationNotFound)
+	if err != nil || sessionMember == nil {
+		return nil, err
+	}
+
+	if sessionMember.Role != models.Owner {
+		errMsg := fmt.Sprintf("User %d cannot manage webhooks of conversation %d", userID, conversationID)
+		env.logger(r).Info(errMsg)
+		apierror.Write(w, "Forbidden from managing webhooks", http.StatusForbidden, apierror.Forbidden)
+		return nil, nil
+	}
+
+	return sessionMember, nil
+}
+
+// getWebhook gets the webhook of a request, responding with 404 Not Found if
+// it does not exist or belongs to another conversation
+func (env *Env) getWebhook(w http.ResponseWriter, r *http.Request, conversationID int64) (*models.Webhook, error) {
+	webhookID, err := strconv.ParseInt(mux.Vars(r)["webhook_id"], 10, 64)
+	if err != nil {
+		errMsg := "Invalid webhook ID"
+		env.logger(r).Infof("%s: %v", errMsg, err)
+		apierror.WriteDetails(w, errMsg, http.StatusBadRequest, apierror.ParameterInvalid, apierror.Details{"parameter": "webhook_id"})
+		return nil, err
+	}
+
+	webhook, err := env.DB.GetWebhook(r.Context(), webhookID)
+	if err != nil {
+		env.internalServerError(w, r, err)
+		return nil, err
+	}
+
+	if webhook == nil || webhook.ConversationID != conversationID {
+		env.logger(r).Infof("Webhook %d is not in conversation %d", webhookID, conversationID)
+		apierror.Write(w, "Webhook not found", http.StatusNotFound, apierror.WebhookNotFound)
+		return nil, nil
+	}
+
+	return webhook, nil
+}
+
+// validateWebhook checks the URL and events of a webhook, returning the field
+// with the first problem found and a message describing it. The URL's host
+// must only resolve to addresses that webhooks can be sent to, although the
+// dispatcher checks the addresses it connects to again, since they can change.
+func (env *Env) validateWebhook(ctx context.Context, webhook *models.Webhook) (string, string) {
+	if len(webhook.URL) > maxWebhookURLLength {
+		return "url", fmt.Sprintf("Webhook URL must be at most %d bytes", maxWebhookURLLength)
+	}
+	u, err := url.Parse(webhook.URL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Hostname() == "" {
+		return "url", "Webhook URL must be an absolute http or https URL"
+	}
+	if err := env.WebhookAddresses.CheckHost(ctx, u.Hostname()); errors.Is(err, webhooks.ErrAddressNotAllowed) {
+		return "url", "Webhook URL must not be a loopback, private, link-local or unspecified address"
+	} else if err != nil {
+		return "url", fmt.Sprintf("Webhook URL host could not be resolved: %v", err)
+	}
+
+	for _, action := range webhook.Events {
+		if !action.Valid() {
+			return "events", fmt.Sprintf("Invalid event type: %s", action)
+		}
+	}
+	return "", ""
+}
+
+// PostWebhookHandler registers a webhook for a conversation. The secret that
+// signs its deliveries is only ever returned in this response.
+func (env *Env) PostWebhookHandler(w http.ResponseWriter, r *http.Request) {
+	sessionMember, err := env.getWebhookOwner(w, r)
+	if err != nil || sessionMember == nil {
+		return
+	}
+
+	reqWebhook := &models.Webhook{}
+	if err := env.parseJSON(w, r, reqWebhook); err != nil {
+		return
+	}
+
+	reqWebhook.URL = strings.TrimSpace(reqWebhook.URL)
+	if reqWebhook.URL == "" {
+		errMsg := "Request body is missing field(s)"
+		env.logger(r).Info(errMsg)
+		apierror.Write(w, errMsg, http.StatusBadRequest, apierror.RequestBodyMissingFields)
+		return
+	} else if field, errMsg := env.validateWebhook(r.Context(), reqWebhook); errMsg != "" {
+		env.logger(r).Info(errMsg)
+		apierror.WriteDetails(w, errMsg, http.StatusBadRequest, apierror.FieldInvalid, apierror.Details{"field": field})
+		return
+	}
+
+	secret, err := webhooks.NewSecret()
+	if err != nil {
+		env.internalServerError(w, r, err)
+		return
+	}
+
+	enabled := true
+	newWebhook := &models.Webhook{
+		ConversationID: sessionMember.ConversationID,
+		URL:            reqWebhook.URL,
+		Events:         reqWebhook.Events,
+		Enabled:        &enabled,
+		Secret:         secret,
+		CreatorID:      sessionMember.UserID,
+		CreatedAt:      time.Now().UTC().Format(models.TimeLayout),
+	}
+	if newWebhook.Events == nil {
+		newWebhook.Events = []models.EventAction{}
+	}
+	if err := env.DB.CreateWebhook(r.Context(), newWebhook); err != nil {
+		env.internalServerError(w, r, err)
+		return
+	}
+
+	location := fmt.Sprintf("%s/%d", r.URL.Path, newWebhook.ID)
+	w.Header().Add("Location", location)
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Add("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(newWebhook)
+}
+
+// GetWebhooksHandler gets the webhooks of a conversation, without their
+// secrets
+func (env *Env) GetWebhooksHandler(w http.ResponseWriter, r *http.Request) {
+	sessionMember, err := env.getWebhookOwner(w, r)
+	if err != nil || sessionMember == nil {
+		return
+	}
+
+	webhookList, err := env.DB.GetWebhooks(r.Context(), sessionMember.ConversationID)
+	if err != nil {
+		env.internalServerError(w, r, err)
+		return
+	}
+	for _, webhook := range webhookList {
+		webhook.Secret = ""
+	}
+
+	w.Header().Add("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(&models.WebhookList{Webhooks: webhookList})
+}
+
+// PatchWebhookHandler changes the URL, events or enabled state of a webhook.
+// Enabling a webhook resets its count of failed deliveries.
+func (env *Env) PatchWebhookHandler(w http.ResponseWriter, r *http.Request) {
+	sessionMember, err := env.getWebhookOwner(w, r)
+	if err != nil || sessionMember == nil {
+		return
+	}
+
+	webhook, err := env.getWebhook(w, r, sessionMember.ConversationID)
+	if err != nil || webhook == nil {
+		return
+	}
+
+	reqPatch := &webhookPatch{}
+	if err := env.parseJSON(w, r, reqPatch); err != nil {
+		return
+	}
+
+	if reqPatch.URL == nil && reqPatch.Events == nil && reqPatch.Enabled == nil {
+		errMsg := "Request body is missing field(s)"
+		env.logger(r).Info(errMsg)
+		apierror.Write(w, errMsg, http.StatusBadRequest, apierror.RequestBodyMissingFields)
+		return
+	}
+	if reqPatch.URL != nil {
+		webhook.URL = strings.TrimSpace(*reqPatch.URL)
+	}
+	if reqPatch.Events != nil {
+		webhook.Events = *reqPatch.Events
+		if webhook.Events == nil {
+			webhook.Events = []models.EventAction{}
+		}
+	}
+	if reqPatch.Enabled != nil {
+		webhook.Enabled = reqPatch.Enabled
+	}
+	if field, errMsg := env.validateWebhook(r.Context(), webhook); errMsg != "" {
+		env.logger(r).Info(errMsg)
+		apierror.WriteDetails(w, errMsg, http.StatusBadRequest, apierror.FieldInvalid, apierror.Details{"field": field})
+		return
+	}
+
+	if err := env.DB.UpdateWebhook(r.Context(), webhook); err != nil {
+		env.internalServerError(w, r, err)
+		return
+	}
+	if *webhook.Enabled {
+		webhook.ConsecutiveFailures = 0
+	}
+
+	webhook.Secret = ""
+	w.Header().Add("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(webhook)
+}
+
+// DeleteWebhookHandler deletes a webhook and its delivery log
+func (env *Env) DeleteWebhookHandler(w http.ResponseWriter, r *http.Request) {
+	sessionMember, err := env.getWebhookOwner(w, r)
+	if err != nil || sessionMember == nil {
+		return
+	}
+
+	webhook, err := env.getWebhook(w, r, sessionMember.ConversationID)
+	if err != nil || webhook == nil {
+		return
+	}
+
+	if err := env.DB.DeleteWebhook(r.Context(), webhook.ID); err != nil {
+		env.internalServerError(w, r, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// GetWebhookDeliveriesHandler gets the latest attempts to deliver events to a
+// webhook, newest first
+func (env *Env) GetWebhookDeliveriesHandler(w http.ResponseWriter, r *http.Request) {
+	sessionMember, err := env.getWebhookOwner(w, r)
+	if err != nil || sessionMember == nil {
+		return
+	}
+
+	limit := defaultDeliveriesLimit
+	if limitStr := r.URL.Query().Get("limit"); limitStr != "" {
+		limit, err = strconv.Atoi(limitStr)
+		if err != nil || limit < 1 || limit > maxDeliveriesLimit {
+			errMsg := fmt.Sprintf("Limit must be between 1 and %d", maxDeliveriesLimit)
+			env.logger(r).Info(errMsg)
+			apierror.WriteDetails(w, errMsg, http.StatusBadRequest, apierror.ParameterInvalid, apierror.Details{"parameter": "limit"})
+			return
+		}
+	}
+
+	webhook, err := env.getWebhook(w, r, sessionMember.ConversationID)
+	if err != nil || webhook == nil {
+		return
+	}
+
+	deliveries, err := env.DB.GetWebhookDeliveries(r.Context(), webhook.ID, limit)
+	if err != nil {
+		env.internalServerError(w, r, err)
+		return
+	}
+
+	w.Header().Add("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(&models.WebhookDeliveryList{Deliveries: deliveries})
+}
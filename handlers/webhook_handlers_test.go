@@ -0,0 +1,277 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"ether/models"
+	"ether/utils"
+	"ether/webhooks"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/mux"
+)
+
+// testResolver resolves the hosts that the webhook tests use without DNS.
+type testResolver map[string]string
+
+func (r testResolver) LookupIPAddr(ctx context.Context, host string) ([]net.IPAddr, error) {
+	ip, ok := r[host]
+	if !ok {
+		return nil, &net.DNSError{Err: "no such host", Name: host, IsNotFound: true}
+	}
+	return []net.IPAddr{{IP: net.ParseIP(ip)}}, nil
+}
+
+var testWebhookAddresses = &webhooks.AddressPolicy{Resolver: testResolver{
+	"hooks.example.com": "93.184.216.34",
+	"localhost":         "127.0.0.1",
+}}
+
+func TestPostWebhookHandler(t *testing.T) {
+	tests := []struct {
+		Name       string
+		StatusCode int
+		ReqBody    string
+		Role       models.Role
+		ResEvents  []models.EventAction
+	}{
+		{
+			Name:       "Successful webhook creation",
+			StatusCode: http.StatusCreated,
+			ReqBody:    `{"url": "https://hooks.example.com/ether", "events": ["member_added", "content_edited"]}`,
+			Role:       models.Owner,
+			ResEvents:  []models.EventAction{models.EventMemberAdded, models.EventContentEdited},
+		},
+		{
+			Name:       "Successful webhook creation (every event)",
+			StatusCode: http.StatusCreated,
+			ReqBody:    `{"url": "http://hooks.example.com:8080/hook"}`,
+			Role:       models.Owner,
+			ResEvents:  []models.EventAction{},
+		},
+		{
+			Name:       "Loopback host",
+			StatusCode: http.StatusBadRequest,
+			ReqBody:    `{"url": "http://localhost:8080/hook"}`,
+			Role:       models.Owner,
+		},
+		{
+			Name:       "Private IP",
+			StatusCode: http.StatusBadRequest,
+			ReqBody:    `{"url": "http://10.0.0.5/hook"}`,
+			Role:       models.Owner,
+		},
+		{
+			Name:       "Link-local IP",
+			StatusCode: http.StatusBadRequest,
+			ReqBody:    `{"url": "http://169.254.169.254/latest/meta-data"}`,
+			Role:       models.Owner,
+		},
+		{
+			Name:       "Host that does not resolve",
+			StatusCode: http.StatusBadRequest,
+			ReqBody:    `{"url": "https://missing.example.com/ether"}`,
+			Role:       models.Owner,
+		},
+		{
+			Name:       "Invalid URL scheme",
+			StatusCode: http.StatusBadRequest,
+			ReqBody:    `{"url": "ftp://hooks.example.com/ether"}`,
+			Role:       models.Owner,
+		},
+		{
+			Name:       "Relative URL",
+			StatusCode: http.StatusBadRequest,
+			ReqBody:    `{"url": "/ether"}`,
+			Role:       models.Owner,
+		},
+		{
+			Name:       "Invalid event",
+			StatusCode: http.StatusBadRequest,
+			ReqBody:    `{"url": "https://hooks.example.com/ether", "events": ["content_viewed"]}`,
+			Role:       models.Owner,
+		},
+		{
+			Name:       "Missing URL",
+			StatusCode: http.StatusBadRequest,
+			ReqBody:    `{"events": ["member_added"]}`,
+			Role:       models.Owner,
+		},
+		{
+			Name:       "Forbidden (admin)",
+			StatusCode: http.StatusForbidden,
+			ReqBody:    `{"url": "https://hooks.example.com/ether"}`,
+			Role:       models.Admin,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.Name, func(t *testing.T) {
+			r := httptest.NewRequest("POST", "/ether/v1/conversations/1/webhooks", bytes.NewBufferString(test.ReqBody))
+			r.Header.Set("User-ID", "1")
+			r = mux.SetURLVars(r, map[string]string{"conversation_id": "1"})
+			w := httptest.NewRecorder()
+
+			mDB := models.NewMockDB(
+				[]*models.Conversation{&models.Conversation{ID: 1, Name: "testname"}},
+				[]*models.UserConversationMapping{&models.UserConversationMapping{
+					UserID:         1,
+					ConversationID: 1,
+					Role:           test.Role,
+					Pending:        utils.BoolPtr(false),
+				}},
+				nil,
+			)
+
+			env := &Env{DB: mDB, WebhookAddresses: testWebhookAddresses}
+			env.PostWebhookHandler(w, r)
+
+			if w.Code != test.StatusCode {
+				t.Errorf("Response has incorrect status code, expected status code %d, got %d", test.StatusCode, w.Code)
+				return
+			}
+
+			if w.Code == http.StatusCreated {
+				resBody := models.Webhook{}
+				_ = json.NewDecoder(w.Body).Decode(&resBody)
+				if !reflect.DeepEqual(test.ResEvents, resBody.Events) {
+					t.Errorf("Response has incorrect events, expected %v, got %v", test.ResEvents, resBody.Events)
+				}
+				if !strings.HasPrefix(resBody.Secret, "whsec_") {
+					t.Errorf("Response has invalid secret %q", resBody.Secret)
+				}
+				if resBody.Enabled == nil || !*resBody.Enabled {
+					t.Errorf("Webhook was not enabled")
+				}
+
+				stored := mDB.Webhooks[resBody.ID]
+				if stored == nil || stored.Secret != resBody.Secret {
+					t.Errorf("Webhook secret was not stored")
+				}
+			}
+		})
+	}
+}
+
+func TestPatchWebhookHandler(t *testing.T) {
+	tests := []struct {
+		Name        string
+		StatusCode  int
+		ReqBody     string
+		WebhookID   string
+		ResURL      string
+		ResEnabled  bool
+		ResFailures int
+	}{
+		{
+			Name:        "Successful webhook re-enabling",
+			StatusCode:  http.StatusOK,
+			ReqBody:     `{"enabled": true}`,
+			WebhookID:   "1",
+			ResURL:      "https://hooks.example.com/ether",
+			ResEnabled:  true,
+			ResFailures: 0,
+		},
+		{
+			Name:        "Successful URL change",
+			StatusCode:  http.StatusOK,
+			ReqBody:     `{"url": "https://hooks.example.com/v2"}`,
+			WebhookID:   "1",
+			ResURL:      "https://hooks.example.com/v2",
+			ResEnabled:  false,
+			ResFailures: 10,
+		},
+		{
+			Name:       "Private URL",
+			StatusCode: http.StatusBadRequest,
+			ReqBody:    `{"url": "http://[::1]:8080/hook"}`,
+			WebhookID:  "1",
+		},
+		{
+			Name:       "Invalid URL",
+			StatusCode: http.StatusBadRequest,
+			ReqBody:    `{"url": "hooks.example.com"}`,
+			WebhookID:  "1",
+		},
+		{
+			Name:       "Empty patch",
+			StatusCode: http.StatusBadRequest,
+			ReqBody:    `{}`,
+			WebhookID:  "1",
+		},
+		{
+			Name:       "Webhook in other conversation",
+			StatusCode: http.StatusNotFound,
+			ReqBody:    `{"enabled": true}`,
+			WebhookID:  "2",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.Name, func(t *testing.T) {
+			r := httptest.NewRequest("PATCH", "/ether/v1/conversations/1/webhooks/1", bytes.NewBufferString(test.ReqBody))
+			r.Header.Set("User-ID", "1")
+			r = mux.SetURLVars(r, map[string]string{
+				"conversation_id": "1",
+				"webhook_id":      test.WebhookID,
+			})
+			w := httptest.NewRecorder()
+
+			mDB := models.NewMockDB(
+				[]*models.Conversation{&models.Conversation{ID: 1, Name: "testname"}},
+				[]*models.UserConversationMapping{&models.UserConversationMapping{
+					UserID:         1,
+					ConversationID: 1,
+					Role:           models.Owner,
+					Pending:        utils.BoolPtr(false),
+				}},
+				nil,
+			)
+			_ = mDB.CreateWebhook(r.Context(), &models.Webhook{
+				ConversationID:      1,
+				URL:                 "https://hooks.example.com/ether",
+				Enabled:             utils.BoolPtr(false),
+				ConsecutiveFailures: 10,
+				Secret:              "secret",
+			})
+			_ = mDB.CreateWebhook(r.Context(), &models.Webhook{
+				ConversationID: 2,
+				URL:            "https://hooks.example.com/ether",
+				Enabled:        utils.BoolPtr(false),
+			})
+
+			env := &Env{DB: mDB, WebhookAddresses: testWebhookAddresses}
+			env.PatchWebhookHandler(w, r)
+
+			if w.Code != test.StatusCode {
+				t.Errorf("Response has incorrect status code, expected status code %d, got %d", test.StatusCode, w.Code)
+				return
+			}
+
+			if w.Code == http.StatusOK {
+				resBody := models.Webhook{}
+				_ = json.NewDecoder(w.Body).Decode(&resBody)
+				if resBody.Secret != "" {
+					t.Errorf("Response includes the webhook secret")
+				}
+
+				stored := mDB.Webhooks[1]
+				if stored.URL != test.ResURL {
+					t.Errorf("Webhook has incorrect URL, expected %q, got %q", test.ResURL, stored.URL)
+				}
+				if *stored.Enabled != test.ResEnabled {
+					t.Errorf("Webhook has incorrect enabled state, expected %t, got %t", test.ResEnabled, *stored.Enabled)
+				}
+				if stored.ConsecutiveFailures != test.ResFailures {
+					t.Errorf("Webhook has incorrect failures, expected %d, got %d", test.ResFailures, stored.ConsecutiveFailures)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,95 @@
+package history
+
+import (
+	"errors"
+	"ether/models"
+	"fmt"
+	"unicode/utf8"
+
+	"github.com/sergi/go-diff/diffmatchpatch"
+)
+
+// Span represents a run of content that was last edited by the same revision.
+type Span struct {
+	Text     string `json:"text"`
+	UserID   *int64 `json:"user_id"`
+	Version  int64  `json:"version"`
+	EditedAt string `json:"edited_at"`
+}
+
+// MaxBlameRevisions is the most revisions that Blame replays.
+const MaxBlameRevisions = 5000
+
+// ErrHistoryTooLong is returned by Blame if the content was edited by more
+// revisions than it replays.
+var ErrHistoryTooLong = errors.New("history: too many revisions to replay")
+
+// Blame replays the revisions of a conversation, in order of version, and
+// splits the resulting content into spans annotated with the revision that
+// last edited each character. Characters that a revision deleted are not in
+// any span. Replaying starts from the last reset revision, whose content is
+// attributed to it as a whole, and fails with ErrHistoryTooLong, before
+// replaying any, if there are more than MaxBlameRevisions from it on. Callers
+// should only load those revisions, with Datastore.GetRevisionsSinceReset.
+func Blame(revisions []*models.Revision) ([]*Span, error) {
+	for i := len(revisions) - 1; i > 0; i-- {
+		if revisions[i].Reset {
+			revisions = revisions[i:]
+			break
+		}
+	}
+	if len(revisions) > MaxBlameRevisions {
+		return nil, ErrHistoryTooLong
+	}
+
+	content := ""
+	var runes []rune
+	var authors []*models.Revision
+
+	for _, revision := range revisions {
+		newContent, err := applyPatch(content, revision.Patch)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid patch of revision %d: %v", revision.Version, err)
+		}
+
+		newRunes := make([]rune, 0, utf8.RuneCountInString(newContent))
+		newAuthors := make([]*models.Revision, 0, cap(newRunes))
+		i := 0
+		for _, diff := range dmp.DiffMain(content, newContent, false) {
+			text := []rune(diff.Text)
+			switch diff.Type {
+			case diffmatchpatch.DiffEqual:
+				newRunes = append(newRunes, text...)
+				newAuthors = append(newAuthors, authors[i:i+len(text)]...)
+				i += len(text)
+			case diffmatchpatch.DiffDelete:
+				i += len(text)
+			case diffmatchpatch.DiffInsert:
+				newRunes = append(newRunes, text...)
+				for range text {
+					newAuthors = append(newAuthors, revision)
+				}
+			}
+		}
+
+		content = newContent
+		runes = newRunes
+		authors = newAuthors
+	}
+
+	spans := make([]*Span, 0)
+	start := 0
+	for i := 1; i <= len(runes); i++ {
+		if i < len(runes) && authors[i] == authors[start] {
+			continue
+		}
+		spans = append(spans, &Span{
+			Text:     string(runes[start:i]),
+			UserID:   authors[start].UserID,
+			Version:  authors[start].Version,
+			EditedAt: authors[start].CreatedAt,
+		})
+		start = i
+	}
+	return spans, nil
+}
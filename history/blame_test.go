@@ -0,0 +1,131 @@
+package history
+
+import (
+	"context"
+	"ether/models"
+	"reflect"
+	"testing"
+)
+
+func TestBlame(t *testing.T) {
+	var alice int64 = 1
+	var bob int64 = 2
+
+	tests := []struct {
+		Name     string
+		Existing string
+		Edits    []struct {
+			UserID  *int64
+			Content string
+		}
+		Spans []Span
+	}{
+		{
+			Name: "Insertions by two users",
+			Edits: []struct {
+				UserID  *int64
+				Content string
+			}{
+				{&alice, "hello world"},
+				{&bob, "hello there world"},
+			},
+			Spans: []Span{
+				{Text: "hello ", UserID: &alice, Version: 1},
+				{Text: "there ", UserID: &bob, Version: 2},
+				{Text: "world", UserID: &alice, Version: 1},
+			},
+		},
+		{
+			Name: "Deleted text is not in any span",
+			Edits: []struct {
+				UserID  *int64
+				Content string
+			}{
+				{&alice, "hello there world"},
+				{&bob, "hello world!"},
+			},
+			Spans: []Span{
+				{Text: "hello world", UserID: &alice, Version: 1},
+				{Text: "!", UserID: &bob, Version: 2},
+			},
+		},
+		{
+			Name:     "Existing content has no user",
+			Existing: "héllo",
+			Edits: []struct {
+				UserID  *int64
+				Content string
+			}{
+				{&alice, "héllo wörld"},
+			},
+			Spans: []Span{
+				{Text: "héllo", Version: 1},
+				{Text: " wörld", UserID: &alice, Version: 2},
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.Name, func(t *testing.T) {
+			mDB := models.NewMockDB(nil, nil, nil)
+			rec := NewRecorder(mDB, nil)
+			before := test.Existing
+			for _, edit := range test.Edits {
+				if err := rec.Record(context.Background(), 1, edit.UserID, before, edit.Content); err != nil {
+					t.Fatalf("Failed to record revision: %v", err)
+				}
+				before = edit.Content
+			}
+
+			revisions, _ := mDB.GetRevisions(context.Background(), 1)
+			spans, err := Blame(revisions)
+			if err != nil {
+				t.Fatalf("Failed to blame: %v", err)
+			}
+
+			resSpans := make([]Span, len(spans))
+			for i, span := range spans {
+				resSpans[i] = *span
+				resSpans[i].EditedAt = ""
+			}
+			if !reflect.DeepEqual(test.Spans, resSpans) {
+				t.Errorf("Incorrect spans, expected %+v, got %+v", test.Spans, resSpans)
+			}
+		})
+	}
+}
+
+func TestBlameAfterReset(t *testing.T) {
+	var alice int64 = 1
+	revisions := []*models.Revision{
+		{Version: 1, UserID: &alice, Patch: "not a patch"},
+		{Version: 2, Patch: makePatch("", "hello"), Reset: true},
+		{Version: 3, UserID: &alice, Patch: makePatch("hello", "hello world")},
+	}
+
+	spans, err := Blame(revisions)
+	if err != nil {
+		t.Fatalf("Failed to blame: %v", err)
+	}
+	expected := []Span{
+		{Text: "hello", Version: 2},
+		{Text: " world", UserID: &alice, Version: 3},
+	}
+	resSpans := make([]Span, len(spans))
+	for i, span := range spans {
+		resSpans[i] = *span
+	}
+	if !reflect.DeepEqual(expected, resSpans) {
+		t.Errorf("Incorrect spans, expected %+v, got %+v", expected, resSpans)
+	}
+}
+
+func TestBlameTooLong(t *testing.T) {
+	revisions := make([]*models.Revision, MaxBlameRevisions+1)
+	for i := range revisions {
+		revisions[i] = &models.Revision{Version: int64(i + 1)}
+	}
+	if _, err := Blame(revisions); err != ErrHistoryTooLong {
+		t.Errorf("Expected %v, got %v", ErrHistoryTooLong, err)
+	}
+}
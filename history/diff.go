@@ -0,0 +1,173 @@
+package history
+
+import (
+	"ether/models"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/sergi/go-diff/diffmatchpatch"
+)
+
+const (
+	// unifiedContext is the number of unchanged lines shown around each change
+	// in a unified diff.
+	unifiedContext = 3
+)
+
+// Content replays the revisions of a conversation, in order of version, up to
+// and including a version and returns the content at that version. Version 0
+// is the empty content that every conversation starts with. Replaying starts
+// from snapshot if it is not nil and at or before the version, or from the
+// last reset revision after it.
+func Content(snapshot *models.Snapshot, revisions []*models.Revision, version int64) (string, error) {
+	content := ""
+	var from int64
+	if snapshot != nil && snapshot.Version <= version {
+		content = snapshot.Content
+		from = snapshot.Version
+	}
+	for _, revision := range revisions {
+		if revision.Version > version {
+			break
+		}
+		if revision.Reset && revision.Version > from {
+			content = ""
+			from = revision.Version - 1
+		}
+	}
+
+	for _, revision := range revisions {
+		if revision.Version > version {
+			break
+		}
+		if revision.Version <= from {
+			continue
+		}
+		var err error
+		content, err = applyPatch(content, revision.Patch)
+		if err != nil {
+			return "", fmt.Errorf("Invalid patch of revision %d: %v", revision.Version, err)
+		}
+	}
+	return content, nil
+}
+
+// VersionAt returns the version of a conversation's content at a time, which
+// is that of the last revision created at or before it, or 0 if there is none.
+// If the first revision has no user it holds content that was written before
+// revisions were kept, and is the earliest version any time can refer to.
+func VersionAt(revisions []*models.Revision, t time.Time) int64 {
+	var version int64
+	if len(revisions) > 0 && revisions[0].Version == 1 && revisions[0].UserID == nil {
+		version = 1
+	}
+	for _, revision := range revisions {
+		createdAt, err := time.Parse(models.TimeLayout, revision.CreatedAt)
+		if err != nil || createdAt.After(t) {
+			break
+		}
+		version = revision.Version
+	}
+	return version
+}
+
+// UnifiedDiff returns the line-based differences between two contents in the
+// unified format, with the names of the contents in its header. It returns an
+// empty string if they are the same.
+func UnifiedDiff(fromName, from, toName, to string) string {
+	fromChars, toChars, lines := dmp.DiffLinesToChars(from, to)
+	diffs := dmp.DiffCharsToLines(dmp.DiffMain(fromChars, toChars, false), lines)
+
+	type diffLine struct {
+		prefix byte
+		text   string
+	}
+	var diffLines []diffLine
+	var changes []int
+	for _, diff := range diffs {
+		prefix := byte(' ')
+		switch diff.Type {
+		case diffmatchpatch.DiffDelete:
+			prefix = '-'
+		case diffmatchpatch.DiffInsert:
+			prefix = '+'
+		}
+		for _, line := range strings.SplitAfter(diff.Text, "\n") {
+			if line == "" {
+				continue
+			}
+			if prefix != ' ' {
+				changes = append(changes, len(diffLines))
+			}
+			diffLines = append(diffLines, diffLine{prefix: prefix, text: line})
+		}
+	}
+	if len(changes) == 0 {
+		return ""
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "--- %s\n+++ %s\n", fromName, toName)
+	for i := 0; i < len(changes); {
+		// Group changes that are close enough for their context to overlap
+		j := i
+		for j+1 < len(changes) && changes[j+1]-changes[j] <= 2*unifiedContext {
+			j++
+		}
+		start := changes[i] - unifiedContext
+		if start < 0 {
+			start = 0
+		}
+		end := changes[j] + unifiedContext + 1
+		if end > len(diffLines) {
+			end = len(diffLines)
+		}
+
+		var fromStart, toStart, fromCount, toCount int
+		for _, line := range diffLines[:start] {
+			if line.prefix != '+' {
+				fromStart++
+			}
+			if line.prefix != '-' {
+				toStart++
+			}
+		}
+		for _, line := range diffLines[start:end] {
+			if line.prefix != '+' {
+				fromCount++
+			}
+			if line.prefix != '-' {
+				toCount++
+			}
+		}
+		// Ranges are 1-based, unless they are empty and name the line they
+		// come after
+		if fromCount > 0 {
+			fromStart++
+		}
+		if toCount > 0 {
+			toStart++
+		}
+
+		fmt.Fprintf(&b, "@@ -%d,%d +%d,%d @@\n", fromStart, fromCount, toStart, toCount)
+		for _, line := range diffLines[start:end] {
+			b.WriteByte(line.prefix)
+			b.WriteString(line.text)
+			if !strings.HasSuffix(line.text, "\n") {
+				b.WriteString("\n\\ No newline at end of file\n")
+			}
+		}
+		i = j + 1
+	}
+	return b.String()
+}
+
+// HTMLDiff returns the character-based differences between two contents as
+// HTML, with insertions in <ins> elements and deletions in <del> elements. The
+// contents are escaped, so their own markup is shown rather than rendered.
+func HTMLDiff(from, to string) string {
+	diffs := dmp.DiffMain(from, to, false)
+	diffs = dmp.DiffCleanupSemantic(diffs)
+	return dmp.DiffPrettyHtml(diffs)
+}
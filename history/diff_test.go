@@ -0,0 +1,171 @@
+package history
+
+import (
+	"context"
+	"ether/models"
+	"testing"
+	"time"
+)
+
+func TestContent(t *testing.T) {
+	var userID int64 = 1
+	mDB := models.NewMockDB(nil, nil, nil)
+	rec := NewRecorder(mDB, nil)
+	versions := []string{"hello", "hello world", "goodbye world"}
+	before := ""
+	for _, content := range versions {
+		_ = rec.Record(context.Background(), 1, &userID, before, content)
+		before = content
+	}
+	revisions, _ := mDB.GetRevisions(context.Background(), 1)
+
+	for version, expected := range append([]string{""}, versions...) {
+		content, err := Content(nil, revisions, int64(version))
+		if err != nil {
+			t.Fatalf("Failed to replay version %d: %v", version, err)
+		}
+		if content != expected {
+			t.Errorf("Incorrect content at version %d, expected %q, got %q", version, expected, content)
+		}
+	}
+}
+
+func TestVersionAt(t *testing.T) {
+	var userID int64 = 1
+	revisions := []*models.Revision{
+		{Version: 1, UserID: &userID, CreatedAt: "2020-01-01 10:00:00"},
+		{Version: 2, UserID: &userID, CreatedAt: "2020-01-01 11:00:00"},
+		{Version: 3, UserID: &userID, CreatedAt: "2020-01-01 12:00:00"},
+	}
+
+	tests := []struct {
+		Name    string
+		Time    string
+		Version int64
+	}{
+		{Name: "Before first revision", Time: "2020-01-01 09:00:00", Version: 0},
+		{Name: "At a revision", Time: "2020-01-01 11:00:00", Version: 2},
+		{Name: "Between revisions", Time: "2020-01-01 11:30:00", Version: 2},
+		{Name: "After last revision", Time: "2020-01-02 00:00:00", Version: 3},
+	}
+
+	for _, test := range tests {
+		t.Run(test.Name, func(t *testing.T) {
+			at, _ := time.Parse(models.TimeLayout, test.Time)
+			if version := VersionAt(revisions, at); version != test.Version {
+				t.Errorf("Incorrect version, expected %d, got %d", test.Version, version)
+			}
+		})
+	}
+}
+
+func TestVersionAtExistingContent(t *testing.T) {
+	var userID int64 = 1
+	revisions := []*models.Revision{
+		{Version: 1, CreatedAt: "2020-01-01 10:00:00"},
+		{Version: 2, UserID: &userID, CreatedAt: "2020-01-01 11:00:00"},
+	}
+
+	tests := []struct {
+		Name    string
+		Time    string
+		Version int64
+	}{
+		{Name: "Before first revision", Time: "2020-01-01 09:00:00", Version: 1},
+		{Name: "At first revision", Time: "2020-01-01 10:00:00", Version: 1},
+		{Name: "After last revision", Time: "2020-01-02 00:00:00", Version: 2},
+	}
+
+	for _, test := range tests {
+		t.Run(test.Name, func(t *testing.T) {
+			at, _ := time.Parse(models.TimeLayout, test.Time)
+			if version := VersionAt(revisions, at); version != test.Version {
+				t.Errorf("Incorrect version, expected %d, got %d", test.Version, version)
+			}
+		})
+	}
+}
+
+func TestUnifiedDiff(t *testing.T) {
+	tests := []struct {
+		Name string
+		From string
+		To   string
+		Diff string
+	}{
+		{
+			Name: "No changes",
+			From: "a\nb\n",
+			To:   "a\nb\n",
+			Diff: "",
+		},
+		{
+			Name: "Changed line",
+			From: "a\nb\nc\n",
+			To:   "a\nB\nc\n",
+			Diff: "--- version 1\n+++ version 2\n@@ -1,3 +1,3 @@\n a\n-b\n+B\n c\n",
+		},
+		{
+			Name: "Separate hunks",
+			From: "1\n2\n3\n4\n5\n6\n7\n8\n9\n10\n",
+			To:   "one\n2\n3\n4\n5\n6\n7\n8\n9\nten\n",
+			Diff: "--- version 1\n+++ version 2\n" +
+				"@@ -1,4 +1,4 @@\n-1\n+one\n 2\n 3\n 4\n" +
+				"@@ -7,4 +7,4 @@\n 7\n 8\n 9\n-10\n+ten\n",
+		},
+		{
+			Name: "From empty content",
+			From: "",
+			To:   "a",
+			Diff: "--- version 1\n+++ version 2\n@@ -0,0 +1,1 @@\n+a\n\\ No newline at end of file\n",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.Name, func(t *testing.T) {
+			diff := UnifiedDiff("version 1", test.From, "version 2", test.To)
+			if diff != test.Diff {
+				t.Errorf("Incorrect diff, expected %q, got %q", test.Diff, diff)
+			}
+		})
+	}
+}
+
+func TestContentFromSnapshot(t *testing.T) {
+	var userID int64 = 1
+	revisions := []*models.Revision{
+		{Version: 1, UserID: &userID, Patch: makePatch("", "hello")},
+		// The snapshot replaces the content up to version 2, so this patch is
+		// never applied
+		{Version: 2, UserID: &userID, Patch: "not a patch"},
+		{Version: 3, UserID: &userID, Patch: makePatch("hello world", "hello there world")},
+		{Version: 4, Patch: makePatch("", "goodbye"), Reset: true},
+		{Version: 5, UserID: &userID, Patch: makePatch("goodbye", "goodbye world")},
+	}
+	snapshot := &models.Snapshot{ConversationID: 1, Version: 2, Content: "hello world"}
+
+	tests := []struct {
+		Name     string
+		Snapshot *models.Snapshot
+		Version  int64
+		Content  string
+	}{
+		{Name: "Before snapshot", Snapshot: snapshot, Version: 1, Content: "hello"},
+		{Name: "At snapshot", Snapshot: snapshot, Version: 2, Content: "hello world"},
+		{Name: "After snapshot", Snapshot: snapshot, Version: 3, Content: "hello there world"},
+		{Name: "At reset", Snapshot: snapshot, Version: 4, Content: "goodbye"},
+		{Name: "After reset", Version: 5, Content: "goodbye world"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.Name, func(t *testing.T) {
+			content, err := Content(test.Snapshot, revisions, test.Version)
+			if err != nil {
+				t.Fatalf("Failed to replay version %d: %v", test.Version, err)
+			}
+			if content != test.Content {
+				t.Errorf("Incorrect content, expected %q, got %q", test.Content, content)
+			}
+		})
+	}
+}
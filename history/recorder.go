@@ -0,0 +1,157 @@
+package history
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"ether/logging"
+	"ether/models"
+	"fmt"
+
+	"github.com/sergi/go-diff/diffmatchpatch"
+)
+
+var dmp *diffmatchpatch.DiffMatchPatch = diffmatchpatch.New()
+
+const (
+	// snapshotInterval is how many versions apart the snapshots of a
+	// conversation's content are.
+	snapshotInterval = 100
+)
+
+// Recorder keeps the revision history of conversation content.
+type Recorder struct {
+	db     models.Datastore
+	logger *logging.Logger
+}
+
+// NewRecorder initializes a new Recorder.
+func NewRecorder(db models.Datastore, logger *logging.Logger) *Recorder {
+	return &Recorder{db: db, logger: logger}
+}
+
+// Record adds a revision by a user, which may be nil, that changed a
+// conversation's content from before to after. The revision's patch is made
+// from the content itself rather than taken from the update, so that replaying
+// the history gives exactly the content that was written.
+//
+// If the history does not end with before, because the content was written
+// before revisions were kept or because recording an earlier change failed,
+// before is first recorded as a revision without a user that replaces the
+// content, so that replaying the history always gives the content that was
+// written. Every snapshotInterval versions the content is also kept as a
+// snapshot, so that rebuilding it does not replay the whole history.
+func (rec *Recorder) Record(ctx context.Context, conversationID int64, userID *int64, before, after string) error {
+	if before == after {
+		return nil
+	}
+
+	latest, err := rec.db.GetLatestRevision(ctx, conversationID)
+	if err != nil {
+		return err
+	}
+	matches := latest == nil && before == ""
+	if latest != nil {
+		matches, err = rec.endsWith(ctx, latest, before)
+		if err != nil {
+			return err
+		}
+	}
+	if !matches {
+		base := &models.Revision{
+			ConversationID: conversationID,
+			Patch:          makePatch("", before),
+			Hash:           hashContent(before),
+			Reset:          latest != nil,
+		}
+		if err := rec.db.CreateRevision(ctx, base); err != nil {
+			return err
+		}
+		rec.logger.WithContext(ctx).Infof(
+			"Recorded existing content of conversation %d as revision %d",
+			conversationID,
+			base.Version,
+		)
+	}
+
+	revision := &models.Revision{
+		ConversationID: conversationID,
+		UserID:         userID,
+		Patch:          makePatch(before, after),
+		Hash:           hashContent(after),
+	}
+	if err := rec.db.CreateRevision(ctx, revision); err != nil {
+		return err
+	}
+
+	if revision.Version%snapshotInterval == 0 {
+		snapshot := &models.Snapshot{
+			ConversationID: conversationID,
+			Version:        revision.Version,
+			Content:        after,
+		}
+		// The revision is recorded either way, and a missing snapshot only
+		// means that more revisions are replayed
+		if err := rec.db.CreateSnapshot(ctx, snapshot); err != nil {
+			rec.logger.WithContext(ctx).Warnf(
+				"Failed to snapshot version %d of conversation %d: %v",
+				revision.Version,
+				conversationID,
+				err,
+			)
+		}
+	}
+	return nil
+}
+
+// endsWith returns whether the content at the latest revision of a
+// conversation is content. Revisions recorded before hashes were kept are
+// replayed instead.
+func (rec *Recorder) endsWith(ctx context.Context, latest *models.Revision, content string) (bool, error) {
+	if latest.Hash != "" {
+		return latest.Hash == hashContent(content), nil
+	}
+
+	revisions, err := rec.db.GetRevisions(ctx, latest.ConversationID)
+	if err != nil {
+		return false, err
+	}
+	replayed, err := Content(nil, revisions, latest.Version)
+	if err != nil {
+		rec.logger.WithContext(ctx).Warnf(
+			"Failed to replay history of conversation %d: %v",
+			latest.ConversationID,
+			err,
+		)
+		return false, nil
+	}
+	return replayed == content, nil
+}
+
+// hashContent returns the SHA-256 hash of content in hex.
+func hashContent(content string) string {
+	sum := sha256.Sum256([]byte(content))
+	return hex.EncodeToString(sum[:])
+}
+
+// makePatch returns the text of a patch that changes before to after.
+func makePatch(before, after string) string {
+	return dmp.PatchToText(dmp.PatchMake(before, after))
+}
+
+// applyPatch applies the text of a patch made by makePatch to content. It
+// fails if any hunk of the patch does not apply, rather than returning content
+// that differs from what was recorded.
+func applyPatch(content, patch string) (string, error) {
+	patches, err := dmp.PatchFromText(patch)
+	if err != nil {
+		return "", err
+	}
+	newContent, applied := dmp.PatchApply(patches, content)
+	for i, ok := range applied {
+		if !ok {
+			return "", fmt.Errorf("hunk %d of %d did not apply", i+1, len(applied))
+		}
+	}
+	return newContent, nil
+}
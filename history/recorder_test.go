@@ -0,0 +1,134 @@
+package history
+
+import (
+	"context"
+	"ether/models"
+	"testing"
+)
+
+func TestRecordRebaselines(t *testing.T) {
+	var userID int64 = 1
+
+	tests := []struct {
+		Name      string
+		Revisions []*models.Revision
+		Before    string
+		Reset     bool
+	}{
+		{
+			Name:   "No history",
+			Before: "hello",
+		},
+		{
+			Name: "Hash mismatch",
+			Revisions: []*models.Revision{
+				{Version: 1, UserID: &userID, Patch: makePatch("", "hello"), Hash: hashContent("hello")},
+			},
+			Before: "hello world",
+			Reset:  true,
+		},
+		{
+			Name: "Replay mismatch without hashes",
+			Revisions: []*models.Revision{
+				{Version: 1, UserID: &userID, Patch: makePatch("", "hello")},
+			},
+			Before: "hello world",
+			Reset:  true,
+		},
+		{
+			Name: "Patch that does not apply",
+			Revisions: []*models.Revision{
+				{Version: 1, UserID: &userID, Patch: makePatch("", "hello")},
+				{Version: 2, UserID: &userID, Patch: makePatch("a completely different text", "hello")},
+			},
+			Before: "hello",
+			Reset:  true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.Name, func(t *testing.T) {
+			mDB := models.NewMockDB(nil, nil, nil)
+			for _, revision := range test.Revisions {
+				revision.ConversationID = 1
+			}
+			mDB.Revisions[1] = test.Revisions
+			rec := NewRecorder(mDB, nil)
+
+			if err := rec.Record(context.Background(), 1, &userID, test.Before, "goodbye"); err != nil {
+				t.Fatalf("Failed to record revision: %v", err)
+			}
+
+			revisions, _ := mDB.GetRevisions(context.Background(), 1)
+			if len(revisions) != len(test.Revisions)+2 {
+				t.Fatalf("Expected %d revisions, got %d", len(test.Revisions)+2, len(revisions))
+			}
+			base := revisions[len(revisions)-2]
+			if base.UserID != nil || base.Reset != test.Reset || base.Hash != hashContent(test.Before) {
+				t.Errorf("Incorrect base revision %+v", base)
+			}
+			for version, expected := range map[int64]string{base.Version: test.Before, base.Version + 1: "goodbye"} {
+				content, err := Content(nil, revisions, version)
+				if err != nil {
+					t.Fatalf("Failed to replay version %d: %v", version, err)
+				}
+				if content != expected {
+					t.Errorf("Incorrect content at version %d, expected %q, got %q", version, expected, content)
+				}
+			}
+		})
+	}
+}
+
+func TestRecordMatchingHistory(t *testing.T) {
+	var userID int64 = 1
+	mDB := models.NewMockDB(nil, nil, nil)
+	rec := NewRecorder(mDB, nil)
+	_ = rec.Record(context.Background(), 1, &userID, "", "hello")
+	// A revision recorded before hashes were kept is replayed instead
+	mDB.Revisions[1][0].Hash = ""
+	_ = rec.Record(context.Background(), 1, &userID, "hello", "hello world")
+	_ = rec.Record(context.Background(), 1, &userID, "hello world", "goodbye")
+
+	revisions, _ := mDB.GetRevisions(context.Background(), 1)
+	if len(revisions) != 3 {
+		t.Fatalf("Expected 3 revisions, got %d", len(revisions))
+	}
+	for _, revision := range revisions {
+		if revision.UserID == nil || revision.Reset {
+			t.Errorf("Unexpected base revision %+v", revision)
+		}
+	}
+}
+
+func TestRecordSnapshots(t *testing.T) {
+	var userID int64 = 1
+	mDB := models.NewMockDB(nil, nil, nil)
+	rec := NewRecorder(mDB, nil)
+	before := ""
+	for i := 1; i <= snapshotInterval+1; i++ {
+		after := before + "a"
+		if err := rec.Record(context.Background(), 1, &userID, before, after); err != nil {
+			t.Fatalf("Failed to record revision: %v", err)
+		}
+		before = after
+	}
+
+	snapshots := mDB.Snapshots[1]
+	if len(snapshots) != 1 {
+		t.Fatalf("Expected 1 snapshot, got %d", len(snapshots))
+	}
+	if snapshots[0].Version != snapshotInterval || len(snapshots[0].Content) != snapshotInterval {
+		t.Errorf("Incorrect snapshot of version %d with %d characters", snapshots[0].Version, len(snapshots[0].Content))
+	}
+}
+
+func TestApplyPatch(t *testing.T) {
+	patch := makePatch("hello world", "hello there world")
+	if content, err := applyPatch("hello world", patch); err != nil || content != "hello there world" {
+		t.Errorf("Incorrect result of applying patch, got %q, %v", content, err)
+	}
+	if content, err := applyPatch("a completely different text", patch); err == nil {
+		t.Errorf("Expected patch not to apply, got %q", content)
+	}
+}
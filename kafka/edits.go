@@ -0,0 +1,112 @@
+package kafka
+
+import (
+	"context"
+	"ether/logging"
+	"ether/models"
+	"time"
+)
+
+const (
+	// maxEditBatchAge is how long a user can edit a conversation without
+	// pausing before their edits so far are recorded as one event.
+	maxEditBatchAge = time.Hour
+)
+
+// EditBatcher collects content edits and records each user's consecutive edits
+// to a conversation as a single content_edited event, so that the activity log
+// is not flooded with an event per keystroke. Batches are kept in the
+// database, so that they are not lost when Ether stops and are recorded once
+// even if several instances are running.
+type EditBatcher struct {
+	db     models.Datastore
+	logger *logging.Logger
+	window time.Duration
+
+	// Notifier, if not nil, is told about the events once they are recorded.
+	Notifier models.EventNotifier
+}
+
+// NewEditBatcher initializes a new EditBatcher. A batch is recorded once its
+// user has not edited the conversation for window.
+func NewEditBatcher(db models.Datastore, logger *logging.Logger, window time.Duration) *EditBatcher {
+	return &EditBatcher{
+		db:     db,
+		logger: logger,
+		window: window,
+	}
+}
+
+// Add adds an edit by a user, which may be nil, to a conversation's batch.
+func (eb *EditBatcher) Add(ctx context.Context, conversationID int64, userID *int64) error {
+	return eb.db.AddEdit(ctx, conversationID, userID, time.Now())
+}
+
+// Run records batches as they become due until ctx is cancelled. Batches that
+// are not due yet when it returns are recorded by the next Run.
+func (eb *EditBatcher) Run(ctx context.Context) {
+	interval := eb.window / 2
+	if interval > time.Minute {
+		interval = time.Minute
+	}
+	if interval <= 0 {
+		interval = time.Second
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case now := <-ticker.C:
+			eb.flush(context.Background(), now)
+		case <-ctx.Done():
+			eb.flush(context.Background(), time.Now())
+			return
+		}
+	}
+}
+
+// flush records the batches that have been idle for the window or open for
+// maxEditBatchAge. Each batch is recorded and removed in a single
+// transaction, so that it is recorded exactly once.
+func (eb *EditBatcher) flush(ctx context.Context, now time.Time) {
+	var events []*models.Event
+	err := eb.db.Transaction(ctx, func(tx models.Datastore) error {
+		batches, err := tx.GetDueEditBatches(ctx, now.Add(-eb.window), now.Add(-maxEditBatchAge))
+		if err != nil {
+			return err
+		}
+
+		events = make([]*models.Event, 0, len(batches))
+		for _, batch := range batches {
+			event := &models.Event{
+				ConversationID: batch.ConversationID,
+				ActorID:        batch.UserID,
+				Action:         models.EventContentEdited,
+				After: models.EventValue(&models.ContentEditValues{
+					Edits:       batch.Edits,
+					FirstEditAt: batch.FirstEditAt.Format(time.RFC3339),
+					LastEditAt:  batch.LastEditAt.Format(time.RFC3339),
+				}),
+			}
+			if err := tx.CreateEvent(ctx, event); err != nil {
+				return err
+			}
+			if err := tx.DeleteEditBatch(ctx, batch.ConversationID, batch.UserID); err != nil {
+				return err
+			}
+			events = append(events, event)
+		}
+		return nil
+	})
+	if err != nil {
+		eb.logger.Errorf("Failed to record content edits: %v", err)
+		return
+	}
+
+	if eb.Notifier != nil {
+		for _, event := range events {
+			eb.Notifier.Notify(event)
+		}
+	}
+}
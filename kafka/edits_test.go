@@ -0,0 +1,93 @@
+package kafka
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"ether/logging"
+	"ether/models"
+	"io/ioutil"
+	"testing"
+	"time"
+)
+
+func TestEditBatcherFlush(t *testing.T) {
+	var userID int64 = 1
+	start := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	window := 5 * time.Minute
+
+	tests := []struct {
+		Name    string
+		Edits   []time.Duration
+		FlushAt time.Duration
+		Events  int
+	}{
+		{
+			Name:    "Batch is recorded once idle for the window",
+			Edits:   []time.Duration{0, time.Minute, 2 * time.Minute},
+			FlushAt: 7 * time.Minute,
+			Events:  1,
+		},
+		{
+			Name:    "Batch is kept while still being edited",
+			Edits:   []time.Duration{0, time.Minute, 2 * time.Minute},
+			FlushAt: 6 * time.Minute,
+			Events:  0,
+		},
+		{
+			Name:    "Batch is recorded once too old",
+			Edits:   []time.Duration{0, 30 * time.Minute, 59 * time.Minute},
+			FlushAt: time.Hour,
+			Events:  1,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.Name, func(t *testing.T) {
+			mDB := models.NewMockDB(nil, nil, nil)
+			eb := NewEditBatcher(mDB, nil, window)
+			for _, offset := range test.Edits {
+				_ = mDB.AddEdit(context.Background(), 1, &userID, start.Add(offset))
+			}
+			eb.flush(context.Background(), start.Add(test.FlushAt))
+
+			if len(mDB.Events) != test.Events {
+				t.Fatalf("Incorrect number of events, expected %d, got %d", test.Events, len(mDB.Events))
+			}
+			if batches := len(mDB.EditBatches); batches != 1-test.Events {
+				t.Errorf("Incorrect number of pending batches, expected %d, got %d", 1-test.Events, batches)
+			}
+			if test.Events == 0 {
+				return
+			}
+
+			event := mDB.Events[0]
+			if event.Action != models.EventContentEdited || event.ActorID == nil || *event.ActorID != userID {
+				t.Errorf("Incorrect event, got %+v", event)
+			}
+			values := models.ContentEditValues{}
+			_ = json.Unmarshal(event.After, &values)
+			if values.Edits != len(test.Edits) {
+				t.Errorf("Incorrect number of edits, expected %d, got %d", len(test.Edits), values.Edits)
+			}
+		})
+	}
+}
+
+func TestEditBatcherFlushFailure(t *testing.T) {
+	var userID int64 = 1
+	start := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	// Recording the event fails, so the batch is kept to be recorded later
+	mDB := models.NewMockDB(nil, nil, []error{nil, nil, errors.New("test error")})
+	eb := NewEditBatcher(mDB, logging.New(ioutil.Discard, logging.LevelError), time.Minute)
+	_ = mDB.AddEdit(context.Background(), 1, &userID, start)
+	eb.flush(context.Background(), start.Add(time.Hour))
+
+	if len(mDB.Events) != 0 {
+		t.Errorf("Incorrect number of events, expected %d, got %d", 0, len(mDB.Events))
+	}
+	if len(mDB.EditBatches) != 1 {
+		t.Errorf("Incorrect number of pending batches, expected %d, got %d", 1, len(mDB.EditBatches))
+	}
+}
@@ -1,51 +1,216 @@
 package kafka
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"ether/filesystem"
+	"ether/logging"
+	"ether/metrics"
 	"ether/models"
+	"ether/tracing"
+	"fmt"
+	"os"
 	"strconv"
 
 	segkafka "github.com/segmentio/kafka-go"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 )
 
 // Env represents all application-level items that are needed by Kafka handlers.
 type Env struct {
 	DB           models.Datastore
 	CachedWriter *filesystem.CachedWriter
+	Edits        *EditBatcher
+	Logger       *logging.Logger
 }
 
-// processUpdate processes an Update type Kafka message for a given
-// conversation.
-func (env *Env) processUpdate(conversationID int64, msg Message) error {
+// processCursor processes a cursor Update type Kafka message for a given
+// conversation by storing the author's caret. Cursor updates do not change the
+// content, so they are not sent to the CachedWriter.
+func (env *Env) processCursor(ctx context.Context, conversationID int64, msg Message) error {
+	if err := env.storeActiveUsers(ctx, conversationID, msg); err != nil {
+		return err
+	}
+	return env.DB.SetCaret(ctx, *msg.Data.UserID, conversationID, models.Caret{
+		Start: *msg.Data.Delta.CaretStart,
+		End:   *msg.Data.Delta.CaretEnd,
+	})
+}
+
+// storeActiveUsers stores the caret of every user in a message's ActiveUsers,
+// if it has any.
+func (env *Env) storeActiveUsers(ctx context.Context, conversationID int64, msg Message) error {
+	if msg.Data.ActiveUsers == nil {
+		return nil
+	}
+	for userID, caret := range *msg.Data.ActiveUsers {
+		err := env.DB.SetCaret(ctx, userID, conversationID, models.Caret{
+			Start: caret.Start,
+			End:   caret.End,
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// permanentError wraps an error that processing the same message again would
+// not fix.
+type permanentError struct {
+	err error
+}
+
+func (e *permanentError) Error() string {
+	return e.err.Error()
+}
+
+func (e *permanentError) Unwrap() error {
+	return e.err
+}
+
+// isPermanent reports whether processing a message failed in a way that
+// retrying cannot fix: the message is invalid or has a schema version that is
+// not supported, its patch cannot be applied to the content, or the patch was
+// applied but a later step failed, in which case retrying would apply it
+// twice.
+func isPermanent(err error) bool {
+	var validationErr *ValidationError
+	var versionErr *UnsupportedVersionError
+	var permanentErr *permanentError
+	return errors.As(err, &validationErr) ||
+		errors.As(err, &versionErr) ||
+		errors.As(err, &permanentErr) ||
+		errors.Is(err, filesystem.ErrInvalidPatch) ||
+		errors.Is(err, filesystem.ErrPatchNotApplied) ||
+		errors.Is(err, filesystem.ErrContentTooLarge) ||
+		errors.Is(err, os.ErrNotExist)
+}
+
+// processUpdate processes an edit Update type Kafka message for a given
+// conversation. It waits for the writer to apply the patch, so that the
+// message is only committed once it has been.
+func (env *Env) processUpdate(ctx context.Context, conversationID int64, msg Message) error {
 	// Tell writer goroutine to update this conversation's content file with
-	// this patch
+	// this patch, and update the database once it has been applied, so that
+	// nothing is done for a patch that does not apply and carets are shifted
+	// in the same order as the edits are applied
+	result := make(chan filesystem.UpdateResult, 1)
 	update := &filesystem.Update{
+		Context:        ctx,
 		ConversationID: conversationID,
+		UserID:         msg.Data.UserID,
 		Patch:          *msg.Data.Patch,
+		Result:         result,
+		OnApplied: func(ctx context.Context, res filesystem.UpdateResult) error {
+			// Only edits that changed the content are recorded
+			if env.Edits != nil && res.Patch != "" {
+				if err := env.Edits.Add(ctx, conversationID, msg.Data.UserID); err != nil {
+					return err
+				}
+			}
+			return env.afterUpdate(ctx, conversationID, msg)
+		},
 	}
 	env.CachedWriter.Write <- update
 
+	res := <-result
+	if errors.Is(res.Err, filesystem.ErrContentTooLarge) {
+		// Nothing was done for the edit, and retrying it cannot succeed, so it
+		// is dropped, but the author and conversation are recorded
+		author := "an unknown user"
+		if msg.Data.UserID != nil {
+			author = fmt.Sprintf("user %d", *msg.Data.UserID)
+		}
+		env.Logger.WithContext(ctx).Warnf("Rejected edit by %s to conversation %d: %v", author, conversationID, res.Err)
+		metrics.KafkaEditsRejected.WithLabelValues("content_too_large").Inc()
+		return res.Err
+	} else if res.Err != nil {
+		return res.Err
+	}
+	if res.AppliedErr != nil {
+		return &permanentError{err: res.AppliedErr}
+	}
+	return nil
+}
+
+// afterUpdate updates the database for an edit that has been applied.
+func (env *Env) afterUpdate(ctx context.Context, conversationID int64, msg Message) error {
 	// Set conversation LastModified time to now
-	return env.DB.TouchConversation(conversationID)
+	if err := env.DB.TouchConversation(ctx, conversationID); err != nil {
+		return err
+	}
+
+	// Move the stored carets that the edit shifted, then store any carets
+	// that came with it, which already account for it
+	if delta := msg.Data.Delta; delta != nil && delta.Doc != nil && *delta.Doc != 0 {
+		if err := env.DB.ShiftCarets(ctx, conversationID, *delta.CaretStart, *delta.Doc); err != nil {
+			return err
+		}
+	}
+	if err := env.storeActiveUsers(ctx, conversationID, msg); err != nil {
+		return err
+	}
+
+	// The author has seen their own edit, so it should not be unread for them
+	if msg.Data.UserID != nil {
+		return env.DB.TouchUserConversationMapping(ctx, *msg.Data.UserID, conversationID)
+	}
+	return nil
 }
 
 // ProcessWSMessage processes a Kafka message that corresponds to a WebSocket
 // message being handled by the "patches" service.
-func (env *Env) ProcessWSMessage(kafkaMsg segkafka.Message) error {
+func (env *Env) ProcessWSMessage(ctx context.Context, kafkaMsg segkafka.Message) (err error) {
+	ctx = otel.GetTextMapPropagator().Extract(ctx, headerCarrier(kafkaMsg.Headers))
+	ctx, span := tracing.Tracer().Start(
+		ctx,
+		kafkaMsg.Topic+" process",
+		trace.WithSpanKind(trace.SpanKindConsumer),
+		trace.WithAttributes(
+			attribute.String("messaging.system", "kafka"),
+			attribute.String("messaging.destination.name", kafkaMsg.Topic),
+			attribute.Int("messaging.kafka.destination.partition", kafkaMsg.Partition),
+			attribute.Int64("messaging.kafka.message.offset", kafkaMsg.Offset),
+		),
+	)
+	defer span.End()
+
+	msgType := "unknown"
+	defer func() {
+		span.SetAttributes(attribute.String("ether.message_type", msgType))
+		if err != nil {
+			tracing.Fail(span, err)
+			metrics.KafkaMessagesFailed.WithLabelValues(msgType).Inc()
+		} else {
+			metrics.KafkaMessagesProcessed.WithLabelValues(msgType).Inc()
+		}
+	}()
+
 	conversationID, err := strconv.ParseInt(string(kafkaMsg.Key), 10, 64)
 	if err != nil {
-		return err
+		return &permanentError{err: fmt.Errorf("Invalid message key %q: %v", kafkaMsg.Key, err)}
 	}
 
 	msg := Message{}
 	if err := json.Unmarshal(kafkaMsg.Value, &msg); err != nil {
+		return &permanentError{err: fmt.Errorf("Invalid message body: %v", err)}
+	}
+	msgType = msg.Type.String()
+
+	if err := msg.Validate(); err != nil {
 		return err
 	}
 
 	switch msg.Type {
 	case TypeUpdate:
-		if err := env.processUpdate(conversationID, msg); err != nil {
+		if msg.Subtype() == UpdateTypeCursor {
+			return env.processCursor(ctx, conversationID, msg)
+		}
+		if err := env.processUpdate(ctx, conversationID, msg); err != nil {
 			return err
 		}
 
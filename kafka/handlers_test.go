@@ -0,0 +1,217 @@
+package kafka
+
+import (
+	"context"
+	"errors"
+	"ether/filesystem"
+	"ether/logging"
+	"ether/models"
+	"io/ioutil"
+	"testing"
+	"time"
+
+	segkafka "github.com/segmentio/kafka-go"
+)
+
+// runWriter starts a CachedWriter on a temporary directory that has a content
+// file for conversation 1, and stops it when the test ends.
+func runWriter(t *testing.T, content string) (*filesystem.CachedWriter, *filesystem.Directory) {
+	directory := filesystem.NewDirectory(t.TempDir())
+	if err := directory.Create(1); err != nil {
+		t.Fatalf("Failed to create content file: %v", err)
+	}
+	if err := directory.WriteFile(1, []byte(content)); err != nil {
+		t.Fatalf("Failed to write content file: %v", err)
+	}
+
+	writer := filesystem.NewCachedWriter(directory, nil, nil, logging.New(ioutil.Discard, logging.LevelError))
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		writer.Run(ctx)
+		close(done)
+	}()
+	t.Cleanup(func() {
+		cancel()
+		<-done
+	})
+	return writer, directory
+}
+
+func TestProcessWSMessage(t *testing.T) {
+	tests := []struct {
+		Name      string
+		Key       string
+		Value     string
+		Initial   string
+		Content   string
+		Batches   int
+		Fails     bool
+		Invalid   bool
+		Permanent bool
+	}{
+		{
+			Name:    "Edit is applied by the writer",
+			Key:     "1",
+			Value:   `{"type":1,"data":{"type":0,"patch":"@@ -0,0 +1,5 @@\n+hello\n","user_id":1}}`,
+			Content: "hello",
+			Batches: 1,
+		},
+		{
+			Name:  "Cursor is not sent to the writer",
+			Key:   "1",
+			Value: `{"type":1,"data":{"type":1,"user_id":1,"delta":{"caret_start":2,"caret_end":2}}}`,
+		},
+		{
+			Name:      "Edit that does not apply",
+			Key:       "1",
+			Value:     `{"type":1,"data":{"type":0,"patch":"@@ -1,24 +1,5 @@\n-a completely different text\n+hello\n","user_id":1}}`,
+			Initial:   "hello world",
+			Content:   "hello world",
+			Fails:     true,
+			Permanent: true,
+		},
+		{
+			Name:      "Edit to a conversation without content",
+			Key:       "2",
+			Value:     `{"type":1,"data":{"type":0,"patch":"@@ -0,0 +1,5 @@\n+hello\n","user_id":1}}`,
+			Fails:     true,
+			Permanent: true,
+		},
+		{
+			Name:      "Edit without patch is rejected",
+			Key:       "1",
+			Value:     `{"type":1,"data":{"type":0}}`,
+			Fails:     true,
+			Invalid:   true,
+			Permanent: true,
+		},
+		{
+			Name:      "Invalid key",
+			Key:       "abc",
+			Value:     `{"type":1,"data":{"type":0,"patch":""}}`,
+			Fails:     true,
+			Permanent: true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.Name, func(t *testing.T) {
+			mDB := models.NewMockDB(
+				[]*models.Conversation{&models.Conversation{ID: 1}},
+				nil,
+				nil,
+			)
+			writer, directory := runWriter(t, test.Initial)
+			env := &Env{
+				DB:           mDB,
+				CachedWriter: writer,
+				Edits:        NewEditBatcher(mDB, nil, time.Minute),
+			}
+
+			err := env.ProcessWSMessage(context.Background(), segkafka.Message{
+				Key:   []byte(test.Key),
+				Value: []byte(test.Value),
+			})
+
+			var validationErr *ValidationError
+			if test.Fails && err == nil {
+				t.Error("Expected an error, got nil")
+			} else if !test.Fails && err != nil {
+				t.Errorf("Unexpected error: %v", err)
+			}
+			if test.Invalid && !errors.As(err, &validationErr) {
+				t.Errorf("Expected a ValidationError, got %v", err)
+			}
+			if err != nil && isPermanent(err) != test.Permanent {
+				t.Errorf("Expected permanent %t, got %t", test.Permanent, isPermanent(err))
+			}
+			if content, _ := directory.ReadFile(1); string(content) != test.Content {
+				t.Errorf("Content is incorrect, expected %q, got %q", test.Content, content)
+			}
+			if batches := len(mDB.EditBatches); batches != test.Batches {
+				t.Errorf("Expected %d pending edit batches, got %d", test.Batches, batches)
+			}
+		})
+	}
+}
+
+func TestProcessWSMessageTransientError(t *testing.T) {
+	mDB := models.NewMockDB(
+		[]*models.Conversation{&models.Conversation{ID: 1}},
+		nil,
+		[]error{errors.New("connection refused")},
+	)
+	writer, _ := runWriter(t, "")
+	env := &Env{
+		DB:           mDB,
+		CachedWriter: writer,
+	}
+
+	err := env.ProcessWSMessage(context.Background(), segkafka.Message{
+		Key:   []byte("1"),
+		Value: []byte(`{"type":1,"data":{"type":1,"user_id":1,"delta":{"caret_start":2,"caret_end":2}}}`),
+	})
+	if err == nil {
+		t.Fatal("Expected an error, got nil")
+	}
+	if isPermanent(err) {
+		t.Errorf("Expected a transient error, got permanent error %v", err)
+	}
+}
+
+func TestProcessWSMessageCarets(t *testing.T) {
+	mDB := models.NewMockDB(
+		[]*models.Conversation{&models.Conversation{ID: 1}},
+		[]*models.UserConversationMapping{
+			&models.UserConversationMapping{UserID: 1, ConversationID: 1},
+			&models.UserConversationMapping{UserID: 2, ConversationID: 1},
+		},
+		nil,
+	)
+	writer, _ := runWriter(t, "hello world")
+	env := &Env{
+		DB:           mDB,
+		CachedWriter: writer,
+	}
+
+	messages := []string{
+		// User 1 selects 10 to 12 and user 2 moves to 3
+		`{"type":1,"data":{"type":1,"user_id":1,"delta":{"caret_start":10,"caret_end":12}}}`,
+		`{"type":1,"data":{"type":1,"user_id":2,"delta":{"caret_start":3,"caret_end":3}}}`,
+		// User 2 types 4 characters at 3, shifting user 1
+		`{"type":1,"data":{"type":0,"patch":"","user_id":2,"delta":{"caret_start":3,"caret_end":3,"doc":4}}}`,
+		// User 2 deletes the first 10 characters
+		`{"type":1,"data":{"type":0,"patch":"","user_id":2,"delta":{"caret_start":0,"caret_end":0,"doc":-10}}}`,
+	}
+	for _, value := range messages {
+		err := env.ProcessWSMessage(context.Background(), segkafka.Message{
+			Key:   []byte("1"),
+			Value: []byte(value),
+		})
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+	}
+
+	// User 1 types 100 characters at 0, but the patch does not apply, so no
+	// caret is shifted
+	err := env.ProcessWSMessage(context.Background(), segkafka.Message{
+		Key:   []byte("1"),
+		Value: []byte(`{"type":1,"data":{"type":0,"patch":"@@ -1,24 +1,5 @@\n-a completely different text\n+hello\n","user_id":1,"delta":{"caret_start":0,"caret_end":0,"doc":100}}}`),
+	})
+	if err == nil {
+		t.Error("Expected an error, got nil")
+	}
+
+	expected := map[int64]models.Caret{
+		1: models.Caret{Start: 4, End: 6},
+		2: models.Caret{Start: 0, End: 0},
+	}
+	for userID, caret := range expected {
+		stored, _ := mDB.GetCaret(context.Background(), userID, 1)
+		if stored == nil || *stored != caret {
+			t.Errorf("User %d has incorrect caret, expected %+v, got %+v", userID, caret, stored)
+		}
+	}
+}
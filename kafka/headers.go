@@ -0,0 +1,50 @@
+package kafka
+
+import (
+	segkafka "github.com/segmentio/kafka-go"
+)
+
+// headerCarrier adapts Kafka message headers to a propagation.TextMapCarrier
+// so that trace context can be extracted from them.
+type headerCarrier []segkafka.Header
+
+// Get returns the value of the first header with the given key.
+func (c headerCarrier) Get(key string) string {
+	for _, header := range c {
+		if header.Key == key {
+			return string(header.Value)
+		}
+	}
+	return ""
+}
+
+// Set is a no-op since consumed message headers are read-only.
+func (c headerCarrier) Set(key, value string) {}
+
+// Keys returns the keys of all headers.
+func (c headerCarrier) Keys() []string {
+	keys := make([]string, 0, len(c))
+	for _, header := range c {
+		keys = append(keys, header.Key)
+	}
+	return keys
+}
+
+// headerWriter adapts the headers of a Kafka message being produced to a
+// propagation.TextMapCarrier so that trace context can be injected into them.
+type headerWriter []segkafka.Header
+
+// Get returns the value of the first header with the given key.
+func (c *headerWriter) Get(key string) string {
+	return headerCarrier(*c).Get(key)
+}
+
+// Set adds a header.
+func (c *headerWriter) Set(key, value string) {
+	*c = append(*c, segkafka.Header{Key: key, Value: []byte(value)})
+}
+
+// Keys returns the keys of all headers.
+func (c *headerWriter) Keys() []string {
+	return headerCarrier(*c).Keys()
+}
@@ -0,0 +1,40 @@
+package kafka
+
+import (
+	"context"
+	"testing"
+
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+)
+
+func TestHeaderCarrierRoundTrip(t *testing.T) {
+	propagator := propagation.TraceContext{}
+	sent := trace.NewSpanContext(trace.SpanContextConfig{
+		TraceID:    trace.TraceID{1, 2, 3},
+		SpanID:     trace.SpanID{4, 5, 6},
+		TraceFlags: trace.FlagsSampled,
+	})
+
+	var headers headerWriter
+	propagator.Inject(trace.ContextWithSpanContext(context.Background(), sent), &headers)
+	if len(headers) == 0 {
+		t.Fatal("No headers were injected")
+	}
+
+	ctx := propagator.Extract(context.Background(), headerCarrier(headers))
+	received := trace.SpanContextFromContext(ctx)
+	if received.TraceID() != sent.TraceID() || received.SpanID() != sent.SpanID() {
+		t.Errorf("Extracted span context is incorrect, expected %v, got %v", sent, received)
+	}
+	if !received.IsRemote() {
+		t.Error("Extracted span context is not remote")
+	}
+
+	if keys := headerCarrier(headers).Keys(); len(keys) != len(headers) {
+		t.Errorf("Incorrect number of keys, expected %d, got %d", len(headers), len(keys))
+	}
+	if value := headerCarrier(headers).Get("missing"); value != "" {
+		t.Errorf("Missing header has a value: %q", value)
+	}
+}
@@ -1,9 +1,46 @@
 package kafka
 
+import (
+	"fmt"
+)
+
+// Schema versions of the Message envelope that Ether understands. Messages
+// without a schema_version are treated as SchemaVersion1.
+const (
+	SchemaVersion1      = 1
+	latestSchemaVersion = SchemaVersion1
+)
+
 // Message represents a json-encoded WebSocket message using the Riht protocol.
 type Message struct {
-	Type MessageType `json:"type"`
-	Data InnerData   `json:"data"`
+	SchemaVersion int         `json:"schema_version,omitempty"`
+	Type          MessageType `json:"type"`
+	Data          InnerData   `json:"data"`
+}
+
+// ValidationError represents a Message that is malformed for its type.
+type ValidationError struct {
+	Type   MessageType
+	Field  string
+	Reason string
+}
+
+func (e *ValidationError) Error() string {
+	return fmt.Sprintf("Invalid %s message: %s %s", e.Type, e.Field, e.Reason)
+}
+
+// UnsupportedVersionError represents a Message with a schema version that is
+// newer than Ether understands.
+type UnsupportedVersionError struct {
+	Version int
+}
+
+func (e *UnsupportedVersionError) Error() string {
+	return fmt.Sprintf(
+		"Unsupported message schema version %d, latest supported is %d",
+		e.Version,
+		latestSchemaVersion,
+	)
 }
 
 // MessageType represents the possible Riht WebSocket protocol message types.
@@ -48,3 +85,103 @@ type Caret struct {
 	Start int `json:"start"`
 	End   int `json:"end"`
 }
+
+// String returns the name of a MessageType.
+func (t MessageType) String() string {
+	switch t {
+	case TypeInit:
+		return "init"
+	case TypeUpdate:
+		return "update"
+	case TypeAck:
+		return "ack"
+	case TypeSync:
+		return "sync"
+	case TypeUserJoin:
+		return "user_join"
+	case TypeUserLeave:
+		return "user_leave"
+	default:
+		return "unknown"
+	}
+}
+
+// String returns the name of an UpdateType.
+func (t UpdateType) String() string {
+	switch t {
+	case UpdateTypeEdit:
+		return "edit"
+	case UpdateTypeCursor:
+		return "cursor"
+	default:
+		return "unknown"
+	}
+}
+
+// Version returns the schema version of the Message.
+func (m *Message) Version() int {
+	if m.SchemaVersion == 0 {
+		return SchemaVersion1
+	}
+	return m.SchemaVersion
+}
+
+// Subtype returns the UpdateType of an Update Message. Updates without a type
+// predate cursor updates and are edits.
+func (m *Message) Subtype() UpdateType {
+	if m.Data.Type == nil {
+		return UpdateTypeEdit
+	}
+	return *m.Data.Type
+}
+
+// Validate checks that the Message has a supported schema version and every
+// field its type requires. It returns an *UnsupportedVersionError or a
+// *ValidationError if it does not.
+func (m *Message) Validate() error {
+	if version := m.Version(); version < SchemaVersion1 || version > latestSchemaVersion {
+		return &UnsupportedVersionError{Version: version}
+	}
+
+	invalid := func(field, reason string) error {
+		return &ValidationError{Type: m.Type, Field: field, Reason: reason}
+	}
+
+	switch m.Type {
+	case TypeInit, TypeAck, TypeSync:
+		return nil
+
+	case TypeUserJoin, TypeUserLeave:
+		if m.Data.UserID == nil {
+			return invalid("data.user_id", "is required")
+		}
+		return nil
+
+	case TypeUpdate:
+		switch m.Subtype() {
+		case UpdateTypeEdit:
+			if m.Data.Patch == nil {
+				return invalid("data.patch", "is required for edit updates")
+			}
+			if delta := m.Data.Delta; delta != nil && delta.Doc != nil && delta.CaretStart == nil {
+				return invalid("data.delta", "must have caret_start with doc for edit updates")
+			}
+		case UpdateTypeCursor:
+			if m.Data.Patch != nil {
+				return invalid("data.patch", "must not be set for cursor updates")
+			}
+			if m.Data.UserID == nil {
+				return invalid("data.user_id", "is required for cursor updates")
+			}
+			if m.Data.Delta == nil || m.Data.Delta.CaretStart == nil || m.Data.Delta.CaretEnd == nil {
+				return invalid("data.delta", "must have caret_start and caret_end for cursor updates")
+			}
+		default:
+			return invalid("data.type", fmt.Sprintf("has unknown value %d", m.Subtype()))
+		}
+		return nil
+
+	default:
+		return invalid("type", fmt.Sprintf("has unknown value %d", m.Type))
+	}
+}
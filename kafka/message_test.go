@@ -0,0 +1,106 @@
+package kafka
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestMessageValidate(t *testing.T) {
+	edit := UpdateTypeEdit
+	cursor := UpdateTypeCursor
+	unknownUpdate := UpdateType(9)
+	patch := "@@ -0,0 +1,5 @@\n+hello\n"
+	var userID int64 = 1
+	caret := 3
+
+	tests := []struct {
+		Name    string
+		Message Message
+		Field   string
+		Version bool
+	}{
+		{
+			Name:    "Valid edit",
+			Message: Message{Type: TypeUpdate, Data: InnerData{Type: &edit, Patch: &patch}},
+		},
+		{
+			Name:    "Valid edit without update type",
+			Message: Message{Type: TypeUpdate, Data: InnerData{Patch: &patch}},
+		},
+		{
+			Name: "Valid cursor",
+			Message: Message{Type: TypeUpdate, Data: InnerData{
+				Type:   &cursor,
+				UserID: &userID,
+				Delta:  &Delta{CaretStart: &caret, CaretEnd: &caret},
+			}},
+		},
+		{
+			Name:    "Valid user join",
+			Message: Message{SchemaVersion: SchemaVersion1, Type: TypeUserJoin, Data: InnerData{UserID: &userID}},
+		},
+		{
+			Name:    "Edit without patch",
+			Message: Message{Type: TypeUpdate, Data: InnerData{Type: &edit}},
+			Field:   "data.patch",
+		},
+		{
+			Name:    "Cursor with patch",
+			Message: Message{Type: TypeUpdate, Data: InnerData{Type: &cursor, Patch: &patch}},
+			Field:   "data.patch",
+		},
+		{
+			Name: "Cursor without caret",
+			Message: Message{Type: TypeUpdate, Data: InnerData{
+				Type:   &cursor,
+				UserID: &userID,
+				Delta:  &Delta{CaretStart: &caret},
+			}},
+			Field: "data.delta",
+		},
+		{
+			Name:    "Unknown update type",
+			Message: Message{Type: TypeUpdate, Data: InnerData{Type: &unknownUpdate}},
+			Field:   "data.type",
+		},
+		{
+			Name:    "Unknown message type",
+			Message: Message{Type: MessageType(9)},
+			Field:   "type",
+		},
+		{
+			Name:    "User leave without user",
+			Message: Message{Type: TypeUserLeave},
+			Field:   "data.user_id",
+		},
+		{
+			Name:    "Unsupported schema version",
+			Message: Message{SchemaVersion: latestSchemaVersion + 1, Type: TypeUpdate, Data: InnerData{Patch: &patch}},
+			Version: true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.Name, func(t *testing.T) {
+			err := test.Message.Validate()
+
+			var validationErr *ValidationError
+			var versionErr *UnsupportedVersionError
+			switch {
+			case test.Version:
+				if !errors.As(err, &versionErr) {
+					t.Errorf("Expected an UnsupportedVersionError, got %v", err)
+				}
+			case test.Field != "":
+				if !errors.As(err, &validationErr) {
+					t.Fatalf("Expected a ValidationError, got %v", err)
+				}
+				if validationErr.Field != test.Field {
+					t.Errorf("Expected invalid field %s, got %s", test.Field, validationErr.Field)
+				}
+			case err != nil:
+				t.Errorf("Unexpected error: %v", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,74 @@
+package kafka
+
+import (
+	"context"
+	"encoding/json"
+	"ether/logging"
+	"strconv"
+
+	segkafka "github.com/segmentio/kafka-go"
+	"go.opentelemetry.io/otel"
+)
+
+// Publisher represents a Kafka producer which publishes content edits that
+// were made by Ether itself rather than sent through the "patches" service, so
+// that the "patches" service can send them to connected clients.
+type Publisher struct {
+	writer *segkafka.Writer
+	logger *logging.Logger
+}
+
+// NewPublisher initializes a new Publisher which connects to the brokers with
+// the same options as a Reader with config, and publishes to topic. It must
+// not be the topic that the Reader consumes, or Ether would apply its own
+// edits twice.
+func NewPublisher(config ReaderConfig, topic string, logger *logging.Logger) (*Publisher, error) {
+	dialer, err := newDialer(config)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Publisher{
+		writer: segkafka.NewWriter(segkafka.WriterConfig{
+			Brokers:  config.Brokers,
+			Topic:    topic,
+			Dialer:   dialer,
+			Balancer: &segkafka.Hash{},
+		}),
+		logger: logger,
+	}, nil
+}
+
+// PublishPatch publishes an edit Update message with a patch that a user made
+// to a conversation's content. Messages are keyed by conversation ID, like
+// those that the Reader consumes, so that the edits of a conversation stay in
+// order.
+func (p *Publisher) PublishPatch(ctx context.Context, conversationID, userID int64, patch string) error {
+	updateType := UpdateTypeEdit
+	value, err := json.Marshal(&Message{
+		SchemaVersion: latestSchemaVersion,
+		Type:          TypeUpdate,
+		Data: InnerData{
+			Type:   &updateType,
+			Patch:  &patch,
+			UserID: &userID,
+		},
+	})
+	if err != nil {
+		return err
+	}
+
+	var headers headerWriter
+	otel.GetTextMapPropagator().Inject(ctx, &headers)
+
+	return p.writer.WriteMessages(ctx, segkafka.Message{
+		Key:     []byte(strconv.FormatInt(conversationID, 10)),
+		Value:   value,
+		Headers: headers,
+	})
+}
+
+// Close flushes pending messages and closes the connections to the brokers.
+func (p *Publisher) Close() error {
+	return p.writer.Close()
+}
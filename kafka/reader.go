@@ -2,44 +2,283 @@ package kafka
 
 import (
 	"context"
-	"log"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"ether/logging"
+	"ether/metrics"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"strings"
+	"sync"
+	"time"
 
 	segkafka "github.com/segmentio/kafka-go"
+	"github.com/segmentio/kafka-go/sasl/plain"
+	"github.com/segmentio/kafka-go/sasl/scram"
 )
 
+const (
+	minReadBackoff = 100 * time.Millisecond
+	maxReadBackoff = 30 * time.Second
+)
+
+// minHandleBackoff is the delay before a message that failed transiently is
+// processed again. It is a variable so that tests can shorten it.
+var minHandleBackoff = minReadBackoff
+
+// SASL mechanisms supported by ReaderConfig.
+const (
+	SASLPlain       = "plain"
+	SASLScramSHA256 = "scram-sha-256"
+	SASLScramSHA512 = "scram-sha-512"
+)
+
+// Reader states reported by Status.
+const (
+	StateRunning  = "running"
+	StateRetrying = "retrying"
+	StateStopped  = "stopped"
+)
+
+// ReaderConfig represents the configuration of a Reader.
+type ReaderConfig struct {
+	Brokers []string
+	Topic   string
+
+	// TLSEnabled makes the Reader connect to the brokers over TLS. CAFile, if
+	// set, replaces the system's root CAs, and CertFile and KeyFile, if set,
+	// are used for client authentication.
+	TLSEnabled            bool
+	TLSCAFile             string
+	TLSCertFile           string
+	TLSKeyFile            string
+	TLSInsecureSkipVerify bool
+
+	// SASLMechanism, if set, is one of SASLPlain, SASLScramSHA256 or
+	// SASLScramSHA512.
+	SASLMechanism string
+	SASLUsername  string
+	SASLPassword  string
+}
+
+// ReaderStatus represents the state of a Reader and the last error it
+// encountered, if any.
+type ReaderStatus struct {
+	State     string
+	LastError error
+}
+
 // Reader represents a Kafka consumer which consumes and processes conversation
 // update messages.
 type Reader struct {
 	reader *segkafka.Reader
+	logger *logging.Logger
+
+	mu     sync.Mutex
+	status ReaderStatus
 }
 
 // NewReader initializes a new Reader.
-func NewReader(location, topic string) *Reader {
+func NewReader(config ReaderConfig, logger *logging.Logger) (*Reader, error) {
+	dialer, err := newDialer(config)
+	if err != nil {
+		return nil, err
+	}
+
 	return &Reader{
 		reader: segkafka.NewReader(segkafka.ReaderConfig{
-			Brokers:  []string{location},
+			Brokers:  config.Brokers,
 			GroupID:  "ether",
-			Topic:    topic,
+			Topic:    config.Topic,
+			Dialer:   dialer,
 			MinBytes: 1,
 			MaxBytes: 10e6,
 		}),
+		logger: logger,
+		status: ReaderStatus{State: StateRunning},
+	}, nil
+}
+
+// newDialer builds a Dialer that connects with the TLS and SASL options of
+// config.
+func newDialer(config ReaderConfig) (*segkafka.Dialer, error) {
+	dialer := &segkafka.Dialer{
+		Timeout:   10 * time.Second,
+		DualStack: true,
+	}
+
+	if config.TLSEnabled {
+		tlsConfig := &tls.Config{InsecureSkipVerify: config.TLSInsecureSkipVerify}
+		if config.TLSCAFile != "" {
+			caCert, err := ioutil.ReadFile(config.TLSCAFile)
+			if err != nil {
+				return nil, err
+			}
+			tlsConfig.RootCAs = x509.NewCertPool()
+			if !tlsConfig.RootCAs.AppendCertsFromPEM(caCert) {
+				return nil, fmt.Errorf("No certificates found in %s", config.TLSCAFile)
+			}
+		}
+		if config.TLSCertFile != "" || config.TLSKeyFile != "" {
+			cert, err := tls.LoadX509KeyPair(config.TLSCertFile, config.TLSKeyFile)
+			if err != nil {
+				return nil, err
+			}
+			tlsConfig.Certificates = []tls.Certificate{cert}
+		}
+		dialer.TLS = tlsConfig
+	}
+
+	var err error
+	switch strings.ToLower(config.SASLMechanism) {
+	case "":
+	case SASLPlain:
+		dialer.SASLMechanism = plain.Mechanism{
+			Username: config.SASLUsername,
+			Password: config.SASLPassword,
+		}
+	case SASLScramSHA256:
+		dialer.SASLMechanism, err = scram.Mechanism(scram.SHA256, config.SASLUsername, config.SASLPassword)
+	case SASLScramSHA512:
+		dialer.SASLMechanism, err = scram.Mechanism(scram.SHA512, config.SASLUsername, config.SASLPassword)
+	default:
+		err = fmt.Errorf("Invalid SASL mechanism: %s", config.SASLMechanism)
+	}
+	if err != nil {
+		return nil, err
 	}
+	return dialer, nil
 }
 
-// Run reads from the Kafka topic indefinitely and, upon receiving a message,
-// updates the relevant conversation content file and updates the relevant
-// conversation LastModified time in the database.
-func (r *Reader) Run(handler func(m segkafka.Message) error) {
-	defer r.reader.Close()
+// Run reads from the Kafka topic until ctx is cancelled and, upon receiving a
+// message, updates the relevant conversation content file and updates the
+// relevant conversation LastModified time in the database. Each message is
+// handled with a context carrying a request ID derived from its partition and
+// offset, and its offset is committed once it has been handled. Messages are
+// delivered at least once: a message that fails transiently is handled again
+// until it succeeds, and one that is still failing when ctx is cancelled is not
+// committed, so that it is handled again after a restart. Transient read errors
+// are retried with exponential backoff; an unrecoverable one stops Run and is
+// reported by Status. The Reader is closed when Run returns.
+func (r *Reader) Run(ctx context.Context, handler func(ctx context.Context, m segkafka.Message) error) {
+	defer func() {
+		if err := r.reader.Close(); err != nil {
+			r.logger.Errorf("Failed to close Kafka reader: %v", err)
+		}
+	}()
 
+	backoff := minReadBackoff
 	for {
-		m, err := r.reader.ReadMessage(context.Background())
+		m, err := r.reader.FetchMessage(ctx)
 		if err != nil {
-			log.Fatal(err)
+			if ctx.Err() != nil {
+				r.logger.Info("Kafka reader stopped")
+				return
+			}
+			metrics.KafkaReadErrors.Inc()
+
+			if !isRetriable(err) {
+				r.logger.Errorf("Failed to read Kafka message, stopping reader: %v", err)
+				r.setStatus(StateStopped, err)
+				return
+			}
+
+			r.logger.Warnf("Failed to read Kafka message, retrying in %s: %v", backoff, err)
+			r.setStatus(StateRetrying, err)
+			select {
+			case <-time.After(backoff):
+			case <-ctx.Done():
+				r.logger.Info("Kafka reader stopped")
+				return
+			}
+			backoff = nextBackoff(backoff)
+			continue
+		}
+
+		if backoff != minReadBackoff {
+			r.logger.Info("Kafka reader recovered")
+			r.setStatus(StateRunning, nil)
+			backoff = minReadBackoff
+		}
+
+		metrics.KafkaConsumerLag.Set(float64(r.reader.Stats().Lag))
+
+		// The message is handled and committed even if ctx is cancelled in the
+		// meantime so that shutting down does not interrupt it
+		id := fmt.Sprintf("kafka-%d-%d", m.Partition, m.Offset)
+		msgCtx := logging.WithRequestID(context.Background(), id)
+		if !r.handle(ctx, msgCtx, m, handler) {
+			r.logger.WithContext(msgCtx).Info("Kafka reader stopped before the message was processed")
+			return
+		}
+		if err := r.reader.CommitMessages(msgCtx, m); err != nil {
+			r.logger.WithContext(msgCtx).Errorf("Failed to commit Kafka message offset: %v", err)
+		}
+	}
+}
+
+// handle processes a message until it succeeds or fails permanently, retrying
+// transient failures with exponential backoff. It returns false if ctx is
+// cancelled while waiting to retry, in which case the message should not be
+// committed.
+func (r *Reader) handle(ctx, msgCtx context.Context, m segkafka.Message, handler func(ctx context.Context, m segkafka.Message) error) bool {
+	logger := r.logger.WithContext(msgCtx)
+	backoff := minHandleBackoff
+	for {
+		err := handler(msgCtx, m)
+		if err == nil {
+			return true
+		}
+		if isPermanent(err) {
+			logger.Errorf("Failed to process Kafka message, skipping it: %v", err)
+			return true
 		}
 
-		if err := handler(m); err != nil {
-			log.Printf("Failed to process Kafka message: %v", err)
+		logger.Warnf("Failed to process Kafka message, retrying in %s: %v", backoff, err)
+		select {
+		case <-time.After(backoff):
+		case <-ctx.Done():
+			return false
 		}
+		backoff = nextBackoff(backoff)
+	}
+}
+
+// Status returns the current state of the Reader.
+func (r *Reader) Status() ReaderStatus {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.status
+}
+
+func (r *Reader) setStatus(state string, err error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.status = ReaderStatus{State: state, LastError: err}
+}
+
+// isRetriable reports whether reading may succeed again after err. Kafka
+// errors are retriable if the protocol marks them as such, the reader being
+// closed is not, and anything else, such as a network error, is assumed to be
+// transient.
+func isRetriable(err error) bool {
+	if errors.Is(err, io.EOF) {
+		return false
+	}
+	var kafkaErr segkafka.Error
+	if errors.As(err, &kafkaErr) {
+		return kafkaErr.Temporary() || kafkaErr.Timeout()
+	}
+	return true
+}
+
+// nextBackoff doubles backoff up to maxReadBackoff.
+func nextBackoff(backoff time.Duration) time.Duration {
+	backoff *= 2
+	if backoff > maxReadBackoff {
+		return maxReadBackoff
 	}
+	return backoff
 }
@@ -0,0 +1,151 @@
+package kafka
+
+import (
+	"context"
+	"errors"
+	"ether/filesystem"
+	"ether/logging"
+	"io"
+	"io/ioutil"
+	"net"
+	"testing"
+	"time"
+
+	segkafka "github.com/segmentio/kafka-go"
+)
+
+func TestIsRetriable(t *testing.T) {
+	tests := []struct {
+		Name      string
+		Err       error
+		Retriable bool
+	}{
+		{Name: "Reader closed", Err: io.EOF, Retriable: false},
+		{Name: "Retriable Kafka error", Err: segkafka.LeaderNotAvailable, Retriable: true},
+		{Name: "Kafka timeout", Err: segkafka.RequestTimedOut, Retriable: true},
+		{Name: "Unrecoverable Kafka error", Err: segkafka.TopicAuthorizationFailed, Retriable: false},
+		{Name: "Network error", Err: &net.OpError{Op: "dial", Err: errors.New("connection refused")}, Retriable: true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.Name, func(t *testing.T) {
+			if retriable := isRetriable(test.Err); retriable != test.Retriable {
+				t.Errorf("Expected retriable %t, got %t", test.Retriable, retriable)
+			}
+		})
+	}
+}
+
+func TestNextBackoff(t *testing.T) {
+	backoff := minReadBackoff
+	for i := 0; i < 20; i++ {
+		backoff = nextBackoff(backoff)
+	}
+	if backoff != maxReadBackoff {
+		t.Errorf("Expected backoff to be capped at %s, got %s", maxReadBackoff, backoff)
+	}
+	if next := nextBackoff(100 * time.Millisecond); next != 200*time.Millisecond {
+		t.Errorf("Expected backoff to double, got %s", next)
+	}
+}
+
+func TestNewDialerInvalidSASL(t *testing.T) {
+	if _, err := newDialer(ReaderConfig{SASLMechanism: "kerberos"}); err == nil {
+		t.Error("Expected an error, got nil")
+	}
+}
+
+func TestReaderHandle(t *testing.T) {
+	minHandleBackoff = time.Millisecond
+	defer func() { minHandleBackoff = minReadBackoff }()
+
+	tests := []struct {
+		Name      string
+		Errors    []error
+		Cancelled bool
+		Calls     int
+		Handled   bool
+	}{
+		{
+			Name:    "Success",
+			Errors:  []error{nil},
+			Calls:   1,
+			Handled: true,
+		},
+		{
+			Name:    "Transient errors are retried",
+			Errors:  []error{errors.New("connection refused"), errors.New("connection refused"), nil},
+			Calls:   3,
+			Handled: true,
+		},
+		{
+			Name:    "Patch that does not apply is skipped",
+			Errors:  []error{filesystem.ErrPatchNotApplied},
+			Calls:   1,
+			Handled: true,
+		},
+		{
+			Name:    "Error after the patch was applied is skipped",
+			Errors:  []error{&permanentError{err: errors.New("connection refused")}},
+			Calls:   1,
+			Handled: true,
+		},
+		{
+			Name:      "Transient error while stopping is not committed",
+			Errors:    []error{errors.New("connection refused")},
+			Cancelled: true,
+			Calls:     1,
+			Handled:   false,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.Name, func(t *testing.T) {
+			r := &Reader{logger: logging.New(ioutil.Discard, logging.LevelError)}
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			if test.Cancelled {
+				cancel()
+			}
+
+			calls := 0
+			handled := r.handle(ctx, context.Background(), segkafka.Message{}, func(ctx context.Context, m segkafka.Message) error {
+				err := test.Errors[calls]
+				calls++
+				return err
+			})
+
+			if handled != test.Handled {
+				t.Errorf("Expected handled %t, got %t", test.Handled, handled)
+			}
+			if calls != test.Calls {
+				t.Errorf("Expected %d calls to the handler, got %d", test.Calls, calls)
+			}
+		})
+	}
+}
+
+func TestReaderHandleUnsupportedVersion(t *testing.T) {
+	minHandleBackoff = time.Millisecond
+	defer func() { minHandleBackoff = minReadBackoff }()
+
+	r := &Reader{logger: logging.New(ioutil.Discard, logging.LevelError)}
+	env := &Env{}
+	m := segkafka.Message{
+		Key:   []byte("1"),
+		Value: []byte(`{"schema_version":2,"type":1,"data":{"type":0,"patch":"@@ -0,0 +1,5 @@\n+hello\n","user_id":1}}`),
+	}
+
+	calls := 0
+	handled := r.handle(context.Background(), context.Background(), m, func(ctx context.Context, m segkafka.Message) error {
+		calls++
+		if calls > 1 {
+			t.Fatal("Message with an unsupported schema version was retried")
+		}
+		return env.ProcessWSMessage(ctx, m)
+	})
+
+	if !handled {
+		t.Error("Message with an unsupported schema version was not committed")
+	}
+}
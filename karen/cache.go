@@ -0,0 +1,61 @@
+package karen
+
+import (
+	"sync"
+	"time"
+)
+
+// cacheEntry represents a cached user, which is nil if the user does not
+// exist.
+type cacheEntry struct {
+	user    *User
+	expires time.Time
+}
+
+// cache is a TTL cache of users.
+type cache struct {
+	mu      sync.Mutex
+	ttl     time.Duration
+	entries map[int64]cacheEntry
+}
+
+func newCache(ttl time.Duration) *cache {
+	return &cache{
+		ttl:     ttl,
+		entries: make(map[int64]cacheEntry),
+	}
+}
+
+// get returns a user and whether it was cached.
+func (c *cache) get(userID int64) (*User, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	entry, ok := c.entries[userID]
+	if !ok {
+		return nil, false
+	}
+	if time.Now().After(entry.expires) {
+		delete(c.entries, userID)
+		return nil, false
+	}
+	return entry.user, true
+}
+
+// set caches a user, and evicts expired entries.
+func (c *cache) set(userID int64, user *User) {
+	if c.ttl <= 0 {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := time.Now()
+	for id, entry := range c.entries {
+		if now.After(entry.expires) {
+			delete(c.entries, id)
+		}
+	}
+	c.entries[userID] = cacheEntry{user: user, expires: now.Add(c.ttl)}
+}
@@ -0,0 +1,253 @@
+package karen
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"ether/tracing"
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const (
+	usersRoute = "/karen/v1/users/"
+
+	// maxConcurrency bounds the number of requests GetUsers makes to Karen at
+	// once, since Karen has no endpoint to get multiple users in one request.
+	maxConcurrency = 8
+)
+
+// ErrUnavailable is returned when Karen is not being called because too many
+// recent requests to it have failed.
+var ErrUnavailable = errors.New("karen: circuit breaker is open")
+
+// StatusError is returned when Karen responds with an unexpected status code.
+type StatusError struct {
+	StatusCode int
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("karen: unexpected response status %d %s", e.StatusCode, http.StatusText(e.StatusCode))
+}
+
+// User represents the profile of a user in Karen.
+type User struct {
+	ID        int64  `json:"id"`
+	Name      string `json:"name"`
+	Email     string `json:"email"`
+	AvatarURL string `json:"avatar_url,omitempty"`
+}
+
+// Client defines the operations that Ether performs against Karen, the user
+// service.
+type Client interface {
+	UserExists(ctx context.Context, userID int64) (bool, error)
+	GetUser(ctx context.Context, userID int64) (*User, error)
+	GetUsers(ctx context.Context, userIDs []int64) (map[int64]*User, error)
+}
+
+// Config represents the settings of an HTTPClient.
+type Config struct {
+	// Host is the host and port where Karen is located.
+	Host string
+
+	// Timeout bounds each individual request to Karen.
+	Timeout time.Duration
+
+	// MaxRetries is the number of times a failed request is retried.
+	MaxRetries int
+
+	// Backoff is the delay before the first retry, doubled for every retry
+	// after it.
+	Backoff time.Duration
+
+	// FailureThreshold is the number of consecutive failed requests after
+	// which the circuit breaker opens.
+	FailureThreshold int
+
+	// Cooldown is how long the circuit breaker stays open before letting a
+	// trial request through.
+	Cooldown time.Duration
+
+	// CacheTTL is how long a user, or the fact that it does not exist, is
+	// cached.
+	CacheTTL time.Duration
+}
+
+// DefaultConfig returns the default Config for a Karen located at host.
+func DefaultConfig(host string) Config {
+	return Config{
+		Host:             host,
+		Timeout:          2 * time.Second,
+		MaxRetries:       2,
+		Backoff:          100 * time.Millisecond,
+		FailureThreshold: 5,
+		Cooldown:         10 * time.Second,
+		CacheTTL:         5 * time.Minute,
+	}
+}
+
+// HTTPClient is a Client that calls Karen over HTTP.
+type HTTPClient struct {
+	config  Config
+	client  *http.Client
+	breaker *breaker
+	cache   *cache
+}
+
+// NewClient initializes a new HTTPClient.
+func NewClient(config Config) *HTTPClient {
+	return &HTTPClient{
+		config:  config,
+		client:  &http.Client{Timeout: config.Timeout},
+		breaker: newBreaker(config.FailureThreshold, config.Cooldown),
+		cache:   newCache(config.CacheTTL),
+	}
+}
+
+// UserExists checks whether Karen has a user with the given ID.
+func (c *HTTPClient) UserExists(ctx context.Context, userID int64) (bool, error) {
+	user, err := c.GetUser(ctx, userID)
+	return user != nil, err
+}
+
+// GetUser gets the profile of a user from Karen. If the user does not exist, it
+// returns nil.
+func (c *HTTPClient) GetUser(ctx context.Context, userID int64) (*User, error) {
+	if user, ok := c.cache.get(userID); ok {
+		return user, nil
+	}
+
+	user, err := c.getUser(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	c.cache.set(userID, user)
+	return user, nil
+}
+
+// GetUsers concurrently gets the profiles of multiple users from Karen, with
+// one request per user that is not cached and at most maxConcurrency requests
+// in flight. The returned map has a nil value for each user that does not
+// exist. If any user could not be retrieved, the map of the users that could
+// be is returned along with an error.
+func (c *HTTPClient) GetUsers(ctx context.Context, userIDs []int64) (map[int64]*User, error) {
+	var mu sync.Mutex
+	var firstErr error
+	users := make(map[int64]*User, len(userIDs))
+	sem := make(chan struct{}, maxConcurrency)
+	var wg sync.WaitGroup
+	for _, userID := range userIDs {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(userID int64) {
+			defer func() {
+				<-sem
+				wg.Done()
+			}()
+
+			user, err := c.GetUser(ctx, userID)
+			mu.Lock()
+			defer mu.Unlock()
+			if err != nil {
+				if firstErr == nil {
+					firstErr = err
+				}
+				return
+			}
+			users[userID] = user
+		}(userID)
+	}
+	wg.Wait()
+
+	return users, firstErr
+}
+
+// getUser requests a user from Karen, retrying with exponential backoff on
+// transport errors and server errors.
+func (c *HTTPClient) getUser(ctx context.Context, userID int64) (*User, error) {
+	backoff := c.config.Backoff
+	var lastErr error
+	for attempt := 0; attempt <= c.config.MaxRetries; attempt++ {
+		if attempt > 0 {
+			select {
+			case <-ctx.Done():
+				return nil, ctx.Err()
+			case <-time.After(backoff):
+			}
+			backoff *= 2
+		}
+
+		if !c.breaker.allow() {
+			return nil, ErrUnavailable
+		}
+
+		user, err := c.doGetUser(ctx, userID)
+		statusErr, isStatusErr := err.(*StatusError)
+		if err == nil || isStatusErr && statusErr.StatusCode < http.StatusInternalServerError {
+			c.breaker.success()
+			return user, err
+		}
+		c.breaker.failure()
+
+		lastErr = err
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+	}
+	return nil, lastErr
+}
+
+// doGetUser makes a single request for a user to Karen.
+func (c *HTTPClient) doGetUser(ctx context.Context, userID int64) (*User, error) {
+	ctx, cancel := context.WithTimeout(ctx, c.config.Timeout)
+	defer cancel()
+
+	ctx, span := tracing.Tracer().Start(
+		ctx,
+		"GET "+usersRoute+"{user_id}",
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(attribute.Int64("user.id", userID)),
+	)
+	defer span.End()
+
+	url := "http://" + c.config.Host + usersRoute + strconv.FormatInt(userID, 10)
+	request, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+	if err != nil {
+		tracing.Fail(span, err)
+		return nil, err
+	}
+	request.Header.Add("User-ID", strconv.FormatInt(userID, 10))
+	otel.GetTextMapPropagator().Inject(ctx, propagation.HeaderCarrier(request.Header))
+
+	response, err := c.client.Do(request)
+	if err != nil {
+		tracing.Fail(span, err)
+		return nil, err
+	}
+	defer response.Body.Close()
+	span.SetAttributes(attribute.Int("http.status_code", response.StatusCode))
+
+	switch response.StatusCode {
+	case http.StatusOK:
+		user := &User{}
+		if err := json.NewDecoder(response.Body).Decode(user); err != nil {
+			return nil, err
+		}
+		return user, nil
+	case http.StatusNotFound:
+		return nil, nil
+	default:
+		err := &StatusError{StatusCode: response.StatusCode}
+		tracing.Fail(span, err)
+		return nil, err
+	}
+}
@@ -0,0 +1,148 @@
+package karen
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestUserExists(t *testing.T) {
+	tests := []struct {
+		Name        string
+		StatusCodes []int
+		Exists      bool
+		Err         bool
+		Requests    int32
+	}{
+		{
+			Name:        "User exists",
+			StatusCodes: []int{http.StatusOK},
+			Exists:      true,
+			Requests:    1,
+		},
+		{
+			Name:        "User does not exist",
+			StatusCodes: []int{http.StatusNotFound},
+			Exists:      false,
+			Requests:    1,
+		},
+		{
+			Name:        "User exists after retries",
+			StatusCodes: []int{http.StatusInternalServerError, http.StatusBadGateway, http.StatusOK},
+			Exists:      true,
+			Requests:    3,
+		},
+		{
+			Name:        "Retries exhausted",
+			StatusCodes: []int{http.StatusInternalServerError},
+			Err:         true,
+			Requests:    3,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.Name, func(t *testing.T) {
+			var requests int32
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				n := atomic.AddInt32(&requests, 1)
+				i := int(n) - 1
+				if i >= len(test.StatusCodes) {
+					i = len(test.StatusCodes) - 1
+				}
+				w.WriteHeader(test.StatusCodes[i])
+				if test.StatusCodes[i] == http.StatusOK {
+					w.Write([]byte(`{"id": 1, "name": "test_name"}`))
+				}
+			}))
+			defer server.Close()
+
+			config := DefaultConfig(strings.TrimPrefix(server.URL, "http://"))
+			config.Backoff = time.Millisecond
+			client := NewClient(config)
+
+			for i := 0; i < 2; i++ {
+				exists, err := client.UserExists(context.Background(), 1)
+				if test.Err != (err != nil) {
+					t.Fatalf("Incorrect error, expected error %t, got %v", test.Err, err)
+				}
+				if exists != test.Exists {
+					t.Errorf("Incorrect existence, expected %t, got %t", test.Exists, exists)
+				}
+				if test.Err {
+					break
+				}
+			}
+
+			// Successful lookups should be served from the cache the second time
+			if requests != test.Requests {
+				t.Errorf("Incorrect number of requests to Karen, expected %d, got %d", test.Requests, requests)
+			}
+		})
+	}
+}
+
+func TestUserExistsCircuitBreaker(t *testing.T) {
+	var requests int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&requests, 1)
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer server.Close()
+
+	config := DefaultConfig(strings.TrimPrefix(server.URL, "http://"))
+	config.MaxRetries = 0
+	config.FailureThreshold = 2
+	config.Cooldown = time.Hour
+	client := NewClient(config)
+
+	for i := 0; i < 2; i++ {
+		if _, err := client.UserExists(context.Background(), 1); err == nil {
+			t.Fatal("Expected error from failing Karen")
+		}
+	}
+
+	if _, err := client.UserExists(context.Background(), 1); err != ErrUnavailable {
+		t.Errorf("Incorrect error, expected %v, got %v", ErrUnavailable, err)
+	}
+	if requests != 2 {
+		t.Errorf("Incorrect number of requests to Karen, expected 2, got %d", requests)
+	}
+}
+
+func TestGetUsers(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case usersRoute + "1":
+			w.Write([]byte(`{"id": 1, "name": "one"}`))
+		case usersRoute + "2":
+			w.Write([]byte(`{"id": 2, "name": "two"}`))
+		case usersRoute + "3":
+			w.WriteHeader(http.StatusInternalServerError)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	config := DefaultConfig(strings.TrimPrefix(server.URL, "http://"))
+	config.MaxRetries = 0
+	client := NewClient(config)
+
+	users, err := client.GetUsers(context.Background(), []int64{1, 2, 3, 4})
+	if err == nil {
+		t.Error("Expected error for user that could not be retrieved")
+	}
+	if users[1] == nil || users[1].Name != "one" || users[2] == nil || users[2].Name != "two" {
+		t.Errorf("Incorrect users retrieved, got %+v", users)
+	}
+	if _, ok := users[3]; ok {
+		t.Error("User that could not be retrieved should be missing")
+	}
+	if user, ok := users[4]; !ok || user != nil {
+		t.Error("User that does not exist should be nil")
+	}
+}
@@ -0,0 +1,44 @@
+package karen
+
+import (
+	"context"
+)
+
+type MockClient struct {
+	Users map[int64]*User
+	Err   error
+}
+
+func NewMockClient(userIDs []int64, err error) *MockClient {
+	client := &MockClient{
+		Users: make(map[int64]*User),
+		Err:   err,
+	}
+	for _, userID := range userIDs {
+		client.Users[userID] = &User{ID: userID}
+	}
+	return client
+}
+
+func (c *MockClient) UserExists(ctx context.Context, userID int64) (bool, error) {
+	user, err := c.GetUser(ctx, userID)
+	return user != nil, err
+}
+
+func (c *MockClient) GetUser(ctx context.Context, userID int64) (*User, error) {
+	if c.Err != nil {
+		return nil, c.Err
+	}
+	return c.Users[userID], nil
+}
+
+func (c *MockClient) GetUsers(ctx context.Context, userIDs []int64) (map[int64]*User, error) {
+	if c.Err != nil {
+		return map[int64]*User{}, c.Err
+	}
+	users := make(map[int64]*User, len(userIDs))
+	for _, userID := range userIDs {
+		users[userID] = c.Users[userID]
+	}
+	return users, nil
+}
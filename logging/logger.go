@@ -0,0 +1,166 @@
+package logging
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Level represents the severity of a log entry.
+type Level int
+
+const (
+	// LevelDebug is for verbose diagnostic entries.
+	LevelDebug Level = iota
+
+	// LevelInfo is for routine entries.
+	LevelInfo
+
+	// LevelWarn is for entries about unexpected but recoverable situations.
+	LevelWarn
+
+	// LevelError is for entries about failures.
+	LevelError
+)
+
+var levelNames = map[Level]string{
+	LevelDebug: "debug",
+	LevelInfo:  "info",
+	LevelWarn:  "warn",
+	LevelError: "error",
+}
+
+// String returns the name of a Level.
+func (l Level) String() string {
+	return levelNames[l]
+}
+
+// ParseLevel parses the name of a Level.
+func ParseLevel(name string) (Level, error) {
+	for level, levelName := range levelNames {
+		if strings.EqualFold(name, levelName) {
+			return level, nil
+		}
+	}
+	return LevelInfo, fmt.Errorf("Invalid log level: %s", name)
+}
+
+// Logger writes leveled log entries as JSON objects, one per line.
+type Logger struct {
+	mu     *sync.Mutex
+	out    io.Writer
+	level  Level
+	fields map[string]interface{}
+}
+
+var defaultLogger = New(os.Stderr, LevelInfo)
+
+// New initializes a new Logger that writes entries of at least the given level
+// to out.
+func New(out io.Writer, level Level) *Logger {
+	return &Logger{
+		mu:     &sync.Mutex{},
+		out:    out,
+		level:  level,
+		fields: map[string]interface{}{},
+	}
+}
+
+// orDefault lets a nil Logger be used as the default Logger.
+func (l *Logger) orDefault() *Logger {
+	if l == nil {
+		return defaultLogger
+	}
+	return l
+}
+
+// With returns a Logger that adds a field to every entry.
+func (l *Logger) With(key string, value interface{}) *Logger {
+	l = l.orDefault()
+	fields := make(map[string]interface{}, len(l.fields)+1)
+	for k, v := range l.fields {
+		fields[k] = v
+	}
+	fields[key] = value
+	return &Logger{
+		mu:     l.mu,
+		out:    l.out,
+		level:  l.level,
+		fields: fields,
+	}
+}
+
+// WithContext returns a Logger that adds the request ID and trace ID carried by
+// ctx, if any, to every entry.
+func (l *Logger) WithContext(ctx context.Context) *Logger {
+	l = l.orDefault()
+	if id := RequestID(ctx); id != "" {
+		l = l.With("request_id", id)
+	}
+	if spanContext := trace.SpanContextFromContext(ctx); spanContext.HasTraceID() {
+		l = l.With("trace_id", spanContext.TraceID().String())
+	}
+	return l
+}
+
+func (l *Logger) log(level Level, msg string) {
+	l = l.orDefault()
+	if level < l.level {
+		return
+	}
+
+	entry := make(map[string]interface{}, len(l.fields)+3)
+	for k, v := range l.fields {
+		entry[k] = v
+	}
+	entry["time"] = time.Now().UTC().Format(time.RFC3339Nano)
+	entry["level"] = level.String()
+	entry["msg"] = msg
+
+	b, err := json.Marshal(entry)
+	if err != nil {
+		b = []byte(fmt.Sprintf(`{"level":"error","msg":"Failed to encode log entry: %s"}`, err))
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.out.Write(append(b, '\n'))
+}
+
+// Debugf writes a debug level entry.
+func (l *Logger) Debugf(format string, args ...interface{}) {
+	l.log(LevelDebug, fmt.Sprintf(format, args...))
+}
+
+// Info writes an info level entry.
+func (l *Logger) Info(msg string) {
+	l.log(LevelInfo, msg)
+}
+
+// Infof writes an info level entry.
+func (l *Logger) Infof(format string, args ...interface{}) {
+	l.log(LevelInfo, fmt.Sprintf(format, args...))
+}
+
+// Warnf writes a warn level entry.
+func (l *Logger) Warnf(format string, args ...interface{}) {
+	l.log(LevelWarn, fmt.Sprintf(format, args...))
+}
+
+// Errorf writes an error level entry.
+func (l *Logger) Errorf(format string, args ...interface{}) {
+	l.log(LevelError, fmt.Sprintf(format, args...))
+}
+
+// Fatalf writes an error level entry and exits the process.
+func (l *Logger) Fatalf(format string, args ...interface{}) {
+	l.log(LevelError, fmt.Sprintf(format, args...))
+	os.Exit(1)
+}
@@ -0,0 +1,82 @@
+package logging
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestLogger(t *testing.T) {
+	var out bytes.Buffer
+	logger := New(&out, LevelInfo).With("component", "test")
+
+	logger.Debugf("Read %d row(s)", 1)
+	logger.Infof("Created %d row(s)", 2)
+
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	if len(lines) != 1 {
+		t.Fatalf("Incorrect number of log entries, expected 1, got %d: %q", len(lines), out.String())
+	}
+
+	entry := map[string]interface{}{}
+	if err := json.Unmarshal([]byte(lines[0]), &entry); err != nil {
+		t.Fatalf("Log entry is not JSON: %v", err)
+	}
+	if entry["level"] != "info" || entry["msg"] != "Created 2 row(s)" || entry["component"] != "test" {
+		t.Errorf("Log entry has incorrect fields: %+v", entry)
+	}
+}
+
+func TestMiddleware(t *testing.T) {
+	tests := []struct {
+		Name      string
+		RequestID string
+	}{
+		{
+			Name:      "Incoming request ID",
+			RequestID: "abc123",
+		},
+		{
+			Name: "Generated request ID",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.Name, func(t *testing.T) {
+			var out bytes.Buffer
+			logger := New(&out, LevelInfo)
+
+			var handlerRequestID string
+			handler := Middleware(logger)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				handlerRequestID = RequestID(r.Context())
+				w.WriteHeader(http.StatusTeapot)
+			}))
+
+			r := httptest.NewRequest("GET", "/ether/v1/conversations", nil)
+			if test.RequestID != "" {
+				r.Header.Set(RequestIDHeader, test.RequestID)
+			}
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, r)
+
+			if handlerRequestID == "" {
+				t.Fatal("Request context has no request ID")
+			}
+			if test.RequestID != "" && handlerRequestID != test.RequestID {
+				t.Errorf("Incorrect request ID, expected %s, got %s", test.RequestID, handlerRequestID)
+			}
+			if w.Header().Get(RequestIDHeader) != handlerRequestID {
+				t.Errorf("Response has incorrect request ID header, expected %s, got %s", handlerRequestID, w.Header().Get(RequestIDHeader))
+			}
+
+			entry := map[string]interface{}{}
+			_ = json.Unmarshal(out.Bytes(), &entry)
+			if entry["request_id"] != handlerRequestID || entry["status"] != float64(http.StatusTeapot) {
+				t.Errorf("Request log entry has incorrect fields: %+v", entry)
+			}
+		})
+	}
+}
@@ -0,0 +1,68 @@
+package logging
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"ether/utils"
+	"net/http"
+	"time"
+)
+
+const (
+	// RequestIDHeader is the HTTP header that carries a request ID.
+	RequestIDHeader = "X-Request-ID"
+
+	maxRequestIDLength = 128
+)
+
+type contextKey int
+
+const requestIDKey contextKey = 0
+
+// WithRequestID returns a copy of ctx that carries a request ID.
+func WithRequestID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, requestIDKey, id)
+}
+
+// RequestID returns the request ID carried by ctx, or an empty string.
+func RequestID(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey).(string)
+	return id
+}
+
+// NewRequestID generates a random request ID.
+func NewRequestID() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return ""
+	}
+	return hex.EncodeToString(b)
+}
+
+// Middleware gives every HTTP request a request ID, reusing the one in its
+// X-Request-ID header if there is one, and logs the request once it has been
+// handled.
+func Middleware(logger *Logger) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			start := time.Now()
+			id := r.Header.Get(RequestIDHeader)
+			if id == "" || len(id) > maxRequestIDLength {
+				id = NewRequestID()
+			}
+			w.Header().Set(RequestIDHeader, id)
+			r = r.WithContext(WithRequestID(r.Context(), id))
+
+			recorder := utils.NewStatusRecorder(w)
+			next.ServeHTTP(recorder, r)
+
+			logger.WithContext(r.Context()).
+				With("path", r.URL.Path).
+				With("method", r.Method).
+				With("status", recorder.Status).
+				With("duration_ms", time.Since(start).Milliseconds()).
+				Info("Handled request")
+		})
+	}
+}
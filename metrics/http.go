@@ -0,0 +1,23 @@
+package metrics
+
+import (
+	"ether/utils"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// Middleware records the count and latency of HTTP requests, labelled with
+// the template of the mux route that matched them.
+func Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		recorder := utils.NewStatusRecorder(w)
+		next.ServeHTTP(recorder, r)
+
+		route := utils.RouteTemplate(r)
+		status := strconv.Itoa(recorder.Status)
+		HTTPRequests.WithLabelValues(route, r.Method, status).Inc()
+		HTTPRequestDuration.WithLabelValues(route, r.Method, status).Observe(time.Since(start).Seconds())
+	})
+}
@@ -0,0 +1,150 @@
+package metrics
+
+import (
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+const namespace = "ether"
+
+var (
+	// HTTPRequests counts HTTP requests by route template, method and status.
+	HTTPRequests = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "http",
+		Name:      "requests_total",
+		Help:      "Number of HTTP requests handled.",
+	}, []string{"route", "method", "status"})
+
+	// HTTPRequestDuration observes HTTP request latency by route template,
+	// method and status.
+	HTTPRequestDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Subsystem: "http",
+		Name:      "request_duration_seconds",
+		Help:      "Latency of HTTP requests.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"route", "method", "status"})
+
+	// HTTPRateLimited counts HTTP requests rejected by the rate limiter by
+	// route template and method.
+	HTTPRateLimited = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "http",
+		Name:      "rate_limited_total",
+		Help:      "Number of HTTP requests rejected for exceeding a rate limit.",
+	}, []string{"route", "method"})
+
+	// KafkaConsumerLag is the number of messages the Kafka consumer is behind
+	// the end of its partition.
+	KafkaConsumerLag = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Subsystem: "kafka",
+		Name:      "consumer_lag",
+		Help:      "Number of messages the Kafka consumer is behind.",
+	})
+
+	// KafkaReadErrors counts errors encountered while reading Kafka messages.
+	KafkaReadErrors = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "kafka",
+		Name:      "read_errors_total",
+		Help:      "Number of errors encountered while reading Kafka messages.",
+	})
+
+	// KafkaMessagesProcessed counts successfully processed Kafka messages by
+	// message type.
+	KafkaMessagesProcessed = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "kafka",
+		Name:      "messages_processed_total",
+		Help:      "Number of Kafka messages processed successfully.",
+	}, []string{"type"})
+
+	// KafkaMessagesFailed counts Kafka messages that failed to be processed by
+	// message type.
+	KafkaMessagesFailed = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "kafka",
+		Name:      "messages_failed_total",
+		Help:      "Number of Kafka messages that failed to be processed.",
+	}, []string{"type"})
+
+	// KafkaEditsRejected counts content edits from Kafka that were rejected
+	// without being applied by reason.
+	KafkaEditsRejected = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "kafka",
+		Name:      "edits_rejected_total",
+		Help:      "Number of content edits from Kafka that were rejected.",
+	}, []string{"reason"})
+
+	// WriterPatchDuration observes how long the content writer takes to apply
+	// a patch to a content file.
+	WriterPatchDuration = promauto.NewHistogram(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Subsystem: "writer",
+		Name:      "patch_apply_duration_seconds",
+		Help:      "Latency of applying a patch to a content file.",
+		Buckets:   prometheus.DefBuckets,
+	})
+
+	// WriterPatchFailures counts patches that the content writer failed to
+	// apply by the step that failed.
+	WriterPatchFailures = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "writer",
+		Name:      "patch_failures_total",
+		Help:      "Number of patches that could not be applied to a content file.",
+	}, []string{"reason"})
+
+	// WriterBytesWritten counts the bytes written to content files.
+	WriterBytesWritten = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "writer",
+		Name:      "bytes_written_total",
+		Help:      "Number of bytes written to content files.",
+	})
+
+	// DBQueryDuration observes the latency of Datastore methods by method
+	// name.
+	DBQueryDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Subsystem: "db",
+		Name:      "query_duration_seconds",
+		Help:      "Latency of database queries.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"method"})
+)
+
+// writerQueue registers the writer queue gauge once, however many times
+// RegisterWriterQueue is called.
+var writerQueue struct {
+	once  sync.Once
+	mu    sync.RWMutex
+	depth func() int
+}
+
+// RegisterWriterQueue registers a gauge that reports the number of updates
+// waiting to be processed by the content writer. The gauge is only registered
+// on the first call; later calls make it report the given depth instead.
+func RegisterWriterQueue(depth func() int) {
+	writerQueue.mu.Lock()
+	writerQueue.depth = depth
+	writerQueue.mu.Unlock()
+
+	writerQueue.once.Do(func() {
+		promauto.NewGaugeFunc(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Subsystem: "writer",
+			Name:      "queue_depth",
+			Help:      "Number of updates waiting to be applied to content files.",
+		}, func() float64 {
+			writerQueue.mu.RLock()
+			defer writerQueue.mu.RUnlock()
+			return float64(writerQueue.depth())
+		})
+	})
+}
@@ -0,0 +1,27 @@
+package metrics
+
+import (
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+func TestRegisterWriterQueue(t *testing.T) {
+	RegisterWriterQueue(func() int { return 1 })
+	RegisterWriterQueue(func() int { return 2 })
+
+	families, err := prometheus.DefaultGatherer.Gather()
+	if err != nil {
+		t.Fatalf("Failed to gather metrics: %v", err)
+	}
+	for _, family := range families {
+		if family.GetName() != "ether_writer_queue_depth" {
+			continue
+		}
+		if value := family.GetMetric()[0].GetGauge().GetValue(); value != 2 {
+			t.Errorf("Queue depth gauge has incorrect value, expected %d, got %v", 2, value)
+		}
+		return
+	}
+	t.Error("Queue depth gauge was not registered")
+}
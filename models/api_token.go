@@ -0,0 +1,230 @@
+package models
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+)
+
+// APIToken represents a long-lived token that a service account uses to access
+// its conversation. Only a hash of the token is stored, so the token itself is
+// only known when it is created.
+type APIToken struct {
+	ID               int64   `json:"id"`
+	ServiceAccountID int64   `json:"service_account_id"`
+	ConversationID   int64   `json:"conversation_id"`
+	Name             string  `json:"name"`
+	Scopes           []Scope `json:"scopes"`
+	Prefix           string  `json:"prefix"`
+	CreatorID        int64   `json:"creator_id"`
+	CreatedAt        string  `json:"created_at"`
+
+	// Hash is the hex-encoded SHA-256 hash of the token.
+	Hash string `json:"-"`
+
+	// Token is only set in the response to creating the token.
+	Token string `json:"token,omitempty"`
+}
+
+// APITokenList represents a list of the API tokens of a conversation
+type APITokenList struct {
+	Tokens []*APIToken `json:"tokens"`
+}
+
+// Scope represents what an API token grants access to
+type Scope string
+
+const (
+	// ScopeReadContent grants reading a conversation's content and the diffs
+	// between its versions
+	ScopeReadContent Scope = "read-content"
+
+	// ScopeWriteContent grants editing a conversation's content
+	ScopeWriteContent Scope = "write-content"
+
+	// ScopeManageMembers grants reading, adding, changing and removing the
+	// members of a conversation, as far as the service role allows
+	ScopeManageMembers Scope = "manage-members"
+
+	apiTokensTable string = "api_tokens"
+)
+
+// Valid checks if a Scope is one of the known scopes
+func (s Scope) Valid() bool {
+	return s == ScopeReadContent || s == ScopeWriteContent || s == ScopeManageMembers
+}
+
+// HasScope checks whether an API token grants a scope
+func (t *APIToken) HasScope(scope Scope) bool {
+	for _, s := range t.Scopes {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
+
+// joinScopes encodes scopes as they are stored in the database
+func joinScopes(scopes []Scope) string {
+	strs := make([]string, len(scopes))
+	for i, scope := range scopes {
+		strs[i] = string(scope)
+	}
+	return strings.Join(strs, ",")
+}
+
+// splitScopes decodes scopes as they are stored in the database
+func splitScopes(value string) []Scope {
+	scopes := make([]Scope, 0)
+	for _, s := range strings.Split(value, ",") {
+		if s != "" {
+			scopes = append(scopes, Scope(s))
+		}
+	}
+	return scopes
+}
+
+// scanAPIToken scans a row of the "api_tokens" table that was selected with
+// apiTokenColumns
+func scanAPIToken(row interface{ Scan(...interface{}) error }) (*APIToken, error) {
+	token := &APIToken{}
+	var scopes string
+	err := row.Scan(
+		&token.ID,
+		&token.ServiceAccountID,
+		&token.ConversationID,
+		&token.Name,
+		&scopes,
+		&token.Prefix,
+		&token.Hash,
+		&token.CreatorID,
+		&token.CreatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+	token.Scopes = splitScopes(scopes)
+	return token, nil
+}
+
+const apiTokenColumns = "ID, ServiceAccountID, ConversationID, Name, Scopes, Prefix, Hash, CreatorID, CreatedAt"
+
+// CreateAPIToken adds a row to the "api_tokens" table and sets the token's ID
+func (db *DB) CreateAPIToken(ctx context.Context, token *APIToken) error {
+	ctx, done := instrument(ctx, "CreateAPIToken")
+	defer done()
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "INSERT INTO %s(ServiceAccountID, ConversationID, Name, Scopes, Prefix, Hash, CreatorID, CreatedAt) ", apiTokensTable)
+	fmt.Fprintf(&b, "VALUES(?, ?, ?, ?, ?, ?, ?, ?)")
+	res, err := db.conn().ExecContext(
+		ctx,
+		b.String(),
+		token.ServiceAccountID,
+		token.ConversationID,
+		token.Name,
+		joinScopes(token.Scopes),
+		token.Prefix,
+		token.Hash,
+		token.CreatorID,
+		token.CreatedAt,
+	)
+	if err != nil {
+		return err
+	}
+
+	id, err := res.LastInsertId()
+	if err != nil {
+		return err
+	}
+	token.ID = id
+	db.logger(ctx).Debugf(`Created 1 row in "%s"`, apiTokensTable)
+	return nil
+}
+
+// GetAPIToken queries for a single row in the "api_tokens" table, returning
+// nil if there is none
+func (db *DB) GetAPIToken(ctx context.Context, id int64) (*APIToken, error) {
+	ctx, done := instrument(ctx, "GetAPIToken")
+	defer done()
+
+	queryString := fmt.Sprintf("SELECT %s FROM %s WHERE ID=?", apiTokenColumns, apiTokensTable)
+	token, err := scanAPIToken(db.conn().QueryRowContext(ctx, queryString, id))
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+		return nil, err
+	}
+	db.logger(ctx).Debugf(`Read 1 row from "%s"`, apiTokensTable)
+	return token, nil
+}
+
+// GetAPITokenByHash queries for the row in the "api_tokens" table with a
+// token hash, returning nil if there is none
+func (db *DB) GetAPITokenByHash(ctx context.Context, hash string) (*APIToken, error) {
+	ctx, done := instrument(ctx, "GetAPITokenByHash")
+	defer done()
+
+	queryString := fmt.Sprintf("SELECT %s FROM %s WHERE Hash=?", apiTokenColumns, apiTokensTable)
+	token, err := scanAPIToken(db.conn().QueryRowContext(ctx, queryString, hash))
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+		return nil, err
+	}
+	db.logger(ctx).Debugf(`Read 1 row from "%s"`, apiTokensTable)
+	return token, nil
+}
+
+// GetAPITokens queries for every row in the "api_tokens" table of a
+// conversation, in order of creation
+func (db *DB) GetAPITokens(ctx context.Context, conversationID int64) ([]*APIToken, error) {
+	ctx, done := instrument(ctx, "GetAPITokens")
+	defer done()
+
+	queryString := fmt.Sprintf(
+		"SELECT %s FROM %s WHERE ConversationID=? ORDER BY ID",
+		apiTokenColumns,
+		apiTokensTable,
+	)
+	rows, err := db.conn().QueryContext(ctx, queryString, conversationID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	tokens := make([]*APIToken, 0)
+	for rows.Next() {
+		token, err := scanAPIToken(rows)
+		if err != nil {
+			return nil, err
+		}
+		tokens = append(tokens, token)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	db.logger(ctx).Debugf(`Read %d row(s) from "%s"`, len(tokens), apiTokensTable)
+	return tokens, nil
+}
+
+// DeleteAPIToken removes a row from the "api_tokens" table, which revokes the
+// token
+func (db *DB) DeleteAPIToken(ctx context.Context, id int64) error {
+	ctx, done := instrument(ctx, "DeleteAPIToken")
+	defer done()
+
+	queryString := fmt.Sprintf("DELETE FROM %s WHERE ID=?", apiTokensTable)
+	res, err := db.conn().ExecContext(ctx, queryString, id)
+	if err != nil {
+		return err
+	}
+
+	if rowCount, err := res.RowsAffected(); err == nil {
+		db.logger(ctx).Debugf(`Deleted %d row(s) from "%s"`, rowCount, apiTokensTable)
+	}
+	return nil
+}
@@ -0,0 +1,96 @@
+package models
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+)
+
+// Caret represents a member's last selection in a conversation's content,
+// as character offsets
+type Caret struct {
+	Start int `json:"start"`
+	End   int `json:"end"`
+}
+
+const caretsTable string = "conversation_carets"
+
+// SetCaret sets a member's caret in the "conversation_carets" table. Nothing is
+// stored if the user is not a member of the conversation.
+func (db *DB) SetCaret(ctx context.Context, userID, conversationID int64, caret Caret) error {
+	ctx, done := instrument(ctx, "SetCaret")
+	defer done()
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "INSERT INTO %s(UserID, ConversationID, CaretStart, CaretEnd) ", caretsTable)
+	fmt.Fprintf(&b, "SELECT UserID, ConversationID, ?, ? FROM %s ", mappingsTable)
+	fmt.Fprintf(&b, "WHERE UserID=? AND ConversationID=? ")
+	fmt.Fprintf(&b, "ON DUPLICATE KEY UPDATE CaretStart=VALUES(CaretStart), CaretEnd=VALUES(CaretEnd)")
+	res, err := db.conn().ExecContext(ctx, b.String(), caret.Start, caret.End, userID, conversationID)
+	if err != nil {
+		return err
+	}
+
+	if rowCount, err := res.RowsAffected(); err == nil {
+		db.logger(ctx).Debugf(`Updated %d row(s) in "%s"`, rowCount, caretsTable)
+	} else {
+		db.logger(ctx).Warnf("Failed to get number of rows affected: %v", err)
+	}
+	return nil
+}
+
+// GetCaret queries for a member's caret in the "conversation_carets" table,
+// returning nil if none has been stored
+func (db *DB) GetCaret(ctx context.Context, userID, conversationID int64) (*Caret, error) {
+	ctx, done := instrument(ctx, "GetCaret")
+	defer done()
+
+	caret := &Caret{}
+	queryString := fmt.Sprintf(
+		"SELECT CaretStart, CaretEnd FROM %s WHERE UserID=? AND ConversationID=?",
+		caretsTable,
+	)
+	err := db.conn().QueryRowContext(ctx, queryString, userID, conversationID).Scan(
+		&caret.Start,
+		&caret.End,
+	)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+		return nil, err
+	}
+	db.logger(ctx).Debugf(`Read 1 row from "%s"`, caretsTable)
+	return caret, nil
+}
+
+// ShiftCarets moves every caret in a conversation that is at or after position
+// by shift characters, without moving any before position, to account for an
+// edit at position that changed the content's length by shift
+func (db *DB) ShiftCarets(ctx context.Context, conversationID int64, position, shift int) error {
+	ctx, done := instrument(ctx, "ShiftCarets")
+	defer done()
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "UPDATE %s SET ", caretsTable)
+	fmt.Fprintf(&b, "CaretStart=IF(CaretStart >= ?, GREATEST(?, CaretStart + ?), CaretStart), ")
+	fmt.Fprintf(&b, "CaretEnd=IF(CaretEnd >= ?, GREATEST(?, CaretEnd + ?), CaretEnd) ")
+	fmt.Fprintf(&b, "WHERE ConversationID=?")
+	res, err := db.conn().ExecContext(ctx,
+		b.String(),
+		position, position, shift,
+		position, position, shift,
+		conversationID,
+	)
+	if err != nil {
+		return err
+	}
+
+	if rowCount, err := res.RowsAffected(); err == nil {
+		db.logger(ctx).Debugf(`Updated %d row(s) in "%s"`, rowCount, caretsTable)
+	} else {
+		db.logger(ctx).Warnf("Failed to get number of rows affected: %v", err)
+	}
+	return nil
+}
@@ -1,9 +1,9 @@
 package models
 
 import (
+	"context"
 	"database/sql"
 	"fmt"
-	"log"
 	"strings"
 )
 
@@ -14,6 +14,10 @@ type Conversation struct {
 	Description  *string `json:"description"`
 	AvatarURL    *string `json:"avatar_url"`
 	LastModified string  `json:"last_modified"`
+
+	// Unread is only set when listing a user's conversations, and is whether
+	// the conversation was modified after the user last opened it.
+	Unread *bool `json:"unread,omitempty"`
 }
 
 const (
@@ -47,8 +51,11 @@ func (c *Conversation) Merge(patch *Conversation) *Conversation {
 }
 
 // CreateConversation adds a row to the "conversations" table
-func (db *DB) CreateConversation(conversation *Conversation, creatorID int64) (int64, error) {
-	tx, err := db.Begin()
+func (db *DB) CreateConversation(ctx context.Context, conversation *Conversation, creatorID int64) (int64, error) {
+	ctx, done := instrument(ctx, "CreateConversation")
+	defer done()
+
+	tx, err := db.begin(ctx)
 	if err != nil {
 		return -1, err
 	}
@@ -56,14 +63,14 @@ func (db *DB) CreateConversation(conversation *Conversation, creatorID int64) (i
 	var b strings.Builder
 	fmt.Fprintf(&b, "INSERT INTO %s(Name, Description, AvatarURL) ", conversationsTable)
 	fmt.Fprintf(&b, "VALUES(?, ?, ?)")
-	res, err := tx.Exec(b.String(), conversation.Name, *conversation.Description, *conversation.AvatarURL)
+	res, err := tx.ExecContext(ctx, b.String(), conversation.Name, *conversation.Description, *conversation.AvatarURL)
 	if err != nil {
 		tx.Rollback()
 		return -1, err
 	}
 
 	if rowCount, err := res.RowsAffected(); err == nil {
-		log.Printf(`Created %d row(s) in "%s"`, rowCount, conversationsTable)
+		db.logger(ctx).Debugf(`Created %d row(s) in "%s"`, rowCount, conversationsTable)
 	} else {
 		tx.Rollback()
 		return -1, err
@@ -78,14 +85,14 @@ func (db *DB) CreateConversation(conversation *Conversation, creatorID int64) (i
 	b.Reset()
 	fmt.Fprintf(&b, "INSERT INTO %s(UserID, ConversationID, Role, Nickname, Pending) ", mappingsTable)
 	fmt.Fprintf(&b, "VALUES(?, ?, ?, ?, ?)")
-	res, err = tx.Exec(b.String(), creatorID, conversationID, Owner, "", 0)
+	res, err = tx.ExecContext(ctx, b.String(), creatorID, conversationID, Owner, "", 0)
 	if err != nil {
 		tx.Rollback()
 		return -1, err
 	}
 
 	if rowCount, err := res.RowsAffected(); err == nil {
-		log.Printf(`Created %d row(s) in "%s"`, rowCount, mappingsTable)
+		db.logger(ctx).Debugf(`Created %d row(s) in "%s"`, rowCount, mappingsTable)
 	} else {
 		tx.Rollback()
 		return -1, err
@@ -99,30 +106,37 @@ func (db *DB) CreateConversation(conversation *Conversation, creatorID int64) (i
 }
 
 // GetConversation queries for a single row from the "conversations" table
-func (db *DB) GetConversation(id int64) (*Conversation, error) {
+func (db *DB) GetConversation(ctx context.Context, id int64) (*Conversation, error) {
+	ctx, done := instrument(ctx, "GetConversation")
+	defer done()
+
 	conversation := &Conversation{}
 	queryString := fmt.Sprintf("SELECT * FROM %s WHERE ID=?", conversationsTable)
-	err := db.QueryRow(queryString, id).Scan(&(conversation.ID), &(conversation.Name), &(conversation.Description), &(conversation.AvatarURL), &(conversation.LastModified))
+	err := db.conn().QueryRowContext(ctx, queryString, id).Scan(&(conversation.ID), &(conversation.Name), &(conversation.Description), &(conversation.AvatarURL), &(conversation.LastModified))
 	if err != nil {
 		if err == sql.ErrNoRows {
 			return nil, nil
 		}
 		return nil, err
 	}
-	log.Printf(`Read 1 row from "%s"`, conversationsTable)
+	db.logger(ctx).Debugf(`Read 1 row from "%s"`, conversationsTable)
 	return conversation, nil
 }
 
 // GetConversations returns all conversations of a user
-func (db *DB) GetConversations(userID int64, sort string) ([]Conversation, error) {
+func (db *DB) GetConversations(ctx context.Context, userID int64, sort string) ([]Conversation, error) {
+	ctx, done := instrument(ctx, "GetConversations")
+	defer done()
+
 	var queryString strings.Builder
-	fmt.Fprintf(&queryString, "SELECT c.ID, c.Name, c.Description, c.AvatarURL, c.LastModified ")
+	fmt.Fprintf(&queryString, "SELECT c.ID, c.Name, c.Description, c.AvatarURL, c.LastModified, ")
+	fmt.Fprintf(&queryString, "c.LastModified > m.LastOpened ")
 	fmt.Fprintf(&queryString, "FROM %s AS c JOIN %s AS m ON c.ID = m.ConversationID ", conversationsTable, mappingsTable)
 	fmt.Fprintf(&queryString, "WHERE UserID=? ORDER BY c.LastModified ")
 	if sort == "desc" || sort == "" {
 		fmt.Fprintf(&queryString, "DESC")
 	}
-	rows, err := db.Query(queryString.String(), userID)
+	rows, err := db.conn().QueryContext(ctx, queryString.String(), userID)
 
 	if err != nil {
 		return nil, err
@@ -130,22 +144,31 @@ func (db *DB) GetConversations(userID int64, sort string) ([]Conversation, error
 	defer rows.Close()
 
 	// Create list of conversations
-	c := Conversation{}
 	conversations := make([]Conversation, 0)
 	for rows.Next() {
-		err := rows.Scan(&c.ID, &c.Name, &c.Description, &c.AvatarURL, &c.LastModified)
+		c := Conversation{}
+		var tmpUnread int8
+		err := rows.Scan(&c.ID, &c.Name, &c.Description, &c.AvatarURL, &c.LastModified, &tmpUnread)
 		if err != nil {
 			return nil, err
 		}
+		var unread bool = tmpUnread == 1
+		c.Unread = &unread
 		conversations = append(conversations, c)
 	}
 
-	log.Printf("Read %d row(s)", len(conversations))
+	db.logger(ctx).Debugf("Read %d row(s)", len(conversations))
 	return conversations, err
 }
 
-// UpdateConversation updates an existing row in the "conversations" table
-func (db *DB) UpdateConversation(conversation *Conversation) error {
+// UpdateConversation updates an existing row in the "conversations" table. If
+// previous is not nil, the row is only updated if its name, description and
+// avatar URL are still those of previous, and ErrModified is returned if they
+// are not.
+func (db *DB) UpdateConversation(ctx context.Context, conversation, previous *Conversation) error {
+	ctx, done := instrument(ctx, "UpdateConversation")
+	defer done()
+
 	if conversation.Name == "" && conversation.Description == nil && conversation.AvatarURL == nil {
 		return nil
 	}
@@ -153,68 +176,167 @@ func (db *DB) UpdateConversation(conversation *Conversation) error {
 	var b strings.Builder
 	fmt.Fprintf(&b, "UPDATE %s SET ", conversationsTable)
 	fmt.Fprintf(&b, "Name=?, Description=?, AvatarURL=? WHERE ID=?")
-	res, err := db.Exec(b.String(), conversation.Name, *conversation.Description, *conversation.AvatarURL, conversation.ID)
+	args := []interface{}{conversation.Name, *conversation.Description, *conversation.AvatarURL, conversation.ID}
+	if previous != nil {
+		fmt.Fprintf(&b, " AND Name<=>? AND Description<=>? AND AvatarURL<=>?")
+		args = append(args, previous.Name, previous.Description, previous.AvatarURL)
+	}
+	res, err := db.conn().ExecContext(ctx, b.String(), args...)
 	if err != nil {
 		return err
 	}
 
-	if rowCount, err := res.RowsAffected(); err == nil {
-		log.Printf(`Updated %d row(s) in "%s"`, rowCount, conversationsTable)
-	} else {
-		log.Println("Failed to get number of rows affected: " + err.Error())
+	rowCount, err := res.RowsAffected()
+	if err != nil {
+		db.logger(ctx).Warnf("Failed to get number of rows affected: %v", err)
+		return nil
+	}
+	db.logger(ctx).Debugf(`Updated %d row(s) in "%s"`, rowCount, conversationsTable)
+	if rowCount > 0 || previous == nil {
+		return nil
+	}
+
+	// No rows are affected either if the row was modified or if it already
+	// had the new values
+	var count int
+	var c strings.Builder
+	fmt.Fprintf(&c, "SELECT COUNT(*) FROM %s ", conversationsTable)
+	fmt.Fprintf(&c, "WHERE ID=? AND Name<=>? AND Description<=>? AND AvatarURL<=>?")
+	err = db.conn().QueryRowContext(ctx,
+		c.String(),
+		conversation.ID,
+		conversation.Name,
+		conversation.Description,
+		conversation.AvatarURL,
+	).Scan(&count)
+	if err != nil {
+		return err
+	}
+	if count == 0 {
+		return ErrModified
 	}
 	return nil
 }
 
 // TouchConversation sets the LastModified value of a "conversations" table row
 // to the current time.
-func (db *DB) TouchConversation(conversationID int64) error {
+func (db *DB) TouchConversation(ctx context.Context, conversationID int64) error {
+	ctx, done := instrument(ctx, "TouchConversation")
+	defer done()
+
 	var b strings.Builder
 	fmt.Fprintf(&b, "UPDATE %s SET ", conversationsTable)
 	fmt.Fprintf(&b, "LastModified=NOW() WHERE ID=?")
-	res, err := db.Exec(b.String(), conversationID)
+	res, err := db.conn().ExecContext(ctx, b.String(), conversationID)
 	if err != nil {
 		return err
 	}
 
 	if rowCount, err := res.RowsAffected(); err == nil {
-		log.Printf(`Updated %d row(s) in "%s"`, rowCount, conversationsTable)
+		db.logger(ctx).Debugf(`Updated %d row(s) in "%s"`, rowCount, conversationsTable)
 	} else {
-		log.Println("Failed to get number of rows affected: " + err.Error())
+		db.logger(ctx).Warnf("Failed to get number of rows affected: %v", err)
 	}
 	return nil
 }
 
-// DeleteConversation removes a row from the "conversations" table
-func (db *DB) DeleteConversation(id int64) error {
-	tx, err := db.Begin()
+// GetUnreadCount returns the number of conversations that a user has accepted
+// an invitation to that were modified after the user last opened them
+func (db *DB) GetUnreadCount(ctx context.Context, userID int64) (int, error) {
+	ctx, done := instrument(ctx, "GetUnreadCount")
+	defer done()
+
+	var queryString strings.Builder
+	fmt.Fprintf(&queryString, "SELECT COUNT(*) ")
+	fmt.Fprintf(&queryString, "FROM %s AS c JOIN %s AS m ON c.ID = m.ConversationID ", conversationsTable, mappingsTable)
+	fmt.Fprintf(&queryString, "WHERE m.UserID=? AND m.Pending=0 AND c.LastModified > m.LastOpened")
+
+	var count int
+	if err := db.conn().QueryRowContext(ctx, queryString.String(), userID).Scan(&count); err != nil {
+		return 0, err
+	}
+	db.logger(ctx).Debugf(`Read 1 row from "%s"`, conversationsTable)
+	return count, nil
+}
+
+// CountOwnedConversations returns the number of conversations that a user
+// owns. In a transaction, the user's ownerships are locked until it ends.
+func (db *DB) CountOwnedConversations(ctx context.Context, userID int64) (int, error) {
+	ctx, done := instrument(ctx, "CountOwnedConversations")
+	defer done()
+
+	queryString := fmt.Sprintf("SELECT COUNT(*) FROM %s WHERE UserID=? AND Role=?%s", mappingsTable, db.forUpdate())
+
+	var count int
+	if err := db.conn().QueryRowContext(ctx, queryString, userID, Owner).Scan(&count); err != nil {
+		return 0, err
+	}
+	db.logger(ctx).Debugf(`Read 1 row from "%s"`, mappingsTable)
+	return count, nil
+}
+
+// DeleteConversation removes a row from the "conversations" table along with
+// the rows of every other table that belong to the conversation, including its
+// webhooks and their delivery logs
+func (db *DB) DeleteConversation(ctx context.Context, id int64) error {
+	ctx, done := instrument(ctx, "DeleteConversation")
+	defer done()
+
+	tx, err := db.begin(ctx)
 	if err != nil {
 		return err
 	}
 
-	queryString := fmt.Sprintf("DELETE FROM %s WHERE ConversationID=?", mappingsTable)
-	res, err := tx.Exec(queryString, id)
+	var b strings.Builder
+	fmt.Fprintf(&b, "DELETE FROM %s WHERE WebhookID IN ", webhookDeliveriesTable)
+	fmt.Fprintf(&b, "(SELECT ID FROM %s WHERE ConversationID=?)", webhooksTable)
+	res, err := tx.ExecContext(ctx, b.String(), id)
 	if err != nil {
 		tx.Rollback()
 		return err
 	}
-
 	if rowCount, err := res.RowsAffected(); err == nil {
-		log.Printf(`Deleted %d row(s) from "%s"`, rowCount, mappingsTable)
+		db.logger(ctx).Debugf(`Deleted %d row(s) from "%s"`, rowCount, webhookDeliveriesTable)
 	} else {
 		tx.Rollback()
 		return err
 	}
 
-	queryString = fmt.Sprintf("DELETE FROM %s WHERE ID=?", conversationsTable)
-	res, err = tx.Exec(queryString, id)
+	for _, table := range []string{
+		apiTokensTable,
+		mappingsTable,
+		serviceAccountsTable,
+		searchTable,
+		caretsTable,
+		revisionsTable,
+		snapshotsTable,
+		editBatchesTable,
+		webhooksTable,
+	} {
+		queryString := fmt.Sprintf("DELETE FROM %s WHERE ConversationID=?", table)
+		res, err := tx.ExecContext(ctx, queryString, id)
+		if err != nil {
+			tx.Rollback()
+			return err
+		}
+
+		if rowCount, err := res.RowsAffected(); err == nil {
+			db.logger(ctx).Debugf(`Deleted %d row(s) from "%s"`, rowCount, table)
+		} else {
+			tx.Rollback()
+			return err
+		}
+	}
+
+	queryString := fmt.Sprintf("DELETE FROM %s WHERE ID=?", conversationsTable)
+	res, err = tx.ExecContext(ctx, queryString, id)
 	if err != nil {
 		tx.Rollback()
 		return err
 	}
 
 	if rowCount, err := res.RowsAffected(); err == nil {
-		log.Printf(`Deleted %d row(s) from "%s"`, rowCount, conversationsTable)
+		db.logger(ctx).Debugf(`Deleted %d row(s) from "%s"`, rowCount, conversationsTable)
 	} else {
 		tx.Rollback()
 		return err
@@ -1,38 +1,192 @@
 package models
 
 import (
+	"context"
 	"database/sql"
+	"errors"
+	"ether/logging"
+	"ether/metrics"
+	"ether/tracing"
 	"fmt"
 	"io/ioutil"
 	"strings"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 
 	// MySQL database driver
 	_ "github.com/go-sql-driver/mysql"
 )
 
+// TimeLayout is the layout of the times in the database, such as a
+// conversation's LastModified, which are in UTC
+const TimeLayout = "2006-01-02 15:04:05"
+
+// ErrModified is returned by a conditional update if the row no longer has the
+// values that it was read with
+var ErrModified = errors.New("Row has been modified since it was read")
+
 // Datastore defines the CRUD operations of models in the database
 type Datastore interface {
-	CreateConversation(conversation *Conversation, creatorID int64) (int64, error)
-	GetConversation(id int64) (*Conversation, error)
-	GetConversations(userID int64, sort string) ([]Conversation, error)
-	UpdateConversation(conversation *Conversation) error
-	TouchConversation(conversationID int64) error
-	DeleteConversation(id int64) error
-
-	CreateUserConversationMapping(mapping *UserConversationMapping) error
-	GetUserConversationMapping(userID, conversationID int64) (*UserConversationMapping, error)
-	GetUserConversationMappings(conversationID int64) ([]*UserConversationMapping, error)
-	UpdateUserConversationMapping(mapping *UserConversationMapping) error
-	DeleteUserConversationMapping(userID, conversationID int64) error
+	CreateConversation(ctx context.Context, conversation *Conversation, creatorID int64) (int64, error)
+	GetConversation(ctx context.Context, id int64) (*Conversation, error)
+	GetConversations(ctx context.Context, userID int64, sort string) ([]Conversation, error)
+	UpdateConversation(ctx context.Context, conversation, previous *Conversation) error
+	TouchConversation(ctx context.Context, conversationID int64) error
+	GetUnreadCount(ctx context.Context, userID int64) (int, error)
+	CountOwnedConversations(ctx context.Context, userID int64) (int, error)
+	DeleteConversation(ctx context.Context, id int64) error
+
+	CreateUserConversationMapping(ctx context.Context, mapping *UserConversationMapping) error
+	GetUserConversationMapping(ctx context.Context, userID, conversationID int64) (*UserConversationMapping, error)
+	GetUserConversationMappings(ctx context.Context, conversationID int64) ([]*UserConversationMapping, error)
+	CountUserConversationMappings(ctx context.Context, conversationID int64) (int, error)
+	UpdateUserConversationMapping(ctx context.Context, mapping, previous *UserConversationMapping) error
+	TouchUserConversationMapping(ctx context.Context, userID, conversationID int64) error
+	DeleteUserConversationMapping(ctx context.Context, userID, conversationID int64) error
+	CreateUserConversationMappings(ctx context.Context, mappings []*UserConversationMapping) ([]error, error)
+	DeleteUserConversationMappings(ctx context.Context, userIDs []int64, conversationID int64) ([]bool, error)
+
+	SetCaret(ctx context.Context, userID, conversationID int64, caret Caret) error
+	GetCaret(ctx context.Context, userID, conversationID int64) (*Caret, error)
+	ShiftCarets(ctx context.Context, conversationID int64, position, shift int) error
+
+	CreateEvent(ctx context.Context, event *Event) error
+	GetEvents(ctx context.Context, conversationID int64, filter EventFilter) ([]*Event, error)
+
+	AddEdit(ctx context.Context, conversationID int64, userID *int64, at time.Time) error
+	GetDueEditBatches(ctx context.Context, idleBefore, startedBefore time.Time) ([]*EditBatch, error)
+	DeleteEditBatch(ctx context.Context, conversationID int64, userID *int64) error
+
+	CreateRevision(ctx context.Context, revision *Revision) error
+	GetLatestRevision(ctx context.Context, conversationID int64) (*Revision, error)
+	GetRevisions(ctx context.Context, conversationID int64) ([]*Revision, error)
+	GetRevisionsSinceReset(ctx context.Context, conversationID int64, limit int) ([]*Revision, error)
+	CreateSnapshot(ctx context.Context, snapshot *Snapshot) error
+	GetSnapshot(ctx context.Context, conversationID, version int64) (*Snapshot, error)
+
+	CreateServiceAccount(ctx context.Context, account *ServiceAccount) error
+	GetServiceAccount(ctx context.Context, id int64) (*ServiceAccount, error)
+	GetServiceAccounts(ctx context.Context, conversationID int64) ([]*ServiceAccount, error)
+	DeleteServiceAccount(ctx context.Context, id int64) error
+
+	CreateAPIToken(ctx context.Context, token *APIToken) error
+	GetAPIToken(ctx context.Context, id int64) (*APIToken, error)
+	GetAPITokenByHash(ctx context.Context, hash string) (*APIToken, error)
+	GetAPITokens(ctx context.Context, conversationID int64) ([]*APIToken, error)
+	DeleteAPIToken(ctx context.Context, id int64) error
+
+	CreateWebhook(ctx context.Context, webhook *Webhook) error
+	GetWebhook(ctx context.Context, id int64) (*Webhook, error)
+	GetWebhooks(ctx context.Context, conversationID int64) ([]*Webhook, error)
+	UpdateWebhook(ctx context.Context, webhook *Webhook) error
+	RecordWebhookFailure(ctx context.Context, id int64, disableAfter int) error
+	ResetWebhookFailures(ctx context.Context, id int64) error
+	DeleteWebhook(ctx context.Context, id int64) error
+	CreateWebhookDelivery(ctx context.Context, delivery *WebhookDelivery) error
+	GetWebhookDeliveries(ctx context.Context, webhookID int64, limit int) ([]*WebhookDelivery, error)
+
+	IndexConversationContent(ctx context.Context, conversationID int64, content string) error
+	SearchConversations(ctx context.Context, userID int64, terms []string) ([]*SearchResult, error)
+
+	Transaction(ctx context.Context, fn func(tx Datastore) error) error
 }
 
 // DB represents an SQL database connection
 type DB struct {
 	*sql.DB
+	Logger *logging.Logger
+
+	// tx is the transaction that every query is made in, if the DB was given
+	// to a Transaction function
+	tx *sql.Tx
+}
+
+// querier represents what both a database connection and a transaction can
+// run queries with
+type querier interface {
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+	QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row
+}
+
+// conn returns the transaction the DB is in, or the database connection if it
+// is not in one
+func (db *DB) conn() querier {
+	if db.tx != nil {
+		return db.tx
+	}
+	return db.DB
+}
+
+// forUpdate returns the clause that locks the rows read by a SELECT until the
+// end of the transaction the DB is in, or nothing if it is not in one
+func (db *DB) forUpdate() string {
+	if db.tx != nil {
+		return " FOR UPDATE"
+	}
+	return ""
+}
+
+// txn represents a transaction started by a Datastore method. If the DB was
+// already in a transaction, the method's queries are made in it, and it is
+// committed or rolled back by whoever started it instead.
+type txn struct {
+	*sql.Tx
+	nested bool
+}
+
+// Commit commits the transaction, unless it is nested
+func (t *txn) Commit() error {
+	if t.nested {
+		return nil
+	}
+	return t.Tx.Commit()
+}
+
+// Rollback rolls back the transaction, unless it is nested, in which case the
+// error that made the method roll back leads to it being rolled back later
+func (t *txn) Rollback() error {
+	if t.nested {
+		return nil
+	}
+	return t.Tx.Rollback()
+}
+
+// begin starts a transaction for a Datastore method
+func (db *DB) begin(ctx context.Context) (*txn, error) {
+	if db.tx != nil {
+		return &txn{Tx: db.tx, nested: true}, nil
+	}
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	return &txn{Tx: tx}, nil
+}
+
+// Transaction calls fn with a Datastore whose operations are all made in a
+// single transaction, which is committed if fn returns nil and rolled back
+// otherwise. Transactions started within fn are part of the same transaction.
+func (db *DB) Transaction(ctx context.Context, fn func(tx Datastore) error) error {
+	if db.tx != nil {
+		return fn(db)
+	}
+
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	if err := fn(&DB{DB: db.DB, Logger: db.Logger, tx: tx}); err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
 }
 
 // NewDB initializes a new DB
-func NewDB(dataSourceName string) (*DB, error) {
+func NewDB(dataSourceName string, logger *logging.Logger) (*DB, error) {
 	db, err := sql.Open("mysql", dataSourceName)
 	if err != nil {
 		return nil, err
@@ -60,7 +214,31 @@ func NewDB(dataSourceName string) (*DB, error) {
 	if err = db.Ping(); err != nil {
 		return nil, err
 	}
-	return &DB{db}, nil
+	return &DB{DB: db, Logger: logger}, nil
+}
+
+// logger returns the DB's logger with the request ID carried by ctx
+func (db *DB) logger(ctx context.Context) *logging.Logger {
+	return db.Logger.WithContext(ctx)
+}
+
+// instrument starts a trace span for a Datastore method and returns a function
+// that ends the span and records the latency of the method
+func instrument(ctx context.Context, method string) (context.Context, func()) {
+	start := time.Now()
+	ctx, span := tracing.Tracer().Start(
+		ctx,
+		"Datastore."+method,
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(
+			attribute.String("db.system", "mysql"),
+			attribute.String("db.operation", method),
+		),
+	)
+	return ctx, func() {
+		span.End()
+		metrics.DBQueryDuration.WithLabelValues(method).Observe(time.Since(start).Seconds())
+	}
 }
 
 // setupDB creates the necessary "ether" database and tables if they don't
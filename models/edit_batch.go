@@ -0,0 +1,123 @@
+package models
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// EditBatch represents consecutive edits to a conversation's content by one
+// user that have not been recorded as a content_edited Event yet. UserID is
+// nil for edits without an author. FirstEditAt and LastEditAt are in UTC.
+type EditBatch struct {
+	ConversationID int64
+	UserID         *int64
+	Edits          int
+	FirstEditAt    time.Time
+	LastEditAt     time.Time
+}
+
+const editBatchesTable string = "conversation_edit_batches"
+
+// editBatchUserID converts the author of an EditBatch to its UserID column,
+// which is 0 for edits without an author
+func editBatchUserID(userID *int64) int64 {
+	if userID == nil {
+		return 0
+	}
+	return *userID
+}
+
+// AddEdit adds an edit by a user, which may be nil, to the user's batch of
+// edits to a conversation in the "conversation_edit_batches" table, starting
+// a batch if there is none
+func (db *DB) AddEdit(ctx context.Context, conversationID int64, userID *int64, at time.Time) error {
+	ctx, done := instrument(ctx, "AddEdit")
+	defer done()
+
+	editAt := at.UTC().Format(TimeLayout)
+	var b strings.Builder
+	fmt.Fprintf(&b, "INSERT INTO %s(ConversationID, UserID, Edits, FirstEditAt, LastEditAt) ", editBatchesTable)
+	fmt.Fprintf(&b, "VALUES(?, ?, 1, ?, ?) ")
+	fmt.Fprintf(&b, "ON DUPLICATE KEY UPDATE Edits=Edits+1, LastEditAt=VALUES(LastEditAt)")
+	res, err := db.conn().ExecContext(ctx, b.String(), conversationID, editBatchUserID(userID), editAt, editAt)
+	if err != nil {
+		return err
+	}
+
+	if rowCount, err := res.RowsAffected(); err == nil {
+		db.logger(ctx).Debugf(`Updated %d row(s) in "%s"`, rowCount, editBatchesTable)
+	} else {
+		db.logger(ctx).Warnf("Failed to get number of rows affected: %v", err)
+	}
+	return nil
+}
+
+// GetDueEditBatches queries for the rows in the "conversation_edit_batches"
+// table whose last edit was at or before idleBefore or whose first edit was
+// at or before startedBefore. In a transaction, the rows are locked until it
+// ends, so that they are only recorded once.
+func (db *DB) GetDueEditBatches(ctx context.Context, idleBefore, startedBefore time.Time) ([]*EditBatch, error) {
+	ctx, done := instrument(ctx, "GetDueEditBatches")
+	defer done()
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "SELECT ConversationID, UserID, Edits, FirstEditAt, LastEditAt FROM %s ", editBatchesTable)
+	fmt.Fprintf(&b, "WHERE LastEditAt<=? OR FirstEditAt<=?%s", db.forUpdate())
+	rows, err := db.conn().QueryContext(ctx,
+		b.String(),
+		idleBefore.UTC().Format(TimeLayout),
+		startedBefore.UTC().Format(TimeLayout),
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	batches := make([]*EditBatch, 0)
+	for rows.Next() {
+		batch := &EditBatch{}
+		var userID int64
+		var firstEditAt, lastEditAt string
+		err := rows.Scan(&batch.ConversationID, &userID, &batch.Edits, &firstEditAt, &lastEditAt)
+		if err != nil {
+			return nil, err
+		}
+		if userID != 0 {
+			batch.UserID = &userID
+		}
+		if batch.FirstEditAt, err = time.Parse(TimeLayout, firstEditAt); err != nil {
+			return nil, err
+		}
+		if batch.LastEditAt, err = time.Parse(TimeLayout, lastEditAt); err != nil {
+			return nil, err
+		}
+		batches = append(batches, batch)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	db.logger(ctx).Debugf(`Read %d row(s) from "%s"`, len(batches), editBatchesTable)
+	return batches, nil
+}
+
+// DeleteEditBatch removes a user's batch of edits to a conversation from the
+// "conversation_edit_batches" table
+func (db *DB) DeleteEditBatch(ctx context.Context, conversationID int64, userID *int64) error {
+	ctx, done := instrument(ctx, "DeleteEditBatch")
+	defer done()
+
+	queryString := fmt.Sprintf("DELETE FROM %s WHERE ConversationID=? AND UserID=?", editBatchesTable)
+	res, err := db.conn().ExecContext(ctx, queryString, conversationID, editBatchUserID(userID))
+	if err != nil {
+		return err
+	}
+
+	if rowCount, err := res.RowsAffected(); err == nil {
+		db.logger(ctx).Debugf(`Deleted %d row(s) from "%s"`, rowCount, editBatchesTable)
+	} else {
+		db.logger(ctx).Warnf("Failed to get number of rows affected: %v", err)
+	}
+	return nil
+}
@@ -0,0 +1,221 @@
+package models
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// Event represents an entry in a conversation's append-only activity log
+type Event struct {
+	ID             int64           `json:"id"`
+	ConversationID int64           `json:"conversation_id"`
+	ActorID        *int64          `json:"actor_id"`
+	Action         EventAction     `json:"action"`
+	TargetID       *int64          `json:"target_id,omitempty"`
+	Before         json.RawMessage `json:"before,omitempty"`
+	After          json.RawMessage `json:"after,omitempty"`
+	CreatedAt      string          `json:"created_at"`
+
+	// Webhooks, if not nil, are the webhooks that the event is sent to instead
+	// of its conversation's current ones, which is how a conversation_deleted
+	// event reaches the webhooks that were deleted with the conversation.
+	Webhooks []*Webhook `json:"-"`
+}
+
+// EventList represents a page of a conversation's activity log
+type EventList struct {
+	Events     []*Event `json:"events"`
+	NextCursor *int64   `json:"next_cursor,omitempty"`
+}
+
+// EventNotifier represents something that is told about events once they have
+// been recorded, such as the webhooks of their conversations
+type EventNotifier interface {
+	Notify(event *Event)
+}
+
+// ContentEditValues represents the value after a content_edited Event, which
+// summarizes a batch of edits
+type ContentEditValues struct {
+	Edits       int    `json:"edits"`
+	FirstEditAt string `json:"first_edit_at"`
+	LastEditAt  string `json:"last_edit_at"`
+}
+
+// EventFilter represents which events to read from a conversation's activity
+// log. Events are read newest first, starting before the event with ID
+// Cursor if it is not 0, and only with one of Actions if there are any.
+type EventFilter struct {
+	Actions []EventAction
+	Cursor  int64
+	Limit   int
+}
+
+// EventAction represents the kind of change an Event records
+type EventAction string
+
+const (
+	// EventConversationCreated records a conversation being created
+	EventConversationCreated EventAction = "conversation_created"
+
+	// EventConversationUpdated records a conversation's metadata changing
+	EventConversationUpdated EventAction = "conversation_updated"
+
+	// EventConversationDeleted records a conversation being deleted
+	EventConversationDeleted EventAction = "conversation_deleted"
+
+	// EventMemberAdded records a user being added to a conversation
+	EventMemberAdded EventAction = "member_added"
+
+	// EventMemberUpdated records a member's role, nickname or invitation
+	// status changing
+	EventMemberUpdated EventAction = "member_updated"
+
+	// EventMemberRemoved records a user leaving or being removed from a
+	// conversation
+	EventMemberRemoved EventAction = "member_removed"
+
+	// EventContentEdited records a batch of consecutive edits to a
+	// conversation's content by one user
+	EventContentEdited EventAction = "content_edited"
+
+	// EventTokenCreated records an API token being issued to a service
+	// account
+	EventTokenCreated EventAction = "token_created"
+
+	// EventTokenRevoked records an API token of a service account being
+	// revoked
+	EventTokenRevoked EventAction = "token_revoked"
+
+	eventsTable string = "conversation_events"
+)
+
+// Valid checks if an EventAction is one of the known actions
+func (a EventAction) Valid() bool {
+	switch a {
+	case EventConversationCreated, EventConversationUpdated, EventConversationDeleted,
+		EventMemberAdded, EventMemberUpdated, EventMemberRemoved, EventContentEdited,
+		EventTokenCreated, EventTokenRevoked:
+		return true
+	}
+	return false
+}
+
+// EventValue encodes the value of something before or after an Event as JSON,
+// returning nil if v is nil or cannot be encoded
+func EventValue(v interface{}) json.RawMessage {
+	if v == nil {
+		return nil
+	}
+	b, err := json.Marshal(v)
+	if err != nil {
+		return nil
+	}
+	return b
+}
+
+// nullableJSON converts a JSON value to a value that is NULL when empty
+func nullableJSON(value json.RawMessage) sql.NullString {
+	return sql.NullString{String: string(value), Valid: len(value) > 0}
+}
+
+// CreateEvent adds a row to the "conversation_events" table
+func (db *DB) CreateEvent(ctx context.Context, event *Event) error {
+	ctx, done := instrument(ctx, "CreateEvent")
+	defer done()
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "INSERT INTO %s(ConversationID, ActorID, Action, TargetID, BeforeValue, AfterValue) ", eventsTable)
+	fmt.Fprintf(&b, "VALUES(?, ?, ?, ?, ?, ?)")
+	res, err := db.conn().ExecContext(ctx,
+		b.String(),
+		event.ConversationID,
+		event.ActorID,
+		event.Action,
+		event.TargetID,
+		nullableJSON(event.Before),
+		nullableJSON(event.After),
+	)
+	if err != nil {
+		return err
+	}
+
+	if id, err := res.LastInsertId(); err == nil {
+		event.ID = id
+	}
+	db.logger(ctx).Debugf(`Created 1 row in "%s"`, eventsTable)
+	return nil
+}
+
+// GetEvents queries for the rows in the "conversation_events" table with a
+// given ConversationID that match a filter, newest first
+func (db *DB) GetEvents(ctx context.Context, conversationID int64, filter EventFilter) ([]*Event, error) {
+	ctx, done := instrument(ctx, "GetEvents")
+	defer done()
+
+	args := []interface{}{conversationID}
+	var b strings.Builder
+	fmt.Fprintf(&b, "SELECT ID, ConversationID, ActorID, Action, TargetID, BeforeValue, AfterValue, CreatedAt ")
+	fmt.Fprintf(&b, "FROM %s WHERE ConversationID=? ", eventsTable)
+	if filter.Cursor != 0 {
+		fmt.Fprintf(&b, "AND ID < ? ")
+		args = append(args, filter.Cursor)
+	}
+	if len(filter.Actions) > 0 {
+		placeholders := make([]string, len(filter.Actions))
+		for i, action := range filter.Actions {
+			placeholders[i] = "?"
+			args = append(args, action)
+		}
+		fmt.Fprintf(&b, "AND Action IN (%s) ", strings.Join(placeholders, ", "))
+	}
+	fmt.Fprintf(&b, "ORDER BY ID DESC LIMIT ?")
+	args = append(args, filter.Limit)
+
+	rows, err := db.conn().QueryContext(ctx, b.String(), args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	events := make([]*Event, 0)
+	for rows.Next() {
+		event := &Event{}
+		var actorID, targetID sql.NullInt64
+		var before, after sql.NullString
+		err := rows.Scan(
+			&event.ID,
+			&event.ConversationID,
+			&actorID,
+			&event.Action,
+			&targetID,
+			&before,
+			&after,
+			&event.CreatedAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+		if actorID.Valid {
+			event.ActorID = &actorID.Int64
+		}
+		if targetID.Valid {
+			event.TargetID = &targetID.Int64
+		}
+		if before.Valid {
+			event.Before = json.RawMessage(before.String)
+		}
+		if after.Valid {
+			event.After = json.RawMessage(after.String)
+		}
+		events = append(events, event)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	db.logger(ctx).Debugf(`Read %d row(s) from "%s"`, len(events), eventsTable)
+	return events, nil
+}
@@ -1,12 +1,28 @@
 package models
 
 import (
+	"context"
+	"reflect"
 	"sort"
+	"strings"
+	"time"
+
+	"github.com/go-sql-driver/mysql"
 )
 
 type MockDB struct {
 	Conversations   map[int64]*Conversation
 	Mappings        map[int64]map[int64]*UserConversationMapping
+	SearchIndex     map[int64]string
+	Carets          map[int64]map[int64]*Caret
+	Events          []*Event
+	EditBatches     []*EditBatch
+	Revisions       map[int64][]*Revision
+	Snapshots       map[int64][]*Snapshot
+	ServiceAccounts map[int64]*ServiceAccount
+	APITokens       map[int64]*APIToken
+	Webhooks        map[int64]*Webhook
+	Deliveries      []*WebhookDelivery
 	Errors          []error
 	Count           int
 	AutoIncrementID int64
@@ -20,6 +36,13 @@ func NewMockDB(
 	db := &MockDB{
 		Conversations:   make(map[int64]*Conversation),
 		Mappings:        make(map[int64]map[int64]*UserConversationMapping),
+		SearchIndex:     make(map[int64]string),
+		Carets:          make(map[int64]map[int64]*Caret),
+		Revisions:       make(map[int64][]*Revision),
+		Snapshots:       make(map[int64][]*Snapshot),
+		ServiceAccounts: make(map[int64]*ServiceAccount),
+		APITokens:       make(map[int64]*APIToken),
+		Webhooks:        make(map[int64]*Webhook),
 		Errors:          errors,
 		Count:           0,
 		AutoIncrementID: 0,
@@ -65,7 +88,7 @@ func (db *MockDB) SetMapping(userID, conversationID int64, mapping *UserConversa
 	db.Mappings[conversationID][userID] = mapping
 }
 
-func (db *MockDB) CreateConversation(conversation *Conversation, creatorID int64) (int64, error) {
+func (db *MockDB) CreateConversation(ctx context.Context, conversation *Conversation, creatorID int64) (int64, error) {
 	if err := db.getError(); err != nil {
 		return -1, err
 	}
@@ -76,14 +99,14 @@ func (db *MockDB) CreateConversation(conversation *Conversation, creatorID int64
 	return conversation.ID, nil
 }
 
-func (db *MockDB) GetConversation(id int64) (*Conversation, error) {
+func (db *MockDB) GetConversation(ctx context.Context, id int64) (*Conversation, error) {
 	if err := db.getError(); err != nil {
 		return nil, err
 	}
 	return db.Conversations[id], nil
 }
 
-func (db *MockDB) GetConversations(id int64, sortBy string) ([]Conversation, error) {
+func (db *MockDB) GetConversations(ctx context.Context, id int64, sortBy string) ([]Conversation, error) {
 	if err := db.getError(); err != nil {
 		return nil, err
 	}
@@ -91,9 +114,14 @@ func (db *MockDB) GetConversations(id int64, sortBy string) ([]Conversation, err
 	var m = db.Mappings
 	var usersConversations []Conversation
 	for c, umap := range m {
-		for u := range umap {
+		for u, mapping := range umap {
 			if u == id {
-				usersConversations = append(usersConversations, *db.Conversations[c])
+				conversation := *db.Conversations[c]
+				if mapping != nil {
+					var unread bool = conversation.LastModified > mapping.LastOpened
+					conversation.Unread = &unread
+				}
+				usersConversations = append(usersConversations, conversation)
 			}
 		}
 	}
@@ -104,27 +132,76 @@ func (db *MockDB) GetConversations(id int64, sortBy string) ([]Conversation, err
 	return usersConversations, nil
 }
 
-func (db *MockDB) UpdateConversation(conversation *Conversation) error {
+func (db *MockDB) UpdateConversation(ctx context.Context, conversation, previous *Conversation) error {
 	if err := db.getError(); err != nil {
 		return err
 	}
+	if current := db.Conversations[conversation.ID]; previous != nil && current != nil &&
+		(current.Name != previous.Name ||
+			!reflect.DeepEqual(current.Description, previous.Description) ||
+			!reflect.DeepEqual(current.AvatarURL, previous.AvatarURL)) {
+		return ErrModified
+	}
 	db.Conversations[conversation.ID] = conversation
 	return nil
 }
 
-func (db *MockDB) TouchConversation(conversationID int64) error {
+func (db *MockDB) TouchConversation(ctx context.Context, conversationID int64) error {
 	return db.getError()
 }
 
-func (db *MockDB) DeleteConversation(id int64) error {
+func (db *MockDB) GetUnreadCount(ctx context.Context, userID int64) (int, error) {
+	if err := db.getError(); err != nil {
+		return 0, err
+	}
+	count := 0
+	for conversationID, conversation := range db.Conversations {
+		mapping := db.GetMapping(userID, conversationID)
+		if conversation == nil || mapping == nil || mapping.Pending != nil && *mapping.Pending {
+			continue
+		}
+		if conversation.LastModified > mapping.LastOpened {
+			count++
+		}
+	}
+	return count, nil
+}
+
+func (db *MockDB) CountOwnedConversations(ctx context.Context, userID int64) (int, error) {
+	if err := db.getError(); err != nil {
+		return 0, err
+	}
+	count := 0
+	for conversationID, conversation := range db.Conversations {
+		mapping := db.GetMapping(userID, conversationID)
+		if conversation != nil && mapping != nil && mapping.Role == Owner {
+			count++
+		}
+	}
+	return count, nil
+}
+
+func (db *MockDB) DeleteConversation(ctx context.Context, id int64) error {
 	if err := db.getError(); err != nil {
 		return err
 	}
 	db.Conversations[id] = nil
+	deliveries := make([]*WebhookDelivery, 0, len(db.Deliveries))
+	for _, delivery := range db.Deliveries {
+		if webhook := db.Webhooks[delivery.WebhookID]; webhook == nil || webhook.ConversationID != id {
+			deliveries = append(deliveries, delivery)
+		}
+	}
+	db.Deliveries = deliveries
+	for webhookID, webhook := range db.Webhooks {
+		if webhook.ConversationID == id {
+			delete(db.Webhooks, webhookID)
+		}
+	}
 	return nil
 }
 
-func (db *MockDB) CreateUserConversationMapping(mapping *UserConversationMapping) error {
+func (db *MockDB) CreateUserConversationMapping(ctx context.Context, mapping *UserConversationMapping) error {
 	if err := db.getError(); err != nil {
 		return err
 	}
@@ -132,14 +209,14 @@ func (db *MockDB) CreateUserConversationMapping(mapping *UserConversationMapping
 	return nil
 }
 
-func (db *MockDB) GetUserConversationMapping(userID, conversationID int64) (*UserConversationMapping, error) {
+func (db *MockDB) GetUserConversationMapping(ctx context.Context, userID, conversationID int64) (*UserConversationMapping, error) {
 	if err := db.getError(); err != nil {
 		return nil, err
 	}
 	return db.GetMapping(userID, conversationID), nil
 }
 
-func (db *MockDB) GetUserConversationMappings(conversationID int64) ([]*UserConversationMapping, error) {
+func (db *MockDB) GetUserConversationMappings(ctx context.Context, conversationID int64) ([]*UserConversationMapping, error) {
 	if err := db.getError(); err != nil {
 		return nil, err
 	}
@@ -154,18 +231,570 @@ func (db *MockDB) GetUserConversationMappings(conversationID int64) ([]*UserConv
 	return res, nil
 }
 
-func (db *MockDB) UpdateUserConversationMapping(mapping *UserConversationMapping) error {
+func (db *MockDB) CountUserConversationMappings(ctx context.Context, conversationID int64) (int, error) {
+	if err := db.getError(); err != nil {
+		return 0, err
+	}
+	return len(db.Mappings[conversationID]), nil
+}
+
+func (db *MockDB) UpdateUserConversationMapping(ctx context.Context, mapping, previous *UserConversationMapping) error {
 	if err := db.getError(); err != nil {
 		return err
 	}
+	if current := db.GetMapping(mapping.UserID, mapping.ConversationID); previous != nil && current != nil &&
+		(current.Role != previous.Role ||
+			!reflect.DeepEqual(current.Nickname, previous.Nickname) ||
+			!reflect.DeepEqual(current.Pending, previous.Pending)) {
+		return ErrModified
+	}
 	db.SetMapping(mapping.UserID, mapping.ConversationID, mapping)
 	return nil
 }
 
-func (db *MockDB) DeleteUserConversationMapping(userID, conversationID int64) error {
+func (db *MockDB) TouchUserConversationMapping(ctx context.Context, userID, conversationID int64) error {
+	if err := db.getError(); err != nil {
+		return err
+	}
+	if mapping := db.GetMapping(userID, conversationID); mapping != nil {
+		mapping.LastOpened = time.Now().Format("2006-01-02 15:04:05")
+	}
+	return nil
+}
+
+func (db *MockDB) DeleteUserConversationMapping(ctx context.Context, userID, conversationID int64) error {
 	if err := db.getError(); err != nil {
 		return err
 	}
 	db.SetMapping(userID, conversationID, nil)
+	delete(db.Carets[conversationID], userID)
+	return nil
+}
+
+func (db *MockDB) CreateUserConversationMappings(ctx context.Context, mappings []*UserConversationMapping) ([]error, error) {
+	if err := db.getError(); err != nil {
+		return nil, err
+	}
+	errs := make([]error, len(mappings))
+	for i, mapping := range mappings {
+		if db.GetMapping(mapping.UserID, mapping.ConversationID) != nil {
+			errs[i] = &mysql.MySQLError{Number: 1062, Message: "Duplicate entry"}
+			continue
+		}
+		db.SetMapping(mapping.UserID, mapping.ConversationID, mapping)
+	}
+	return errs, nil
+}
+
+func (db *MockDB) DeleteUserConversationMappings(ctx context.Context, userIDs []int64, conversationID int64) ([]bool, error) {
+	if err := db.getError(); err != nil {
+		return nil, err
+	}
+	deleted := make([]bool, len(userIDs))
+	for i, userID := range userIDs {
+		deleted[i] = db.GetMapping(userID, conversationID) != nil
+		db.SetMapping(userID, conversationID, nil)
+		delete(db.Carets[conversationID], userID)
+	}
+	return deleted, nil
+}
+
+func (db *MockDB) SetCaret(ctx context.Context, userID, conversationID int64, caret Caret) error {
+	if err := db.getError(); err != nil {
+		return err
+	}
+	if db.GetMapping(userID, conversationID) == nil {
+		return nil
+	}
+	if db.Carets[conversationID] == nil {
+		db.Carets[conversationID] = make(map[int64]*Caret)
+	}
+	db.Carets[conversationID][userID] = &caret
+	return nil
+}
+
+func (db *MockDB) GetCaret(ctx context.Context, userID, conversationID int64) (*Caret, error) {
+	if err := db.getError(); err != nil {
+		return nil, err
+	}
+	return db.Carets[conversationID][userID], nil
+}
+
+func (db *MockDB) ShiftCarets(ctx context.Context, conversationID int64, position, shift int) error {
+	if err := db.getError(); err != nil {
+		return err
+	}
+	shiftOffset := func(offset int) int {
+		if offset < position {
+			return offset
+		}
+		if offset+shift < position {
+			return position
+		}
+		return offset + shift
+	}
+	for _, caret := range db.Carets[conversationID] {
+		caret.Start = shiftOffset(caret.Start)
+		caret.End = shiftOffset(caret.End)
+	}
+	return nil
+}
+
+func (db *MockDB) IndexConversationContent(ctx context.Context, conversationID int64, content string) error {
+	if err := db.getError(); err != nil {
+		return err
+	}
+	db.SearchIndex[conversationID] = content
+	return nil
+}
+
+func (db *MockDB) SearchConversations(ctx context.Context, userID int64, terms []string) ([]*SearchResult, error) {
+	if err := db.getError(); err != nil {
+		return nil, err
+	}
+
+	results := make([]*SearchResult, 0)
+	for conversationID, content := range db.SearchIndex {
+		mapping := db.GetMapping(userID, conversationID)
+		conversation := db.Conversations[conversationID]
+		if mapping == nil || mapping.Pending != nil && *mapping.Pending || conversation == nil {
+			continue
+		}
+
+		matched := true
+		for _, term := range terms {
+			if !strings.Contains(strings.ToLower(content), term) {
+				matched = false
+				break
+			}
+		}
+		if matched {
+			results = append(results, &SearchResult{
+				ConversationID: conversationID,
+				Name:           conversation.Name,
+				Content:        content,
+			})
+		}
+	}
+	sort.Slice(results, func(i, j int) bool {
+		return results[i].ConversationID < results[j].ConversationID
+	})
+	return results, nil
+}
+
+func (db *MockDB) CreateEvent(ctx context.Context, event *Event) error {
+	if err := db.getError(); err != nil {
+		return err
+	}
+	event.ID = int64(len(db.Events) + 1)
+	event.CreatedAt = time.Now().Format("2006-01-02 15:04:05")
+	db.Events = append(db.Events, event)
+	return nil
+}
+
+func (db *MockDB) GetEvents(ctx context.Context, conversationID int64, filter EventFilter) ([]*Event, error) {
+	if err := db.getError(); err != nil {
+		return nil, err
+	}
+
+	events := make([]*Event, 0)
+	for i := len(db.Events) - 1; i >= 0 && len(events) < filter.Limit; i-- {
+		event := db.Events[i]
+		if event.ConversationID != conversationID {
+			continue
+		}
+		if filter.Cursor != 0 && event.ID >= filter.Cursor {
+			continue
+		}
+		matched := len(filter.Actions) == 0
+		for _, action := range filter.Actions {
+			if event.Action == action {
+				matched = true
+			}
+		}
+		if matched {
+			events = append(events, event)
+		}
+	}
+	return events, nil
+}
+
+func (db *MockDB) CreateRevision(ctx context.Context, revision *Revision) error {
+	if err := db.getError(); err != nil {
+		return err
+	}
+	revisions := db.Revisions[revision.ConversationID]
+	revision.Version = int64(len(revisions) + 1)
+	if revision.CreatedAt == "" {
+		revision.CreatedAt = time.Now().Format("2006-01-02 15:04:05")
+	}
+	db.Revisions[revision.ConversationID] = append(revisions, revision)
+	return nil
+}
+
+func (db *MockDB) GetLatestRevision(ctx context.Context, conversationID int64) (*Revision, error) {
+	if err := db.getError(); err != nil {
+		return nil, err
+	}
+	revisions := db.Revisions[conversationID]
+	if len(revisions) == 0 {
+		return nil, nil
+	}
+	return revisions[len(revisions)-1], nil
+}
+
+func (db *MockDB) GetRevisions(ctx context.Context, conversationID int64) ([]*Revision, error) {
+	if err := db.getError(); err != nil {
+		return nil, err
+	}
+	revisions := make([]*Revision, len(db.Revisions[conversationID]))
+	copy(revisions, db.Revisions[conversationID])
+	return revisions, nil
+}
+
+func (db *MockDB) GetRevisionsSinceReset(ctx context.Context, conversationID int64, limit int) ([]*Revision, error) {
+	if err := db.getError(); err != nil {
+		return nil, err
+	}
+	revisions := db.Revisions[conversationID]
+	for i := len(revisions) - 1; i > 0; i-- {
+		if revisions[i].Reset {
+			revisions = revisions[i:]
+			break
+		}
+	}
+	if len(revisions) > limit {
+		revisions = revisions[:limit]
+	}
+	result := make([]*Revision, len(revisions))
+	copy(result, revisions)
+	return result, nil
+}
+
+func (db *MockDB) CreateSnapshot(ctx context.Context, snapshot *Snapshot) error {
+	if err := db.getError(); err != nil {
+		return err
+	}
+	snapshots := db.Snapshots[snapshot.ConversationID]
+	for i, existing := range snapshots {
+		if existing.Version == snapshot.Version {
+			snapshots[i] = snapshot
+			return nil
+		}
+	}
+	db.Snapshots[snapshot.ConversationID] = append(snapshots, snapshot)
+	return nil
+}
+
+func (db *MockDB) GetSnapshot(ctx context.Context, conversationID, version int64) (*Snapshot, error) {
+	if err := db.getError(); err != nil {
+		return nil, err
+	}
+	var latest *Snapshot
+	for _, snapshot := range db.Snapshots[conversationID] {
+		if snapshot.Version <= version && (latest == nil || snapshot.Version > latest.Version) {
+			latest = snapshot
+		}
+	}
+	return latest, nil
+}
+
+func (db *MockDB) CreateServiceAccount(ctx context.Context, account *ServiceAccount) error {
+	if err := db.getError(); err != nil {
+		return err
+	}
+	account.ID = ServiceAccountMinID + int64(len(db.ServiceAccounts))
+	if account.CreatedAt == "" {
+		account.CreatedAt = time.Now().Format("2006-01-02 15:04:05")
+	}
+	db.ServiceAccounts[account.ID] = account
+	db.SetMapping(account.ID, account.ConversationID, &UserConversationMapping{
+		UserID:         account.ID,
+		ConversationID: account.ConversationID,
+		Role:           Service,
+		Nickname:       &account.Name,
+		Pending:        new(bool),
+	})
+	return nil
+}
+
+func (db *MockDB) GetServiceAccount(ctx context.Context, id int64) (*ServiceAccount, error) {
+	if err := db.getError(); err != nil {
+		return nil, err
+	}
+	return db.ServiceAccounts[id], nil
+}
+
+func (db *MockDB) GetServiceAccounts(ctx context.Context, conversationID int64) ([]*ServiceAccount, error) {
+	if err := db.getError(); err != nil {
+		return nil, err
+	}
+	accounts := make([]*ServiceAccount, 0)
+	for _, account := range db.ServiceAccounts {
+		if account.ConversationID == conversationID {
+			accounts = append(accounts, account)
+		}
+	}
+	sort.Slice(accounts, func(i, j int) bool { return accounts[i].ID < accounts[j].ID })
+	return accounts, nil
+}
+
+func (db *MockDB) DeleteServiceAccount(ctx context.Context, id int64) error {
+	if err := db.getError(); err != nil {
+		return err
+	}
+	account := db.ServiceAccounts[id]
+	if account == nil {
+		return nil
+	}
+	for tokenID, token := range db.APITokens {
+		if token.ServiceAccountID == id {
+			delete(db.APITokens, tokenID)
+		}
+	}
+	if db.Mappings[account.ConversationID] != nil {
+		delete(db.Mappings[account.ConversationID], id)
+	}
+	delete(db.ServiceAccounts, id)
+	return nil
+}
+
+func (db *MockDB) CreateAPIToken(ctx context.Context, token *APIToken) error {
+	if err := db.getError(); err != nil {
+		return err
+	}
+	var id int64
+	for tokenID := range db.APITokens {
+		if tokenID > id {
+			id = tokenID
+		}
+	}
+	token.ID = id + 1
+	if token.CreatedAt == "" {
+		token.CreatedAt = time.Now().Format("2006-01-02 15:04:05")
+	}
+	db.APITokens[token.ID] = token
+	return nil
+}
+
+func (db *MockDB) GetAPIToken(ctx context.Context, id int64) (*APIToken, error) {
+	if err := db.getError(); err != nil {
+		return nil, err
+	}
+	return db.APITokens[id], nil
+}
+
+func (db *MockDB) GetAPITokenByHash(ctx context.Context, hash string) (*APIToken, error) {
+	if err := db.getError(); err != nil {
+		return nil, err
+	}
+	for _, token := range db.APITokens {
+		if token.Hash == hash {
+			return token, nil
+		}
+	}
+	return nil, nil
+}
+
+func (db *MockDB) GetAPITokens(ctx context.Context, conversationID int64) ([]*APIToken, error) {
+	if err := db.getError(); err != nil {
+		return nil, err
+	}
+	tokens := make([]*APIToken, 0)
+	for _, token := range db.APITokens {
+		if token.ConversationID == conversationID {
+			tokens = append(tokens, token)
+		}
+	}
+	sort.Slice(tokens, func(i, j int) bool { return tokens[i].ID < tokens[j].ID })
+	return tokens, nil
+}
+
+func (db *MockDB) DeleteAPIToken(ctx context.Context, id int64) error {
+	if err := db.getError(); err != nil {
+		return err
+	}
+	delete(db.APITokens, id)
+	return nil
+}
+
+func (db *MockDB) CreateWebhook(ctx context.Context, webhook *Webhook) error {
+	if err := db.getError(); err != nil {
+		return err
+	}
+	var id int64
+	for webhookID := range db.Webhooks {
+		if webhookID > id {
+			id = webhookID
+		}
+	}
+	webhook.ID = id + 1
+	if webhook.CreatedAt == "" {
+		webhook.CreatedAt = time.Now().Format("2006-01-02 15:04:05")
+	}
+	stored := *webhook
+	db.Webhooks[webhook.ID] = &stored
+	return nil
+}
+
+func (db *MockDB) GetWebhook(ctx context.Context, id int64) (*Webhook, error) {
+	if err := db.getError(); err != nil {
+		return nil, err
+	}
+	if db.Webhooks[id] == nil {
+		return nil, nil
+	}
+	webhook := *db.Webhooks[id]
+	return &webhook, nil
+}
+
+func (db *MockDB) GetWebhooks(ctx context.Context, conversationID int64) ([]*Webhook, error) {
+	if err := db.getError(); err != nil {
+		return nil, err
+	}
+	webhooks := make([]*Webhook, 0)
+	for _, webhook := range db.Webhooks {
+		if webhook.ConversationID == conversationID {
+			w := *webhook
+			webhooks = append(webhooks, &w)
+		}
+	}
+	sort.Slice(webhooks, func(i, j int) bool { return webhooks[i].ID < webhooks[j].ID })
+	return webhooks, nil
+}
+
+func (db *MockDB) UpdateWebhook(ctx context.Context, webhook *Webhook) error {
+	if err := db.getError(); err != nil {
+		return err
+	}
+	stored := db.Webhooks[webhook.ID]
+	if stored == nil {
+		return nil
+	}
+	stored.URL = webhook.URL
+	stored.Events = webhook.Events
+	enabled := webhook.Enabled != nil && *webhook.Enabled
+	stored.Enabled = &enabled
+	if enabled {
+		stored.ConsecutiveFailures = 0
+	}
+	return nil
+}
+
+func (db *MockDB) RecordWebhookFailure(ctx context.Context, id int64, disableAfter int) error {
+	if err := db.getError(); err != nil {
+		return err
+	}
+	stored := db.Webhooks[id]
+	if stored == nil {
+		return nil
+	}
+	stored.ConsecutiveFailures++
+	if stored.ConsecutiveFailures >= disableAfter {
+		stored.Enabled = new(bool)
+	}
+	return nil
+}
+
+func (db *MockDB) ResetWebhookFailures(ctx context.Context, id int64) error {
+	if err := db.getError(); err != nil {
+		return err
+	}
+	if stored := db.Webhooks[id]; stored != nil {
+		stored.ConsecutiveFailures = 0
+	}
+	return nil
+}
+
+func (db *MockDB) DeleteWebhook(ctx context.Context, id int64) error {
+	if err := db.getError(); err != nil {
+		return err
+	}
+	deliveries := make([]*WebhookDelivery, 0, len(db.Deliveries))
+	for _, delivery := range db.Deliveries {
+		if delivery.WebhookID != id {
+			deliveries = append(deliveries, delivery)
+		}
+	}
+	db.Deliveries = deliveries
+	delete(db.Webhooks, id)
+	return nil
+}
+
+func (db *MockDB) CreateWebhookDelivery(ctx context.Context, delivery *WebhookDelivery) error {
+	if err := db.getError(); err != nil {
+		return err
+	}
+	delivery.ID = int64(len(db.Deliveries) + 1)
+	if delivery.CreatedAt == "" {
+		delivery.CreatedAt = time.Now().Format("2006-01-02 15:04:05")
+	}
+	db.Deliveries = append(db.Deliveries, delivery)
+	return nil
+}
+
+func (db *MockDB) GetWebhookDeliveries(ctx context.Context, webhookID int64, limit int) ([]*WebhookDelivery, error) {
+	if err := db.getError(); err != nil {
+		return nil, err
+	}
+	deliveries := make([]*WebhookDelivery, 0)
+	for i := len(db.Deliveries) - 1; i >= 0 && len(deliveries) < limit; i-- {
+		if db.Deliveries[i].WebhookID == webhookID {
+			deliveries = append(deliveries, db.Deliveries[i])
+		}
+	}
+	return deliveries, nil
+}
+
+// Transaction calls fn with the MockDB itself, since the MockDB cannot roll
+// back changes.
+func (db *MockDB) Transaction(ctx context.Context, fn func(tx Datastore) error) error {
+	return fn(db)
+}
+
+func (db *MockDB) AddEdit(ctx context.Context, conversationID int64, userID *int64, at time.Time) error {
+	if err := db.getError(); err != nil {
+		return err
+	}
+	for _, batch := range db.EditBatches {
+		if batch.ConversationID == conversationID && editBatchUserID(batch.UserID) == editBatchUserID(userID) {
+			batch.Edits++
+			batch.LastEditAt = at.UTC()
+			return nil
+		}
+	}
+	db.EditBatches = append(db.EditBatches, &EditBatch{
+		ConversationID: conversationID,
+		UserID:         userID,
+		Edits:          1,
+		FirstEditAt:    at.UTC(),
+		LastEditAt:     at.UTC(),
+	})
+	return nil
+}
+
+func (db *MockDB) GetDueEditBatches(ctx context.Context, idleBefore, startedBefore time.Time) ([]*EditBatch, error) {
+	if err := db.getError(); err != nil {
+		return nil, err
+	}
+	batches := make([]*EditBatch, 0)
+	for _, batch := range db.EditBatches {
+		if !batch.LastEditAt.After(idleBefore) || !batch.FirstEditAt.After(startedBefore) {
+			batches = append(batches, batch)
+		}
+	}
+	return batches, nil
+}
+
+func (db *MockDB) DeleteEditBatch(ctx context.Context, conversationID int64, userID *int64) error {
+	if err := db.getError(); err != nil {
+		return err
+	}
+	for i, batch := range db.EditBatches {
+		if batch.ConversationID == conversationID && editBatchUserID(batch.UserID) == editBatchUserID(userID) {
+			db.EditBatches = append(db.EditBatches[:i], db.EditBatches[i+1:]...)
+			return nil
+		}
+	}
 	return nil
 }
@@ -0,0 +1,226 @@
+package models
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+)
+
+// Revision represents a patch that was applied to a conversation's content.
+// Versions start at 1 for each conversation, and applying the patches of every
+// revision up to a version in order to empty content gives the content at that
+// version. A reset revision's patch applies to empty content rather than the
+// content at the previous version.
+type Revision struct {
+	ConversationID int64  `json:"conversation_id"`
+	Version        int64  `json:"version"`
+	UserID         *int64 `json:"user_id"`
+	Patch          string `json:"patch"`
+	Hash           string `json:"-"`
+	Reset          bool   `json:"-"`
+	CreatedAt      string `json:"created_at"`
+}
+
+// Snapshot represents the full content of a conversation at a version, so that
+// the content at later versions can be rebuilt without replaying every
+// revision before it
+type Snapshot struct {
+	ConversationID int64
+	Version        int64
+	Content        string
+}
+
+const revisionsTable string = "conversation_revisions"
+const snapshotsTable string = "conversation_snapshots"
+
+// CreateRevision adds a row to the "conversation_revisions" table with the
+// next version of its conversation, and sets the revision's Version to it
+func (db *DB) CreateRevision(ctx context.Context, revision *Revision) error {
+	ctx, done := instrument(ctx, "CreateRevision")
+	defer done()
+
+	tx, err := db.begin(ctx)
+	if err != nil {
+		return err
+	}
+
+	var version int64
+	queryString := fmt.Sprintf(
+		"SELECT COALESCE(MAX(Version), 0) FROM %s WHERE ConversationID=? FOR UPDATE",
+		revisionsTable,
+	)
+	if err := tx.QueryRowContext(ctx, queryString, revision.ConversationID).Scan(&version); err != nil {
+		tx.Rollback()
+		return err
+	}
+	version++
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "INSERT INTO %s(ConversationID, Version, UserID, Patch, Hash, Reset) ", revisionsTable)
+	fmt.Fprintf(&b, "VALUES(?, ?, ?, ?, ?, ?)")
+	_, err = tx.ExecContext(ctx,
+		b.String(),
+		revision.ConversationID,
+		version,
+		revision.UserID,
+		revision.Patch,
+		sql.NullString{String: revision.Hash, Valid: revision.Hash != ""},
+		revision.Reset,
+	)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	revision.Version = version
+	db.logger(ctx).Debugf(`Created 1 row in "%s"`, revisionsTable)
+	return nil
+}
+
+// GetLatestRevision queries for the row in the "conversation_revisions" table
+// with the highest version of a conversation, returning nil if it has none
+func (db *DB) GetLatestRevision(ctx context.Context, conversationID int64) (*Revision, error) {
+	ctx, done := instrument(ctx, "GetLatestRevision")
+	defer done()
+
+	revision := &Revision{}
+	var userID sql.NullInt64
+	var hash sql.NullString
+	var b strings.Builder
+	fmt.Fprintf(&b, "SELECT ConversationID, Version, UserID, Patch, Hash, Reset, CreatedAt FROM %s ", revisionsTable)
+	fmt.Fprintf(&b, "WHERE ConversationID=? ORDER BY Version DESC LIMIT 1")
+	err := db.conn().QueryRowContext(ctx, b.String(), conversationID).Scan(
+		&revision.ConversationID,
+		&revision.Version,
+		&userID,
+		&revision.Patch,
+		&hash,
+		&revision.Reset,
+		&revision.CreatedAt,
+	)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+		return nil, err
+	}
+	if userID.Valid {
+		revision.UserID = &userID.Int64
+	}
+	revision.Hash = hash.String
+	db.logger(ctx).Debugf(`Read 1 row from "%s"`, revisionsTable)
+	return revision, nil
+}
+
+// GetRevisions queries for every row in the "conversation_revisions" table of
+// a conversation, in order of version
+func (db *DB) GetRevisions(ctx context.Context, conversationID int64) ([]*Revision, error) {
+	ctx, done := instrument(ctx, "GetRevisions")
+	defer done()
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "SELECT ConversationID, Version, UserID, Patch, Hash, Reset, CreatedAt FROM %s ", revisionsTable)
+	fmt.Fprintf(&b, "WHERE ConversationID=? ORDER BY Version")
+	return db.queryRevisions(ctx, b.String(), conversationID)
+}
+
+// GetRevisionsSinceReset queries for the rows in the "conversation_revisions"
+// table of a conversation from its last reset revision on, or from its first
+// revision if none was reset, in order of version and at most limit of them
+func (db *DB) GetRevisionsSinceReset(ctx context.Context, conversationID int64, limit int) ([]*Revision, error) {
+	ctx, done := instrument(ctx, "GetRevisionsSinceReset")
+	defer done()
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "SELECT ConversationID, Version, UserID, Patch, Hash, Reset, CreatedAt FROM %s ", revisionsTable)
+	fmt.Fprintf(&b, "WHERE ConversationID=? AND Version>=(")
+	fmt.Fprintf(&b, "SELECT COALESCE(MAX(Version), 0) FROM %s WHERE ConversationID=? AND Reset", revisionsTable)
+	fmt.Fprintf(&b, ") ORDER BY Version LIMIT ?")
+	return db.queryRevisions(ctx, b.String(), conversationID, conversationID, limit)
+}
+
+// queryRevisions queries for rows in the "conversation_revisions" table
+func (db *DB) queryRevisions(ctx context.Context, query string, args ...interface{}) ([]*Revision, error) {
+	rows, err := db.conn().QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	revisions := make([]*Revision, 0)
+	for rows.Next() {
+		revision := &Revision{}
+		var userID sql.NullInt64
+		var hash sql.NullString
+		err := rows.Scan(
+			&revision.ConversationID,
+			&revision.Version,
+			&userID,
+			&revision.Patch,
+			&hash,
+			&revision.Reset,
+			&revision.CreatedAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+		if userID.Valid {
+			revision.UserID = &userID.Int64
+		}
+		revision.Hash = hash.String
+		revisions = append(revisions, revision)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	db.logger(ctx).Debugf(`Read %d row(s) from "%s"`, len(revisions), revisionsTable)
+	return revisions, nil
+}
+
+// CreateSnapshot adds a row to the "conversation_snapshots" table, replacing
+// any snapshot of the same version
+func (db *DB) CreateSnapshot(ctx context.Context, snapshot *Snapshot) error {
+	ctx, done := instrument(ctx, "CreateSnapshot")
+	defer done()
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "INSERT INTO %s(ConversationID, Version, Content) ", snapshotsTable)
+	fmt.Fprintf(&b, "VALUES(?, ?, ?) ")
+	fmt.Fprintf(&b, "ON DUPLICATE KEY UPDATE Content=VALUES(Content)")
+	_, err := db.conn().ExecContext(ctx, b.String(), snapshot.ConversationID, snapshot.Version, snapshot.Content)
+	if err != nil {
+		return err
+	}
+	db.logger(ctx).Debugf(`Created 1 row in "%s"`, snapshotsTable)
+	return nil
+}
+
+// GetSnapshot queries for the row in the "conversation_snapshots" table with
+// the highest version of a conversation at or before a version, returning nil
+// if there is none
+func (db *DB) GetSnapshot(ctx context.Context, conversationID, version int64) (*Snapshot, error) {
+	ctx, done := instrument(ctx, "GetSnapshot")
+	defer done()
+
+	snapshot := &Snapshot{}
+	var b strings.Builder
+	fmt.Fprintf(&b, "SELECT ConversationID, Version, Content FROM %s ", snapshotsTable)
+	fmt.Fprintf(&b, "WHERE ConversationID=? AND Version<=? ORDER BY Version DESC LIMIT 1")
+	err := db.conn().QueryRowContext(ctx, b.String(), conversationID, version).Scan(
+		&snapshot.ConversationID,
+		&snapshot.Version,
+		&snapshot.Content,
+	)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+		return nil, err
+	}
+	db.logger(ctx).Debugf(`Read 1 row from "%s"`, snapshotsTable)
+	return snapshot, nil
+}
@@ -0,0 +1,85 @@
+package models
+
+import (
+	"context"
+	"fmt"
+	"strings"
+)
+
+// SearchResult represents a conversation whose content matches a search query
+type SearchResult struct {
+	ConversationID int64    `json:"conversation_id"`
+	Name           string   `json:"name"`
+	Snippets       []string `json:"snippets"`
+	Content        string   `json:"-"`
+}
+
+// SearchResultList represents a list of conversations matching a search query
+type SearchResultList struct {
+	Results []*SearchResult `json:"results"`
+}
+
+const (
+	searchTable string = "conversation_search"
+
+	searchResultLimit int = 50
+)
+
+// IndexConversationContent sets the searchable text of a conversation in the
+// "conversation_search" table
+func (db *DB) IndexConversationContent(ctx context.Context, conversationID int64, content string) error {
+	ctx, done := instrument(ctx, "IndexConversationContent")
+	defer done()
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "INSERT INTO %s(ConversationID, Content) VALUES(?, ?) ", searchTable)
+	fmt.Fprintf(&b, "ON DUPLICATE KEY UPDATE Content=VALUES(Content)")
+	res, err := db.conn().ExecContext(ctx, b.String(), conversationID, content)
+	if err != nil {
+		return err
+	}
+
+	if rowCount, err := res.RowsAffected(); err == nil {
+		db.logger(ctx).Debugf(`Updated %d row(s) in "%s"`, rowCount, searchTable)
+	} else {
+		db.logger(ctx).Warnf("Failed to get number of rows affected: %v", err)
+	}
+	return nil
+}
+
+// SearchConversations returns the conversations that a user has accepted an
+// invitation to whose content contains all of the given alphanumeric terms
+func (db *DB) SearchConversations(ctx context.Context, userID int64, terms []string) ([]*SearchResult, error) {
+	ctx, done := instrument(ctx, "SearchConversations")
+	defer done()
+
+	booleanTerms := make([]string, len(terms))
+	for i, term := range terms {
+		booleanTerms[i] = "+" + term + "*"
+	}
+	against := strings.Join(booleanTerms, " ")
+
+	var queryString strings.Builder
+	fmt.Fprintf(&queryString, "SELECT c.ID, c.Name, s.Content ")
+	fmt.Fprintf(&queryString, "FROM %s AS s JOIN %s AS c ON c.ID = s.ConversationID ", searchTable, conversationsTable)
+	fmt.Fprintf(&queryString, "JOIN %s AS m ON c.ID = m.ConversationID ", mappingsTable)
+	fmt.Fprintf(&queryString, "WHERE m.UserID=? AND m.Pending=0 AND MATCH(s.Content) AGAINST(? IN BOOLEAN MODE) ")
+	fmt.Fprintf(&queryString, "ORDER BY MATCH(s.Content) AGAINST(? IN BOOLEAN MODE) DESC LIMIT %d", searchResultLimit)
+	rows, err := db.conn().QueryContext(ctx, queryString.String(), userID, against, against)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	results := make([]*SearchResult, 0)
+	for rows.Next() {
+		result := &SearchResult{}
+		if err := rows.Scan(&result.ConversationID, &result.Name, &result.Content); err != nil {
+			return nil, err
+		}
+		results = append(results, result)
+	}
+
+	db.logger(ctx).Debugf(`Read %d row(s) from "%s"`, len(results), searchTable)
+	return results, rows.Err()
+}
@@ -0,0 +1,192 @@
+package models
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+)
+
+// ServiceAccount represents a non-human member of a conversation, such as a
+// bot or an integration, which accesses it with API tokens instead of a user's
+// session. Its ID is also its user ID as a member of the conversation.
+type ServiceAccount struct {
+	ID             int64  `json:"id"`
+	ConversationID int64  `json:"conversation_id"`
+	Name           string `json:"name"`
+	CreatorID      int64  `json:"creator_id"`
+	CreatedAt      string `json:"created_at"`
+}
+
+// ServiceAccountList represents a list of the service accounts of a
+// conversation
+type ServiceAccountList struct {
+	ServiceAccounts []*ServiceAccount `json:"service_accounts"`
+}
+
+const (
+	// ServiceAccountMinID is the lowest ID of a service account. IDs from it
+	// up are reserved for service accounts, so that they can be members of
+	// conversations without clashing with the IDs of users.
+	ServiceAccountMinID int64 = 2000000000
+
+	serviceAccountsTable string = "service_accounts"
+)
+
+// IsServiceAccount checks whether a user ID is that of a service account
+func IsServiceAccount(userID int64) bool {
+	return userID >= ServiceAccountMinID
+}
+
+// CreateServiceAccount adds a row to the "service_accounts" table and adds the
+// service account to its conversation with the service role, then sets the
+// service account's ID
+func (db *DB) CreateServiceAccount(ctx context.Context, account *ServiceAccount) error {
+	ctx, done := instrument(ctx, "CreateServiceAccount")
+	defer done()
+
+	tx, err := db.begin(ctx)
+	if err != nil {
+		return err
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "INSERT INTO %s(ConversationID, Name, CreatorID, CreatedAt) ", serviceAccountsTable)
+	fmt.Fprintf(&b, "VALUES(?, ?, ?, ?)")
+	res, err := tx.ExecContext(
+		ctx,
+		b.String(),
+		account.ConversationID,
+		account.Name,
+		account.CreatorID,
+		account.CreatedAt,
+	)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	id, err := res.LastInsertId()
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	b.Reset()
+	fmt.Fprintf(&b, "INSERT INTO %s(UserID, ConversationID, Role, Nickname, Pending, LastOpened) ", mappingsTable)
+	fmt.Fprintf(&b, "VALUES(?, ?, ?, ?, ?, ?)")
+	_, err = tx.ExecContext(ctx, b.String(), id, account.ConversationID, Service, account.Name, 0, account.CreatedAt)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	account.ID = id
+	db.logger(ctx).Debugf(`Created 1 row in "%s" and "%s"`, serviceAccountsTable, mappingsTable)
+	return nil
+}
+
+// GetServiceAccount queries for a single row in the "service_accounts" table,
+// returning nil if there is none
+func (db *DB) GetServiceAccount(ctx context.Context, id int64) (*ServiceAccount, error) {
+	ctx, done := instrument(ctx, "GetServiceAccount")
+	defer done()
+
+	account := &ServiceAccount{}
+	var b strings.Builder
+	fmt.Fprintf(&b, "SELECT ID, ConversationID, Name, CreatorID, CreatedAt FROM %s ", serviceAccountsTable)
+	fmt.Fprintf(&b, "WHERE ID=?")
+	err := db.conn().QueryRowContext(ctx, b.String(), id).Scan(
+		&account.ID,
+		&account.ConversationID,
+		&account.Name,
+		&account.CreatorID,
+		&account.CreatedAt,
+	)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+		return nil, err
+	}
+	db.logger(ctx).Debugf(`Read 1 row from "%s"`, serviceAccountsTable)
+	return account, nil
+}
+
+// GetServiceAccounts queries for every row in the "service_accounts" table of
+// a conversation, in order of creation
+func (db *DB) GetServiceAccounts(ctx context.Context, conversationID int64) ([]*ServiceAccount, error) {
+	ctx, done := instrument(ctx, "GetServiceAccounts")
+	defer done()
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "SELECT ID, ConversationID, Name, CreatorID, CreatedAt FROM %s ", serviceAccountsTable)
+	fmt.Fprintf(&b, "WHERE ConversationID=? ORDER BY ID")
+	rows, err := db.conn().QueryContext(ctx, b.String(), conversationID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	accounts := make([]*ServiceAccount, 0)
+	for rows.Next() {
+		account := &ServiceAccount{}
+		err := rows.Scan(
+			&account.ID,
+			&account.ConversationID,
+			&account.Name,
+			&account.CreatorID,
+			&account.CreatedAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+		accounts = append(accounts, account)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	db.logger(ctx).Debugf(`Read %d row(s) from "%s"`, len(accounts), serviceAccountsTable)
+	return accounts, nil
+}
+
+// DeleteServiceAccount removes a row from the "service_accounts" table along
+// with the service account's API tokens and membership of its conversation
+func (db *DB) DeleteServiceAccount(ctx context.Context, id int64) error {
+	ctx, done := instrument(ctx, "DeleteServiceAccount")
+	defer done()
+
+	tx, err := db.begin(ctx)
+	if err != nil {
+		return err
+	}
+
+	queries := []struct {
+		table  string
+		column string
+	}{
+		{apiTokensTable, "ServiceAccountID"},
+		{caretsTable, "UserID"},
+		{mappingsTable, "UserID"},
+		{serviceAccountsTable, "ID"},
+	}
+	for _, query := range queries {
+		queryString := fmt.Sprintf("DELETE FROM %s WHERE %s=?", query.table, query.column)
+		res, err := tx.ExecContext(ctx, queryString, id)
+		if err != nil {
+			tx.Rollback()
+			return err
+		}
+
+		if rowCount, err := res.RowsAffected(); err == nil {
+			db.logger(ctx).Debugf(`Deleted %d row(s) from "%s"`, rowCount, query.table)
+		} else {
+			tx.Rollback()
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
@@ -0,0 +1,19 @@
+package models
+
+// Quota represents how much of something is used against its limit. Limit is
+// nil if there is no limit.
+type Quota struct {
+	Used  int64  `json:"used"`
+	Limit *int64 `json:"limit"`
+}
+
+// UserUsage represents a user's usage of their quotas
+type UserUsage struct {
+	Conversations Quota `json:"conversations"`
+}
+
+// ConversationUsage represents a conversation's usage of its quotas
+type ConversationUsage struct {
+	Members      Quota `json:"members"`
+	ContentBytes Quota `json:"content_bytes"`
+}
@@ -1,11 +1,13 @@
 package models
 
 import (
+	"context"
 	"database/sql"
 	"errors"
 	"fmt"
-	"log"
 	"strings"
+
+	"github.com/go-sql-driver/mysql"
 )
 
 // UserConversationMapping represents a user's relationship to a conversation
@@ -16,6 +18,21 @@ type UserConversationMapping struct {
 	Nickname       *string `json:"nickname,omitempty"`
 	Pending        *bool   `json:"pending,omitempty"`
 	LastOpened     string  `json:"last_opened,omitempty"`
+
+	// Caret is only set when a single member is requested.
+	Caret *Caret `json:"caret,omitempty"`
+
+	// User and UserMissing are only set when a member listing is expanded
+	// with user profiles.
+	User        *UserProfile `json:"user,omitempty"`
+	UserMissing bool         `json:"user_missing,omitempty"`
+}
+
+// UserProfile represents the profile of a user as known by the user service
+type UserProfile struct {
+	Name      string `json:"name"`
+	Email     string `json:"email"`
+	AvatarURL string `json:"avatar_url,omitempty"`
 }
 
 // UserConversationMappingList represents a list of users in a conversation
@@ -23,6 +40,22 @@ type UserConversationMappingList struct {
 	Users []*UserConversationMapping `json:"users"`
 }
 
+// MappingResult represents the outcome of adding or removing a single user in a
+// batch of conversation membership changes
+type MappingResult struct {
+	UserID int64         `json:"user_id"`
+	Status MappingStatus `json:"status"`
+}
+
+// MappingResultList represents the outcomes of a batch of conversation
+// membership changes
+type MappingResultList struct {
+	Results []*MappingResult `json:"results"`
+}
+
+// MappingStatus represents the outcome of a conversation membership change
+type MappingStatus string
+
 // Role represents a user's access control rights in a conversation
 type Role string
 
@@ -39,9 +72,33 @@ const (
 	// and represents the lowest level of privilege
 	User Role = "user"
 
+	// Service is a role that only service accounts can have. What a service
+	// account can do is restricted by the scopes of its API tokens, and it
+	// ranks between admins and users.
+	Service Role = "service"
+
 	mappingsTable string = "users_to_conversations"
 )
 
+const (
+	// MappingCreated means that the user was added to the conversation
+	MappingCreated MappingStatus = "created"
+
+	// MappingDeleted means that the user was removed from the conversation
+	MappingDeleted MappingStatus = "deleted"
+
+	// MappingConflict means that the user was already in the conversation
+	MappingConflict MappingStatus = "conflict"
+
+	// MappingNotFound means that the user does not exist or, when removing, is
+	// not in the conversation
+	MappingNotFound MappingStatus = "not_found"
+
+	// MappingForbidden means that the session user is not allowed to make the
+	// change
+	MappingForbidden MappingStatus = "forbidden"
+)
+
 // Merge creates a new UserConversationMapping by copying the original mapping
 // and replacing its fields with the non-zero-value fields of a patch mapping
 func (m *UserConversationMapping) Merge(patch *UserConversationMapping) *UserConversationMapping {
@@ -81,7 +138,14 @@ func (m *UserConversationMapping) Merge(patch *UserConversationMapping) *UserCon
 
 // Valid checks whether a given role has an acceptable string value
 func (r Role) Valid() bool {
-	return r == Owner || r == Admin || r == User
+	return r == Owner || r == Admin || r == User || r == Service
+}
+
+// CanAssign checks whether a member with this role is allowed to add another
+// member with the given role. The service role is only given to service
+// accounts when they are created.
+func (r Role) CanAssign(other Role) bool {
+	return other.Valid() && other != Owner && other != Service && (r == Owner || other == User)
 }
 
 // Compare checks whether a given role value is greater than, less than, or
@@ -97,15 +161,31 @@ func (r Role) Compare(other Role) (int, error) {
 
 	if r == other {
 		return 0, nil
-	} else if r == Owner && (other == Admin || other == User) || r == Admin && other == User {
+	} else if r.rank() > other.rank() {
 		return 1, nil
 	} else {
 		return -1, nil
 	}
 }
 
+// rank orders roles by privilege
+func (r Role) rank() int {
+	switch r {
+	case Owner:
+		return 3
+	case Admin:
+		return 2
+	case Service:
+		return 1
+	}
+	return 0
+}
+
 // CreateUserConversationMapping adds a row to the "users_to_conversations" table
-func (db *DB) CreateUserConversationMapping(mapping *UserConversationMapping) error {
+func (db *DB) CreateUserConversationMapping(ctx context.Context, mapping *UserConversationMapping) error {
+	ctx, done := instrument(ctx, "CreateUserConversationMapping")
+	defer done()
+
 	var b strings.Builder
 	fmt.Fprintf(&b, "INSERT INTO %s(UserID, ConversationID, Role, Nickname, Pending, LastOpened) ", mappingsTable)
 	fmt.Fprintf(&b, "VALUES(?, ?, ?, ?, ?, ?)")
@@ -113,7 +193,7 @@ func (db *DB) CreateUserConversationMapping(mapping *UserConversationMapping) er
 	if *mapping.Pending {
 		pendingFlag = 1
 	}
-	res, err := db.Exec(
+	res, err := db.conn().ExecContext(ctx,
 		b.String(),
 		mapping.UserID,
 		mapping.ConversationID,
@@ -126,7 +206,7 @@ func (db *DB) CreateUserConversationMapping(mapping *UserConversationMapping) er
 		return err
 	}
 	if rowCount, err := res.RowsAffected(); err == nil {
-		log.Printf(`Created %d row(s) in "%s"`, rowCount, mappingsTable)
+		db.logger(ctx).Debugf(`Created %d row(s) in "%s"`, rowCount, mappingsTable)
 	}
 	return nil
 }
@@ -134,11 +214,14 @@ func (db *DB) CreateUserConversationMapping(mapping *UserConversationMapping) er
 // GetUserConversationMapping queries for a single row from the
 // "users_to_conversations" table using the combination of ConversationID and
 // UserID which should be unique to each row
-func (db *DB) GetUserConversationMapping(userID, conversationID int64) (*UserConversationMapping, error) {
+func (db *DB) GetUserConversationMapping(ctx context.Context, userID, conversationID int64) (*UserConversationMapping, error) {
+	ctx, done := instrument(ctx, "GetUserConversationMapping")
+	defer done()
+
 	var tmpPending int8
 	mapping := &UserConversationMapping{}
 	queryString := fmt.Sprintf("SELECT * FROM %s WHERE UserID=? AND ConversationID=?", mappingsTable)
-	err := db.QueryRow(queryString, userID, conversationID).Scan(
+	err := db.conn().QueryRowContext(ctx, queryString, userID, conversationID).Scan(
 		&(mapping.UserID),
 		&(mapping.ConversationID),
 		&(mapping.Role),
@@ -154,15 +237,18 @@ func (db *DB) GetUserConversationMapping(userID, conversationID int64) (*UserCon
 	}
 	var pending bool = tmpPending == 1
 	mapping.Pending = &pending
-	log.Printf(`Read 1 row from "%s"`, mappingsTable)
+	db.logger(ctx).Debugf(`Read 1 row from "%s"`, mappingsTable)
 	return mapping, nil
 }
 
 // GetUserConversationMappings queries for all the rows in the
 // "user_to_conversations" table with a given ConversationID
-func (db *DB) GetUserConversationMappings(conversationID int64) ([]*UserConversationMapping, error) {
+func (db *DB) GetUserConversationMappings(ctx context.Context, conversationID int64) ([]*UserConversationMapping, error) {
+	ctx, done := instrument(ctx, "GetUserConversationMappings")
+	defer done()
+
 	queryString := fmt.Sprintf("SELECT * FROM %s WHERE ConversationID=?", mappingsTable)
-	rows, err := db.Query(queryString, conversationID)
+	rows, err := db.conn().QueryContext(ctx, queryString, conversationID)
 	if err != nil {
 		return nil, err
 	}
@@ -186,55 +272,246 @@ func (db *DB) GetUserConversationMappings(conversationID int64) ([]*UserConversa
 		mapping.Pending = &pending
 		mappings = append(mappings, mapping)
 	}
-	log.Printf(`Read %d row(s) from "%s"`, len(mappings), mappingsTable)
+	db.logger(ctx).Debugf(`Read %d row(s) from "%s"`, len(mappings), mappingsTable)
 	return mappings, nil
 }
 
+// CountUserConversationMappings returns the number of members of a
+// conversation, including pending ones and service accounts. In a
+// transaction, the members are locked until it ends.
+func (db *DB) CountUserConversationMappings(ctx context.Context, conversationID int64) (int, error) {
+	ctx, done := instrument(ctx, "CountUserConversationMappings")
+	defer done()
+
+	queryString := fmt.Sprintf("SELECT COUNT(*) FROM %s WHERE ConversationID=?%s", mappingsTable, db.forUpdate())
+
+	var count int
+	if err := db.conn().QueryRowContext(ctx, queryString, conversationID).Scan(&count); err != nil {
+		return 0, err
+	}
+	db.logger(ctx).Debugf(`Read 1 row from "%s"`, mappingsTable)
+	return count, nil
+}
+
 // UpdateUserConversationMapping updates an existing row in the
-// "users_to_conversations" table
-func (db *DB) UpdateUserConversationMapping(mapping *UserConversationMapping) error {
+// "users_to_conversations" table. If previous is not nil, the row is only
+// updated if its role, nickname and invitation status are still those of
+// previous, and ErrModified is returned if they are not.
+func (db *DB) UpdateUserConversationMapping(ctx context.Context, mapping, previous *UserConversationMapping) error {
+	ctx, done := instrument(ctx, "UpdateUserConversationMapping")
+	defer done()
+
 	var b strings.Builder
 	fmt.Fprintf(&b, "UPDATE %s SET ", mappingsTable)
 	fmt.Fprintf(&b, "Role=?, Nickname=?, Pending=?, LastOpened=? ")
 	fmt.Fprintf(&b, "WHERE UserID=? AND ConversationID=?")
-	pendingFlag := 0
-	if *mapping.Pending {
-		pendingFlag = 1
-	}
-	res, err := db.Exec(
-		b.String(),
+	args := []interface{}{
 		mapping.Role,
 		mapping.Nickname,
-		pendingFlag,
+		pendingValue(mapping.Pending),
 		mapping.LastOpened,
 		mapping.UserID,
 		mapping.ConversationID,
-	)
+	}
+	if previous != nil {
+		fmt.Fprintf(&b, " AND Role=? AND Nickname<=>? AND Pending=?")
+		args = append(args, previous.Role, previous.Nickname, pendingValue(previous.Pending))
+	}
+	res, err := db.conn().ExecContext(ctx, b.String(), args...)
 	if err != nil {
 		return err
 	}
 
-	if rowCount, err := res.RowsAffected(); err == nil {
-		log.Printf(`Updated %d row(s) in "%s"`, rowCount, mappingsTable)
-	} else {
-		log.Println("Failed to get number of rows affected: " + err.Error())
+	rowCount, err := res.RowsAffected()
+	if err != nil {
+		db.logger(ctx).Warnf("Failed to get number of rows affected: %v", err)
+		return nil
+	}
+	db.logger(ctx).Debugf(`Updated %d row(s) in "%s"`, rowCount, mappingsTable)
+	if rowCount > 0 || previous == nil {
+		return nil
+	}
+
+	// No rows are affected either if the row was modified or if it already
+	// had the new values
+	var count int
+	var c strings.Builder
+	fmt.Fprintf(&c, "SELECT COUNT(*) FROM %s ", mappingsTable)
+	fmt.Fprintf(&c, "WHERE UserID=? AND ConversationID=? AND Role=? AND Nickname<=>? AND Pending=?")
+	err = db.conn().QueryRowContext(ctx,
+		c.String(),
+		mapping.UserID,
+		mapping.ConversationID,
+		mapping.Role,
+		mapping.Nickname,
+		pendingValue(mapping.Pending),
+	).Scan(&count)
+	if err != nil {
+		return err
+	}
+	if count == 0 {
+		return ErrModified
 	}
 	return nil
 }
 
-// DeleteUserConversationMapping removes a row from the "users_to_conversations"
-// table
-func (db *DB) DeleteUserConversationMapping(userID, conversationID int64) error {
-	queryString := fmt.Sprintf("DELETE FROM %s WHERE UserID=? AND ConversationID=?", mappingsTable)
-	res, err := db.Exec(queryString, userID, conversationID)
+// pendingValue converts an invitation status to its value in the
+// "users_to_conversations" table
+func pendingValue(pending *bool) int {
+	if pending != nil && *pending {
+		return 1
+	}
+	return 0
+}
+
+// TouchUserConversationMapping sets the LastOpened value of a
+// "users_to_conversations" table row to the current time
+func (db *DB) TouchUserConversationMapping(ctx context.Context, userID, conversationID int64) error {
+	ctx, done := instrument(ctx, "TouchUserConversationMapping")
+	defer done()
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "UPDATE %s SET ", mappingsTable)
+	fmt.Fprintf(&b, "LastOpened=NOW() WHERE UserID=? AND ConversationID=?")
+	res, err := db.conn().ExecContext(ctx, b.String(), userID, conversationID)
 	if err != nil {
 		return err
 	}
 
 	if rowCount, err := res.RowsAffected(); err == nil {
-		log.Printf(`Deleted %d row(s) in "%s"`, rowCount, mappingsTable)
+		db.logger(ctx).Debugf(`Updated %d row(s) in "%s"`, rowCount, mappingsTable)
 	} else {
-		log.Println("Failed to get number of rows deleted: " + err.Error())
+		db.logger(ctx).Warnf("Failed to get number of rows affected: %v", err)
 	}
 	return nil
 }
+
+// DeleteUserConversationMapping removes a row from the "users_to_conversations"
+// table along with the user's caret in the conversation
+func (db *DB) DeleteUserConversationMapping(ctx context.Context, userID, conversationID int64) error {
+	ctx, done := instrument(ctx, "DeleteUserConversationMapping")
+	defer done()
+
+	tx, err := db.begin(ctx)
+	if err != nil {
+		return err
+	}
+
+	for _, table := range []string{caretsTable, mappingsTable} {
+		queryString := fmt.Sprintf("DELETE FROM %s WHERE UserID=? AND ConversationID=?", table)
+		res, err := tx.ExecContext(ctx, queryString, userID, conversationID)
+		if err != nil {
+			tx.Rollback()
+			return err
+		}
+
+		if rowCount, err := res.RowsAffected(); err == nil {
+			db.logger(ctx).Debugf(`Deleted %d row(s) in "%s"`, rowCount, table)
+		} else {
+			db.logger(ctx).Warnf("Failed to get number of rows deleted: %v", err)
+		}
+	}
+
+	return tx.Commit()
+}
+
+// CreateUserConversationMappings adds multiple rows to the
+// "users_to_conversations" table in a single transaction. Rows that already
+// exist are skipped and their duplicate entry errors are returned in the list
+// of per-row errors.
+func (db *DB) CreateUserConversationMappings(ctx context.Context, mappings []*UserConversationMapping) ([]error, error) {
+	ctx, done := instrument(ctx, "CreateUserConversationMappings")
+	defer done()
+
+	tx, err := db.begin(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "INSERT INTO %s(UserID, ConversationID, Role, Nickname, Pending, LastOpened) ", mappingsTable)
+	fmt.Fprintf(&b, "VALUES(?, ?, ?, ?, ?, ?)")
+	rowErrs := make([]error, len(mappings))
+	var rowCount int64 = 0
+	for i, mapping := range mappings {
+		pendingFlag := 0
+		if *mapping.Pending {
+			pendingFlag = 1
+		}
+		res, err := tx.ExecContext(ctx,
+			b.String(),
+			mapping.UserID,
+			mapping.ConversationID,
+			mapping.Role,
+			mapping.Nickname,
+			pendingFlag,
+			mapping.LastOpened,
+		)
+		if err != nil {
+			if mySQLErr, ok := err.(*mysql.MySQLError); ok && mySQLErr.Number == 1062 {
+				rowErrs[i] = err
+				continue
+			}
+			tx.Rollback()
+			return nil, err
+		}
+
+		if n, err := res.RowsAffected(); err == nil {
+			rowCount += n
+		} else {
+			tx.Rollback()
+			return nil, err
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	db.logger(ctx).Debugf(`Created %d row(s) in "%s"`, rowCount, mappingsTable)
+	return rowErrs, nil
+}
+
+// DeleteUserConversationMappings removes multiple rows with a given
+// ConversationID from the "users_to_conversations" table in a single
+// transaction. The returned list reports, for each user, whether a row was
+// actually deleted.
+func (db *DB) DeleteUserConversationMappings(ctx context.Context, userIDs []int64, conversationID int64) ([]bool, error) {
+	ctx, done := instrument(ctx, "DeleteUserConversationMappings")
+	defer done()
+
+	tx, err := db.begin(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	caretsQueryString := fmt.Sprintf("DELETE FROM %s WHERE UserID=? AND ConversationID=?", caretsTable)
+	queryString := fmt.Sprintf("DELETE FROM %s WHERE UserID=? AND ConversationID=?", mappingsTable)
+	deleted := make([]bool, len(userIDs))
+	var rowCount int64 = 0
+	for i, userID := range userIDs {
+		if _, err := tx.ExecContext(ctx, caretsQueryString, userID, conversationID); err != nil {
+			tx.Rollback()
+			return nil, err
+		}
+
+		res, err := tx.ExecContext(ctx, queryString, userID, conversationID)
+		if err != nil {
+			tx.Rollback()
+			return nil, err
+		}
+
+		if n, err := res.RowsAffected(); err == nil {
+			rowCount += n
+			deleted[i] = n > 0
+		} else {
+			tx.Rollback()
+			return nil, err
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	db.logger(ctx).Debugf(`Deleted %d row(s) in "%s"`, rowCount, mappingsTable)
+	return deleted, nil
+}
@@ -0,0 +1,384 @@
+package models
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+)
+
+// Webhook represents a URL that is sent the events of a conversation as they
+// happen. Only events with one of Events are sent, or every event if Events
+// is empty.
+type Webhook struct {
+	ID                  int64         `json:"id"`
+	ConversationID      int64         `json:"conversation_id"`
+	URL                 string        `json:"url"`
+	Events              []EventAction `json:"events"`
+	Enabled             *bool         `json:"enabled,omitempty"`
+	ConsecutiveFailures int           `json:"consecutive_failures"`
+	CreatorID           int64         `json:"creator_id"`
+	CreatedAt           string        `json:"created_at"`
+
+	// Secret signs the payloads sent to the webhook. It is only set in the
+	// response to creating the webhook.
+	Secret string `json:"secret,omitempty"`
+}
+
+// WebhookList represents a list of the webhooks of a conversation
+type WebhookList struct {
+	Webhooks []*Webhook `json:"webhooks"`
+}
+
+// WebhookDelivery represents one attempt to send an event to a webhook
+type WebhookDelivery struct {
+	ID         int64       `json:"id"`
+	WebhookID  int64       `json:"webhook_id"`
+	EventID    int64       `json:"event_id"`
+	Action     EventAction `json:"action"`
+	Attempt    int         `json:"attempt"`
+	StatusCode *int        `json:"status_code"`
+	Error      *string     `json:"error"`
+	DurationMS int64       `json:"duration_ms"`
+	CreatedAt  string      `json:"created_at"`
+}
+
+// WebhookDeliveryList represents a webhook's delivery log
+type WebhookDeliveryList struct {
+	Deliveries []*WebhookDelivery `json:"deliveries"`
+}
+
+const (
+	webhooksTable          string = "webhooks"
+	webhookDeliveriesTable string = "webhook_deliveries"
+
+	webhookColumns = "ID, ConversationID, URL, Secret, Events, Enabled, ConsecutiveFailures, CreatorID, CreatedAt"
+)
+
+// Matches checks whether a webhook is sent events with an action
+func (w *Webhook) Matches(action EventAction) bool {
+	if len(w.Events) == 0 {
+		return true
+	}
+	for _, a := range w.Events {
+		if a == action {
+			return true
+		}
+	}
+	return false
+}
+
+// joinActions encodes event actions as they are stored in the database
+func joinActions(actions []EventAction) string {
+	strs := make([]string, len(actions))
+	for i, action := range actions {
+		strs[i] = string(action)
+	}
+	return strings.Join(strs, ",")
+}
+
+// splitActions decodes event actions as they are stored in the database
+func splitActions(value string) []EventAction {
+	actions := make([]EventAction, 0)
+	for _, a := range strings.Split(value, ",") {
+		if a != "" {
+			actions = append(actions, EventAction(a))
+		}
+	}
+	return actions
+}
+
+// scanWebhook scans a row of the "webhooks" table that was selected with
+// webhookColumns
+func scanWebhook(row interface{ Scan(...interface{}) error }) (*Webhook, error) {
+	webhook := &Webhook{}
+	var events string
+	var enabled bool
+	err := row.Scan(
+		&webhook.ID,
+		&webhook.ConversationID,
+		&webhook.URL,
+		&webhook.Secret,
+		&events,
+		&enabled,
+		&webhook.ConsecutiveFailures,
+		&webhook.CreatorID,
+		&webhook.CreatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+	webhook.Events = splitActions(events)
+	webhook.Enabled = &enabled
+	return webhook, nil
+}
+
+// CreateWebhook adds a row to the "webhooks" table and sets the webhook's ID
+func (db *DB) CreateWebhook(ctx context.Context, webhook *Webhook) error {
+	ctx, done := instrument(ctx, "CreateWebhook")
+	defer done()
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "INSERT INTO %s(ConversationID, URL, Secret, Events, Enabled, CreatorID, CreatedAt) ", webhooksTable)
+	fmt.Fprintf(&b, "VALUES(?, ?, ?, ?, ?, ?, ?)")
+	res, err := db.conn().ExecContext(
+		ctx,
+		b.String(),
+		webhook.ConversationID,
+		webhook.URL,
+		webhook.Secret,
+		joinActions(webhook.Events),
+		webhook.Enabled != nil && *webhook.Enabled,
+		webhook.CreatorID,
+		webhook.CreatedAt,
+	)
+	if err != nil {
+		return err
+	}
+
+	id, err := res.LastInsertId()
+	if err != nil {
+		return err
+	}
+	webhook.ID = id
+	db.logger(ctx).Debugf(`Created 1 row in "%s"`, webhooksTable)
+	return nil
+}
+
+// GetWebhook queries for a single row in the "webhooks" table, returning nil
+// if there is none
+func (db *DB) GetWebhook(ctx context.Context, id int64) (*Webhook, error) {
+	ctx, done := instrument(ctx, "GetWebhook")
+	defer done()
+
+	queryString := fmt.Sprintf("SELECT %s FROM %s WHERE ID=?", webhookColumns, webhooksTable)
+	webhook, err := scanWebhook(db.conn().QueryRowContext(ctx, queryString, id))
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+		return nil, err
+	}
+	db.logger(ctx).Debugf(`Read 1 row from "%s"`, webhooksTable)
+	return webhook, nil
+}
+
+// GetWebhooks queries for every row in the "webhooks" table of a
+// conversation, in order of creation
+func (db *DB) GetWebhooks(ctx context.Context, conversationID int64) ([]*Webhook, error) {
+	ctx, done := instrument(ctx, "GetWebhooks")
+	defer done()
+
+	queryString := fmt.Sprintf(
+		"SELECT %s FROM %s WHERE ConversationID=? ORDER BY ID",
+		webhookColumns,
+		webhooksTable,
+	)
+	rows, err := db.conn().QueryContext(ctx, queryString, conversationID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	webhooks := make([]*Webhook, 0)
+	for rows.Next() {
+		webhook, err := scanWebhook(rows)
+		if err != nil {
+			return nil, err
+		}
+		webhooks = append(webhooks, webhook)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	db.logger(ctx).Debugf(`Read %d row(s) from "%s"`, len(webhooks), webhooksTable)
+	return webhooks, nil
+}
+
+// UpdateWebhook updates the URL, events and enabled status of a row in the
+// "webhooks" table. Enabling a webhook resets its consecutive failures.
+func (db *DB) UpdateWebhook(ctx context.Context, webhook *Webhook) error {
+	ctx, done := instrument(ctx, "UpdateWebhook")
+	defer done()
+
+	enabled := webhook.Enabled != nil && *webhook.Enabled
+	var b strings.Builder
+	fmt.Fprintf(&b, "UPDATE %s SET URL=?, Events=?, Enabled=?, ", webhooksTable)
+	fmt.Fprintf(&b, "ConsecutiveFailures=IF(?, 0, ConsecutiveFailures) WHERE ID=?")
+	res, err := db.conn().ExecContext(
+		ctx,
+		b.String(),
+		webhook.URL,
+		joinActions(webhook.Events),
+		enabled,
+		enabled,
+		webhook.ID,
+	)
+	if err != nil {
+		return err
+	}
+
+	if rowCount, err := res.RowsAffected(); err == nil {
+		db.logger(ctx).Debugf(`Updated %d row(s) in "%s"`, rowCount, webhooksTable)
+	}
+	return nil
+}
+
+// RecordWebhookFailure counts a failed delivery to a row in the "webhooks"
+// table, and disables it once it has failed disableAfter times in a row
+func (db *DB) RecordWebhookFailure(ctx context.Context, id int64, disableAfter int) error {
+	ctx, done := instrument(ctx, "RecordWebhookFailure")
+	defer done()
+
+	// Assignments are made in order, so Enabled is set from the new count
+	var b strings.Builder
+	fmt.Fprintf(&b, "UPDATE %s SET ConsecutiveFailures=ConsecutiveFailures+1, ", webhooksTable)
+	fmt.Fprintf(&b, "Enabled=Enabled AND ConsecutiveFailures<? WHERE ID=?")
+	res, err := db.conn().ExecContext(ctx, b.String(), disableAfter, id)
+	if err != nil {
+		return err
+	}
+
+	if rowCount, err := res.RowsAffected(); err == nil {
+		db.logger(ctx).Debugf(`Updated %d row(s) in "%s"`, rowCount, webhooksTable)
+	}
+	return nil
+}
+
+// ResetWebhookFailures sets the consecutive failed deliveries of a row in the
+// "webhooks" table to 0 after a successful delivery
+func (db *DB) ResetWebhookFailures(ctx context.Context, id int64) error {
+	ctx, done := instrument(ctx, "ResetWebhookFailures")
+	defer done()
+
+	queryString := fmt.Sprintf("UPDATE %s SET ConsecutiveFailures=0 WHERE ID=?", webhooksTable)
+	res, err := db.conn().ExecContext(ctx, queryString, id)
+	if err != nil {
+		return err
+	}
+
+	if rowCount, err := res.RowsAffected(); err == nil {
+		db.logger(ctx).Debugf(`Updated %d row(s) in "%s"`, rowCount, webhooksTable)
+	}
+	return nil
+}
+
+// DeleteWebhook removes a row from the "webhooks" table along with its
+// delivery log
+func (db *DB) DeleteWebhook(ctx context.Context, id int64) error {
+	ctx, done := instrument(ctx, "DeleteWebhook")
+	defer done()
+
+	tx, err := db.begin(ctx)
+	if err != nil {
+		return err
+	}
+
+	queries := []struct {
+		table  string
+		column string
+	}{
+		{webhookDeliveriesTable, "WebhookID"},
+		{webhooksTable, "ID"},
+	}
+	for _, query := range queries {
+		queryString := fmt.Sprintf("DELETE FROM %s WHERE %s=?", query.table, query.column)
+		res, err := tx.ExecContext(ctx, queryString, id)
+		if err != nil {
+			tx.Rollback()
+			return err
+		}
+
+		if rowCount, err := res.RowsAffected(); err == nil {
+			db.logger(ctx).Debugf(`Deleted %d row(s) from "%s"`, rowCount, query.table)
+		} else {
+			tx.Rollback()
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// CreateWebhookDelivery adds a row to the "webhook_deliveries" table and sets
+// the delivery's ID
+func (db *DB) CreateWebhookDelivery(ctx context.Context, delivery *WebhookDelivery) error {
+	ctx, done := instrument(ctx, "CreateWebhookDelivery")
+	defer done()
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "INSERT INTO %s(WebhookID, EventID, Action, Attempt, StatusCode, Error, DurationMS) ", webhookDeliveriesTable)
+	fmt.Fprintf(&b, "VALUES(?, ?, ?, ?, ?, ?, ?)")
+	res, err := db.conn().ExecContext(
+		ctx,
+		b.String(),
+		delivery.WebhookID,
+		delivery.EventID,
+		delivery.Action,
+		delivery.Attempt,
+		delivery.StatusCode,
+		delivery.Error,
+		delivery.DurationMS,
+	)
+	if err != nil {
+		return err
+	}
+
+	id, err := res.LastInsertId()
+	if err != nil {
+		return err
+	}
+	delivery.ID = id
+	db.logger(ctx).Debugf(`Created 1 row in "%s"`, webhookDeliveriesTable)
+	return nil
+}
+
+// GetWebhookDeliveries queries for the latest rows in the
+// "webhook_deliveries" table of a webhook, newest first
+func (db *DB) GetWebhookDeliveries(ctx context.Context, webhookID int64, limit int) ([]*WebhookDelivery, error) {
+	ctx, done := instrument(ctx, "GetWebhookDeliveries")
+	defer done()
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "SELECT ID, WebhookID, EventID, Action, Attempt, StatusCode, Error, DurationMS, CreatedAt ")
+	fmt.Fprintf(&b, "FROM %s WHERE WebhookID=? ORDER BY ID DESC LIMIT ?", webhookDeliveriesTable)
+	rows, err := db.conn().QueryContext(ctx, b.String(), webhookID, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	deliveries := make([]*WebhookDelivery, 0)
+	for rows.Next() {
+		delivery := &WebhookDelivery{}
+		var statusCode sql.NullInt64
+		var errStr sql.NullString
+		err := rows.Scan(
+			&delivery.ID,
+			&delivery.WebhookID,
+			&delivery.EventID,
+			&delivery.Action,
+			&delivery.Attempt,
+			&statusCode,
+			&errStr,
+			&delivery.DurationMS,
+			&delivery.CreatedAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+		if statusCode.Valid {
+			code := int(statusCode.Int64)
+			delivery.StatusCode = &code
+		}
+		if errStr.Valid {
+			delivery.Error = &errStr.String
+		}
+		deliveries = append(deliveries, delivery)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	db.logger(ctx).Debugf(`Read %d row(s) from "%s"`, len(deliveries), webhookDeliveriesTable)
+	return deliveries, nil
+}
@@ -0,0 +1,137 @@
+package ratelimit
+
+import (
+	"ether/apierror"
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// sweepInterval is how often buckets that have refilled are forgotten, so that
+// the buckets of clients that have gone away do not pile up.
+const sweepInterval = time.Minute
+
+// bucket represents the tokens left for a key.
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// Limiter is a token-bucket rate limiter with a bucket per key. Each bucket
+// holds up to burst tokens and refills at rate tokens per second.
+type Limiter struct {
+	rate  float64
+	burst float64
+
+	mu        sync.Mutex
+	buckets   map[string]*bucket
+	lastSweep time.Time
+}
+
+// NewLimiter initializes a new Limiter that allows count requests per
+// interval for each key, and up to burst requests at once.
+func NewLimiter(count int, interval time.Duration, burst int) *Limiter {
+	return &Limiter{
+		rate:    float64(count) / interval.Seconds(),
+		burst:   float64(burst),
+		buckets: make(map[string]*bucket),
+	}
+}
+
+// Allow takes n tokens from the bucket of key if it has them. Otherwise, it
+// returns how long to wait until it will. A request for more tokens than the
+// burst is allowed once the bucket is full, and leaves it in debt.
+func (l *Limiter) Allow(key string, n int) (bool, time.Duration) {
+	return l.allow(key, n, time.Now())
+}
+
+func (l *Limiter) allow(key string, n int, now time.Time) (bool, time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	b := l.bucket(key, now)
+	ok, wait := l.has(b, n)
+	if ok {
+		b.tokens -= float64(n)
+	}
+	return ok, wait
+}
+
+// Check is Allow without taking the tokens, for requests that should only be
+// charged once they succeed, with Take.
+func (l *Limiter) Check(key string, n int) (bool, time.Duration) {
+	return l.check(key, n, time.Now())
+}
+
+func (l *Limiter) check(key string, n int, now time.Time) (bool, time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	return l.has(l.bucket(key, now), n)
+}
+
+// Take takes n tokens from the bucket of key, leaving it in debt if it does
+// not have them, since requests that passed Check at the same time can
+// together take more than it had.
+func (l *Limiter) Take(key string, n int) {
+	l.take(key, n, time.Now())
+}
+
+func (l *Limiter) take(key string, n int, now time.Time) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.bucket(key, now).tokens -= float64(n)
+}
+
+// bucket returns the bucket of key, refilled up to now. l.mu must be held.
+func (l *Limiter) bucket(key string, now time.Time) *bucket {
+	if now.Sub(l.lastSweep) >= sweepInterval {
+		l.sweep(now)
+	}
+
+	b, ok := l.buckets[key]
+	if !ok {
+		b = &bucket{tokens: l.burst, last: now}
+		l.buckets[key] = b
+	} else if elapsed := now.Sub(b.last).Seconds(); elapsed > 0 {
+		b.tokens = math.Min(l.burst, b.tokens+elapsed*l.rate)
+		b.last = now
+	}
+	return b
+}
+
+// has checks if a bucket has n tokens, and otherwise returns how long to wait
+// until it will. More tokens than the burst only need a full bucket.
+func (l *Limiter) has(b *bucket, n int) (bool, time.Duration) {
+	needed := math.Min(float64(n), l.burst)
+	if b.tokens < needed {
+		return false, time.Duration((needed - b.tokens) / l.rate * float64(time.Second))
+	}
+	return true, 0
+}
+
+// sweep forgets the buckets that are full again.
+func (l *Limiter) sweep(now time.Time) {
+	for key, b := range l.buckets {
+		if b.tokens+now.Sub(b.last).Seconds()*l.rate >= l.burst {
+			delete(l.buckets, key)
+		}
+	}
+	l.lastSweep = now
+}
+
+// Reject responds with 429 Too Many Requests and a Retry-After header telling
+// the client how many seconds to wait.
+func Reject(w http.ResponseWriter, retryAfter time.Duration, msg string) {
+	seconds := int64(math.Ceil(retryAfter.Seconds()))
+	if seconds < 1 {
+		seconds = 1
+	}
+	w.Header().Set("Retry-After", strconv.FormatInt(seconds, 10))
+	apierror.WriteDetails(w, msg, http.StatusTooManyRequests, apierror.RateLimited, apierror.Details{
+		"retry_after": seconds,
+	})
+}
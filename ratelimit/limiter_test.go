@@ -0,0 +1,186 @@
+package ratelimit
+
+import (
+	"encoding/json"
+	"ether/apierror"
+	"ether/logging"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+func TestLimiterAllow(t *testing.T) {
+	start := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	type request struct {
+		Key        string
+		N          int
+		At         time.Duration
+		Allowed    bool
+		RetryAfter time.Duration
+	}
+	tests := []struct {
+		Name     string
+		Requests []request
+	}{
+		{
+			Name: "Burst is allowed then limited",
+			Requests: []request{
+				{Key: "a", N: 1, At: 0, Allowed: true},
+				{Key: "a", N: 1, At: 0, Allowed: true},
+				{Key: "a", N: 1, At: 0, Allowed: true},
+				{Key: "a", N: 1, At: 0, Allowed: false, RetryAfter: time.Second},
+			},
+		},
+		{
+			Name: "Bucket refills over time",
+			Requests: []request{
+				{Key: "a", N: 3, At: 0, Allowed: true},
+				{Key: "a", N: 1, At: 500 * time.Millisecond, Allowed: false, RetryAfter: 500 * time.Millisecond},
+				{Key: "a", N: 1, At: time.Second, Allowed: true},
+				{Key: "a", N: 3, At: time.Hour, Allowed: true},
+			},
+		},
+		{
+			Name: "Keys have separate buckets",
+			Requests: []request{
+				{Key: "a", N: 3, At: 0, Allowed: true},
+				{Key: "b", N: 3, At: 0, Allowed: true},
+				{Key: "a", N: 1, At: 0, Allowed: false, RetryAfter: time.Second},
+			},
+		},
+		{
+			Name: "More than the burst leaves the bucket in debt",
+			Requests: []request{
+				{Key: "a", N: 5, At: 0, Allowed: true},
+				{Key: "a", N: 1, At: 2 * time.Second, Allowed: false, RetryAfter: time.Second},
+				{Key: "a", N: 1, At: 3 * time.Second, Allowed: true},
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.Name, func(t *testing.T) {
+			// 1 token per second, up to 3 at once
+			l := NewLimiter(60, time.Minute, 3)
+			for i, req := range test.Requests {
+				allowed, retryAfter := l.allow(req.Key, req.N, start.Add(req.At))
+				if allowed != req.Allowed || retryAfter != req.RetryAfter {
+					t.Errorf(
+						"Request %d got (%t, %s), expected (%t, %s)",
+						i+1,
+						allowed,
+						retryAfter,
+						req.Allowed,
+						req.RetryAfter,
+					)
+				}
+			}
+		})
+	}
+}
+
+func TestMiddleware(t *testing.T) {
+	tests := []struct {
+		Name       string
+		Method     string
+		UserID     string
+		Requests   int
+		StatusCode int
+	}{
+		{
+			Name:       "Reads within burst",
+			Method:     "GET",
+			UserID:     "1",
+			Requests:   2,
+			StatusCode: http.StatusOK,
+		},
+		{
+			Name:       "Reads over burst",
+			Method:     "GET",
+			UserID:     "1",
+			Requests:   3,
+			StatusCode: http.StatusTooManyRequests,
+		},
+		{
+			Name:       "Writes over burst",
+			Method:     "POST",
+			UserID:     "1",
+			Requests:   2,
+			StatusCode: http.StatusTooManyRequests,
+		},
+		{
+			Name:       "Requests without a user",
+			Method:     "POST",
+			Requests:   5,
+			StatusCode: http.StatusOK,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.Name, func(t *testing.T) {
+			router := mux.NewRouter()
+			router.HandleFunc("/ether/v1/conversations", func(w http.ResponseWriter, r *http.Request) {})
+			router.Use(Middleware(
+				NewLimiter(1, time.Minute, 2),
+				NewLimiter(1, time.Minute, 1),
+				logging.New(ioutil.Discard, logging.LevelError),
+			))
+
+			var w *httptest.ResponseRecorder
+			for i := 0; i < test.Requests; i++ {
+				r := httptest.NewRequest(test.Method, "/ether/v1/conversations", nil)
+				if test.UserID != "" {
+					r.Header.Set("User-ID", test.UserID)
+				}
+				w = httptest.NewRecorder()
+				router.ServeHTTP(w, r)
+			}
+
+			if w.Code != test.StatusCode {
+				t.Errorf("Response has incorrect status code, expected status code %d, got %d", test.StatusCode, w.Code)
+			}
+			if w.Code == http.StatusTooManyRequests && w.Header().Get("Retry-After") != "60" {
+				t.Errorf("Response has incorrect Retry-After, expected %q, got %q", "60", w.Header().Get("Retry-After"))
+			}
+			if w.Code == http.StatusTooManyRequests {
+				resBody := apierror.Error{}
+				_ = json.NewDecoder(w.Body).Decode(&resBody)
+				if resBody.Code != apierror.RateLimited {
+					t.Errorf("Response has incorrect error code, expected %q, got %q", apierror.RateLimited, resBody.Code)
+				}
+			}
+		})
+	}
+}
+
+func TestLimiterCheckAndTake(t *testing.T) {
+	start := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	// 1 token per second, up to 3 at once
+	l := NewLimiter(60, time.Minute, 3)
+
+	// Checking does not take tokens
+	for i := 0; i < 5; i++ {
+		if allowed, _ := l.check("a", 3, start); !allowed {
+			t.Fatalf("Check %d was not allowed", i+1)
+		}
+	}
+
+	l.take("a", 2, start)
+	if allowed, retryAfter := l.check("a", 2, start); allowed || retryAfter != time.Second {
+		t.Errorf("Check got (%t, %s), expected (false, 1s)", allowed, retryAfter)
+	}
+
+	// Taking more than is left leaves the bucket in debt
+	l.take("a", 3, start)
+	if allowed, retryAfter := l.check("a", 1, start); allowed || retryAfter != 3*time.Second {
+		t.Errorf("Check got (%t, %s), expected (false, 3s)", allowed, retryAfter)
+	}
+	if allowed, _ := l.check("b", 3, start); !allowed {
+		t.Error("Check of another key was not allowed")
+	}
+}
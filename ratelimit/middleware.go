@@ -0,0 +1,56 @@
+package ratelimit
+
+import (
+	"ether/logging"
+	"ether/metrics"
+	"ether/utils"
+	"net/http"
+	"strconv"
+)
+
+// isWrite checks whether a request changes anything, and so is limited by the
+// budget for writes rather than reads.
+func isWrite(r *http.Request) bool {
+	switch r.Method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions:
+		return false
+	}
+	return true
+}
+
+// Middleware limits how often each session user can call each route, with
+// separate limiters for reads and writes. A nil limiter does not limit its
+// requests. Requests without a User-ID, such as health checks, are not
+// limited.
+func Middleware(reads, writes *Limiter, logger *logging.Logger) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			limiter := reads
+			if isWrite(r) {
+				limiter = writes
+			}
+			userID, err := strconv.ParseInt(r.Header.Get("User-ID"), 10, 64)
+			if limiter == nil || err != nil {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			route := utils.RouteTemplate(r)
+			key := strconv.FormatInt(userID, 10) + " " + route
+			if ok, retryAfter := limiter.Allow(key, 1); !ok {
+				logger.WithContext(r.Context()).Infof(
+					"User %d is rate limited on %s %s for %s",
+					userID,
+					r.Method,
+					route,
+					retryAfter,
+				)
+				metrics.HTTPRateLimited.WithLabelValues(route, r.Method).Inc()
+				Reject(w, retryAfter, "Too many requests")
+				return
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
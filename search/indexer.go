@@ -0,0 +1,56 @@
+package search
+
+import (
+	"context"
+	"ether/filesystem"
+	"ether/logging"
+	"ether/models"
+	"fmt"
+)
+
+// Indexer maintains the full-text search index of conversation content.
+type Indexer struct {
+	db     models.Datastore
+	logger *logging.Logger
+}
+
+// NewIndexer initializes a new Indexer.
+func NewIndexer(db models.Datastore, logger *logging.Logger) *Indexer {
+	return &Indexer{db: db, logger: logger}
+}
+
+// Index updates the search index entry of a conversation with the text of its
+// HTML content.
+func (i *Indexer) Index(ctx context.Context, conversationID int64, content string) error {
+	return i.db.IndexConversationContent(ctx, conversationID, StripHTML(content))
+}
+
+// Reindex rebuilds the search index from every content file in a directory.
+// A conversation that cannot be reindexed is logged and skipped, so that one
+// bad file does not stop the rest from being indexed, and an error counting
+// the failures is returned once every conversation has been tried.
+func (i *Indexer) Reindex(ctx context.Context, directory *filesystem.Directory) error {
+	conversationIDs, err := directory.List()
+	if err != nil {
+		return err
+	}
+
+	logger := i.logger.WithContext(ctx)
+	failed := 0
+	for _, conversationID := range conversationIDs {
+		content, err := directory.ReadFile(conversationID)
+		if err == nil {
+			err = i.Index(ctx, conversationID, string(content))
+		}
+		if err != nil {
+			logger.With("conversation_id", conversationID).Errorf("Failed to reindex conversation: %v", err)
+			failed++
+		}
+	}
+
+	logger.Infof("Reindexed %d conversation(s)", len(conversationIDs)-failed)
+	if failed > 0 {
+		return fmt.Errorf("Failed to reindex %d of %d conversation(s)", failed, len(conversationIDs))
+	}
+	return nil
+}
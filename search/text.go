@@ -0,0 +1,133 @@
+package search
+
+import (
+	"html"
+	"sort"
+	"strings"
+	"unicode"
+)
+
+const (
+	snippetContext = 60
+	highlightOpen  = "<mark>"
+	highlightClose = "</mark>"
+)
+
+// StripHTML removes all markup from an HTML document and returns its text
+// content with entities decoded and whitespace collapsed.
+func StripHTML(content string) string {
+	var b strings.Builder
+	inTag := false
+	for _, r := range content {
+		switch {
+		case r == '<':
+			inTag = true
+			b.WriteRune(' ')
+		case r == '>' && inTag:
+			inTag = false
+		case !inTag:
+			b.WriteRune(r)
+		}
+	}
+	return strings.Join(strings.Fields(html.UnescapeString(b.String())), " ")
+}
+
+// Terms splits a search query into its lowercase, de-duplicated alphanumeric
+// terms.
+func Terms(query string) []string {
+	fields := strings.FieldsFunc(query, func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+
+	seen := make(map[string]bool)
+	terms := make([]string, 0, len(fields))
+	for _, field := range fields {
+		term := strings.ToLower(field)
+		if seen[term] {
+			continue
+		}
+		seen[term] = true
+		terms = append(terms, term)
+	}
+	return terms
+}
+
+// match represents the rune offsets of a term occurrence in a text.
+type match struct {
+	start int
+	end   int
+}
+
+// findMatches returns the case-insensitive occurrences of all terms in text,
+// ordered by position.
+func findMatches(text []rune, terms []string) []match {
+	lower := make([]rune, len(text))
+	for i, r := range text {
+		lower[i] = unicode.ToLower(r)
+	}
+
+	matches := make([]match, 0)
+	for _, term := range terms {
+		termRunes := []rune(term)
+		if len(termRunes) == 0 {
+			continue
+		}
+		for i := 0; i+len(termRunes) <= len(lower); i++ {
+			if string(lower[i:i+len(termRunes)]) == term {
+				matches = append(matches, match{start: i, end: i + len(termRunes)})
+			}
+		}
+	}
+
+	sort.Slice(matches, func(i, j int) bool {
+		return matches[i].start < matches[j].start
+	})
+	return matches
+}
+
+// Snippets returns up to max excerpts of text surrounding occurrences of the
+// given terms. Excerpts are HTML-escaped with each occurrence wrapped in a
+// <mark> element.
+func Snippets(text string, terms []string, max int) []string {
+	runes := []rune(text)
+	matches := findMatches(runes, terms)
+
+	snippets := make([]string, 0)
+	for i := 0; i < len(matches) && len(snippets) < max; {
+		start := matches[i].start - snippetContext
+		if start < 0 {
+			start = 0
+		}
+		end := matches[i].end + snippetContext
+		if end > len(runes) {
+			end = len(runes)
+		}
+
+		var b strings.Builder
+		if start > 0 {
+			b.WriteString("…")
+		}
+		pos := start
+		for ; i < len(matches) && matches[i].start < end; i++ {
+			m := matches[i]
+			if m.start < pos {
+				// Overlaps a previously highlighted occurrence
+				continue
+			}
+			if m.end > end {
+				end = m.end
+			}
+			b.WriteString(html.EscapeString(string(runes[pos:m.start])))
+			b.WriteString(highlightOpen)
+			b.WriteString(html.EscapeString(string(runes[m.start:m.end])))
+			b.WriteString(highlightClose)
+			pos = m.end
+		}
+		b.WriteString(html.EscapeString(string(runes[pos:end])))
+		if end < len(runes) {
+			b.WriteString("…")
+		}
+		snippets = append(snippets, b.String())
+	}
+	return snippets
+}
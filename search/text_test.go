@@ -0,0 +1,183 @@
+package search
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestStripHTML(t *testing.T) {
+	tests := []struct {
+		Name    string
+		Content string
+		Text    string
+	}{
+		{
+			Name:    "Plain text",
+			Content: "hello world",
+			Text:    "hello world",
+		},
+		{
+			Name:    "Tags are removed",
+			Content: "<p>hello <b>world</b></p>",
+			Text:    "hello world",
+		},
+		{
+			Name:    "Adjacent blocks stay separate words",
+			Content: "<p>hello</p><p>world</p>",
+			Text:    "hello world",
+		},
+		{
+			Name:    "Entities are decoded",
+			Content: "<p>fish &amp; chips &lt;3</p>",
+			Text:    "fish & chips <3",
+		},
+		{
+			Name:    "Whitespace is collapsed",
+			Content: "  hello \n\t <br/>  world  ",
+			Text:    "hello world",
+		},
+		{
+			Name:    "Attributes are removed",
+			Content: `<a href="https://example.com" title="home">link</a>`,
+			Text:    "link",
+		},
+		{
+			Name:    "Empty document",
+			Content: "<html><body></body></html>",
+			Text:    "",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.Name, func(t *testing.T) {
+			if text := StripHTML(test.Content); text != test.Text {
+				t.Errorf("StripHTML returned incorrect text, expected %q, got %q", test.Text, text)
+			}
+		})
+	}
+}
+
+func TestTerms(t *testing.T) {
+	tests := []struct {
+		Name  string
+		Query string
+		Terms []string
+	}{
+		{
+			Name:  "Single term",
+			Query: "hello",
+			Terms: []string{"hello"},
+		},
+		{
+			Name:  "Terms are lowercased",
+			Query: "Hello WORLD",
+			Terms: []string{"hello", "world"},
+		},
+		{
+			Name:  "Terms are de-duplicated",
+			Query: "hello world Hello",
+			Terms: []string{"hello", "world"},
+		},
+		{
+			Name:  "Punctuation splits terms",
+			Query: "fish&chips, 2020-01-01!",
+			Terms: []string{"fish", "chips", "2020", "01"},
+		},
+		{
+			Name:  "Non-ASCII letters are kept",
+			Query: "café Straße",
+			Terms: []string{"café", "straße"},
+		},
+		{
+			Name:  "No terms",
+			Query: " -- !? ",
+			Terms: []string{},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.Name, func(t *testing.T) {
+			if terms := Terms(test.Query); !reflect.DeepEqual(terms, test.Terms) {
+				t.Errorf("Terms returned incorrect terms, expected %q, got %q", test.Terms, terms)
+			}
+		})
+	}
+}
+
+func TestSnippets(t *testing.T) {
+	long := strings.Repeat("a", snippetContext+10)
+
+	tests := []struct {
+		Name     string
+		Text     string
+		Terms    []string
+		Max      int
+		Snippets []string
+	}{
+		{
+			Name:     "No match",
+			Text:     "hello world",
+			Terms:    []string{"bye"},
+			Max:      3,
+			Snippets: []string{},
+		},
+		{
+			Name:     "Match is highlighted case-insensitively",
+			Text:     "Hello World",
+			Terms:    []string{"world"},
+			Max:      3,
+			Snippets: []string{"Hello <mark>World</mark>"},
+		},
+		{
+			Name:     "Text is escaped",
+			Text:     "<b> & world",
+			Terms:    []string{"world"},
+			Max:      3,
+			Snippets: []string{"&lt;b&gt; &amp; <mark>world</mark>"},
+		},
+		{
+			Name:     "Nearby matches share a snippet",
+			Text:     "hello big world",
+			Terms:    []string{"hello", "world"},
+			Max:      3,
+			Snippets: []string{"<mark>hello</mark> big <mark>world</mark>"},
+		},
+		{
+			Name:     "Overlapping matches are highlighted once",
+			Text:     "hello",
+			Terms:    []string{"hell", "ello"},
+			Max:      3,
+			Snippets: []string{"<mark>hell</mark>o"},
+		},
+		{
+			Name:  "Distant matches get ellipses",
+			Text:  "x" + long + "x" + long + "x",
+			Terms: []string{"x"},
+			Max:   3,
+			Snippets: []string{
+				"<mark>x</mark>" + long[:snippetContext] + "…",
+				"…" + long[10:] + "<mark>x</mark>" + long[:snippetContext] + "…",
+				"…" + long[10:] + "<mark>x</mark>",
+			},
+		},
+		{
+			Name:  "Snippets are limited",
+			Text:  "x" + long + "x" + long + "x",
+			Terms: []string{"x"},
+			Max:   1,
+			Snippets: []string{
+				"<mark>x</mark>" + long[:snippetContext] + "…",
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.Name, func(t *testing.T) {
+			snippets := Snippets(test.Text, test.Terms, test.Max)
+			if !reflect.DeepEqual(snippets, test.Snippets) {
+				t.Errorf("Snippets returned incorrect snippets, expected %q, got %q", test.Snippets, snippets)
+			}
+		})
+	}
+}
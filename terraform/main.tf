@@ -91,7 +91,7 @@ module "ether" {
   db_location     = module.rds_instance.db_endpoint
   db_username     = var.rds_username
   db_password     = var.rds_password
-  kafka_server    = split(",", aws_msk_cluster.main.bootstrap_brokers)[0]
+  kafka_brokers   = aws_msk_cluster.main.bootstrap_brokers
   kafka_topic     = "updates"
   karen_endpoint  = module.karen.elb_dns_name
   efs_id          = aws_efs_file_system.ether.id
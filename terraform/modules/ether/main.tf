@@ -43,15 +43,15 @@ resource "aws_ecs_task_definition" "ether" {
             "value": "/tmp"
         },
         {
-            "name": "ETHER_KAFKA_SERVER",
-            "value": "${var.kafka_server}"
+            "name": "ETHER_KAFKA_BROKERS",
+            "value": "${var.kafka_brokers}"
         },
         {
             "name": "ETHER_KAFKA_TOPIC",
             "value": "${var.kafka_topic}"
         },
         {
-            "name": "KAREN_SERVER",
+            "name": "ETHER_KAREN_SERVER",
             "value": "${var.karen_endpoint}"
         }
     ],
@@ -93,6 +93,14 @@ resource "aws_elb" "ether" {
     lb_port           = 80
     lb_protocol       = "http"
   }
+
+  health_check {
+    target              = "HTTP:${var.port}/readyz"
+    interval            = 15
+    timeout             = 5
+    healthy_threshold   = 2
+    unhealthy_threshold = 3
+  }
 }
 
 resource "aws_ecs_service" "ether" {
@@ -56,9 +56,9 @@ variable "db_password" {
   description = "Password for accessing the MariaDB server"
 }
 
-variable "kafka_server" {
+variable "kafka_brokers" {
   type        = string
-  description = "Server where Kafka is running"
+  description = "Comma-separated list of Kafka brokers"
 }
 
 variable "kafka_topic" {
@@ -0,0 +1,143 @@
+package tracing
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// recordSpans installs a tracer provider that records ended spans for the
+// duration of a test.
+func recordSpans(t *testing.T) *tracetest.SpanRecorder {
+	recorder := tracetest.NewSpanRecorder()
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))
+	previousProvider := otel.GetTracerProvider()
+	previousPropagator := otel.GetTextMapPropagator()
+	otel.SetTracerProvider(provider)
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+	t.Cleanup(func() {
+		otel.SetTracerProvider(previousProvider)
+		otel.SetTextMapPropagator(previousPropagator)
+	})
+	return recorder
+}
+
+func TestSetup(t *testing.T) {
+	tests := []struct {
+		Name     string
+		Exporter string
+		Error    bool
+	}{
+		{Name: "Default exporter", Exporter: ""},
+		{Name: "No exporter", Exporter: ExporterNone},
+		{Name: "Stdout exporter", Exporter: ExporterStdout},
+		{Name: "Invalid exporter", Exporter: "jaeger", Error: true},
+	}
+
+	previousProvider := otel.GetTracerProvider()
+	previousPropagator := otel.GetTextMapPropagator()
+	defer func() {
+		otel.SetTracerProvider(previousProvider)
+		otel.SetTextMapPropagator(previousPropagator)
+	}()
+
+	for _, test := range tests {
+		t.Run(test.Name, func(t *testing.T) {
+			shutdown, err := Setup(context.Background(), test.Exporter)
+			if test.Error {
+				if err == nil {
+					t.Error("Setup did not return an error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Setup returned an error: %v", err)
+			}
+			if err := shutdown(context.Background()); err != nil {
+				t.Errorf("Shutdown returned an error: %v", err)
+			}
+		})
+	}
+}
+
+func TestMiddleware(t *testing.T) {
+	recorder := recordSpans(t)
+
+	parent := trace.NewSpanContext(trace.SpanContextConfig{
+		TraceID:    trace.TraceID{1, 2, 3},
+		SpanID:     trace.SpanID{4, 5, 6},
+		TraceFlags: trace.FlagsSampled,
+	})
+
+	var handlerSpan trace.SpanContext
+	handler := Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		handlerSpan = trace.SpanContextFromContext(r.Context())
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+
+	r := httptest.NewRequest("GET", "/ether/v1/conversations", nil)
+	otel.GetTextMapPropagator().Inject(
+		trace.ContextWithSpanContext(context.Background(), parent),
+		propagation.HeaderCarrier(r.Header),
+	)
+	handler.ServeHTTP(httptest.NewRecorder(), r)
+
+	spans := recorder.Ended()
+	if len(spans) != 1 {
+		t.Fatalf("Incorrect number of spans, expected %d, got %d", 1, len(spans))
+	}
+	span := spans[0]
+
+	if span.Name() != "GET unmatched" {
+		t.Errorf("Span has incorrect name, expected %q, got %q", "GET unmatched", span.Name())
+	}
+	if span.Parent().SpanID() != parent.SpanID() || span.SpanContext().TraceID() != parent.TraceID() {
+		t.Error("Span does not continue the propagated trace")
+	}
+	if !handlerSpan.Equal(span.SpanContext()) {
+		t.Error("Request context does not have the span")
+	}
+	if span.Status().Code != codes.Error {
+		t.Errorf("Span has incorrect status, expected %v, got %v", codes.Error, span.Status().Code)
+	}
+
+	expected := attribute.Int("http.status_code", http.StatusServiceUnavailable)
+	found := false
+	for _, attr := range span.Attributes() {
+		if attr == expected {
+			found = true
+		}
+	}
+	if !found {
+		t.Errorf("Span is missing attribute %v", expected)
+	}
+}
+
+func TestFail(t *testing.T) {
+	recorder := recordSpans(t)
+
+	_, span := Tracer().Start(context.Background(), "test")
+	Fail(span, errors.New("test error"))
+	span.End()
+
+	spans := recorder.Ended()
+	if len(spans) != 1 {
+		t.Fatalf("Incorrect number of spans, expected %d, got %d", 1, len(spans))
+	}
+	if status := spans[0].Status(); status.Code != codes.Error || status.Description != "test error" {
+		t.Errorf("Span has incorrect status, got %+v", status)
+	}
+	if events := spans[0].Events(); len(events) != 1 || events[0].Name != "exception" {
+		t.Errorf("Span did not record the error, got events %+v", events)
+	}
+}
@@ -0,0 +1,28 @@
+package utils
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestStatusRecorder(t *testing.T) {
+	w := httptest.NewRecorder()
+	recorder := NewStatusRecorder(w)
+
+	var rw http.ResponseWriter = recorder
+	flusher, ok := rw.(http.Flusher)
+	if !ok {
+		t.Fatal("StatusRecorder does not implement http.Flusher")
+	}
+
+	recorder.WriteHeader(http.StatusAccepted)
+	flusher.Flush()
+
+	if recorder.Status != http.StatusAccepted {
+		t.Errorf("StatusRecorder has incorrect status, expected %d, got %d", http.StatusAccepted, recorder.Status)
+	}
+	if !w.Flushed {
+		t.Error("StatusRecorder did not flush the wrapped writer")
+	}
+}
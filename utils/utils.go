@@ -7,3 +7,7 @@ func StringPtr(s string) *string {
 func BoolPtr(b bool) *bool {
 	return &b
 }
+
+func Int64Ptr(i int64) *int64 {
+	return &i
+}
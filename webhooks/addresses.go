@@ -0,0 +1,88 @@
+package webhooks
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"syscall"
+)
+
+// ErrAddressNotAllowed is returned for webhook hosts that resolve to an
+// address that webhooks cannot be sent to.
+var ErrAddressNotAllowed = errors.New("Webhook address is not allowed")
+
+// Resolver looks up the IP addresses of a host, as net.Resolver does.
+type Resolver interface {
+	LookupIPAddr(ctx context.Context, host string) ([]net.IPAddr, error)
+}
+
+// AddressPolicy decides which addresses webhooks can be registered for and
+// sent to. Loopback, private, link-local and unspecified addresses are not
+// allowed, so that webhooks cannot be used to reach the services on Ether's
+// own network, unless AllowPrivateNetworks is set.
+type AddressPolicy struct {
+	// AllowPrivateNetworks allows every address, such as in development.
+	AllowPrivateNetworks bool
+
+	// Resolver looks up the addresses of hosts, and is net.DefaultResolver if
+	// nil.
+	Resolver Resolver
+}
+
+// AllowedIP checks if webhooks can be sent to an IP address.
+func (p *AddressPolicy) AllowedIP(ip net.IP) bool {
+	if p != nil && p.AllowPrivateNetworks {
+		return true
+	}
+	return !(ip.IsLoopback() ||
+		ip.IsPrivate() ||
+		ip.IsLinkLocalUnicast() ||
+		ip.IsLinkLocalMulticast() ||
+		ip.IsInterfaceLocalMulticast() ||
+		ip.IsUnspecified())
+}
+
+// CheckHost resolves the host of a webhook URL and returns
+// ErrAddressNotAllowed if any of its addresses is not allowed.
+func (p *AddressPolicy) CheckHost(ctx context.Context, host string) error {
+	if ip := net.ParseIP(host); ip != nil {
+		if !p.AllowedIP(ip) {
+			return ErrAddressNotAllowed
+		}
+		return nil
+	}
+
+	var resolver Resolver = net.DefaultResolver
+	if p != nil && p.Resolver != nil {
+		resolver = p.Resolver
+	}
+	addrs, err := resolver.LookupIPAddr(ctx, host)
+	if err != nil {
+		return err
+	}
+	if len(addrs) == 0 {
+		return fmt.Errorf("No addresses found for %s", host)
+	}
+	for _, addr := range addrs {
+		if !p.AllowedIP(addr.IP) {
+			return ErrAddressNotAllowed
+		}
+	}
+	return nil
+}
+
+// control is a net.Dialer Control function that refuses connections to
+// addresses that are not allowed. It checks the address that is actually
+// dialed, so a host cannot pass CheckHost and then resolve to another address.
+func (p *AddressPolicy) control(network, address string, c syscall.RawConn) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	ip := net.ParseIP(host)
+	if ip == nil || !p.AllowedIP(ip) {
+		return ErrAddressNotAllowed
+	}
+	return nil
+}
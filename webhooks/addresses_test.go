@@ -0,0 +1,71 @@
+package webhooks
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+)
+
+// mockResolver resolves hosts from a map.
+type mockResolver map[string][]string
+
+func (r mockResolver) LookupIPAddr(ctx context.Context, host string) ([]net.IPAddr, error) {
+	ips, ok := r[host]
+	if !ok {
+		return nil, &net.DNSError{Err: "no such host", Name: host, IsNotFound: true}
+	}
+	addrs := make([]net.IPAddr, len(ips))
+	for i, ip := range ips {
+		addrs[i] = net.IPAddr{IP: net.ParseIP(ip)}
+	}
+	return addrs, nil
+}
+
+func TestAddressPolicyCheckHost(t *testing.T) {
+	resolver := mockResolver{
+		"hooks.example.com": {"93.184.216.34", "2606:2800:220:1:248:1893:25c8:1946"},
+		"internal.example":  {"10.0.0.5"},
+		"mixed.example":     {"93.184.216.34", "127.0.0.1"},
+	}
+
+	tests := []struct {
+		Name    string
+		Host    string
+		Allowed bool
+	}{
+		{Name: "Public host", Host: "hooks.example.com", Allowed: true},
+		{Name: "Public IP", Host: "93.184.216.34", Allowed: true},
+		{Name: "Private host", Host: "internal.example"},
+		{Name: "Host with a loopback address", Host: "mixed.example"},
+		{Name: "Loopback IPv4", Host: "127.0.0.1"},
+		{Name: "Loopback IPv6", Host: "::1"},
+		{Name: "IPv4-mapped loopback", Host: "::ffff:127.0.0.1"},
+		{Name: "Private IPv4", Host: "192.168.1.1"},
+		{Name: "Private IPv6", Host: "fd00::1"},
+		{Name: "Link-local", Host: "169.254.169.254"},
+		{Name: "Unspecified", Host: "0.0.0.0"},
+	}
+
+	policy := &AddressPolicy{Resolver: resolver}
+	for _, test := range tests {
+		t.Run(test.Name, func(t *testing.T) {
+			err := policy.CheckHost(context.Background(), test.Host)
+			if test.Allowed && err != nil {
+				t.Errorf("Expected host to be allowed, got %v", err)
+			}
+			if !test.Allowed && !errors.Is(err, ErrAddressNotAllowed) {
+				t.Errorf("Expected %v, got %v", ErrAddressNotAllowed, err)
+			}
+		})
+	}
+
+	if err := policy.CheckHost(context.Background(), "missing.example"); err == nil || errors.Is(err, ErrAddressNotAllowed) {
+		t.Errorf("Expected resolution error, got %v", err)
+	}
+
+	allowAll := &AddressPolicy{AllowPrivateNetworks: true, Resolver: resolver}
+	if err := allowAll.CheckHost(context.Background(), "internal.example"); err != nil {
+		t.Errorf("Expected private host to be allowed, got %v", err)
+	}
+}
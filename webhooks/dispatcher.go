@@ -0,0 +1,460 @@
+package webhooks
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"ether/logging"
+	"ether/models"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	// SignatureHeader carries "sha256=" followed by the hex-encoded
+	// HMAC-SHA256 of the timestamp, a period and the request body, keyed by
+	// the webhook's secret.
+	SignatureHeader = "X-Ether-Signature"
+
+	// TimestampHeader carries the Unix time at which a delivery was signed, so
+	// that receivers can reject old deliveries being replayed.
+	TimestampHeader = "X-Ether-Timestamp"
+
+	// EventHeader carries the action of the delivered event.
+	EventHeader = "X-Ether-Event"
+
+	// DeliveryHeader carries the ID of the delivered event, which is the same
+	// for every attempt to deliver it.
+	DeliveryHeader = "X-Ether-Delivery"
+
+	queueSize = 1000
+
+	// maxContentEditDelay is how long content edits can be debounced while a
+	// conversation is edited without pausing.
+	maxContentEditDelay = 10 * time.Minute
+
+	// maxErrorLength is how much of an error is kept in the delivery log.
+	maxErrorLength = 1024
+
+	// secretPrefix identifies webhook secrets, such as in leaked credential
+	// scans.
+	secretPrefix = "whsec_"
+
+	// maxResponseLength is how much of a response body is read before the
+	// connection is reused.
+	maxResponseLength = 64 << 10
+)
+
+// Config represents the options of a Dispatcher.
+type Config struct {
+	// Workers is how many events are delivered at a time.
+	Workers int
+
+	// MaxAttempts is how many times an event is sent to a webhook before the
+	// delivery fails.
+	MaxAttempts int
+
+	// RetryBackoff is how long to wait before the first retry, doubled before
+	// each one after it.
+	RetryBackoff time.Duration
+
+	// DisableAfter is how many deliveries to a webhook fail in a row before it
+	// is disabled.
+	DisableAfter int
+
+	// ContentEditDebounce is how long a conversation's content must not be
+	// edited before its edits are delivered as one content_edited event.
+	ContentEditDebounce time.Duration
+
+	// Timeout is the timeout of each request to a webhook.
+	Timeout time.Duration
+
+	// AllowPrivateNetworks lets requests be sent to loopback, private,
+	// link-local and unspecified addresses, such as in development.
+	AllowPrivateNetworks bool
+}
+
+// DefaultConfig returns the default options of a Dispatcher.
+func DefaultConfig() Config {
+	return Config{
+		Workers:             4,
+		MaxAttempts:         5,
+		RetryBackoff:        time.Second,
+		DisableAfter:        10,
+		ContentEditDebounce: time.Minute,
+		Timeout:             10 * time.Second,
+	}
+}
+
+// Payload represents the body of a request to a webhook.
+type Payload struct {
+	WebhookID int64         `json:"webhook_id"`
+	Event     *models.Event `json:"event"`
+}
+
+// pendingEdits represents content_edited events of a conversation that are
+// being debounced.
+type pendingEdits struct {
+	event  *models.Event
+	values models.ContentEditValues
+	first  time.Time
+	last   time.Time
+}
+
+// Dispatcher sends conversation events to the webhooks of their conversations.
+type Dispatcher struct {
+	db     models.Datastore
+	logger *logging.Logger
+	config Config
+	client *http.Client
+	queue  chan *models.Event
+
+	mu    sync.Mutex
+	edits map[int64]*pendingEdits
+}
+
+// NewDispatcher initializes a new Dispatcher.
+func NewDispatcher(db models.Datastore, logger *logging.Logger, config Config) *Dispatcher {
+	return &Dispatcher{
+		db:     db,
+		logger: logger,
+		config: config,
+		client: newClient(config),
+		queue:  make(chan *models.Event, queueSize),
+		edits:  make(map[int64]*pendingEdits),
+	}
+}
+
+// newClient initializes the client that requests are sent to webhooks with.
+// It only connects to the addresses that the AddressPolicy allows, without a
+// proxy that would connect for it, and does not follow redirects, which could
+// lead to any address.
+func newClient(config Config) *http.Client {
+	policy := &AddressPolicy{AllowPrivateNetworks: config.AllowPrivateNetworks}
+	dialer := &net.Dialer{
+		Timeout:   30 * time.Second,
+		KeepAlive: 30 * time.Second,
+		Control:   policy.control,
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.Proxy = nil
+	transport.DialContext = dialer.DialContext
+
+	return &http.Client{
+		Timeout:   config.Timeout,
+		Transport: transport,
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+}
+
+// NewSecret generates a random secret for signing the deliveries to a webhook.
+func NewSecret() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return secretPrefix + hex.EncodeToString(b), nil
+}
+
+// Sign returns the signature of a delivery, as sent in SignatureHeader.
+func Sign(secret, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// Notify queues an event that has been recorded to be delivered to the
+// webhooks of its conversation. Content edits are debounced first. Events are
+// dropped if the queue is full, so that recording them never blocks.
+func (d *Dispatcher) Notify(event *models.Event) {
+	if event.Action == models.EventContentEdited {
+		d.debounce(event, time.Now())
+		return
+	}
+	d.enqueue(event)
+}
+
+func (d *Dispatcher) enqueue(event *models.Event) {
+	select {
+	case d.queue <- event:
+	default:
+		d.logger.Warnf(
+			"Webhook queue is full, dropping %s event %d of conversation %d",
+			event.Action,
+			event.ID,
+			event.ConversationID,
+		)
+	}
+}
+
+// debounce merges a content_edited event into those of its conversation that
+// have not been delivered yet.
+func (d *Dispatcher) debounce(event *models.Event, now time.Time) {
+	var values models.ContentEditValues
+	if err := json.Unmarshal(event.After, &values); err != nil {
+		d.logger.Warnf("Failed to read content_edited event %d: %v", event.ID, err)
+		d.enqueue(event)
+		return
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	pending, ok := d.edits[event.ConversationID]
+	if !ok {
+		merged := *event
+		d.edits[event.ConversationID] = &pendingEdits{
+			event:  &merged,
+			values: values,
+			first:  now,
+			last:   now,
+		}
+		return
+	}
+
+	// The merged event is identified by the latest one, and only has an actor
+	// if every edit was made by the same user
+	if pending.event.ActorID != nil && (event.ActorID == nil || *event.ActorID != *pending.event.ActorID) {
+		pending.event.ActorID = nil
+	}
+	pending.event.ID = event.ID
+	pending.event.CreatedAt = event.CreatedAt
+	pending.values.Edits += values.Edits
+	if values.FirstEditAt < pending.values.FirstEditAt {
+		pending.values.FirstEditAt = values.FirstEditAt
+	}
+	if values.LastEditAt > pending.values.LastEditAt {
+		pending.values.LastEditAt = values.LastEditAt
+	}
+	pending.last = now
+}
+
+// flushEdits queues the debounced content edits of the conversations that
+// have not been edited for the debounce period or have been debounced for
+// maxContentEditDelay, or of every conversation if all is true.
+func (d *Dispatcher) flushEdits(now time.Time, all bool) {
+	d.mu.Lock()
+	due := make([]*pendingEdits, 0)
+	for conversationID, pending := range d.edits {
+		if all || now.Sub(pending.last) >= d.config.ContentEditDebounce ||
+			now.Sub(pending.first) >= maxContentEditDelay {
+			due = append(due, pending)
+			delete(d.edits, conversationID)
+		}
+	}
+	d.mu.Unlock()
+
+	for _, pending := range due {
+		pending.event.After = models.EventValue(&pending.values)
+		d.enqueue(pending.event)
+	}
+}
+
+// Run delivers queued events until ctx is cancelled. It then queues every
+// debounced content edit and delivers the queued events without retrying
+// before returning.
+func (d *Dispatcher) Run(ctx context.Context) {
+	workCtx, stopWork := context.WithCancel(context.Background())
+	var wg sync.WaitGroup
+	for i := 0; i < d.config.Workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			d.work(ctx, workCtx)
+		}()
+	}
+
+	interval := d.config.ContentEditDebounce / 2
+	if interval > time.Second {
+		interval = time.Second
+	}
+	if interval <= 0 {
+		interval = time.Millisecond
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case now := <-ticker.C:
+			d.flushEdits(now, false)
+		case <-ctx.Done():
+			d.flushEdits(time.Now(), true)
+			stopWork()
+			wg.Wait()
+			return
+		}
+	}
+}
+
+// work delivers queued events until workCtx is cancelled, and then until the
+// queue is empty. Retries are given up once ctx is cancelled.
+func (d *Dispatcher) work(ctx, workCtx context.Context) {
+	for {
+		select {
+		case event := <-d.queue:
+			d.deliver(ctx, event)
+		case <-workCtx.Done():
+			for {
+				select {
+				case event := <-d.queue:
+					d.deliver(ctx, event)
+				default:
+					return
+				}
+			}
+		}
+	}
+}
+
+// deliver sends an event to every enabled webhook of its conversation that
+// matches it, or of its Webhooks if it has them.
+func (d *Dispatcher) deliver(ctx context.Context, event *models.Event) {
+	webhooks := event.Webhooks
+	if webhooks == nil {
+		var err error
+		webhooks, err = d.db.GetWebhooks(context.Background(), event.ConversationID)
+		if err != nil {
+			d.logger.Errorf("Failed to get webhooks of conversation %d: %v", event.ConversationID, err)
+			return
+		}
+	}
+	if event.CreatedAt == "" {
+		event.CreatedAt = time.Now().UTC().Format(models.TimeLayout)
+	}
+
+	var wg sync.WaitGroup
+	for _, webhook := range webhooks {
+		if webhook.Enabled == nil || !*webhook.Enabled || !webhook.Matches(event.Action) {
+			continue
+		}
+		wg.Add(1)
+		go func(webhook *models.Webhook) {
+			defer wg.Done()
+			d.send(ctx, webhook, event)
+		}(webhook)
+	}
+	wg.Wait()
+}
+
+// send sends an event to a webhook, retrying with exponential backoff until it
+// responds with a 2xx status code or MaxAttempts is reached, and records the
+// outcome. Nothing is recorded for webhooks that the event was given, which
+// have already been deleted.
+func (d *Dispatcher) send(ctx context.Context, webhook *models.Webhook, event *models.Event) {
+	record := event.Webhooks == nil
+
+	body, err := json.Marshal(&Payload{WebhookID: webhook.ID, Event: event})
+	if err != nil {
+		d.logger.Errorf("Failed to encode event %d: %v", event.ID, err)
+		return
+	}
+
+	backoff := d.config.RetryBackoff
+	for attempt := 1; attempt <= d.config.MaxAttempts; attempt++ {
+		if attempt > 1 {
+			select {
+			case <-time.After(backoff):
+				backoff *= 2
+			case <-ctx.Done():
+				d.logger.Warnf("Gave up delivering event %d to webhook %d while shutting down", event.ID, webhook.ID)
+				return
+			}
+		}
+
+		delivery := d.post(webhook, event, body)
+		delivery.Attempt = attempt
+		if record {
+			if err := d.db.CreateWebhookDelivery(context.Background(), delivery); err != nil {
+				d.logger.Errorf("Failed to log delivery of event %d to webhook %d: %v", event.ID, webhook.ID, err)
+			}
+		}
+
+		if delivery.Error == nil && *delivery.StatusCode/100 == 2 {
+			if record && webhook.ConsecutiveFailures > 0 {
+				if err := d.db.ResetWebhookFailures(context.Background(), webhook.ID); err != nil {
+					d.logger.Errorf("Failed to reset failures of webhook %d: %v", webhook.ID, err)
+				}
+			}
+			return
+		}
+	}
+
+	if !record {
+		d.logger.Infof("Failed to deliver event %d to deleted webhook %d", event.ID, webhook.ID)
+		return
+	}
+	if err := d.db.RecordWebhookFailure(context.Background(), webhook.ID, d.config.DisableAfter); err != nil {
+		d.logger.Errorf("Failed to record failure of webhook %d: %v", webhook.ID, err)
+	}
+	if webhook.ConsecutiveFailures+1 >= d.config.DisableAfter {
+		d.logger.Warnf(
+			"Disabled webhook %d of conversation %d after %d failed deliveries in a row",
+			webhook.ID,
+			webhook.ConversationID,
+			webhook.ConsecutiveFailures+1,
+		)
+	} else {
+		d.logger.Infof("Failed to deliver event %d to webhook %d", event.ID, webhook.ID)
+	}
+}
+
+// post makes a single signed request to a webhook. The request is not tied to
+// the lifetime of the Dispatcher, so that it is not cut off while shutting
+// down.
+func (d *Dispatcher) post(webhook *models.Webhook, event *models.Event, body []byte) *models.WebhookDelivery {
+	delivery := &models.WebhookDelivery{
+		WebhookID: webhook.ID,
+		EventID:   event.ID,
+		Action:    event.Action,
+	}
+	fail := func(err error) *models.WebhookDelivery {
+		errStr := err.Error()
+		if len(errStr) > maxErrorLength {
+			errStr = errStr[:maxErrorLength]
+		}
+		delivery.Error = &errStr
+		return delivery
+	}
+
+	req, err := http.NewRequest(http.MethodPost, webhook.URL, bytes.NewReader(body))
+	if err != nil {
+		return fail(err)
+	}
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "Ether-Webhooks")
+	req.Header.Set(EventHeader, string(event.Action))
+	req.Header.Set(DeliveryHeader, strconv.FormatInt(event.ID, 10))
+	req.Header.Set(TimestampHeader, timestamp)
+	req.Header.Set(SignatureHeader, Sign(webhook.Secret, timestamp, body))
+
+	start := time.Now()
+	res, err := d.client.Do(req)
+	delivery.DurationMS = time.Since(start).Milliseconds()
+	if err != nil {
+		return fail(err)
+	}
+	io.Copy(ioutil.Discard, io.LimitReader(res.Body, maxResponseLength))
+	res.Body.Close()
+
+	delivery.StatusCode = &res.StatusCode
+	if res.StatusCode/100 != 2 {
+		errStr := fmt.Sprintf("Webhook responded with %s", res.Status)
+		delivery.Error = &errStr
+	}
+	return delivery
+}
@@ -0,0 +1,317 @@
+package webhooks
+
+import (
+	"context"
+	"encoding/json"
+	"ether/models"
+	"ether/utils"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestDispatcherDeliver(t *testing.T) {
+	tests := []struct {
+		Name        string
+		Action      models.EventAction
+		Events      []models.EventAction
+		Failures    int
+		StatusCodes []int
+		Attempts    int
+		ResFailures int
+		ResEnabled  bool
+		ResDeleted  bool
+	}{
+		{
+			Name:        "Delivered on first attempt",
+			Action:      models.EventMemberAdded,
+			StatusCodes: []int{http.StatusOK},
+			Attempts:    1,
+			ResEnabled:  true,
+		},
+		{
+			Name:        "Retried after server error",
+			Action:      models.EventMemberAdded,
+			Failures:    2,
+			StatusCodes: []int{http.StatusInternalServerError, http.StatusNoContent},
+			Attempts:    2,
+			ResEnabled:  true,
+		},
+		{
+			Name:        "Failed after every attempt",
+			Action:      models.EventMemberRemoved,
+			StatusCodes: []int{http.StatusBadGateway, http.StatusBadGateway, http.StatusBadGateway},
+			Attempts:    3,
+			ResFailures: 1,
+			ResEnabled:  true,
+		},
+		{
+			Name:        "Disabled after repeated failures",
+			Action:      models.EventMemberRemoved,
+			Failures:    4,
+			StatusCodes: []int{http.StatusNotFound, http.StatusNotFound, http.StatusNotFound},
+			Attempts:    3,
+			ResFailures: 5,
+			ResEnabled:  false,
+		},
+		{
+			Name:       "Event not in filter",
+			Action:     models.EventConversationUpdated,
+			Events:     []models.EventAction{models.EventMemberAdded},
+			ResEnabled: true,
+		},
+		{
+			Name:        "Sent to webhook deleted with conversation",
+			Action:      models.EventConversationDeleted,
+			StatusCodes: []int{http.StatusOK},
+			Attempts:    1,
+			ResDeleted:  true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.Name, func(t *testing.T) {
+			var mu sync.Mutex
+			requests := 0
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				body, _ := ioutil.ReadAll(r.Body)
+				signature := Sign("secret", r.Header.Get(TimestampHeader), body)
+				if r.Header.Get(SignatureHeader) != signature {
+					t.Errorf("Request has incorrect signature, expected %q, got %q", signature, r.Header.Get(SignatureHeader))
+				}
+				if r.Header.Get(EventHeader) != string(test.Action) {
+					t.Errorf("Request has incorrect event, expected %q, got %q", test.Action, r.Header.Get(EventHeader))
+				}
+
+				payload := Payload{}
+				if err := json.Unmarshal(body, &payload); err != nil || payload.Event == nil || payload.WebhookID != 1 {
+					t.Errorf("Request has invalid payload %s", body)
+				}
+
+				mu.Lock()
+				defer mu.Unlock()
+				if requests >= len(test.StatusCodes) {
+					t.Errorf("Too many requests")
+					w.WriteHeader(http.StatusInternalServerError)
+					return
+				}
+				w.WriteHeader(test.StatusCodes[requests])
+				requests++
+			}))
+			defer server.Close()
+
+			mDB := models.NewMockDB(nil, nil, nil)
+			_ = mDB.CreateWebhook(context.Background(), &models.Webhook{
+				ConversationID:      1,
+				URL:                 server.URL,
+				Events:              test.Events,
+				Enabled:             utils.BoolPtr(true),
+				ConsecutiveFailures: test.Failures,
+				Secret:              "secret",
+			})
+
+			d := NewDispatcher(mDB, nil, Config{
+				Workers:      1,
+				MaxAttempts:  3,
+				RetryBackoff: time.Millisecond,
+				DisableAfter: 5,
+				Timeout:      time.Second,
+				// The test server listens on a loopback address
+				AllowPrivateNetworks: true,
+			})
+			event := &models.Event{ID: 1, ConversationID: 1, Action: test.Action}
+			if test.ResDeleted {
+				// The handler deletes the webhooks with the conversation and
+				// gives them to the event
+				event.Webhooks, _ = mDB.GetWebhooks(context.Background(), 1)
+				_ = mDB.DeleteConversation(context.Background(), 1)
+			}
+			d.deliver(context.Background(), event)
+
+			if requests != test.Attempts {
+				t.Errorf("Incorrect number of requests, expected %d, got %d", test.Attempts, requests)
+			}
+
+			webhook := mDB.Webhooks[1]
+			if test.ResDeleted {
+				if webhook != nil || len(mDB.Deliveries) != 0 {
+					t.Errorf("Deleted webhook was recreated or had deliveries logged")
+				}
+				return
+			}
+
+			if len(mDB.Deliveries) != test.Attempts {
+				t.Errorf("Incorrect number of deliveries, expected %d, got %d", test.Attempts, len(mDB.Deliveries))
+			}
+			for i, delivery := range mDB.Deliveries {
+				if delivery.Attempt != i+1 || delivery.StatusCode == nil || *delivery.StatusCode != test.StatusCodes[i] {
+					t.Errorf("Delivery %d was logged incorrectly: %+v", i+1, delivery)
+				}
+			}
+			if webhook.ConsecutiveFailures != test.ResFailures {
+				t.Errorf("Webhook has incorrect failures, expected %d, got %d", test.ResFailures, webhook.ConsecutiveFailures)
+			}
+			if *webhook.Enabled != test.ResEnabled {
+				t.Errorf("Webhook has incorrect enabled state, expected %t, got %t", test.ResEnabled, *webhook.Enabled)
+			}
+		})
+	}
+}
+
+func TestDispatcherDebounce(t *testing.T) {
+	var userID1, userID2 int64 = 1, 2
+	start := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	edit := func(id int64, actorID *int64, at time.Duration) *models.Event {
+		timestamp := start.Add(at).Format(time.RFC3339)
+		return &models.Event{
+			ID:             id,
+			ConversationID: 1,
+			ActorID:        actorID,
+			Action:         models.EventContentEdited,
+			After: models.EventValue(&models.ContentEditValues{
+				Edits:       2,
+				FirstEditAt: timestamp,
+				LastEditAt:  timestamp,
+			}),
+		}
+	}
+
+	tests := []struct {
+		Name      string
+		Events    []*models.Event
+		FlushAt   time.Duration
+		Queued    int
+		ResID     int64
+		ResActor  *int64
+		ResValues models.ContentEditValues
+	}{
+		{
+			Name:     "Edits by one user are merged",
+			Events:   []*models.Event{edit(1, &userID1, 0), edit(2, &userID1, 10*time.Second)},
+			FlushAt:  2 * time.Minute,
+			Queued:   1,
+			ResID:    2,
+			ResActor: &userID1,
+			ResValues: models.ContentEditValues{
+				Edits:       4,
+				FirstEditAt: start.Format(time.RFC3339),
+				LastEditAt:  start.Add(10 * time.Second).Format(time.RFC3339),
+			},
+		},
+		{
+			Name:    "Edits by several users are merged without an actor",
+			Events:  []*models.Event{edit(1, &userID1, 0), edit(2, &userID2, 0), edit(3, &userID1, 0)},
+			FlushAt: 2 * time.Minute,
+			Queued:  1,
+			ResID:   3,
+			ResValues: models.ContentEditValues{
+				Edits:       6,
+				FirstEditAt: start.Format(time.RFC3339),
+				LastEditAt:  start.Format(time.RFC3339),
+			},
+		},
+		{
+			Name:    "Edits are kept while still being edited",
+			Events:  []*models.Event{edit(1, &userID1, 0)},
+			FlushAt: 30 * time.Second,
+			Queued:  0,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.Name, func(t *testing.T) {
+			d := NewDispatcher(models.NewMockDB(nil, nil, nil), nil, Config{ContentEditDebounce: time.Minute})
+			for _, event := range test.Events {
+				d.debounce(event, start)
+			}
+			d.flushEdits(start.Add(test.FlushAt), false)
+
+			if len(d.queue) != test.Queued {
+				t.Fatalf("Incorrect number of queued events, expected %d, got %d", test.Queued, len(d.queue))
+			}
+			if test.Queued == 0 {
+				return
+			}
+
+			event := <-d.queue
+			if event.ID != test.ResID {
+				t.Errorf("Event has incorrect ID, expected %d, got %d", test.ResID, event.ID)
+			}
+			if (event.ActorID == nil) != (test.ResActor == nil) ||
+				(event.ActorID != nil && *event.ActorID != *test.ResActor) {
+				t.Errorf("Event has incorrect actor, expected %v, got %v", test.ResActor, event.ActorID)
+			}
+			values := models.ContentEditValues{}
+			_ = json.Unmarshal(event.After, &values)
+			if values != test.ResValues {
+				t.Errorf("Event has incorrect values, expected %+v, got %+v", test.ResValues, values)
+			}
+		})
+	}
+}
+
+func TestDispatcherPostAddresses(t *testing.T) {
+	var requests int
+	target := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+	}))
+	defer target.Close()
+	redirect := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, target.URL, http.StatusTemporaryRedirect)
+	}))
+	defer redirect.Close()
+
+	tests := []struct {
+		Name                 string
+		URL                  string
+		AllowPrivateNetworks bool
+		StatusCode           int
+	}{
+		{
+			Name: "Loopback address",
+			URL:  target.URL,
+		},
+		{
+			Name:                 "Loopback address allowed",
+			URL:                  target.URL,
+			AllowPrivateNetworks: true,
+			StatusCode:           http.StatusOK,
+		},
+		{
+			Name:                 "Redirect is not followed",
+			URL:                  redirect.URL,
+			AllowPrivateNetworks: true,
+			StatusCode:           http.StatusTemporaryRedirect,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.Name, func(t *testing.T) {
+			requests = 0
+			d := NewDispatcher(models.NewMockDB(nil, nil, nil), nil, Config{
+				Timeout:              time.Second,
+				AllowPrivateNetworks: test.AllowPrivateNetworks,
+			})
+			delivery := d.post(
+				&models.Webhook{ID: 1, URL: test.URL, Secret: "secret"},
+				&models.Event{ID: 1, Action: models.EventMemberAdded},
+				[]byte("{}"),
+			)
+
+			if test.StatusCode == 0 {
+				if delivery.StatusCode != nil || delivery.Error == nil || !strings.Contains(*delivery.Error, ErrAddressNotAllowed.Error()) {
+					t.Errorf("Expected delivery to be refused, got %+v", delivery)
+				}
+			} else if delivery.StatusCode == nil || *delivery.StatusCode != test.StatusCode {
+				t.Errorf("Delivery has incorrect status code, expected %d, got %v", test.StatusCode, delivery.StatusCode)
+			}
+			if test.StatusCode != http.StatusOK && requests != 0 {
+				t.Errorf("Expected no requests to reach the target, got %d", requests)
+			}
+		})
+	}
+}